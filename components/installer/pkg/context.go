@@ -41,6 +41,7 @@ type InstallerResponses struct {
 	PrivateGateway          string
 	PublicHostname          string
 	DNSDomain               string
+	NTPServers              []string
 	StorageSystemPercentage int
 	ControllerDisk          string
 	RootPassword            string
@@ -91,6 +92,7 @@ func init() {
 	DefaultContext.Responses.PodSubnet = "10.10.0.0/16"
 	DefaultContext.Responses.ServiceSubnet = "10.11.0.0/16"
 	DefaultContext.Responses.DNSDomain = "cluster.local"
+	DefaultContext.Responses.NTPServers = []string{"0.pool.ntp.org", "1.pool.ntp.org", "2.pool.ntp.org", "3.pool.ntp.org"}
 	DefaultContext.Responses.StorageSystemPercentage = 50
 
 	DefaultContext.InstallID = uuid.New().String()
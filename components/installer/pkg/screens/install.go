@@ -111,7 +111,9 @@ func InstallScreen(screenSet *widgets.ScreenSet, context interface{}) *widgets.S
 		fmt.Sprintf("OPEROS_DNS_SERVICE_IP=%s", ctx.Responses.DNSIP),
 		fmt.Sprintf("OPEROS_PUBLIC_HOSTNAME=%s", ctx.Responses.PublicHostname),
 		fmt.Sprintf("OPEROS_DNS_DOMAIN=%s", ctx.Responses.DNSDomain),
+		fmt.Sprintf("OPEROS_NTP_SERVERS=%s", strings.Join(ctx.Responses.NTPServers, " ")),
 		fmt.Sprintf("OPEROS_WORKER_STORAGE_PERCENTAGE=%d", ctx.Responses.StorageSystemPercentage),
+		fmt.Sprintf("OPEROS_HOSTNAME_PATTERN=%s", "worker-{n}"),
 		fmt.Sprintf("OPEROS_CLUSTER_NAME=%s", ctx.Responses.OrgInfo.Cluster),
 		fmt.Sprintf("OPEROS_CLUSTER_ORG=%s", ctx.Responses.OrgInfo.Organization),
 		fmt.Sprintf("OPEROS_CLUSTER_DEPARTMENT=%s", ctx.Responses.OrgInfo.Department),
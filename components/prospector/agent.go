@@ -0,0 +1,349 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+//ReportResult is teamster's answer to a report sent to its /report endpoint
+type ReportResult struct {
+	//OSDLoadout lists uuid:id:key for every OSD teamster assigned to the node
+	OSDLoadout string `json:"osd_loadout"`
+	Commands
+}
+
+//Commands are the actions teamster asks of a worker, in answer to a report or
+//to a poll of its /commands endpoint
+type Commands struct {
+	//Reboot asks the worker to reboot itself, e.g. after it was drained
+	Reboot bool `json:"reboot,omitempty"`
+}
+
+//Agent keeps teamster's copy of the report current between reboots. It
+//re-collects the report every Interval and sends it to teamster's /report
+//endpoint whenever something relevant changed, e.g. a disk was hot-added.
+type Agent struct {
+	//ServerURL is the base URL of teamster's HTTP API
+	ServerURL string
+	Interval  time.Duration
+	//PollInterval is how often teamster is asked for commands between
+	//reports. Commands are only received with reports if it is zero.
+	PollInterval time.Duration
+	//Jitter is the fraction by which each interval is randomly stretched or
+	//shortened, so that workers booted together drift apart
+	Jitter float64
+	//MinBackoff and MaxBackoff bound the retry delay after a failed send
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	//LoadoutFile receives the OSD loadout returned by teamster, if set. It
+	//is picked up by make-partitions on the next boot.
+	LoadoutFile string
+
+	Collect func() (*Report, error)
+	Client  *http.Client
+	//Reboot is called when teamster asks for the worker to be rebooted
+	Reboot func() error
+
+	lastDigest string
+	//uuid is the node's UUID as of the last collected report, which the
+	//commands for it are polled by
+	uuid     string
+	failures uint
+	rand     *rand.Rand
+}
+
+//NewAgent creates an agent collecting reports with the default collectors
+func NewAgent(serverURL string, interval time.Duration) *Agent {
+	return &Agent{
+		ServerURL:  serverURL,
+		Interval:   interval,
+		Jitter:     0.2,
+		MinBackoff: 15 * time.Second,
+		MaxBackoff: 30 * time.Minute,
+		Collect: func() (*Report, error) {
+			return CollectReport(DefaultCollectors())
+		},
+		Client: &http.Client{Timeout: time.Minute},
+		Reboot: func() error {
+			return exec.Command("systemctl", "reboot").Run()
+		},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+//UseCertificate makes the agent identify itself to teamster with a client
+//certificate, such as the kubelet certificate of the node, and trust only
+//servers with certificates issued by the CA in caFile. Teamster hands out
+//commands only to the node they are meant for.
+func (a *Agent) UseCertificate(caFile, certFile, keyFile string) error {
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	a.Client.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:      rootCAs,
+			Certificates: []tls.Certificate{cert},
+		},
+	}
+	return nil
+}
+
+//Run reports until stop is closed. The first report is delayed by a random
+//fraction of the interval to spread out workers that booted at the same time.
+func (a *Agent) Run(stop <-chan struct{}) {
+	delay := time.Duration(a.rand.Int63n(int64(a.Interval) + 1))
+
+	var poll <-chan time.Time
+	if a.PollInterval > 0 {
+		ticker := time.NewTicker(a.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	log.Printf("next report in %s", delay)
+	next := time.After(delay)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-poll:
+			if err := a.Poll(); err != nil {
+				log.Printf("failed to poll teamster for commands: %s", err)
+			}
+			continue
+		case <-next:
+		}
+
+		sent, err := a.RunOnce()
+		if err != nil {
+			a.failures++
+			delay = a.backoff()
+			log.Printf("failed to report to teamster (attempt %d): %s", a.failures, err)
+		} else {
+			if sent {
+				log.Printf("sent updated report to teamster")
+			}
+			a.failures = 0
+			delay = a.nextInterval()
+		}
+
+		log.Printf("next report in %s", delay)
+		next = time.After(delay)
+	}
+}
+
+//RunOnce collects a report and sends it if it differs from the last report
+//teamster accepted. It tells whether a report was sent.
+func (a *Agent) RunOnce() (bool, error) {
+	report, err := a.Collect()
+	if err != nil {
+		return false, fmt.Errorf("Failed to collect report due to %s", err)
+	}
+
+	digest, err := ReportDigest(report)
+	if err != nil {
+		return false, err
+	}
+
+	// ReportDigest has made sure that the UUID can be read
+	uuid, _ := report.System.GetUUID()
+	a.uuid = uuid.ToString()
+
+	if digest == a.lastDigest {
+		return false, nil
+	}
+
+	if err := a.send(report); err != nil {
+		return false, err
+	}
+
+	a.lastDigest = digest
+	return true, nil
+}
+
+func (a *Agent) send(report *Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(a.ServerURL, "/") + "/report"
+	resp, err := a.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("teamster rejected report: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result ReportResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Failed to parse teamster response due to %s", err)
+	}
+
+	if a.LoadoutFile != "" {
+		current, _ := ioutil.ReadFile(a.LoadoutFile)
+		if string(current) != result.OSDLoadout {
+			log.Printf("OSD loadout changed, updating %s", a.LoadoutFile)
+			if err := writeFileAtomic(a.LoadoutFile, []byte(result.OSDLoadout), 0600); err != nil {
+				return err
+			}
+		}
+	}
+
+	return a.execute(&result.Commands)
+}
+
+//Poll asks teamster for commands queued for the node, and carries them out.
+//It does nothing until a report has been collected.
+func (a *Agent) Poll() error {
+	if a.uuid == "" {
+		return nil
+	}
+
+	url := strings.TrimRight(a.ServerURL, "/") + "/commands/" + a.uuid
+	resp, err := a.Client.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("teamster refused to send commands: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var commands Commands
+	if err := json.NewDecoder(resp.Body).Decode(&commands); err != nil {
+		return fmt.Errorf("Failed to parse teamster response due to %s", err)
+	}
+
+	return a.execute(&commands)
+}
+
+func (a *Agent) execute(commands *Commands) error {
+	if commands.Reboot {
+		log.Printf("teamster asked for a reboot, rebooting")
+		if a.Reboot != nil {
+			if err := a.Reboot(); err != nil {
+				return fmt.Errorf("Failed to reboot due to %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+//nextInterval is the interval stretched or shortened by up to Jitter
+func (a *Agent) nextInterval() time.Duration {
+	factor := 1 + a.Jitter*(2*a.rand.Float64()-1)
+	return time.Duration(float64(a.Interval) * factor)
+}
+
+//backoff doubles the delay with every consecutive failure, up to
+//MaxBackoff, and picks a random point in the upper half of it
+func (a *Agent) backoff() time.Duration {
+	delay := a.MaxBackoff
+	if a.failures < 32 {
+		if d := a.MinBackoff << (a.failures - 1); d > 0 && d < a.MaxBackoff {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(a.rand.Int63n(int64(delay/2)+1))
+}
+
+//ReportDigest hashes the parts of a report which teamster acts upon. Values
+//which drift on their own, like the current CPU clock in the device tree or
+//the power-on hours of disks, are left out so that they don't cause reports.
+func ReportDigest(report *Report) (string, error) {
+	if report.System == nil {
+		return "", fmt.Errorf("Report does not contain a hardware tree")
+	}
+
+	uuid, err := report.System.GetUUID()
+	if err != nil {
+		return "", err
+	}
+
+	type disk struct {
+		Name, Model, Serial, Size, Type string
+	}
+	disks := make([]disk, 0, len(report.Storage.BlockDevices))
+	for _, blkDevice := range report.Storage.BlockDevices {
+		disks = append(disks, disk{
+			blkDevice.Name, blkDevice.Model, blkDevice.Serial, blkDevice.Size, blkDevice.Type,
+		})
+	}
+
+	health := make([]DiskHealth, 0, len(report.DiskHealth))
+	for _, h := range report.DiskHealth {
+		stable := *h
+		stable.PowerOnHours = nil
+		health = append(health, stable)
+	}
+
+	data, err := json.Marshal(struct {
+		UUID       string
+		Disks      []disk
+		Network    []*NetworkInterface
+		Firmware   *Firmware
+		DiskHealth []DiskHealth
+	}{uuid.ToHexString(), disks, report.Network, report.Firmware, health})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadAgentTestReport(t *testing.T) *Report {
+	data, err := ioutil.ReadFile(TSTPath + "/report_teamster/report_v2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ParseReport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestReportDigest(t *testing.T) {
+	hours := int64(100)
+	laterHours := int64(101)
+
+	tests := []struct {
+		name       string
+		modify     func(*Report)
+		wantChange bool
+	}{
+		{
+			name:   "unchanged",
+			modify: func(r *Report) {},
+		},
+		{
+			name:   "new collection time",
+			modify: func(r *Report) { r.CollectedAt = r.CollectedAt.Add(time.Hour) },
+		},
+		{
+			name:   "mount point changed",
+			modify: func(r *Report) { r.Storage.BlockDevices[0].MountPoint = "/mnt" },
+		},
+		{
+			name:   "power-on hours advanced",
+			modify: func(r *Report) { r.DiskHealth[0].PowerOnHours = &laterHours },
+		},
+		{
+			name: "disk hot-added",
+			modify: func(r *Report) {
+				r.Storage.BlockDevices = append(r.Storage.BlockDevices, &BlockDevice{
+					Name: "sdz", Model: "NEW DISK", Serial: "Z1", Size: "1000", Type: "disk",
+				})
+			},
+			wantChange: true,
+		},
+		{
+			name: "disk started failing",
+			modify: func(r *Report) {
+				failed := false
+				r.DiskHealth[0].HealthPassed = &failed
+			},
+			wantChange: true,
+		},
+		{
+			name:       "link went down",
+			modify:     func(r *Report) { r.Network[0].Link = false },
+			wantChange: true,
+		},
+	}
+
+	for _, tt := range tests {
+		passed := true
+		report := loadAgentTestReport(t)
+		report.Network = []*NetworkInterface{{Name: "eno1", MAC: "00:11:22:33:44:55", Link: true}}
+		report.DiskHealth = []*DiskHealth{{Device: "sda", HealthPassed: &passed, PowerOnHours: &hours}}
+
+		before, err := ReportDigest(report)
+		if err != nil {
+			t.Fatalf("%q. ReportDigest() error = %v", tt.name, err)
+		}
+
+		tt.modify(report)
+
+		after, err := ReportDigest(report)
+		if err != nil {
+			t.Fatalf("%q. ReportDigest() error = %v", tt.name, err)
+		}
+
+		if (before != after) != tt.wantChange {
+			t.Errorf("%q. digest changed = %v, want %v", tt.name, before != after, tt.wantChange)
+		}
+	}
+}
+
+func TestAgentRunOnce(t *testing.T) {
+	received := 0
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/report" {
+			t.Errorf("agent posted to %s", r.URL.Path)
+			return
+		}
+		received++
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(&ReportResult{OSDLoadout: "uuid:1:key\n"})
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "prospector-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := loadAgentTestReport(t)
+	agent := NewAgent(server.URL+"/", time.Minute)
+	agent.LoadoutFile = filepath.Join(dir, "osd-loadout")
+	agent.Collect = func() (*Report, error) { return report, nil }
+
+	sent, err := agent.RunOnce()
+	if err != nil || !sent || received != 1 {
+		t.Fatalf("first RunOnce() = %v, %v; %d reports received", sent, err, received)
+	}
+
+	loadout, err := ioutil.ReadFile(agent.LoadoutFile)
+	if err != nil || string(loadout) != "uuid:1:key\n" {
+		t.Errorf("loadout file = %q, %v", loadout, err)
+	}
+
+	sent, err = agent.RunOnce()
+	if err != nil || sent || received != 1 {
+		t.Errorf("unchanged RunOnce() = %v, %v; %d reports received", sent, err, received)
+	}
+
+	// A rejected report is retried on the next run
+	report.Storage.BlockDevices = report.Storage.BlockDevices[1:]
+	status = http.StatusNotFound
+	if _, err := agent.RunOnce(); err == nil {
+		t.Error("RunOnce() succeeded although teamster rejected the report")
+	}
+
+	status = http.StatusOK
+	sent, err = agent.RunOnce()
+	if err != nil || !sent || received != 3 {
+		t.Errorf("retried RunOnce() = %v, %v; %d reports received", sent, err, received)
+	}
+}
+
+func TestAgentPoll(t *testing.T) {
+	reboot := false
+	polled := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/report":
+			json.NewEncoder(w).Encode(&ReportResult{})
+		case strings.HasPrefix(r.URL.Path, "/commands/"):
+			polled = strings.TrimPrefix(r.URL.Path, "/commands/")
+			json.NewEncoder(w).Encode(&Commands{Reboot: reboot})
+		default:
+			t.Errorf("agent posted to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	report := loadAgentTestReport(t)
+	rebooted := 0
+	agent := NewAgent(server.URL, time.Minute)
+	agent.Collect = func() (*Report, error) { return report, nil }
+	agent.Reboot = func() error {
+		rebooted++
+		return nil
+	}
+
+	// The UUID to poll for is not known before the first report
+	if err := agent.Poll(); err != nil || polled != "" {
+		t.Fatalf("Poll() before reporting = %v; polled %q", err, polled)
+	}
+
+	if _, err := agent.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := agent.Poll(); err != nil || rebooted != 0 {
+		t.Fatalf("Poll() = %v; rebooted %d times", err, rebooted)
+	}
+	uuid, _ := report.System.GetUUID()
+	if polled != uuid.ToString() {
+		t.Errorf("polled commands for %q, want %q", polled, uuid.ToString())
+	}
+
+	reboot = true
+	if err := agent.Poll(); err != nil || rebooted != 1 {
+		t.Errorf("Poll() = %v; rebooted %d times", err, rebooted)
+	}
+}
+
+func TestAgentBackoff(t *testing.T) {
+	agent := &Agent{
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: time.Minute,
+		rand:       rand.New(rand.NewSource(1)),
+	}
+
+	tests := []struct {
+		failures uint
+		max      time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		agent.failures = tt.failures
+		for i := 0; i < 20; i++ {
+			if delay := agent.backoff(); delay < tt.max/2 || delay > tt.max {
+				t.Errorf("backoff() after %d failures = %s, want between %s and %s", tt.failures, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
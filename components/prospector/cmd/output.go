@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	formatJSON  = "json"
+	formatYAML  = "yaml"
+	formatTable = "table"
+)
+
+func validFormat(format string) bool {
+	return format == formatJSON || format == formatYAML || format == formatTable
+}
+
+//writeOutput prints v in the requested format. The table rendering is left to
+//the caller, JSON and YAML use the JSON field names of v.
+func writeOutput(w io.Writer, format string, v interface{}, table func(*tabwriter.Writer)) error {
+	switch format {
+	case formatJSON:
+		return json.NewEncoder(w).Encode(v)
+
+	case formatYAML:
+		// Round-trip through JSON so that YAML keys match the JSON ones
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		var generic interface{}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+
+		out, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+
+	case formatTable:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q", format)
+}
@@ -20,134 +20,560 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/paxautoma/operos/components/prospector"
 )
 
-//GetHostUUID function which returns the JSON representation of the device tree
-//where the code is executed. Should be run wih the root priveledges, to
-//ensure that all the device information is accessed correctly
-func GetHostUUID(lshwXMLFile *string) {
+//Exit codes
+const (
+	exitOK = 0
+	//exitFailure means the host could not be inspected or the input not read
+	exitFailure = 1
+	//exitUsage means the command line was wrong
+	exitUsage = 2
+	//exitFindings is returned when diff found differences or collisions
+	//found colliding UUIDs
+	exitFindings = 3
+)
 
-	var uuid prospector.UUIDType
+const usage = `usage: prospector <command> [flags] [args]
 
-	if *lshwXMLFile != "" {
+Commands:
+  report              print the report sent to teamster (default)
+  uuid                print the UUID of the host
+  disks               print the UUIDs of the disks
+  tree                print the device tree
+  diff <a> <b>        compare the UUIDs of two saved reports or lshw dumps
+  collisions <dir>    find UUIDs shared by different machines in saved reports
+  agent               keep re-sending the report to teamster
 
-		xmldata, err := ioutil.ReadFile(*lshwXMLFile)
+Commands printing data take --format json|yaml|table. report, uuid, disks and
+tree take --input <file> to read a saved report, lshw XML or device tree JSON
+instead of inspecting this host. Run "prospector <command> -h" for details.
 
-		if err != nil {
-			fmt.Printf("error: %v", err)
-			return
+Exit codes: 0 success, 1 failure, 2 usage error, 3 differences or collisions
+found.
+`
+
+//Must be run with root privileges, to ensure that all the device information
+//is accessed correctly
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	c := &cli{stdout, stderr}
+
+	commands := map[string]func([]string) int{
+		"report":     c.report,
+		"uuid":       c.uuid,
+		"disks":      c.disks,
+		"tree":       c.tree,
+		"diff":       c.diff,
+		"collisions": c.collisions,
+		"agent":      c.agent,
+	}
+
+	// Without a command prospector prints the report, as it always did
+	if len(args) == 0 {
+		return c.report(args)
+	}
+
+	switch name := args[0]; {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		fmt.Fprint(c.stdout, usage)
+		return exitOK
+	case strings.HasPrefix(name, "-"):
+		return c.report(args)
+	case commands[name] != nil:
+		return commands[name](args[1:])
+	default:
+		fmt.Fprintf(c.stderr, "prospector: unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+}
+
+func (c *cli) fail(err error) int {
+	fmt.Fprintf(c.stderr, "prospector: %s\n", err)
+	return exitFailure
+}
+
+//commandFlags holds the flags shared by the commands printing data
+type commandFlags struct {
+	*flag.FlagSet
+	format string
+	input  string
+	nargs  int
+}
+
+func (c *cli) newFlags(name, args string, nargs int, defaultFormat string, withInput bool) *commandFlags {
+	fs := &commandFlags{FlagSet: flag.NewFlagSet(name, flag.ContinueOnError), nargs: nargs}
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: prospector %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+
+	if defaultFormat != "" {
+		fs.StringVar(&fs.format, "format", defaultFormat, "Output format: json, yaml or table")
+	}
+	if withInput {
+		fs.StringVar(&fs.input, "input", "", "Read a saved report, lshw XML or device tree JSON instead of inspecting this host")
+	}
+
+	return fs
+}
+
+//parse parses the command line, returning false and the exit code if the
+//command should not run
+func (fs *commandFlags) parse(c *cli, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
 		}
+		return exitUsage, false
+	}
+
+	if fs.format != "" && !validFormat(fs.format) {
+		fmt.Fprintf(c.stderr, "prospector: unknown format %q, use json, yaml or table\n", fs.format)
+		return exitUsage, false
+	}
+
+	if fs.NArg() != fs.nargs {
+		fs.Usage()
+		return exitUsage, false
+	}
 
-		v, err := prospector.NewDeviceTree(xmldata, "xml")
-		uuid, err = v.GetUUID()
+	return exitOK, true
+}
 
-	} else {
-		uuidRef, err := prospector.GetUUIDForHost()
-		uuid = *uuidRef
+func (c *cli) write(format string, v interface{}, table func(*tabwriter.Writer)) int {
+	if err := writeOutput(c.stdout, format, v, table); err != nil {
+		return c.fail(err)
+	}
+	return exitOK
+}
+
+func loadReport(input string) (*prospector.Report, error) {
+	if input == "" {
+		return prospector.CollectReport(prospector.DefaultCollectors())
+	}
+	return prospector.LoadReportFile(input)
+}
+
+func loadTree(input string) (*prospector.DeviceTree, error) {
+	if input != "" {
+		report, err := prospector.LoadReportFile(input)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to obatin generate UUIDs for blockdevices due to %s\n", err)
-			os.Exit(1)
+			return nil, err
 		}
+		return report.System, nil
 	}
 
-	fmt.Printf("UUID:%s\n", uuid.ToString())
+	xmldata, err := prospector.RunLSHW()
+	if err != nil {
+		return nil, err
+	}
 
+	tree, err := prospector.NewDeviceTree(xmldata, "xml")
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.System == nil {
+		return nil, fmt.Errorf("lshw did not report a system")
+	}
+
+	return tree, nil
 }
 
-//GetBlockDevices returns the JSON representation of the device tree
-//where the code is executed. Should be run wih the root priveledges, to
-//ensure that all the device information is accessed correctly
-func GetBlockDevices() {
+//report prints the report sent to teamster
+func (c *cli) report(args []string) int {
+	fs := c.newFlags("report", "", 0, formatJSON, true)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
+	}
 
-	devicedata, err := prospector.RunLSBLK()
+	report, err := loadReport(fs.input)
+	if err != nil {
+		return c.fail(err)
+	}
 
+	uuid, err := report.System.GetUUID()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to obatin generate UUIDs for blockdevices due to %s\n", err)
-		os.Exit(1)
+		return c.fail(err)
+	}
+
+	return c.write(fs.format, report, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "HOSTNAME\t%s\n", report.Hostname)
+		fmt.Fprintf(tw, "UUID\t%s\n", uuid.ToString())
+		fmt.Fprintf(tw, "SCHEMA\t%s\n", report.SchemaVersion)
+		fmt.Fprintf(tw, "AGENT\t%s\n", report.AgentVersion)
+		fmt.Fprintf(tw, "COLLECTED\t%s\n", report.CollectedAt.Format(time.RFC3339))
+		fmt.Fprintf(tw, "BLOCK DEVICES\t%d\n", len(report.Storage.BlockDevices))
+		fmt.Fprintf(tw, "NICS\t%d\n", len(report.Network))
+		if report.Firmware != nil {
+			fmt.Fprintf(tw, "BIOS\t%s %s\n", report.Firmware.BIOSVendor, report.Firmware.BIOSVersion)
+			fmt.Fprintf(tw, "BMC\t%s %s\n", report.Firmware.BMCManufacturer, report.Firmware.BMCFirmwareVersion)
+		}
+
+		collectors := make([]string, 0, len(report.CollectorErrors))
+		for name := range report.CollectorErrors {
+			collectors = append(collectors, name)
+		}
+		sort.Strings(collectors)
+		for _, name := range collectors {
+			fmt.Fprintf(tw, "ERROR (%s)\t%s\n", name, report.CollectorErrors[name])
+		}
+	})
+}
+
+type uuidOutput struct {
+	UUID string `json:"uuid"`
+	//Fingerprint includes the minor bytes which are left out of the UUID
+	Fingerprint string `json:"fingerprint"`
+}
+
+//uuid prints the UUID of the host
+func (c *cli) uuid(args []string) int {
+	fs := c.newFlags("uuid", "", 0, formatTable, true)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
 	}
 
-	hostUUID, err := prospector.GetUUIDForHost()
+	tree, err := loadTree(fs.input)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to calculate UUID for host due to %s", err)
-		os.Exit(1)
+		return c.fail(err)
 	}
 
-	blockDevices, err := prospector.GenerateUUIDForBlockDevices(devicedata, hostUUID)
+	uuid, err := tree.GetUUID()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to obatin generate UUIDs for blockdevices due to %s\n", err)
-		os.Exit(1)
+		return c.fail(err)
 	}
 
-	fmt.Printf("Device,UUID\n")
+	out := uuidOutput{UUID: uuid.ToString(), Fingerprint: uuid.ToHexString()}
+	return c.write(fs.format, &out, func(tw *tabwriter.Writer) {
+		fmt.Fprintf(tw, "UUID\t%s\n", out.UUID)
+		fmt.Fprintf(tw, "FINGERPRINT\t%s\n", out.Fingerprint)
+	})
+}
 
-	keys := []string{}
+type diskOutput struct {
+	Device string `json:"device"`
+	UUID   string `json:"uuid"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	Size   string `json:"size"`
+}
 
-	for deviceName := range *blockDevices {
-		keys = append(keys, deviceName)
+//disks prints the UUIDs of the disks, which make-partitions matches against
+//the OSD loadout
+func (c *cli) disks(args []string) int {
+	fs := c.newFlags("disks", "", 0, formatTable, true)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
 	}
 
-	sort.Strings(keys)
+	tree, blkDevices, err := loadDisks(fs.input)
+	if err != nil {
+		return c.fail(err)
+	}
 
-	for _, deviceName := range keys {
-		fmt.Printf("%s,%s\n", deviceName, (*blockDevices)[deviceName])
+	hostUUID, err := tree.GetUUID()
+	if err != nil {
+		return c.fail(err)
 	}
 
+	disks := []diskOutput{}
+	for _, blkDevice := range blkDevices {
+		if blkDevice.Type != "disk" {
+			continue
+		}
+
+		uuid, err := prospector.UUIDStringForBlkDevice(blkDevice, &hostUUID)
+		if err != nil {
+			return c.fail(fmt.Errorf("Failed to compute UUID for %s due to %s", blkDevice.Name, err))
+		}
+
+		disks = append(disks, diskOutput{
+			Device: blkDevice.Name,
+			UUID:   *uuid,
+			Model:  strings.TrimSpace(blkDevice.Model),
+			Serial: strings.TrimSpace(blkDevice.Serial),
+			Size:   blkDevice.Size,
+		})
+	}
+
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Device < disks[j].Device })
+
+	return c.write(fs.format, disks, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "DEVICE\tUUID\tSIZE\tSERIAL\tMODEL")
+		for _, disk := range disks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", disk.Device, disk.UUID, disk.Size, disk.Serial, disk.Model)
+		}
+	})
 }
 
-//ShowDeviceTree returns the JSON representation of the device tree
-//where the code is executed. Should be run wih the root priveledges, to
-//ensure that all the device information is accessed correctly
-func ShowDeviceTree() {
-	xmldata, err := prospector.RunLSHW()
+func loadDisks(input string) (*prospector.DeviceTree, []*prospector.BlockDevice, error) {
+	if input != "" {
+		report, err := prospector.LoadReportFile(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		return report.System, report.Storage.BlockDevices, nil
+	}
+
+	tree, err := loadTree("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lsblkData, err := prospector.RunLSBLK()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var storage prospector.BlockDevices
+	if err := json.Unmarshal(lsblkData, &storage); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse lsblk output due to %s", err)
+	}
 
+	return tree, storage.BlockDevices, nil
+}
+
+//tree prints the device tree which the UUID is computed from
+func (c *cli) tree(args []string) int {
+	fs := c.newFlags("tree", "", 0, formatJSON, true)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
+	}
+
+	tree, err := loadTree(fs.input)
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return c.fail(err)
+	}
+
+	return c.write(fs.format, tree, func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, "CLASS\tDESCRIPTION\tPRODUCT\tVENDOR\tSERIAL")
+		var walk func(device *prospector.Device, indent string)
+		walk = func(device *prospector.Device, indent string) {
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\n", indent, device.Class, device.Description, device.Product, device.Vendor, device.Serial)
+			for _, child := range device.Devices {
+				walk(child, indent+"  ")
+			}
+		}
+		walk(tree.System, "")
+	})
+}
+
+type diffSide struct {
+	File        string `json:"file"`
+	UUID        string `json:"uuid"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type diffOutput struct {
+	A diffSide `json:"a"`
+	B diffSide `json:"b"`
+	//SameHost is set when only minor components changed
+	SameHost     bool                        `json:"same_host"`
+	Identical    bool                        `json:"identical"`
+	ChangedBytes []prospector.UUIDByteChange `json:"changed_bytes"`
+}
+
+//diff compares the UUIDs computed from two inputs, showing which bytes, and
+//so which levels of the device tree, changed
+func (c *cli) diff(args []string) int {
+	fs := c.newFlags("diff", "<a> <b>", 2, formatTable, false)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
+	}
+
+	var uuids [2]prospector.UUIDType
+	var sides [2]diffSide
+	for i, file := range fs.Args() {
+		report, err := prospector.LoadReportFile(file)
+		if err != nil {
+			return c.fail(err)
+		}
+
+		if uuids[i], err = report.System.GetUUID(); err != nil {
+			return c.fail(fmt.Errorf("%s: %s", file, err))
+		}
+
+		sides[i] = diffSide{File: file, UUID: uuids[i].ToString(), Fingerprint: uuids[i].ToHexString()}
+	}
+
+	out := diffOutput{
+		A:            sides[0],
+		B:            sides[1],
+		SameHost:     uuids[0].HasTheSameMajorParts(&uuids[1]),
+		Identical:    uuids[0].IsIdenticalTo(&uuids[1]),
+		ChangedBytes: uuids[0].ChangedBytes(&uuids[1]),
 	}
 
-	v, err := prospector.NewDeviceTree(xmldata, "xml")
+	code := c.write(fs.format, &out, func(tw *tabwriter.Writer) {
+		marker := []byte(strings.Repeat(" ", 2*prospector.BytesPerUUID))
+		for _, change := range out.ChangedBytes {
+			marker[2*change.Position] = '^'
+			marker[2*change.Position+1] = '^'
+		}
+
+		fmt.Fprintln(tw, "\tFILE\tFINGERPRINT")
+		fmt.Fprintf(tw, "A\t%s\t%s\n", out.A.File, out.A.Fingerprint)
+		fmt.Fprintf(tw, "B\t%s\t%s\n", out.B.File, out.B.Fingerprint)
+		fmt.Fprintf(tw, "\t\t%s\n", strings.TrimRight(string(marker), " "))
+
+		switch {
+		case out.Identical:
+			fmt.Fprintln(tw, "UUIDs are identical")
+			return
+		case out.SameHost:
+			fmt.Fprintln(tw, "Same host, minor components changed")
+		default:
+			fmt.Fprintln(tw, "Different hosts")
+		}
+
+		fmt.Fprintln(tw, "\nBYTE\tLEVEL\tPART\tA\tB")
+		for _, change := range out.ChangedBytes {
+			part := "minor"
+			if change.Major {
+				part = "major"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%02x\t%02x\n", change.Position, change.Level, part, change.From, change.To)
+		}
+	})
+
+	if code == exitOK && !out.Identical {
+		return exitFindings
+	}
+	return code
+}
+
+//collisions checks a directory of saved reports for UUIDs which were computed
+//for more than one machine
+func (c *cli) collisions(args []string) int {
+	fs := c.newFlags("collisions", "<dir>", 1, formatTable, false)
+	if code, ok := fs.parse(c, args); !ok {
+		return code
+	}
 
+	dir := fs.Arg(0)
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		fmt.Printf("error: %v", err)
-		return
+		return c.fail(err)
 	}
 
-	devicedata, err := prospector.RunLSBLK()
-	var blockDevices prospector.BlockDevices
+	reports := make(map[string]*prospector.Report)
+	unreadable := 0
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".json" && ext != ".xml") {
+			continue
+		}
+
+		report, err := prospector.LoadReportFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			fmt.Fprintf(c.stderr, "prospector: skipping %s: %s\n", file.Name(), err)
+			unreadable++
+			continue
+		}
+		reports[file.Name()] = report
+	}
 
-	err = json.Unmarshal(devicedata, &blockDevices)
+	collisions, err := prospector.FindCollisions(reports)
 	if err != nil {
-		return
+		return c.fail(err)
 	}
 
-	out := new(prospector.Report)
-	out.System = v
-	out.Storage = blockDevices
+	code := c.write(fs.format, collisions, func(tw *tabwriter.Writer) {
+		if len(collisions) == 0 {
+			fmt.Fprintf(tw, "No collisions among %d reports\n", len(reports))
+			return
+		}
 
-	if jsonout, err := json.Marshal(out); err == nil {
-		fmt.Println(string(jsonout))
-	} else {
-		return
+		fmt.Fprintln(tw, "UUID\tMACS\tREPORTS")
+		for _, collision := range collisions {
+			for _, source := range collision.Sources {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", collision.UUID, strings.Join(source.MACs, ","), strings.Join(source.Reports, ","))
+			}
+		}
+	})
+
+	switch {
+	case code != exitOK:
+		return code
+	case len(collisions) > 0:
+		return exitFindings
+	case unreadable > 0:
+		return exitFailure
 	}
+	return exitOK
 }
 
-var getBlockDevices = flag.Bool("blk-device-uuid", false, "Generate the UUID for block devices")
-var hostUUIDOnly = flag.String("host-uuid-only", "", "The name of the XML file which to generate UUID")
+//agent periodically re-sends the report to teamster until the process is
+//terminated
+func (c *cli) agent(args []string) int {
+	fs := c.newFlags("agent", "", 0, "", false)
+	server := fs.String("server", "", "Base URL of teamster, e.g. https://10.0.0.1:2682")
+	interval := fs.Duration("interval", 10*time.Minute, "How often the report is collected")
+	pollInterval := fs.Duration("poll-interval", 30*time.Second, "How often teamster is asked for commands, such as a reboot; 0 to only ask when reporting")
+	loadoutFile := fs.String("loadout-file", "/etc/paxautoma/osd-loadout", "Where the OSD loadout returned by teamster is stored")
+	caFile := fs.String("ca", "", "CA certificate teamster's HTTPS certificate is checked against")
+	certFile := fs.String("cert", "", "Certificate the node identifies itself to teamster with; required to receive commands")
+	keyFile := fs.String("key", "", "Private key of the -cert certificate")
+	if code, ok := fs.parse(c, args); !ok {
+		return code
+	}
 
-func main() {
-	flag.Parse()
+	if *server == "" {
+		fmt.Fprintln(c.stderr, "prospector: -server is required")
+		return exitUsage
+	}
+
+	if *interval <= 0 {
+		fmt.Fprintln(c.stderr, "prospector: -interval must be positive")
+		return exitUsage
+	}
 
-	if *getBlockDevices {
-		GetBlockDevices()
-	} else if *hostUUIDOnly != "" {
-		GetHostUUID(hostUUIDOnly)
-	} else {
-		ShowDeviceTree()
+	if *pollInterval < 0 {
+		fmt.Fprintln(c.stderr, "prospector: -poll-interval must not be negative")
+		return exitUsage
 	}
 
+	agent := prospector.NewAgent(*server, *interval)
+	agent.PollInterval = *pollInterval
+	agent.LoadoutFile = *loadoutFile
+	if *certFile != "" {
+		if err := agent.UseCertificate(*caFile, *certFile, *keyFile); err != nil {
+			fmt.Fprintf(c.stderr, "prospector: %s\n", err)
+			return exitFailure
+		}
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	agent.Run(stop)
+	return exitOK
 }
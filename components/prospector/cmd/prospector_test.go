@@ -0,0 +1,200 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paxautoma/operos/components/prospector"
+)
+
+const tstPath = "../tst"
+
+func Test_run(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:       "uuid from lshw XML",
+			args:       []string{"uuid", "--input", tstPath + "/vbox/node1.xml"},
+			wantStdout: []string{"UUID", "FINGERPRINT"},
+		},
+		{
+			name:       "uuid as JSON",
+			args:       []string{"uuid", "--format", "json", "--input", tstPath + "/json/laptop.json"},
+			wantStdout: []string{`"uuid":`, `"fingerprint":`},
+		},
+		{
+			name:       "disks from a saved report",
+			args:       []string{"disks", "--input", tstPath + "/report_teamster/report_v2.json"},
+			wantStdout: []string{"DEVICE", "sda"},
+		},
+		{
+			name:       "report as table",
+			args:       []string{"report", "--format", "table", "--input", tstPath + "/report_teamster/report_v2.json"},
+			wantStdout: []string{"3d217465-7272-824e-7458-ab9ca9a9b985", "BLOCK DEVICES"},
+		},
+		{
+			name:       "bare flags print the report",
+			args:       []string{"--input", tstPath + "/report_teamster/report_v2.json"},
+			wantStdout: []string{`"schema_version":"2.0"`},
+		},
+		{
+			name:       "tree as table",
+			args:       []string{"tree", "--format", "table", "--input", tstPath + "/vbox/node1.xml"},
+			wantStdout: []string{"CLASS", "system"},
+		},
+		{
+			name:       "tree as YAML",
+			args:       []string{"tree", "--format", "yaml", "--input", tstPath + "/vbox/node1.xml"},
+			wantStdout: []string{"system"},
+		},
+		{
+			name:       "diff of identical hosts",
+			args:       []string{"diff", tstPath + "/vbox/node1.xml", tstPath + "/vbox/node1.xml"},
+			wantStdout: []string{"identical"},
+		},
+		{
+			name:       "diff of changed NIC",
+			args:       []string{"diff", tstPath + "/vbox/controller.xml", tstPath + "/vbox/controller_different_nic.xml"},
+			wantCode:   exitFindings,
+			wantStdout: []string{"^^", "BYTE"},
+		},
+		{
+			name:       "diff of missing file",
+			args:       []string{"diff", tstPath + "/vbox/node1.xml", tstPath + "/vbox/missing.xml"},
+			wantCode:   exitFailure,
+			wantStderr: "missing.xml",
+		},
+		{
+			name:     "diff needs two files",
+			args:     []string{"diff", tstPath + "/vbox/node1.xml"},
+			wantCode: exitUsage,
+		},
+		{
+			name:       "unknown format",
+			args:       []string{"uuid", "--format", "xml"},
+			wantCode:   exitUsage,
+			wantStderr: "unknown format",
+		},
+		{
+			name:       "unknown command",
+			args:       []string{"frobnicate"},
+			wantCode:   exitUsage,
+			wantStderr: "unknown command",
+		},
+		{
+			name:       "agent without server",
+			args:       []string{"agent"},
+			wantCode:   exitUsage,
+			wantStderr: "-server is required",
+		},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, &stdout, &stderr)
+
+		if code != tt.wantCode {
+			t.Errorf("%q. run() = %d, want %d; stderr: %s", tt.name, code, tt.wantCode, stderr.String())
+		}
+		for _, want := range tt.wantStdout {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("%q. stdout does not contain %q:\n%s", tt.name, want, stdout.String())
+			}
+		}
+		if !strings.Contains(stderr.String(), tt.wantStderr) {
+			t.Errorf("%q. stderr does not contain %q:\n%s", tt.name, tt.wantStderr, stderr.String())
+		}
+	}
+}
+
+func Test_runDiffJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"diff", "--format", "json", tstPath + "/vbox/controller.xml", tstPath + "/vbox/controller_different_nic.xml"}, &stdout, &stderr)
+	if code != exitFindings {
+		t.Fatalf("run() = %d, want %d; stderr: %s", code, exitFindings, stderr.String())
+	}
+
+	var out diffOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Identical || len(out.ChangedBytes) == 0 {
+		t.Errorf("expected changed bytes, got %+v", out)
+	}
+	for _, change := range out.ChangedBytes {
+		if change.From == change.To {
+			t.Errorf("byte %d reported as changed but is %02x in both", change.Position, change.From)
+		}
+	}
+}
+
+func Test_runCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector-collisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Two clones of the same VM, which only differ in their MAC addresses
+	for name, mac := range map[string]string{"clone-a.json": "08:00:27:aa:00:01", "clone-b.json": "08:00:27:bb:00:01"} {
+		report, err := prospector.LoadReportFile(tstPath + "/vbox/node1.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report.Network = []*prospector.NetworkInterface{{Name: "eth0", MAC: mac}}
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"collisions", dir}, &stdout, &stderr); code != exitFindings {
+		t.Errorf("run() = %d, want %d; stderr: %s", code, exitFindings, stderr.String())
+	}
+	for _, want := range []string{"clone-a.json", "clone-b.json", "08:00:27:bb:00:01"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout does not contain %q:\n%s", want, stdout.String())
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, "clone-b.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"collisions", dir}, &stdout, &stderr); code != exitOK {
+		t.Errorf("run() = %d, want %d; stderr: %s", code, exitOK, stderr.String())
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//Collector gathers one optional section of a report. A collector that cannot
+//reach its data source returns an error and leaves the report alone; the
+//rest of the report is still sent.
+type Collector interface {
+	Name() string
+	Collect(report *Report) error
+}
+
+//CommandRunner runs an external tool and returns its standard output. Some
+//tools (smartctl) exit non-zero while still producing useful output, so the
+//output is returned alongside the error.
+type CommandRunner func(name string, args ...string) ([]byte, error)
+
+//ExecCommand is the CommandRunner used outside of tests
+func ExecCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+//DefaultCollectors returns the collectors used for reports sent to teamster.
+//They run after the hardware and storage sections have been filled in.
+func DefaultCollectors() []Collector {
+	return []Collector{
+		&NetworkCollector{Run: ExecCommand, SysfsRoot: "/sys"},
+		&FirmwareCollector{Run: ExecCommand, SysfsRoot: "/sys"},
+		&SMARTCollector{Run: ExecCommand},
+	}
+}
+
+//RunCollectors runs the collectors in order. Failures are logged and noted
+//in the report's collector_errors rather than aborting the report.
+func RunCollectors(report *Report, collectors []Collector) {
+	for _, collector := range collectors {
+		if err := collector.Collect(report); err != nil {
+			log.Printf("collector %s failed: %s", collector.Name(), err)
+			if report.CollectorErrors == nil {
+				report.CollectorErrors = make(map[string]string)
+			}
+			report.CollectorErrors[collector.Name()] = err.Error()
+		}
+	}
+}
+
+//CollectReport builds a full report for the current host: the lshw device
+//tree, the lsblk block devices and whatever the collectors can gather
+func CollectReport(collectors []Collector) (*Report, error) {
+	xmldata, err := RunLSHW()
+	if err != nil {
+		return nil, err
+	}
+
+	devTree, err := NewDeviceTree(xmldata, "xml")
+	if err != nil {
+		return nil, err
+	}
+
+	devicedata, err := RunLSBLK()
+	if err != nil {
+		return nil, err
+	}
+
+	var blockDevices BlockDevices
+	if err := json.Unmarshal(devicedata, &blockDevices); err != nil {
+		return nil, fmt.Errorf("Failed to parse lsblk output due to %s", err)
+	}
+
+	report := NewReport(devTree, blockDevices)
+	RunCollectors(report, collectors)
+
+	return report, nil
+}
+
+//isCommandMissing tells if err means the tool is not installed
+func isCommandMissing(err error) bool {
+	if execErr, ok := err.(*exec.Error); ok {
+		return execErr.Err == exec.ErrNotFound
+	}
+	return false
+}
+
+//readSysfsValue reads a single-line sysfs attribute, returning "" if it does
+//not exist or cannot be read
+func readSysfsValue(elem ...string) string {
+	data, err := ioutil.ReadFile(filepath.Join(elem...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+//parseKeyValueLines splits "key: value" output into a map. Keys are
+//lowercased; lines without the separator are ignored.
+func parseKeyValueLines(out []byte, sep string) map[string]string {
+	res := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, sep, 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		if key == "" {
+			continue
+		}
+		res[key] = strings.TrimSpace(parts[1])
+	}
+	return res
+}
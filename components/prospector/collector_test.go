@@ -0,0 +1,416 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeRunner serves captured command output from the tst directory. Commands
+// missing from the map behave as if the tool was not installed.
+func fakeRunner(outputs map[string]string) CommandRunner {
+	return func(name string, args ...string) ([]byte, error) {
+		cmd := strings.Join(append([]string{name}, args...), " ")
+		file, ok := outputs[cmd]
+		if !ok {
+			return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+		}
+		if file == "" {
+			return nil, errors.New("exit status 1")
+		}
+		return ioutil.ReadFile(filepath.Join(TSTPath, file))
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestParseEthtoolLink(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want *EthtoolLink
+	}{
+		{name: "link up", file: "ethtool/eno1.txt", want: &EthtoolLink{SpeedMbps: 1000, Duplex: "full", Link: true}},
+		{name: "link down", file: "ethtool/eno2_down.txt", want: &EthtoolLink{SpeedMbps: 0, Duplex: "", Link: false}},
+	}
+	for _, tt := range tests {
+		out, err := ioutil.ReadFile(filepath.Join(TSTPath, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseEthtoolLink(out)
+		if err != nil {
+			t.Errorf("%q. ParseEthtoolLink() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. ParseEthtoolLink() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseEthtoolDriver(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join(TSTPath, "ethtool/eno1_driver.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseEthtoolDriver(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &EthtoolDriver{
+		Driver:          "igb",
+		Version:         "5.4.0-k",
+		FirmwareVersion: "1.67, 0x80000d66, 16.5.20",
+		BusInfo:         "0000:02:00.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEthtoolDriver() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseEthtoolDriver([]byte("Cannot get driver information: Operation not supported\n")); err == nil {
+		t.Error("ParseEthtoolDriver() accepted output without a driver")
+	}
+}
+
+func TestParseLLDPCtl(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join(TSTPath, "lldpctl/keyvalue.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]*LLDPNeighbor{
+		"eno1": {
+			ChassisName:     "tor-r12.dc1",
+			ChassisID:       "00:1c:73:aa:bb:cc",
+			PortID:          "Ethernet12",
+			PortDescription: "r12-node03 eno1",
+		},
+		"eno2.100": {
+			ChassisID: "00:1c:73:dd:ee:ff",
+			PortID:    "00:1c:73:dd:ee:01",
+		},
+	}
+	if got := ParseLLDPCtl(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLLDPCtl() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseIPMIMCInfo(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join(TSTPath, "ipmitool/mc_info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseIPMIMCInfo(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &BMCInfo{Manufacturer: "DELL Inc", FirmwareVersion: "2.52", IPMIVersion: "2.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseIPMIMCInfo() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseSmartctl(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		want    *DiskHealth
+		wantErr bool
+	}{
+		{
+			name: "ATA SSD",
+			file: "smartctl/ata_ssd.txt",
+			want: &DiskHealth{
+				Device:             "sda",
+				Model:              "Samsung SSD 840 PRO Series",
+				Serial:             "S1AXNSADB03901H",
+				HealthPassed:       boolPtr(true),
+				ReallocatedSectors: int64Ptr(3),
+				PowerOnHours:       int64Ptr(37625),
+				WearLevelPercent:   int64Ptr(6),
+			},
+		},
+		{
+			name: "failing ATA HDD",
+			file: "smartctl/ata_hdd_failing.txt",
+			want: &DiskHealth{
+				Device:             "sda",
+				Model:              "ST2000DM001-1CH164",
+				Serial:             "Z1E5KX4P",
+				HealthPassed:       boolPtr(false),
+				ReallocatedSectors: int64Ptr(61224),
+				PowerOnHours:       int64Ptr(39744),
+			},
+		},
+		{
+			name: "NVMe",
+			file: "smartctl/nvme.txt",
+			want: &DiskHealth{
+				Device:           "sda",
+				Model:            "Samsung SSD 960 EVO 500GB",
+				Serial:           "S3EUNX0J612345",
+				HealthPassed:     boolPtr(true),
+				PowerOnHours:     int64Ptr(4521),
+				WearLevelPercent: int64Ptr(7),
+				MediaErrors:      int64Ptr(0),
+			},
+		},
+		{
+			name: "SCSI",
+			file: "smartctl/scsi.txt",
+			want: &DiskHealth{
+				Device:             "sda",
+				Model:              "ST600MM0006",
+				Serial:             "S0M1EXAMPLE",
+				HealthPassed:       boolPtr(true),
+				ReallocatedSectors: int64Ptr(12),
+				PowerOnHours:       int64Ptr(28715),
+			},
+		},
+		{
+			name:    "virtual disk without SMART",
+			file:    "smartctl/virtual.txt",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := ioutil.ReadFile(filepath.Join(TSTPath, tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ParseSmartctl("sda", out)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. ParseSmartctl() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. ParseSmartctl() = %s, want %s", tt.name, describeHealth(got), describeHealth(tt.want))
+		}
+	}
+}
+
+func describeHealth(h *DiskHealth) string {
+	if h == nil {
+		return "<nil>"
+	}
+	deref := func(v *int64) string {
+		if v == nil {
+			return "nil"
+		}
+		return fmt.Sprintf("%d", *v)
+	}
+	passed := "nil"
+	if h.HealthPassed != nil {
+		passed = fmt.Sprintf("%t", *h.HealthPassed)
+	}
+	return fmt.Sprintf("{%s %q %q passed=%s realloc=%s hours=%s wear=%s media=%s}",
+		h.Device, h.Model, h.Serial, passed,
+		deref(h.ReallocatedSectors), deref(h.PowerOnHours), deref(h.WearLevelPercent), deref(h.MediaErrors))
+}
+
+func TestSMARTCollector(t *testing.T) {
+	report := &Report{Storage: BlockDevices{BlockDevices: []*BlockDevice{
+		{Name: "sda", KName: "sda", Type: "disk"},
+		{Name: "sda1", KName: "sda1", Type: "part"},
+		{Name: "sdb", KName: "sdb", Type: "disk"},
+		{Name: "sr0", KName: "sr0", Type: "rom"},
+	}}}
+
+	collector := &SMARTCollector{Run: fakeRunner(map[string]string{
+		"smartctl -H -i -A /dev/sda": "smartctl/ata_ssd.txt",
+		"smartctl -H -i -A /dev/sdb": "smartctl/virtual.txt",
+	})}
+
+	RunCollectors(report, []Collector{collector})
+
+	if len(report.DiskHealth) != 1 || report.DiskHealth[0].Device != "sda" {
+		t.Errorf("expected SMART data for sda only, got %+v", report.DiskHealth)
+	}
+	if !strings.Contains(report.CollectorErrors["smart"], "sdb") {
+		t.Errorf("expected sdb to be reported as failed, got %v", report.CollectorErrors)
+	}
+}
+
+func TestSMARTCollectorWithoutSmartctl(t *testing.T) {
+	report := &Report{Storage: BlockDevices{BlockDevices: []*BlockDevice{
+		{Name: "sda", KName: "sda", Type: "disk"},
+	}}}
+
+	RunCollectors(report, []Collector{&SMARTCollector{Run: fakeRunner(nil)}})
+
+	if report.DiskHealth != nil {
+		t.Errorf("expected no SMART data, got %+v", report.DiskHealth)
+	}
+	if report.CollectorErrors["smart"] != "smartctl not available" {
+		t.Errorf("unexpected collector errors %v", report.CollectorErrors)
+	}
+}
+
+// makeSysfsNet lays out a fake /sys/class/net with one physical NIC and a
+// loopback device
+func makeSysfsNet(t *testing.T) string {
+	root, err := ioutil.TempDir("", "prospector-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"class/net/eno1/address":    "0c:c4:7a:12:34:56\n",
+		"class/net/eno1/speed":      "100\n",
+		"class/net/eno1/duplex":     "half\n",
+		"class/net/eno1/carrier":    "1\n",
+		"class/net/lo/address":      "00:00:00:00:00:00\n",
+		"class/net/lo/carrier":      "1\n",
+		"class/dmi/id/bios_vendor":  "American Megatrends Inc.\n",
+		"class/dmi/id/bios_version": "2.0b\n",
+		"class/dmi/id/bios_date":    "09/13/2016\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "devices/pci0000:00/0000:02:00.0/driver"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "devices/pci0000:00/0000:02:00.0"), filepath.Join(root, "class/net/eno1/device")); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestNetworkCollector(t *testing.T) {
+	root := makeSysfsNet(t)
+	defer os.RemoveAll(root)
+
+	t.Run("with ethtool", func(t *testing.T) {
+		report := &Report{}
+		collector := &NetworkCollector{
+			SysfsRoot: root,
+			Run: fakeRunner(map[string]string{
+				"ethtool eno1":        "ethtool/eno1.txt",
+				"ethtool -i eno1":     "ethtool/eno1_driver.txt",
+				"lldpctl -f keyvalue": "lldpctl/keyvalue.txt",
+			}),
+		}
+		if err := collector.Collect(report); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []*NetworkInterface{{
+			Name:            "eno1",
+			MAC:             "0c:c4:7a:12:34:56",
+			Driver:          "igb",
+			DriverVersion:   "5.4.0-k",
+			FirmwareVersion: "1.67, 0x80000d66, 16.5.20",
+			BusInfo:         "0000:02:00.0",
+			SpeedMbps:       1000,
+			Duplex:          "full",
+			Link:            true,
+			Neighbor: &LLDPNeighbor{
+				ChassisName:     "tor-r12.dc1",
+				ChassisID:       "00:1c:73:aa:bb:cc",
+				PortID:          "Ethernet12",
+				PortDescription: "r12-node03 eno1",
+			},
+		}}
+		if !reflect.DeepEqual(report.Network, want) {
+			t.Errorf("Network = %+v, want %+v", report.Network[0], want[0])
+		}
+	})
+
+	t.Run("without ethtool", func(t *testing.T) {
+		report := &Report{}
+		collector := &NetworkCollector{SysfsRoot: root, Run: fakeRunner(nil)}
+		if err := collector.Collect(report); err == nil {
+			t.Error("expected missing ethtool to be reported")
+		}
+
+		want := []*NetworkInterface{{
+			Name:      "eno1",
+			MAC:       "0c:c4:7a:12:34:56",
+			SpeedMbps: 100,
+			Duplex:    "half",
+			Link:      true,
+		}}
+		if !reflect.DeepEqual(report.Network, want) {
+			t.Errorf("Network = %+v, want %+v", report.Network[0], want[0])
+		}
+	})
+}
+
+func TestFirmwareCollector(t *testing.T) {
+	root := makeSysfsNet(t)
+	defer os.RemoveAll(root)
+
+	report := &Report{}
+	collector := &FirmwareCollector{
+		SysfsRoot: root,
+		Run:       fakeRunner(map[string]string{"ipmitool mc info": "ipmitool/mc_info.txt"}),
+	}
+	if err := collector.Collect(report); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Firmware{
+		BIOSVendor:         "American Megatrends Inc.",
+		BIOSVersion:        "2.0b",
+		BIOSDate:           "09/13/2016",
+		BMCManufacturer:    "DELL Inc",
+		BMCFirmwareVersion: "2.52",
+		IPMIVersion:        "2.0",
+	}
+	if !reflect.DeepEqual(report.Firmware, want) {
+		t.Errorf("Firmware = %+v, want %+v", report.Firmware, want)
+	}
+
+	// A host without a BMC still gets its BIOS versions reported
+	report = &Report{}
+	collector.Run = fakeRunner(map[string]string{"ipmitool mc info": ""})
+	if err := collector.Collect(report); err != nil {
+		t.Errorf("missing BMC should not be an error, got %v", err)
+	}
+	if report.Firmware.BIOSVersion != "2.0b" || report.Firmware.BMCFirmwareVersion != "" {
+		t.Errorf("Firmware = %+v", report.Firmware)
+	}
+}
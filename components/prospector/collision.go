@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//MACAddresses lists the MAC addresses of the NICs in the report, sorted. The
+//network section is used when present, the device tree otherwise.
+func (report *Report) MACAddresses() []string {
+	seen := make(map[string]bool)
+
+	for _, nic := range report.Network {
+		if nic.MAC != "" {
+			seen[strings.ToLower(nic.MAC)] = true
+		}
+	}
+
+	if len(seen) == 0 && report.System != nil && report.System.System != nil {
+		report.System.System.collectMACAddresses(seen)
+	}
+
+	macs := make([]string, 0, len(seen))
+	for mac := range seen {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+//collectMACAddresses adds the serials of network devices, which lshw sets to
+//their MAC address
+func (device *Device) collectMACAddresses(macs map[string]bool) {
+	if device.Class == "network" && device.Serial != "" {
+		macs[strings.ToLower(device.Serial)] = true
+	}
+
+	for _, child := range device.Devices {
+		child.collectMACAddresses(macs)
+	}
+}
+
+//CollisionSource is one of the machines sharing a UUID
+type CollisionSource struct {
+	MACs []string `json:"macs"`
+	//Reports names the reports which came from this machine
+	Reports []string `json:"reports"`
+}
+
+//Collision is a UUID computed for more than one machine. This happens for
+//cloned VMs, whose UUID falls back to the MAC of the first NIC, and for hosts
+//whose DMI data lacks serial numbers.
+type Collision struct {
+	UUID    string             `json:"uuid"`
+	Sources []*CollisionSource `json:"sources"`
+}
+
+//FindCollisions groups reports, keyed by name, by their UUID and returns the
+//UUIDs reported by machines with different MAC addresses. Clones which also
+//share their MAC addresses can not be told apart from a single machine.
+func FindCollisions(reports map[string]*Report) ([]*Collision, error) {
+	byUUID := make(map[string]map[string]*CollisionSource)
+
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		report := reports[name]
+		uuid, err := report.System.GetUUID()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		uuidString := uuid.ToString()
+		if byUUID[uuidString] == nil {
+			byUUID[uuidString] = make(map[string]*CollisionSource)
+		}
+
+		macs := report.MACAddresses()
+		key := strings.Join(macs, ",")
+		source, ok := byUUID[uuidString][key]
+		if !ok {
+			source = &CollisionSource{MACs: macs}
+			byUUID[uuidString][key] = source
+		}
+		source.Reports = append(source.Reports, name)
+	}
+
+	collisions := []*Collision{}
+	for uuid, sources := range byUUID {
+		if len(sources) < 2 {
+			continue
+		}
+
+		collision := &Collision{UUID: uuid}
+		for _, source := range sources {
+			collision.Sources = append(collision.Sources, source)
+		}
+		sort.Slice(collision.Sources, func(i, j int) bool {
+			return collision.Sources[i].Reports[0] < collision.Sources[j].Reports[0]
+		})
+		collisions = append(collisions, collision)
+	}
+
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].UUID < collisions[j].UUID })
+	return collisions, nil
+}
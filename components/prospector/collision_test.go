@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func loadCollisionTestReport(t *testing.T, file string, macs ...string) *Report {
+	report, err := LoadReportFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mac := range macs {
+		report.Network = append(report.Network, &NetworkInterface{MAC: mac})
+	}
+	return report
+}
+
+func TestReportMACAddresses(t *testing.T) {
+	fromTree := loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml")
+	if macs := fromTree.MACAddresses(); len(macs) == 0 {
+		t.Errorf("no MAC addresses found in the device tree")
+	}
+
+	fromNetwork := loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:AA:00:02", "08:00:27:aa:00:01")
+	want := []string{"08:00:27:aa:00:01", "08:00:27:aa:00:02"}
+	if macs := fromNetwork.MACAddresses(); !reflect.DeepEqual(macs, want) {
+		t.Errorf("MACAddresses() = %v, want %v", macs, want)
+	}
+}
+
+func TestFindCollisions(t *testing.T) {
+	tests := []struct {
+		name        string
+		reports     map[string]*Report
+		wantSources [][]string
+	}{
+		{
+			name: "distinct hosts",
+			reports: map[string]*Report{
+				"node1":  loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml"),
+				"laptop": loadCollisionTestReport(t, TSTPath+"/json/laptop.json"),
+			},
+		},
+		{
+			name: "same host reported twice",
+			reports: map[string]*Report{
+				"boot":  loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:aa:00:01"),
+				"agent": loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:aa:00:01"),
+			},
+		},
+		{
+			name: "clones with different MACs",
+			reports: map[string]*Report{
+				"clone-a":       loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:aa:00:01"),
+				"clone-a-later": loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:aa:00:01"),
+				"clone-b":       loadCollisionTestReport(t, TSTPath+"/vbox/node1.xml", "08:00:27:bb:00:01"),
+				"laptop":        loadCollisionTestReport(t, TSTPath+"/json/laptop.json"),
+			},
+			wantSources: [][]string{{"clone-a", "clone-a-later"}, {"clone-b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		collisions, err := FindCollisions(tt.reports)
+		if err != nil {
+			t.Errorf("%q. FindCollisions() error = %v", tt.name, err)
+			continue
+		}
+
+		if len(tt.wantSources) == 0 {
+			if len(collisions) != 0 {
+				t.Errorf("%q. FindCollisions() = %v, want none", tt.name, collisions)
+			}
+			continue
+		}
+
+		if len(collisions) != 1 {
+			t.Errorf("%q. got %d collisions, want 1", tt.name, len(collisions))
+			continue
+		}
+
+		var sources [][]string
+		for _, source := range collisions[0].Sources {
+			sources = append(sources, source.Reports)
+		}
+		if !reflect.DeepEqual(sources, tt.wantSources) {
+			t.Errorf("%q. sources = %v, want %v", tt.name, sources, tt.wantSources)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+//Firmware lists the versions of the platform firmware
+type Firmware struct {
+	BIOSVendor  string `json:"bios_vendor,omitempty"`
+	BIOSVersion string `json:"bios_version,omitempty"`
+	BIOSDate    string `json:"bios_date,omitempty"`
+
+	BMCManufacturer    string `json:"bmc_manufacturer,omitempty"`
+	BMCFirmwareVersion string `json:"bmc_firmware_version,omitempty"`
+	IPMIVersion        string `json:"ipmi_version,omitempty"`
+}
+
+//BMCInfo is the output of `ipmitool mc info`
+type BMCInfo struct {
+	Manufacturer    string
+	FirmwareVersion string
+	IPMIVersion     string
+}
+
+//ParseIPMIMCInfo parses the output of `ipmitool mc info`
+func ParseIPMIMCInfo(out []byte) (*BMCInfo, error) {
+	values := parseKeyValueLines(out, ":")
+	if values["firmware revision"] == "" {
+		return nil, errors.New("ipmitool output does not contain a firmware revision")
+	}
+
+	return &BMCInfo{
+		Manufacturer:    values["manufacturer name"],
+		FirmwareVersion: values["firmware revision"],
+		IPMIVersion:     values["ipmi version"],
+	}, nil
+}
+
+//FirmwareCollector reads the BIOS versions from the DMI tables in sysfs and
+//asks the BMC for its version with ipmitool. Most VMs and many desktops have
+//no BMC, in which case only the BIOS part is filled in.
+type FirmwareCollector struct {
+	Run       CommandRunner
+	SysfsRoot string
+}
+
+func (c *FirmwareCollector) Name() string {
+	return "firmware"
+}
+
+func (c *FirmwareCollector) Collect(report *Report) error {
+	dmiDir := filepath.Join(c.SysfsRoot, "class", "dmi", "id")
+
+	firmware := &Firmware{
+		BIOSVendor:  readSysfsValue(dmiDir, "bios_vendor"),
+		BIOSVersion: readSysfsValue(dmiDir, "bios_version"),
+		BIOSDate:    readSysfsValue(dmiDir, "bios_date"),
+	}
+	report.Firmware = firmware
+
+	out, err := c.Run("ipmitool", "mc", "info")
+	if err != nil {
+		if isCommandMissing(err) {
+			return errors.New("ipmitool not available, BMC version not collected")
+		}
+		// No BMC present is normal and not worth reporting
+		return nil
+	}
+
+	bmc, err := ParseIPMIMCInfo(out)
+	if err != nil {
+		return fmt.Errorf("Failed to parse BMC info due to %s", err)
+	}
+
+	firmware.BMCManufacturer = bmc.Manufacturer
+	firmware.BMCFirmwareVersion = bmc.FirmwareVersion
+	firmware.IPMIVersion = bmc.IPMIVersion
+
+	return nil
+}
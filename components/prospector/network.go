@@ -0,0 +1,262 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//NetworkInterface describes the link state and driver of a physical NIC
+type NetworkInterface struct {
+	Name            string `json:"name"`
+	MAC             string `json:"mac"`
+	Driver          string `json:"driver,omitempty"`
+	DriverVersion   string `json:"driver_version,omitempty"`
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+	BusInfo         string `json:"bus_info,omitempty"`
+	//SpeedMbps is 0 when the speed is unknown, e.g. when the link is down
+	SpeedMbps int    `json:"speed_mbps,omitempty"`
+	Duplex    string `json:"duplex,omitempty"`
+	Link      bool   `json:"link"`
+	//Neighbor is only known when lldpd runs on the host and the switch
+	//speaks LLDP (since 2.2)
+	Neighbor *LLDPNeighbor `json:"lldp_neighbor,omitempty"`
+}
+
+//LLDPNeighbor is the switch port a NIC is plugged into, as the switch
+//advertises it over LLDP. Teamster places nodes in racks by the name of
+//their top-of-rack switch.
+type LLDPNeighbor struct {
+	ChassisName     string `json:"chassis_name,omitempty"`
+	ChassisID       string `json:"chassis_id,omitempty"`
+	PortID          string `json:"port_id,omitempty"`
+	PortDescription string `json:"port_description,omitempty"`
+}
+
+//EthtoolLink is the part of `ethtool <iface>` output prospector cares about
+type EthtoolLink struct {
+	SpeedMbps int
+	Duplex    string
+	Link      bool
+}
+
+//EthtoolDriver is the output of `ethtool -i <iface>`
+type EthtoolDriver struct {
+	Driver          string
+	Version         string
+	FirmwareVersion string
+	BusInfo         string
+}
+
+//ParseEthtoolLink parses the output of `ethtool <iface>`
+func ParseEthtoolLink(out []byte) (*EthtoolLink, error) {
+	values := parseKeyValueLines(out, ":")
+	if len(values) == 0 {
+		return nil, errors.New("Empty ethtool output")
+	}
+
+	link := &EthtoolLink{
+		SpeedMbps: parseSpeed(values["speed"]),
+		Duplex:    normalizeDuplex(values["duplex"]),
+		Link:      values["link detected"] == "yes",
+	}
+	return link, nil
+}
+
+//ParseEthtoolDriver parses the output of `ethtool -i <iface>`
+func ParseEthtoolDriver(out []byte) (*EthtoolDriver, error) {
+	values := parseKeyValueLines(out, ":")
+	if values["driver"] == "" {
+		return nil, errors.New("ethtool output does not name a driver")
+	}
+
+	return &EthtoolDriver{
+		Driver:          values["driver"],
+		Version:         values["version"],
+		FirmwareVersion: values["firmware-version"],
+		BusInfo:         values["bus-info"],
+	}, nil
+}
+
+//ParseLLDPCtl parses the output of `lldpctl -f keyvalue` into the neighbor
+//seen on each interface
+func ParseLLDPCtl(out []byte) map[string]*LLDPNeighbor {
+	neighbors := make(map[string]*LLDPNeighbor)
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "lldp.") {
+			continue
+		}
+		key := strings.TrimPrefix(parts[0], "lldp.")
+
+		//Interface names may contain dots (VLANs), so the interface is
+		//whatever comes before the chassis or port section
+		var iface, field string
+		for _, section := range []string{".chassis.", ".port."} {
+			if idx := strings.Index(key, section); idx > 0 {
+				iface, field = key[:idx], key[idx+1:]
+				break
+			}
+		}
+		if iface == "" {
+			continue
+		}
+
+		neighbor, ok := neighbors[iface]
+		if !ok {
+			neighbor = new(LLDPNeighbor)
+			neighbors[iface] = neighbor
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch field {
+		case "chassis.name":
+			neighbor.ChassisName = value
+		case "chassis.mac", "chassis.local":
+			if neighbor.ChassisID == "" {
+				neighbor.ChassisID = value
+			}
+		case "port.ifname", "port.local", "port.mac":
+			if neighbor.PortID == "" {
+				neighbor.PortID = value
+			}
+		case "port.descr":
+			neighbor.PortDescription = value
+		}
+	}
+	return neighbors
+}
+
+//parseSpeed turns "1000Mb/s" or sysfs "1000" into Mb/s, 0 if unknown
+func parseSpeed(speed string) int {
+	speed = strings.TrimSuffix(strings.TrimSpace(speed), "Mb/s")
+	mbps, err := strconv.Atoi(speed)
+	if err != nil || mbps < 0 {
+		return 0
+	}
+	return mbps
+}
+
+func normalizeDuplex(duplex string) string {
+	switch strings.ToLower(strings.TrimSpace(duplex)) {
+	case "full":
+		return "full"
+	case "half":
+		return "half"
+	}
+	return ""
+}
+
+//NetworkCollector reports every NIC backed by a device, i.e. skipping
+//loopback, bridges, veths and the like. ethtool supplies the details; without
+//it the collector falls back to what sysfs exposes.
+type NetworkCollector struct {
+	Run       CommandRunner
+	SysfsRoot string
+}
+
+func (c *NetworkCollector) Name() string {
+	return "network"
+}
+
+func (c *NetworkCollector) Collect(report *Report) error {
+	netDir := filepath.Join(c.SysfsRoot, "class", "net")
+	entries, err := ioutil.ReadDir(netDir)
+	if err != nil {
+		return err
+	}
+
+	var ifaces []*NetworkInterface
+	ethtoolMissing := false
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, err := os.Stat(filepath.Join(netDir, name, "device")); err != nil {
+			continue
+		}
+
+		iface := &NetworkInterface{
+			Name:      name,
+			MAC:       readSysfsValue(netDir, name, "address"),
+			SpeedMbps: parseSpeed(readSysfsValue(netDir, name, "speed")),
+			Duplex:    normalizeDuplex(readSysfsValue(netDir, name, "duplex")),
+			Link:      readSysfsValue(netDir, name, "carrier") == "1",
+		}
+		if driver, err := os.Readlink(filepath.Join(netDir, name, "device", "driver")); err == nil {
+			iface.Driver = filepath.Base(driver)
+		}
+
+		if !ethtoolMissing {
+			if err := c.ethtool(iface); isCommandMissing(err) {
+				ethtoolMissing = true
+			}
+		}
+
+		ifaces = append(ifaces, iface)
+	}
+
+	//lldpd is optional, so failing to reach it is not an error
+	if out, err := c.Run("lldpctl", "-f", "keyvalue"); err == nil {
+		neighbors := ParseLLDPCtl(out)
+		for _, iface := range ifaces {
+			iface.Neighbor = neighbors[iface.Name]
+		}
+	}
+
+	report.Network = ifaces
+
+	if ethtoolMissing {
+		return errors.New("ethtool not available, only sysfs data was collected")
+	}
+	return nil
+}
+
+//ethtool fills in the interface from ethtool, keeping the sysfs values for
+//anything ethtool does not know
+func (c *NetworkCollector) ethtool(iface *NetworkInterface) error {
+	out, err := c.Run("ethtool", iface.Name)
+	if isCommandMissing(err) {
+		return err
+	}
+	if link, err := ParseEthtoolLink(out); err == nil {
+		if link.SpeedMbps != 0 {
+			iface.SpeedMbps = link.SpeedMbps
+		}
+		if link.Duplex != "" {
+			iface.Duplex = link.Duplex
+		}
+		iface.Link = iface.Link || link.Link
+	}
+
+	out, err = c.Run("ethtool", "-i", iface.Name)
+	if isCommandMissing(err) {
+		return err
+	}
+	if driver, err := ParseEthtoolDriver(out); err == nil {
+		iface.Driver = driver.Driver
+		iface.DriverVersion = driver.Version
+		iface.FirmwareVersion = driver.FirmwareVersion
+		iface.BusInfo = driver.BusInfo
+	}
+
+	return nil
+}
@@ -16,7 +16,169 @@ limitations under the License.
 
 package prospector
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ReportSchemaVersion is the version of the report format produced by this
+//build. The major part is bumped on incompatible changes, the minor part when
+//fields are added. Consumers must reject major versions they do not know.
+//The format is described by report.schema.json.
+const ReportSchemaVersion = "2.2"
+
+//legacyReportSchemaVersion is assigned to reports which predate versioning
+const legacyReportSchemaVersion = "1.0"
+
+//AgentVersion is the version of the prospector build producing the reports.
+//It is overridden at link time with -X.
+var AgentVersion = "dev"
+
+//Report is the document a worker sends to teamster to identify itself
 type Report struct {
+	SchemaVersion string    `json:"schema_version"`
+	AgentVersion  string    `json:"agent_version,omitempty"`
+	CollectedAt   time.Time `json:"collected_at"`
+	Hostname      string    `json:"hostname,omitempty"`
+
 	System  *DeviceTree  `json:"hardware"`
 	Storage BlockDevices `json:"storage"`
+
+	//Sections added in 2.1, filled in by the collectors
+	Network         []*NetworkInterface `json:"network,omitempty"`
+	Firmware        *Firmware           `json:"firmware,omitempty"`
+	DiskHealth      []*DiskHealth       `json:"disk_health,omitempty"`
+	CollectorErrors map[string]string   `json:"collector_errors,omitempty"`
+}
+
+//UnsupportedSchemaError is returned by ParseReport for reports whose major
+//schema version is not understood by this build
+type UnsupportedSchemaError struct {
+	Version string
+}
+
+func (e *UnsupportedSchemaError) Error() string {
+	return fmt.Sprintf("unsupported report schema version %q (supported: 1.x, 2.x)", e.Version)
+}
+
+//NewReport wraps the device tree and block devices of the current host in a
+//report envelope stamped with the schema and agent versions
+func NewReport(system *DeviceTree, storage BlockDevices) *Report {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = ""
+	}
+
+	return &Report{
+		SchemaVersion: ReportSchemaVersion,
+		AgentVersion:  AgentVersion,
+		CollectedAt:   time.Now().UTC(),
+		Hostname:      hostname,
+		System:        system,
+		Storage:       storage,
+	}
+}
+
+//ParseReport decodes a report of any supported schema version. Reports
+//without a schema_version are treated as version 1.0, which only carried the
+//hardware and storage sections.
+func ParseReport(data []byte) (*Report, error) {
+	var envelope struct {
+		SchemaVersion string `json:"schema_version"`
+	}
+
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("Failed to parse report due to %s", err)
+	}
+
+	major, err := schemaMajorVersion(envelope.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(Report)
+
+	switch major {
+	case 1, 2:
+		if err := json.Unmarshal(data, report); err != nil {
+			return nil, fmt.Errorf("Failed to parse report due to %s", err)
+		}
+	default:
+		return nil, &UnsupportedSchemaError{Version: envelope.SchemaVersion}
+	}
+
+	if major == 1 {
+		report.SchemaVersion = legacyReportSchemaVersion
+	}
+
+	if report.System == nil || report.System.System == nil {
+		return nil, errors.New("Report does not contain a hardware tree")
+	}
+
+	return report, nil
+}
+
+//schemaMajorVersion extracts the major part of a "major.minor" version
+func schemaMajorVersion(version string) (int, error) {
+	if version == "" {
+		return 1, nil
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil || major < 1 {
+		return 0, &UnsupportedSchemaError{Version: version}
+	}
+
+	return major, nil
+}
+
+//LoadReportFile reads a saved report, or the lshw XML or device tree JSON of a
+//host, so that it can be inspected offline. Inputs without a storage section
+//yield a report without block devices.
+func LoadReportFile(filename string) (*Report, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '<' {
+		return reportFromTree(NewDeviceTree(data, "xml"))
+	}
+
+	var probe struct {
+		Hardware json.RawMessage `json:"hardware"`
+		System   json.RawMessage `json:"system"`
+	}
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s due to %s", filename, err)
+	}
+
+	switch {
+	case probe.Hardware != nil:
+		return ParseReport(data)
+	case probe.System != nil:
+		return reportFromTree(NewDeviceTree(data, "json"))
+	}
+
+	return nil, fmt.Errorf("%s is neither a report nor a device tree", filename)
+}
+
+func reportFromTree(tree *DeviceTree, err error) (*Report, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.System == nil {
+		return nil, errors.New("Input does not contain a device tree")
+	}
+
+	return &Report{SchemaVersion: ReportSchemaVersion, System: tree}, nil
 }
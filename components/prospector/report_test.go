@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseReport(t *testing.T) {
+	tests := []struct {
+		name             string
+		file             string
+		data             string
+		wantSchema       string
+		wantAgent        string
+		wantUnsupported  bool
+		wantErr          bool
+		wantUUID         string
+		wantBlockDevices int
+	}{
+		{
+			name:             "v1 report without version",
+			file:             TSTPath + "/report_teamster/report_lower_case_n.json",
+			wantSchema:       "1.0",
+			wantUUID:         "3d217465-7272-824e-7458-ab9ca9a9b985",
+			wantBlockDevices: 4,
+		},
+		{
+			name:             "v2 report",
+			file:             TSTPath + "/report_teamster/report_v2.json",
+			wantSchema:       "2.0",
+			wantAgent:        "0.2.1.x",
+			wantUUID:         "3d217465-7272-824e-7458-ab9ca9a9b985",
+			wantBlockDevices: 4,
+		},
+		{
+			name:       "newer minor version is accepted",
+			data:       `{"schema_version": "2.7", "hardware": {"system": {"id": "a", "class": "system", "serial": "123"}}, "future": true}`,
+			wantSchema: "2.7",
+		},
+		{
+			name:            "unknown major version",
+			data:            `{"schema_version": "3.0", "hardware": {"system": {"id": "a", "class": "system"}}}`,
+			wantUnsupported: true,
+			wantErr:         true,
+		},
+		{
+			name:            "garbage version",
+			data:            `{"schema_version": "banana"}`,
+			wantUnsupported: true,
+			wantErr:         true,
+		},
+		{
+			name:    "missing hardware",
+			data:    `{"schema_version": "2.0", "storage": {"blockdevices": []}}`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			data:    `{"schema_version": `,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		data := []byte(tt.data)
+		if tt.file != "" {
+			var err error
+			if data, err = ioutil.ReadFile(tt.file); err != nil {
+				t.Fatalf("%q. failed to read %s: %v", tt.name, tt.file, err)
+			}
+		}
+
+		report, err := ParseReport(data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. ParseReport() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if _, ok := err.(*UnsupportedSchemaError); ok != tt.wantUnsupported {
+			t.Errorf("%q. ParseReport() error = %v, want UnsupportedSchemaError %v", tt.name, err, tt.wantUnsupported)
+		}
+		if err != nil {
+			continue
+		}
+
+		if report.SchemaVersion != tt.wantSchema {
+			t.Errorf("%q. SchemaVersion = %s, want %s", tt.name, report.SchemaVersion, tt.wantSchema)
+		}
+		if report.AgentVersion != tt.wantAgent {
+			t.Errorf("%q. AgentVersion = %s, want %s", tt.name, report.AgentVersion, tt.wantAgent)
+		}
+		if len(report.Storage.BlockDevices) != tt.wantBlockDevices {
+			t.Errorf("%q. got %d block devices, want %d", tt.name, len(report.Storage.BlockDevices), tt.wantBlockDevices)
+		}
+		if tt.wantUUID != "" {
+			uuid, err := report.System.GetUUID()
+			if err != nil {
+				t.Errorf("%q. GetUUID() error = %v", tt.name, err)
+			} else if uuid.ToString() != tt.wantUUID {
+				t.Errorf("%q. UUID = %s, want %s", tt.name, uuid.ToString(), tt.wantUUID)
+			}
+		}
+	}
+}
+
+func TestNewReportRoundTrip(t *testing.T) {
+	devTree, err := LoadDeviceTree(TSTPath + "/vbox/node1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := NewReport(devTree, BlockDevices{})
+	if report.SchemaVersion != ReportSchemaVersion {
+		t.Errorf("SchemaVersion = %s, want %s", report.SchemaVersion, ReportSchemaVersion)
+	}
+	if report.CollectedAt.IsZero() {
+		t.Error("CollectedAt was not set")
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseReport(data)
+	if err != nil {
+		t.Fatalf("ParseReport() error = %v", err)
+	}
+	if !parsed.CollectedAt.Equal(report.CollectedAt) || parsed.Hostname != report.Hostname {
+		t.Errorf("envelope did not survive a round trip: %+v", parsed)
+	}
+}
+
+func TestLoadReportFile(t *testing.T) {
+	tests := []struct {
+		name             string
+		file             string
+		wantErr          bool
+		wantBlockDevices int
+	}{
+		{name: "saved report", file: TSTPath + "/report_teamster/report_v2.json", wantBlockDevices: 4},
+		{name: "lshw XML", file: TSTPath + "/vbox/node1.xml"},
+		{name: "device tree JSON", file: TSTPath + "/json/laptop.json"},
+		{name: "lsblk output is not a report", file: TSTPath + "/lsblk/test1.json", wantErr: true},
+		{name: "missing file", file: TSTPath + "/does-not-exist.json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		report, err := LoadReportFile(tt.file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q. LoadReportFile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if _, err := report.System.GetUUID(); err != nil {
+			t.Errorf("%q. GetUUID() error = %v", tt.name, err)
+		}
+		if len(report.Storage.BlockDevices) != tt.wantBlockDevices {
+			t.Errorf("%q. got %d block devices, want %d", tt.name, len(report.Storage.BlockDevices), tt.wantBlockDevices)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prospector
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//DiskHealth holds the SMART data of a disk. Attributes the drive does not
+//report are left nil, so that "unknown" is not mistaken for zero.
+type DiskHealth struct {
+	Device string `json:"device"`
+	Model  string `json:"model,omitempty"`
+	Serial string `json:"serial,omitempty"`
+
+	HealthPassed       *bool  `json:"health_passed,omitempty"`
+	ReallocatedSectors *int64 `json:"reallocated_sectors,omitempty"`
+	PowerOnHours       *int64 `json:"power_on_hours,omitempty"`
+	//WearLevelPercent is the share of the rated endurance used up (SSDs only)
+	WearLevelPercent *int64 `json:"wear_level_percent,omitempty"`
+	MediaErrors      *int64 `json:"media_errors,omitempty"`
+}
+
+//ATA attributes whose normalized value is the remaining life of an SSD
+var ataWearAttributes = map[string]bool{
+	"169": true, // Remaining_Lifetime_Perc
+	"177": true, // Wear_Leveling_Count
+	"202": true, // Percent_Lifetime_Remain
+	"231": true, // SSD_Life_Left
+	"233": true, // Media_Wearout_Indicator
+}
+
+//ParseSmartctl parses the output of `smartctl -H -i -A <device>` for ATA,
+//NVMe and SCSI drives
+func ParseSmartctl(device string, out []byte) (*DiskHealth, error) {
+	health := &DiskHealth{Device: device}
+	found := false
+	inATATable := false
+
+	for _, line := range strings.Split(string(out), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if inATATable {
+			if trimmed == "" {
+				inATATable = false
+				continue
+			}
+			if parseATAAttribute(trimmed, health) {
+				found = true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "ID#") {
+			inATATable = true
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "Device Model", "Model Number", "Product":
+			health.Model = value
+		case "Serial Number", "Serial number":
+			health.Serial = value
+		case "SMART overall-health self-assessment test result":
+			passed := value == "PASSED"
+			health.HealthPassed = &passed
+			found = true
+		case "SMART Health Status":
+			passed := value == "OK"
+			health.HealthPassed = &passed
+			found = true
+		case "Percentage Used":
+			health.WearLevelPercent = parseLeadingInt(value)
+			found = true
+		case "Power On Hours":
+			health.PowerOnHours = parseLeadingInt(value)
+			found = true
+		case "Media and Data Integrity Errors":
+			health.MediaErrors = parseLeadingInt(value)
+			found = true
+		case "Elements in grown defect list":
+			health.ReallocatedSectors = parseLeadingInt(value)
+			found = true
+		case "Accumulated power on time, hours":
+			// "hours:minutes 1234:56", split on the first colon above
+			fields := strings.Fields(value)
+			if len(fields) > 0 {
+				health.PowerOnHours = parseLeadingInt(fields[len(fields)-1])
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return nil, errors.New("smartctl output does not contain SMART data")
+	}
+
+	return health, nil
+}
+
+//parseATAAttribute handles one row of the ATA attribute table:
+//ID# ATTRIBUTE_NAME FLAG VALUE WORST THRESH TYPE UPDATED WHEN_FAILED RAW_VALUE
+func parseATAAttribute(row string, health *DiskHealth) bool {
+	fields := strings.Fields(row)
+	if len(fields) < 10 {
+		return false
+	}
+
+	id := fields[0]
+	raw := parseLeadingInt(fields[9])
+
+	switch {
+	case id == "5":
+		health.ReallocatedSectors = raw
+	case id == "9":
+		health.PowerOnHours = raw
+	case ataWearAttributes[id]:
+		if remaining := parseLeadingInt(fields[3]); remaining != nil && health.WearLevelPercent == nil {
+			used := 100 - *remaining
+			if used < 0 {
+				used = 0
+			}
+			health.WearLevelPercent = &used
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+//parseLeadingInt parses numbers like "1,234", "2%" or "13425h+12m+03.123s"
+func parseLeadingInt(s string) *int64 {
+	digits := ""
+	for _, c := range strings.Replace(s, ",", "", -1) {
+		if c < '0' || c > '9' {
+			break
+		}
+		digits += string(c)
+	}
+
+	if digits == "" {
+		return nil
+	}
+
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+//SMARTCollector runs smartctl against every disk in the storage section
+type SMARTCollector struct {
+	Run CommandRunner
+}
+
+func (c *SMARTCollector) Name() string {
+	return "smart"
+}
+
+func (c *SMARTCollector) Collect(report *Report) error {
+	var failed []string
+
+	for _, blkDevice := range report.Storage.BlockDevices {
+		if blkDevice.Type != "disk" {
+			continue
+		}
+
+		device := "/dev/" + blkDevice.KName
+		if blkDevice.KName == "" {
+			device = "/dev/" + blkDevice.Name
+		}
+
+		// smartctl uses its exit code as a bit mask of drive conditions, so
+		// a non-zero exit still comes with usable output
+		out, err := c.Run("smartctl", "-H", "-i", "-A", device)
+		if isCommandMissing(err) {
+			return errors.New("smartctl not available")
+		}
+
+		health, parseErr := ParseSmartctl(blkDevice.Name, out)
+		if parseErr != nil {
+			failed = append(failed, blkDevice.Name)
+			continue
+		}
+		report.DiskHealth = append(report.DiskHealth, health)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("No SMART data for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
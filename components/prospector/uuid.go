@@ -78,6 +78,36 @@ func (uuid1 *UUIDType) BytesDiffer(uuid2 *UUIDType) int {
 	return diffLen
 }
 
+//UUIDByteChange describes a byte which differs between two UUIDs
+type UUIDByteChange struct {
+	Position int `json:"position"`
+	//Level is the depth in the device tree of the devices hashed into the byte
+	Level int `json:"level"`
+	//Major is set for bytes identifying the server rather than its parts
+	Major bool `json:"major"`
+	From  byte `json:"from"`
+	To    byte `json:"to"`
+}
+
+//ChangedBytes lists the bytes in which uuid2 differs from uuid1
+func (uuid1 *UUIDType) ChangedBytes(uuid2 *UUIDType) []UUIDByteChange {
+	changes := []UUIDByteChange{}
+
+	for index, element := range uuid1 {
+		if uuid2[index] != element {
+			changes = append(changes, UUIDByteChange{
+				Position: index,
+				Level:    index / BytePerTreeLevel,
+				Major:    index < BytesForMajorComponents,
+				From:     element,
+				To:       uuid2[index],
+			})
+		}
+	}
+
+	return changes
+}
+
 //IsIdenticalTo tells if 2 UUIDs are identical
 func (uuid1 *UUIDType) IsIdenticalTo(uuid2 *UUIDType) bool {
 	if uuid1.BytesDiffer(uuid2) == 0 {
@@ -80,6 +80,33 @@ func TestUUIDType_BytesDiffer(t *testing.T) {
 	}
 }
 
+func TestUUIDType_ChangedBytes(t *testing.T) {
+	uuidA := UUIDType{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	uuidB := UUIDType{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 0}
+	uuidC := UUIDType{1, 22, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 0}
+
+	tests := []struct {
+		name  string
+		uuid1 *UUIDType
+		uuid2 *UUIDType
+		want  []UUIDByteChange
+	}{
+		{name: "equal test", uuid1: &uuidA, uuid2: &uuidA, want: []UUIDByteChange{}},
+		{name: "minor change", uuid1: &uuidA, uuid2: &uuidB, want: []UUIDByteChange{
+			{Position: 15, Level: 3, Major: false, From: 16, To: 0},
+		}},
+		{name: "major and minor change", uuid1: &uuidA, uuid2: &uuidC, want: []UUIDByteChange{
+			{Position: 1, Level: 0, Major: true, From: 2, To: 22},
+			{Position: 15, Level: 3, Major: false, From: 16, To: 0},
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.uuid1.ChangedBytes(tt.uuid2); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q. UUIDType.ChangedBytes() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 func Test_GetUUID(t *testing.T) {
 
 	testFileName := TSTPath + "/json/laptop.json"
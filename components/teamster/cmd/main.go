@@ -33,6 +33,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/paxautoma/operos/components/teamster/pkg/cluster"
+	"github.com/paxautoma/operos/components/teamster/pkg/license"
 	"github.com/paxautoma/operos/components/teamster/pkg/teamster"
 )
 
@@ -42,10 +43,14 @@ func main() {
 
 	installID := flag.String("install-id", "", "the Install ID of the Operos Cluster")
 	listenAddr := flag.String("listen-addr", ":2680", "the address:port that teamster should bind to for HTTP/1")
+	listenTLS := flag.String("listen-tls", "", "the address:port that teamster should bind to for HTTPS, where nodes identify themselves with their certificates")
+	tlsCert := flag.String("tls-cert", "", "the certificate file to serve HTTPS with")
+	tlsKey := flag.String("tls-key", "", "the private key file to serve HTTPS with")
 	listenGrpc := flag.String("listen-grpc", ":2681", "the address:port that teamster should bind to for gRPC")
 	etcdCluster := flag.String("etcd-cluster", "localhost:2379", "the hostname:port of the etcd cluster to connect to")
 	shadowFile := flag.String("shadow-file", "/etc/shadow", "name of the shadow file to use to obtain root password")
 	rootAccount := flag.String("root", "root", "user name of the user whose password hash will be sent to worker nodes")
+	collisionWindow := flag.Duration("collision-window", teamster.DefaultCollisionWindow, "how long a machine reporting a node UUID blocks other machines from obtaining it")
 
 	flag.Parse()
 
@@ -72,6 +77,19 @@ func main() {
 	}
 
 	api := teamster.NewTeamsterAPI(oc, *shadowFile, *rootAccount)
+	api.SetCollisionWindow(*collisionWindow)
+
+	// The key is built in, so that whoever runs teamster can not verify
+	// licenses against a key of their own
+	if license.VendorKey != "" {
+		key, err := license.ParsePublicKey(license.VendorKey)
+		if err != nil {
+			log.Fatalf("error: Invalid license key: %s", err)
+		}
+		api.SetLicenseKey(key)
+	} else {
+		log.Printf("warning: No license key; licenses can not be installed")
+	}
 
 	grpcServer := grpc.NewServer(
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
@@ -94,5 +112,17 @@ func main() {
 	var handler http.Handler
 	handler = handlers.RecoveryHandler(handlers.RecoveryLogger(logger))(api.GetHttpHandler())
 	handler = handlers.LoggingHandler(logger.Writer(), handler)
+
+	if *listenTLS != "" {
+		tlsConfig, err := api.NodeTLSConfig(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("error: Unable to set up HTTPS: %s", err)
+		}
+		server := &http.Server{Addr: *listenTLS, Handler: handler, TLSConfig: tlsConfig}
+		go func() {
+			log.Fatal(server.ListenAndServeTLS("", ""))
+		}()
+	}
+
 	log.Fatal(http.ListenAndServe(*listenAddr, handler))
 }
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Alert is a condition in the cluster which needs the attention of an
+// operator. Alerts are kept in etcd under cluster/<install id>/alerts/, one
+// per kind and subject, the latest occurrence replacing earlier ones.
+type Alert struct {
+	Kind     string      `json:"kind"`
+	Subject  string      `json:"subject"`
+	Message  string      `json:"message"`
+	Details  interface{} `json:"details,omitempty"`
+	RaisedAt time.Time   `json:"raised_at"`
+}
+
+const AlertIdentityCollision = "identity-collision"
+
+func (cluster *OperosCluster) RaiseAlert(alert *Alert) error {
+	if alert.RaisedAt.IsZero() {
+		alert.RaisedAt = time.Now().UTC()
+	}
+
+	log.Printf("ALERT %s (%s): %s", alert.Kind, alert.Subject, alert.Message)
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.etcdRequestTimeout)
+	defer cancel()
+
+	key := fmt.Sprintf("cluster/%s/alerts/%s/%s", cluster.InstallID, alert.Kind, alert.Subject)
+	_, err = cluster.etcd.Put(ctx, key, string(data))
+	return err
+}
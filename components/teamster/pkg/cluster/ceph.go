@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/paxautoma/operos/components/prospector"
+)
+
+const (
+	// MaxPGsPerOSD is how many placement groups, counting each replica, an
+	// OSD may hold before Ceph refuses to create more pools
+	MaxPGsPerOSD = 200
+
+	// DefaultPGNum is the number of placement groups of new pools
+	DefaultPGNum = 64
+
+	// KubeCephUser is the Ceph user the RBD provisioner and kubelets use
+	KubeCephUser = "client.kube"
+)
+
+// ErrPoolExists is returned when creating a pool which already exists
+var ErrPoolExists = errors.New("pool already exists")
+
+// CapacityError is returned when the OSDs of the cluster can not hold a pool
+type CapacityError string
+
+func (e CapacityError) Error() string {
+	return string(e)
+}
+
+// CephReport holds the JSON output of the ceph commands which describe the
+// state of the storage cluster
+type CephReport struct {
+	Status     []byte
+	OSDDump    []byte
+	OSDDF      []byte
+	DF         []byte
+	CrushRules []byte
+}
+
+// GetCephReport runs the ceph commands a storage overview is built from
+func GetCephReport() (*CephReport, error) {
+	report := &CephReport{}
+	for _, command := range []struct {
+		args []string
+		out  *[]byte
+	}{
+		{[]string{"status"}, &report.Status},
+		{[]string{"osd", "dump"}, &report.OSDDump},
+		{[]string{"osd", "df"}, &report.OSDDF},
+		{[]string{"df"}, &report.DF},
+		{[]string{"osd", "crush", "rule", "dump"}, &report.CrushRules},
+	} {
+		out, err := CephCommand(append(command.args, "-f", "json")...)
+		if err != nil {
+			return nil, fmt.Errorf("ceph %s failed: %s %s", strings.Join(command.args, " "), err, out)
+		}
+		*command.out = out
+	}
+	return report, nil
+}
+
+// OSDLocation tells which node and disk an OSD was created for
+type OSDLocation struct {
+	OSDID   string
+	OSDUUID string
+	Node    *Node
+	// Device is nil if the disk is missing from the latest report of the
+	// node
+	Device *prospector.BlockDevice
+}
+
+// OSDLocations returns where each OSD of the cluster came from, by OSD ID
+func (cluster *OperosCluster) OSDLocations() []OSDLocation {
+	var locations []OSDLocation
+	for _, node := range cluster.Nodes {
+		devices := node.osdDevices()
+		for osdUUID, osd := range node.OSDs {
+			locations = append(locations, OSDLocation{
+				OSDID:   osd.Id,
+				OSDUUID: osdUUID,
+				Node:    node,
+				Device:  devices[osdUUID],
+			})
+		}
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		a, errA := strconv.Atoi(locations[i].OSDID)
+		b, errB := strconv.Atoi(locations[j].OSDID)
+		if errA != nil || errB != nil {
+			return locations[i].OSDID < locations[j].OSDID
+		}
+		return a < b
+	})
+	return locations
+}
+
+// osdDevices maps the OSD UUIDs of the disks of a node to the disks, the
+// same way InventoryOSDs derives them
+func (node *Node) osdDevices() map[string]*prospector.BlockDevice {
+	devices := make(map[string]*prospector.BlockDevice)
+	if node.LatestReport == nil || node.Fingerprint == nil {
+		return devices
+	}
+
+	for _, blkDevice := range node.LatestReport.Storage.BlockDevices {
+		if blkDevice.Type != "disk" {
+			continue
+		}
+		if uuid, err := prospector.UUIDStringForBlkDevice(blkDevice, node.Fingerprint); err == nil {
+			devices[*uuid] = blkDevice
+		}
+	}
+	return devices
+}
+
+// cephPool is a pool as reported by `ceph osd dump`
+type cephPool struct {
+	Name  string `json:"pool_name"`
+	Size  int    `json:"size"`
+	PGNum int    `json:"pg_num"`
+}
+
+func listPools() ([]cephPool, error) {
+	out, err := CephCommand("osd", "dump", "-f", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the OSD map: %s", err)
+	}
+	var dump struct {
+		Pools []cephPool `json:"pools"`
+	}
+	if err := json.Unmarshal(out, &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse the OSD map: %s", err)
+	}
+	return dump.Pools, nil
+}
+
+// checkPoolCapacity tells whether a pool of size replicas and pgNum
+// placement groups fits on the OSDs of the cluster. Replicas are kept on
+// different hosts, so there must be as many hosts with OSDs as replicas.
+func checkPoolCapacity(hosts, osds int, pools []cephPool, size, pgNum int) error {
+	if size > hosts {
+		return CapacityError(fmt.Sprintf("only %d nodes have OSDs, which can not hold %d replicas", hosts, size))
+	}
+
+	pgs := size * pgNum
+	for _, pool := range pools {
+		pgs += pool.Size * pool.PGNum
+	}
+	if pgs > MaxPGsPerOSD*osds {
+		return CapacityError(fmt.Sprintf("%d OSDs can hold at most %d placement groups, and the pools would need %d", osds, MaxPGsPerOSD*osds, pgs))
+	}
+	return nil
+}
+
+// osdCapsWithPool adds RBD access to a pool to the OSD capabilities of a
+// Ceph user
+func osdCapsWithPool(caps, pool string) string {
+	grant := "profile rbd pool=" + pool
+	for _, cap := range strings.Split(caps, ",") {
+		if strings.TrimSpace(cap) == grant {
+			return caps
+		}
+	}
+	if strings.TrimSpace(caps) == "" {
+		return grant
+	}
+	return caps + ", " + grant
+}
+
+// CreatePool creates a replicated pool for RBD images, which Kubernetes may
+// use. pgNum is DefaultPGNum if zero.
+func (cluster *OperosCluster) CreatePool(name string, size, pgNum int) error {
+	if pgNum == 0 {
+		pgNum = DefaultPGNum
+	}
+
+	pools, err := listPools()
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		if pool.Name == name {
+			return ErrPoolExists
+		}
+	}
+
+	hosts, osds := 0, 0
+	for _, node := range cluster.Nodes {
+		if len(node.OSDs) > 0 {
+			hosts++
+			osds += len(node.OSDs)
+		}
+	}
+	if err := checkPoolCapacity(hosts, osds, pools, size, pgNum); err != nil {
+		return err
+	}
+
+	minSize := size - 1
+	if minSize < 1 {
+		minSize = 1
+	}
+	for _, args := range [][]string{
+		{"osd", "pool", "create", name, strconv.Itoa(pgNum), strconv.Itoa(pgNum), "replicated"},
+		{"osd", "pool", "set", name, "size", strconv.Itoa(size)},
+		{"osd", "pool", "set", name, "min_size", strconv.Itoa(minSize)},
+		{"osd", "pool", "application", "enable", name, "rbd"},
+	} {
+		if out, err := CephCommand(args...); err != nil {
+			return fmt.Errorf("ceph %s failed: %s %s", strings.Join(args, " "), err, out)
+		}
+	}
+	log.Printf("created pool %s with %d replicas and %d placement groups", name, size, pgNum)
+
+	return grantKubePool(name)
+}
+
+// grantKubePool lets Kubernetes keep RBD images in a pool
+func grantKubePool(pool string) error {
+	out, err := CephCommand("auth", "get", KubeCephUser, "-f", "json")
+	if err != nil {
+		return fmt.Errorf("failed to get the capabilities of %s: %s %s", KubeCephUser, err, out)
+	}
+	var users []struct {
+		Caps map[string]string `json:"caps"`
+	}
+	if err := json.Unmarshal(out, &users); err != nil || len(users) == 0 {
+		return fmt.Errorf("failed to parse the capabilities of %s", KubeCephUser)
+	}
+
+	caps := users[0].Caps
+	if out, err := CephCommand("auth", "caps", KubeCephUser, "mon", caps["mon"], "osd", osdCapsWithPool(caps["osd"], pool)); err != nil {
+		return fmt.Errorf("failed to let %s use pool %s: %s %s", KubeCephUser, pool, err, out)
+	}
+	return nil
+}
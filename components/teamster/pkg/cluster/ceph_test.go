@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paxautoma/operos/components/prospector"
+)
+
+func TestOSDLocations(t *testing.T) {
+	fingerprint := &prospector.UUIDType{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	disk := &prospector.BlockDevice{Name: "sdb", Type: "disk", Model: "ST4000", Serial: "Z1Z0", Size: "4000787030016"}
+	diskUUID, err := prospector.UUIDStringForBlkDevice(disk, fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &prospector.Report{}
+	report.Storage.BlockDevices = []*prospector.BlockDevice{
+		{Name: "sda1", Type: "part"},
+		disk,
+	}
+	node := &Node{
+		Id:           "a",
+		Hostname:     "worker-1",
+		Fingerprint:  fingerprint,
+		LatestReport: report,
+		OSDs: map[string]*NodeOSD{
+			*diskUUID: {Id: "10"},
+			"gone":    {Id: "2"},
+		},
+	}
+	cluster := &OperosCluster{Nodes: map[string]*Node{"a": node}}
+
+	locations := cluster.OSDLocations()
+	var got []string
+	for _, location := range locations {
+		device := "-"
+		if location.Device != nil {
+			device = location.Device.Name
+		}
+		got = append(got, strings.Join([]string{location.OSDID, location.Node.NodeName(), device}, " "))
+	}
+
+	want := "2 worker-1 -, 10 worker-1 sdb"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("OSDLocations() = %q, want %q", strings.Join(got, ", "), want)
+	}
+}
+
+func TestCheckPoolCapacity(t *testing.T) {
+	pools := []cephPool{{Name: "kube", Size: 2, PGNum: 128}}
+
+	tests := []struct {
+		name        string
+		hosts, osds int
+		size, pgNum int
+		wantErr     bool
+	}{
+		{"fits", 3, 6, 3, 64, false},
+		{"too many replicas", 2, 6, 3, 64, true},
+		{"too many placement groups", 3, 3, 3, 128, true},
+		{"exactly full", 2, 2, 2, 72, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPoolCapacity(tt.hosts, tt.osds, pools, tt.size, tt.pgNum)
+			if _, isCapacity := err.(CapacityError); isCapacity != tt.wantErr {
+				t.Errorf("checkPoolCapacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOSDCapsWithPool(t *testing.T) {
+	tests := []struct {
+		caps, want string
+	}{
+		{"profile rbd pool=kube", "profile rbd pool=kube, profile rbd pool=fast"},
+		{"profile rbd pool=kube, profile rbd pool=fast", "profile rbd pool=kube, profile rbd pool=fast"},
+		{"", "profile rbd pool=fast"},
+	}
+
+	for _, tt := range tests {
+		if got := osdCapsWithPool(tt.caps, "fast"); got != tt.want {
+			t.Errorf("osdCapsWithPool(%q) = %q, want %q", tt.caps, got, tt.want)
+		}
+	}
+}
+
+func TestCreatePool(t *testing.T) {
+	var commands []string
+	defer func(original func(...string) ([]byte, error)) { CephCommand = original }(CephCommand)
+	CephCommand = func(args ...string) ([]byte, error) {
+		command := strings.Join(args, " ")
+		switch command {
+		case "osd dump -f json":
+			return []byte(`{"pools": [{"pool": 1, "pool_name": "kube", "size": 2, "pg_num": 128}]}`), nil
+		case "auth get client.kube -f json":
+			return []byte(`[{"entity": "client.kube", "caps": {"mon": "profile rbd", "osd": "profile rbd pool=kube"}}]`), nil
+		}
+		commands = append(commands, command)
+		return nil, nil
+	}
+
+	cluster := &OperosCluster{Nodes: map[string]*Node{
+		"a": {Id: "a", OSDs: map[string]*NodeOSD{"1": {Id: "0"}, "2": {Id: "1"}}},
+		"b": {Id: "b", OSDs: map[string]*NodeOSD{"3": {Id: "2"}}},
+		"c": {Id: "c"},
+	}}
+
+	if err := cluster.CreatePool("kube", 2, 0); err != ErrPoolExists {
+		t.Errorf("CreatePool() of an existing pool error = %v, want %v", err, ErrPoolExists)
+	}
+	if err := cluster.CreatePool("fast", 3, 0); err == nil {
+		t.Error("expected a pool with more replicas than nodes with OSDs to be refused")
+	}
+	if len(commands) != 0 {
+		t.Fatalf("refused pools ran %q", commands)
+	}
+
+	if err := cluster.CreatePool("fast", 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"osd pool create fast 64 64 replicated",
+		"osd pool set fast size 2",
+		"osd pool set fast min_size 1",
+		"osd pool application enable fast rbd",
+		"auth caps client.kube mon profile rbd osd profile rbd pool=kube, profile rbd pool=fast",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CreatePool() ran %q, want %q", commands, want)
+	}
+}
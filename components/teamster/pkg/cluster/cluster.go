@@ -47,14 +47,22 @@ type NodeOSD struct {
 }
 
 type Node struct {
-	Id                 string
+	Id string
+	// Hostname is empty for nodes registered before hostnames were
+	// allocated; see NodeName
+	Hostname           string
 	Fingerprint        *prospector.UUIDType
 	LatestReport       *prospector.Report
 	KubeletPrivateKey  []byte
 	KubeletCertificate []byte
-	LuksKeyFile        []byte
-	Cluster            *OperosCluster
-	OSDs               map[string]*NodeOSD
+	// PreviousKubeletCN is the CN of the kubelet certificate the node held
+	// before it was renamed, which it presents until it registers again
+	PreviousKubeletCN string
+	LuksKeyFile       []byte
+	Cluster           *OperosCluster
+	OSDs              map[string]*NodeOSD
+	// Metadata is nil until an operator sets it
+	Metadata *NodeMetadata
 }
 
 type OperosCluster struct {
@@ -68,6 +76,7 @@ type OperosCluster struct {
 	etcd                 *clientv3.Client
 	etcdRequestTimeout   time.Duration
 	CephConfig           []byte
+	License              []byte
 	Secrets              map[string][]byte
 }
 
@@ -88,17 +97,28 @@ func (cluster *OperosCluster) loadNode(nodeid string) *Node {
 		keyv := strings.Split(string(ev.Key), "/")
 		switch keyv[3] {
 		case "latestreport":
-			report := new(prospector.Report)
-			if err = json.Unmarshal(ev.Value, &report); err != nil {
+			report, err := prospector.ParseReport(ev.Value)
+			if err != nil {
 				log.Printf("unable to unmarshal latest report from prospector: %s", err)
+				report = new(prospector.Report)
 			}
 			node.LatestReport = report
+		case "hostname":
+			node.Hostname = string(ev.Value)
 		case "secret-kubelet-key":
 			node.KubeletPrivateKey = ev.Value
 		case "secret-kubelet-cert":
 			node.KubeletCertificate = ev.Value
+		case "previous-kubelet-cn":
+			node.PreviousKubeletCN = string(ev.Value)
 		case "secret-luks-keyfile":
 			node.LuksKeyFile = ev.Value
+		case "metadata":
+			node.Metadata = new(NodeMetadata)
+			if err := json.Unmarshal(ev.Value, node.Metadata); err != nil {
+				log.Printf("unable to decode node metadata: %s", err)
+				node.Metadata = nil
+			}
 		case "fingerprint":
 			if node.Fingerprint, err = prospector.UUIDTypeFromHexString(ev.Value); err != nil {
 				log.Printf("unable to decode node fingerprint: %s", err)
@@ -129,12 +149,9 @@ func (cluster *OperosCluster) loadNode(nodeid string) *Node {
 
 func (cluster *OperosCluster) storeNode(node *Node) error {
 	ctx, cancel := context.WithTimeout(context.Background(), cluster.etcdRequestTimeout)
+	defer cancel()
 	node_key := fmt.Sprintf("nodes/%s/%s", cluster.InstallID, node.Id)
 
-	if _, ok := cluster.Nodes[node.Id]; ok {
-		return errors.New(fmt.Sprintf("Node %s already exists in the cluster %s", cluster.InstallID, node.Id))
-	}
-
 	//XXX: TODO: wrap this in a transaction
 
 	serialized_report, err := json.Marshal(node.LatestReport)
@@ -152,6 +169,13 @@ func (cluster *OperosCluster) storeNode(node *Node) error {
 		return err
 	}
 
+	if node.Hostname != "" {
+		_, err = cluster.etcd.Put(ctx, fmt.Sprintf("%s/%s", node_key, "hostname"), node.Hostname)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = cluster.etcd.Put(ctx, fmt.Sprintf("%s/%s", node_key, "secret-kubelet-key"), string(node.KubeletPrivateKey))
 
 	if err != nil {
@@ -164,6 +188,15 @@ func (cluster *OperosCluster) storeNode(node *Node) error {
 		return err
 	}
 
+	if node.PreviousKubeletCN != "" {
+		_, err = cluster.etcd.Put(ctx, fmt.Sprintf("%s/%s", node_key, "previous-kubelet-cn"), node.PreviousKubeletCN)
+	} else {
+		_, err = cluster.etcd.Delete(ctx, fmt.Sprintf("%s/%s", node_key, "previous-kubelet-cn"))
+	}
+	if err != nil {
+		return err
+	}
+
 	_, err = cluster.etcd.Put(ctx, fmt.Sprintf("%s/%s", node_key, "secret-luks-keyfile"), string(node.LuksKeyFile))
 
 	if err != nil {
@@ -197,7 +230,9 @@ func (cluster *OperosCluster) storeNode(node *Node) error {
 
 	keys := make([]string, 0, len(cluster.Nodes)+1)
 	for k := range cluster.Nodes {
-		keys = append(keys, k)
+		if k != node.Id {
+			keys = append(keys, k)
+		}
 	}
 
 	keys = append(keys, node.Id)
@@ -212,7 +247,6 @@ func (cluster *OperosCluster) storeNode(node *Node) error {
 
 	cluster.Nodes[node.Id] = node
 
-	cancel()
 	return nil
 }
 
@@ -256,14 +290,6 @@ func CephAddHostToCrush(host string) error {
 	return err
 }
 
-func CephCrushMoveHostDefault(host string) error {
-	cmdName := "/usr/bin/ceph"
-	cmdArgs := []string{"osd", "crush", "move", host, "root=default"}
-
-	_, err := exec.Command(cmdName, cmdArgs...).Output()
-	return err
-}
-
 func CephOSDPurge(osd_name string) error {
 	cmdName := "/usr/bin/ceph"
 	cmdArgs := []string{"osd", "purge", osd_name, "--yes-i-really-mean-it"}
@@ -299,8 +325,8 @@ func (node *Node) AddOSD(osd_uuid string, osd *NodeOSD) error {
 
 	osd.Key = osd_key
 
-	if out, err := CephOSDCrushAdd(osd_name, osd.Weight, node.Id); err != nil {
-		log.Printf("Adding osd %s as %s with weight %s on %s to crushmap failed; will not be active: %s %s", osd_uuid, osd_name, osd.Weight, node.Id, err, out)
+	if out, err := CephOSDCrushAdd(osd_name, osd.Weight, node.NodeName()); err != nil {
+		log.Printf("Adding osd %s as %s with weight %s on %s to crushmap failed; will not be active: %s %s", osd_uuid, osd_name, osd.Weight, node.NodeName(), err, out)
 	}
 
 	return nil
@@ -331,16 +357,20 @@ func (node *Node) InventoryOSDs(blkdevices []*prospector.BlockDevice) (map[strin
 }
 
 func (cluster *OperosCluster) AddNode(id *prospector.UUIDType, uuid string, report *prospector.Report) (*Node, error) {
+	if _, ok := cluster.Nodes[uuid]; ok {
+		return nil, errors.New(fmt.Sprintf("Node %s already exists in the cluster %s", uuid, cluster.InstallID))
+	}
+
 	node := new(Node)
 	node.Id = uuid
 	node.Fingerprint = id
 	node.LatestReport = report
 
-	log.Printf("Adding node %s to cluster %s", node.Id, cluster.InstallID)
+	node.Hostname = cluster.allocateHostname(node)
+
+	log.Printf("Adding node %s to cluster %s as %s", node.Id, cluster.InstallID, node.Hostname)
 
-	cn := fmt.Sprintf("Operos Cluster (%s) Node (%s)", cluster.InstallID, uuid)
-	groups := []string{cluster.Vars["OPEROS_CLUSTER_ORG"]}
-	c, p, err := cluster.requestAndSign(cn, groups)
+	c, p, err := cluster.certifyKubelet(node.Hostname)
 	if err != nil {
 		return nil, err
 	}
@@ -353,8 +383,18 @@ func (cluster *OperosCluster) AddNode(id *prospector.UUIDType, uuid string, repo
 		return nil, err
 	}
 	node.LuksKeyFile = keyfile
-	CephAddHostToCrush(node.Id)
-	CephCrushMoveHostDefault(node.Id)
+	CephAddHostToCrush(node.NodeName())
+
+	// The host is placed in its rack before it gets any OSDs, so that data
+	// is not moved twice
+	nodes := make(map[string]*Node, len(cluster.Nodes)+1)
+	for id, other := range cluster.Nodes {
+		nodes[id] = other
+	}
+	nodes[node.Id] = node
+	if err := cluster.reconcileCrush(nodes); err != nil {
+		log.Printf("Unable to place node %s in the CRUSH map: %s", node.Id, err)
+	}
 
 	if node.OSDs, err = node.InventoryOSDs(node.LatestReport.Storage.BlockDevices); err != nil {
 		log.Printf("Unable to inventory storage from node %s to cluster %s: %s", node.Id, cluster.InstallID, err)
@@ -376,7 +416,29 @@ func (cluster *OperosCluster) AddNode(id *prospector.UUIDType, uuid string, repo
 	return node, nil
 }
 
+// certifyKubelet issues the certificate the kubelet of a node identifies
+// itself with. The CN carries the name of the node as Kubernetes knows it.
+func (cluster *OperosCluster) certifyKubelet(hostname string) ([]byte, []byte, error) {
+	cn := fmt.Sprintf("system:node:%s", hostname)
+	groups := []string{"system:nodes", cluster.Vars["OPEROS_CLUSTER_ORG"]}
+	return cluster.requestAndSign(cn, groups)
+}
+
+// KubeletCN is the CN of the kubelet certificate teamster last issued to the
+// node. Nodes whose certificate cannot be read are assumed to hold one for
+// their current name.
+func (node *Node) KubeletCN() string {
+	if cert, err := helpers.ParseCertificatePEM(node.KubeletCertificate); err == nil {
+		return cert.Subject.CommonName
+	}
+	return fmt.Sprintf("system:node:%s", node.NodeName())
+}
+
 func (cluster *OperosCluster) requestAndSign(cn string, o []string) ([]byte, []byte, error) {
+	return cluster.requestAndSignWith(cluster.Signer, cn, o)
+}
+
+func (cluster *OperosCluster) requestAndSignWith(s signer.Signer, cn string, o []string) ([]byte, []byte, error) {
 	req := csr.New()
 	req.CN = cn
 	req.Names = make([]csr.Name, len(o)+1)
@@ -409,7 +471,7 @@ func (cluster *OperosCluster) requestAndSign(cn string, o []string) ([]byte, []b
 	}
 
 	var cert []byte
-	cert, err = cluster.Signer.Sign(signReq)
+	cert, err = s.Sign(signReq)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -454,13 +516,32 @@ func (cluster *OperosCluster) UpdateNode(node *Node, id *prospector.UUIDType, uu
 
 	// -- update node last request field
 	// -- check node certificate validity and update
+	location := node.Location()
 	node.LatestReport = report
-	cluster.storeNode(node)
+	if node.Location() != location {
+		if err := cluster.ReconcileCrush(); err != nil {
+			log.Printf("Unable to move node %s in the CRUSH map: %s", node.Id, err)
+		}
+	}
+	if err := cluster.storeNode(node); err != nil {
+		log.Printf("storing node %s failed: %s", uuid, err)
+		return err
+	}
 	return nil
 }
 
-func (cluster *OperosCluster) AddUser(user string, groups []string) ([]byte, []byte, error) {
-	return cluster.requestAndSign(user, groups)
+// AddUser issues a client certificate for a user which is valid for ttl, or
+// for the default of a year if ttl is zero
+func (cluster *OperosCluster) AddUser(user string, groups []string, ttl time.Duration) ([]byte, []byte, error) {
+	if ttl == 0 {
+		return cluster.requestAndSign(user, groups)
+	}
+
+	s, err := operosSigner(cluster.CACert, cluster.Secrets["secret-ca-key"], ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cluster.requestAndSignWith(s, user, groups)
 }
 
 func (cluster *OperosCluster) generateLuksKeyFile() ([]byte, error) {
@@ -472,14 +553,13 @@ func (cluster *OperosCluster) generateLuksKeyFile() ([]byte, error) {
 	return keym, nil
 }
 
-func operosSigner(certificate []byte, key []byte) (signer.Signer, error) {
-	d := helpers.OneYear
+func operosSigner(certificate []byte, key []byte, expiry time.Duration) (signer.Signer, error) {
 	policy := &config.Signing{
 		Profiles: map[string]*config.SigningProfile{},
 		Default: &config.SigningProfile{
 			Usage:        []string{"digital signature", "client auth"},
-			Expiry:       d,
-			ExpiryString: "8760h",
+			Expiry:       expiry,
+			ExpiryString: expiry.String(),
 		},
 	}
 
@@ -538,6 +618,8 @@ func InstantiateCluster(etcd *clientv3.Client, requestTimeout time.Duration, ins
 			}
 		case "ceph-config":
 			oc.CephConfig = ev.Value
+		case "license":
+			oc.License = ev.Value
 		default:
 			if strings.HasPrefix(keyv[2], "secret") {
 				oc.Secrets[keyv[2]] = ev.Value
@@ -557,7 +639,7 @@ func InstantiateCluster(etcd *clientv3.Client, requestTimeout time.Duration, ins
 		return nil, errors.New("Certificate Authority Certificate unconfigured")
 	}
 
-	oc.Signer, err = operosSigner(ca_cert, ca_key)
+	oc.Signer, err = operosSigner(ca_cert, ca_key, helpers.OneYear)
 	if err != nil {
 		log.Println("error: unable to initilize signer: ", err)
 		return nil, err
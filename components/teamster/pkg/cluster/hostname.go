@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HostnamePatternVar is the cluster setting holding the pattern new
+	// workers are named after
+	HostnamePatternVar = "OPEROS_HOSTNAME_PATTERN"
+
+	// DefaultHostnamePattern is used when the setting is missing or invalid
+	DefaultHostnamePattern = "worker-{n}"
+)
+
+// ErrHostnameTaken is returned when renaming a node to the name of another
+var ErrHostnameTaken = errors.New("hostname is already taken")
+
+var (
+	hostnameRe            = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	hostnamePlaceholderRe = regexp.MustCompile(`\{[a-z]*\}`)
+	hostnameInvalidRe     = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// NodeName is the hostname of the node, which is also its name in
+// Kubernetes and in the CRUSH map. Nodes registered before hostnames were
+// allocated keep their UUID.
+func (node *Node) NodeName() string {
+	if node.Hostname != "" {
+		return node.Hostname
+	}
+	return node.Id
+}
+
+// ValidateHostname checks that a name can be used as a hostname, and as the
+// name of a Kubernetes node
+func ValidateHostname(hostname string) error {
+	if !hostnameRe.MatchString(hostname) {
+		return fmt.Errorf("hostname %q must be at most 63 lowercase letters, digits or '-', and start and end with a letter or digit", hostname)
+	}
+	return nil
+}
+
+// ValidateHostnamePattern checks a pattern for new hostnames. Patterns are
+// made of text and the placeholders {n}, the lowest number giving a name not
+// yet taken, {slot}, the same within a rack, and {rack}, {zone} and {uuid}.
+func ValidateHostnamePattern(pattern string) error {
+	numbered := false
+	for _, placeholder := range hostnamePlaceholderRe.FindAllString(pattern, -1) {
+		switch placeholder {
+		case "{n}", "{slot}":
+			numbered = true
+		case "{rack}", "{zone}", "{uuid}":
+		default:
+			return fmt.Errorf("unknown placeholder %s in hostname pattern", placeholder)
+		}
+	}
+	if !numbered {
+		return fmt.Errorf("hostname pattern %q must contain {n} or {slot}, so that names are unique", pattern)
+	}
+	return nil
+}
+
+// renderHostname fills in a pattern for a node. Since {slot} takes the
+// lowest free number, it counts up separately in each rack of a pattern
+// like {rack}-{slot}.
+func renderHostname(pattern string, node *Node, number int) string {
+	location := node.Location()
+	part := func(value, fallback string) string {
+		value = strings.Trim(hostnameInvalidRe.ReplaceAllString(strings.ToLower(value), "-"), "-")
+		if value == "" {
+			return fallback
+		}
+		return value
+	}
+
+	name := strings.NewReplacer(
+		"{n}", strconv.Itoa(number),
+		"{slot}", strconv.Itoa(number),
+		"{rack}", part(location.Rack, "norack"),
+		"{zone}", part(location.Datacenter, "nozone"),
+		"{uuid}", part(strings.SplitN(node.Id, "-", 2)[0], "node"),
+	).Replace(pattern)
+	return strings.Trim(hostnameInvalidRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// hostnameTaken tells whether a name belongs to a node other than except.
+// UUIDs count too, since older nodes go by them.
+func (cluster *OperosCluster) hostnameTaken(hostname string, except *Node) bool {
+	for _, node := range cluster.Nodes {
+		if node != except && (node.NodeName() == hostname || node.Id == hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// allocateHostname picks the name of a new node from the hostname pattern
+// of the cluster
+func (cluster *OperosCluster) allocateHostname(node *Node) string {
+	pattern := cluster.Vars[HostnamePatternVar]
+	if pattern == "" {
+		pattern = DefaultHostnamePattern
+	} else if err := ValidateHostnamePattern(pattern); err != nil {
+		log.Printf("Using the default hostname pattern: %s", err)
+		pattern = DefaultHostnamePattern
+	}
+
+	// At most len(cluster.Nodes) names are taken, so one of these is free
+	// unless the pattern makes names too long
+	for number := 1; number <= len(cluster.Nodes)+1; number++ {
+		hostname := renderHostname(pattern, node, number)
+		if ValidateHostname(hostname) == nil && !cluster.hostnameTaken(hostname, node) {
+			return hostname
+		}
+	}
+
+	log.Printf("Pattern %q gives no usable hostname for node %s; using its UUID", pattern, node.Id)
+	return node.Id
+}
+
+// RenameNode changes the hostname of a node, and issues it a kubelet
+// certificate for the new name. The node picks both up the next time it
+// boots, and registers with Kubernetes under the new name.
+func (cluster *OperosCluster) RenameNode(node *Node, hostname string) error {
+	if err := ValidateHostname(hostname); err != nil {
+		return err
+	}
+	if hostname == node.NodeName() {
+		return nil
+	}
+	if cluster.hostnameTaken(hostname, node) {
+		return ErrHostnameTaken
+	}
+
+	previous := node.NodeName()
+	cert, key, err := cluster.certifyKubelet(hostname)
+	if err != nil {
+		return err
+	}
+
+	// The node keeps presenting the certificate it booted with, so that is
+	// the one remembered across repeated renames
+	if node.PreviousKubeletCN == "" {
+		node.PreviousKubeletCN = node.KubeletCN()
+	}
+	node.Hostname = hostname
+	node.KubeletCertificate = cert
+	node.KubeletPrivateKey = key
+	if err := cluster.storeNode(node); err != nil {
+		return err
+	}
+
+	log.Printf("Renamed node %s from %s to %s", node.Id, previous, hostname)
+	if out, err := CephCommand("osd", "crush", "rename-bucket", previous, hostname); err != nil {
+		log.Printf("Unable to rename CRUSH bucket %s to %s: %s %s", previous, hostname, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"testing"
+)
+
+func TestValidateHostnamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"worker-{n}", false},
+		{"{rack}-{slot}", false},
+		{"{zone}-{rack}-{slot}", false},
+		{"worker", true},
+		{"{rack}", true},
+		{"worker-{host}-{n}", true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateHostnamePattern(tt.pattern); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateHostnamePattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAllocateHostname(t *testing.T) {
+	racked := func(id, rack string) *Node {
+		return &Node{Id: id, Metadata: &NodeMetadata{Rack: rack}}
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		existing []*Node
+		node     *Node
+		want     string
+	}{
+		{"first", "", nil, &Node{Id: "f8e48ce2-6c32"}, "worker-1"},
+		{"lowest free", "worker-{n}", []*Node{
+			{Id: "a", Hostname: "worker-1"},
+			{Id: "b", Hostname: "worker-3"},
+		}, &Node{Id: "c"}, "worker-2"},
+		{"per rack", "{rack}-{slot}", []*Node{
+			{Id: "a", Hostname: "r1-1"},
+			{Id: "b", Hostname: "r2-1"},
+		}, racked("c", "R2"), "r2-2"},
+		{"no rack", "{rack}-{slot}", nil, &Node{Id: "c"}, "norack-1"},
+		{"uuid", "node-{uuid}-{n}", nil, &Node{Id: "f8e48ce2-6c32"}, "node-f8e48ce2-1"},
+		{"uuid names taken", "{n}", []*Node{
+			{Id: "1"},
+		}, &Node{Id: "c"}, "2"},
+		{"invalid pattern", "worker", nil, &Node{Id: "c"}, "worker-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &OperosCluster{
+				Nodes: make(map[string]*Node),
+				Vars:  map[string]string{HostnamePatternVar: tt.pattern},
+			}
+			for _, node := range tt.existing {
+				cluster.Nodes[node.Id] = node
+			}
+
+			if got := cluster.allocateHostname(tt.node); got != tt.want {
+				t.Errorf("allocateHostname() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameNodeValidation(t *testing.T) {
+	node := &Node{Id: "a", Hostname: "worker-1"}
+	cluster := &OperosCluster{Nodes: map[string]*Node{
+		"a": node,
+		"b": {Id: "b", Hostname: "worker-2"},
+	}}
+
+	if err := cluster.RenameNode(node, "Worker_1"); err == nil {
+		t.Error("expected an invalid hostname to be refused")
+	}
+	if err := cluster.RenameNode(node, "worker-2"); err != ErrHostnameTaken {
+		t.Errorf("RenameNode() error = %v, want %v", err, ErrHostnameTaken)
+	}
+	if err := cluster.RenameNode(node, "b"); err != ErrHostnameTaken {
+		t.Errorf("RenameNode() error = %v, want %v", err, ErrHostnameTaken)
+	}
+	if err := cluster.RenameNode(node, "worker-1"); err != nil {
+		t.Errorf("RenameNode() to the current name error = %v", err)
+	}
+}
@@ -14,17 +14,24 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package cluster
 
-import "testing"
+import (
+	"context"
+	"fmt"
+)
 
-func Test_main(t *testing.T) {
-	tests := []struct {
-		name string
-	}{
-		{name: "Check if it runs"},
-	}
-	for range tests {
-		main()
+// SetLicense stores the license file of the cluster, replacing any other.
+// It is kept apart from the cluster vars, so that it is not given to
+// workers.
+func (cluster *OperosCluster) SetLicense(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.etcdRequestTimeout)
+	defer cancel()
+
+	key := fmt.Sprintf("cluster/%s/license", cluster.InstallID)
+	if _, err := cluster.etcd.Put(ctx, key, string(data)); err != nil {
+		return err
 	}
+	cluster.License = data
+	return nil
 }
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// NodeMetadata is what operators say about a node, as opposed to what the
+// node reports about itself. It is kept in etcd under
+// nodes/<install id>/<node id>/metadata, and survives the node registering
+// again. Waterfront keeps the labels and taints of the Kubernetes node in
+// sync with it.
+type NodeMetadata struct {
+	// Name is a friendly name, shown alongside the UUID
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Taints []Taint           `json:"taints,omitempty"`
+	Rack   string            `json:"rack,omitempty"`
+	Zone   string            `json:"zone,omitempty"`
+}
+
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+var (
+	labelNameRe   = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)$`)
+	labelValueRe  = regexp.MustCompile(`^(([A-Za-z0-9][-A-Za-z0-9_.]{0,61})?[A-Za-z0-9])?$`)
+	labelPrefixRe = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+	taintEffects = map[string]bool{
+		"NoSchedule":       true,
+		"PreferNoSchedule": true,
+		"NoExecute":        true,
+	}
+
+	// reservedLabelDomains belong to Kubernetes and Operos, which set
+	// labels such as the hostname and the zone themselves
+	reservedLabelDomains = []string{"kubernetes.io", "paxautoma.com"}
+)
+
+// Validate checks that the labels and taints are acceptable to Kubernetes
+func (md *NodeMetadata) Validate() error {
+	if len(md.Name) > 253 {
+		return fmt.Errorf("name must be at most 253 characters")
+	}
+
+	for key, value := range md.Labels {
+		if err := validateLabelKey(key); err != nil {
+			return err
+		}
+		if !labelValueRe.MatchString(value) {
+			return fmt.Errorf("value %q of label %s must be at most 63 letters, digits, '-', '_' or '.'", value, key)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, taint := range md.Taints {
+		if err := validateLabelKey(taint.Key); err != nil {
+			return fmt.Errorf("taint: %s", err)
+		}
+		if !labelValueRe.MatchString(taint.Value) {
+			return fmt.Errorf("value %q of taint %s must be at most 63 letters, digits, '-', '_' or '.'", taint.Value, taint.Key)
+		}
+		if !taintEffects[taint.Effect] {
+			return fmt.Errorf("effect of taint %s must be NoSchedule, PreferNoSchedule or NoExecute", taint.Key)
+		}
+		if seen[taint.Key+":"+taint.Effect] {
+			return fmt.Errorf("taint %s:%s is given more than once", taint.Key, taint.Effect)
+		}
+		seen[taint.Key+":"+taint.Effect] = true
+	}
+
+	for field, value := range map[string]string{"rack": md.Rack, "zone": md.Zone} {
+		if !labelValueRe.MatchString(value) {
+			return fmt.Errorf("%s %q must be at most 63 letters, digits, '-', '_' or '.'", field, value)
+		}
+	}
+	return nil
+}
+
+func validateLabelKey(key string) error {
+	name := key
+	if idx := strings.LastIndex(key, "/"); idx >= 0 {
+		prefix := key[:idx]
+		name = key[idx+1:]
+		if len(prefix) > 253 || !labelPrefixRe.MatchString(prefix) {
+			return fmt.Errorf("prefix of key %q must be a DNS subdomain", key)
+		}
+		for _, domain := range reservedLabelDomains {
+			if prefix == domain || strings.HasSuffix(prefix, "."+domain) {
+				return fmt.Errorf("key %q uses the reserved prefix %s", key, prefix)
+			}
+		}
+	}
+	if !labelNameRe.MatchString(name) {
+		return fmt.Errorf("key %q must be at most 63 letters, digits, '-', '_' or '.', optionally after a DNS subdomain and '/'", key)
+	}
+	return nil
+}
+
+// SetNodeMetadata stores the metadata of a node
+func (cluster *OperosCluster) SetNodeMetadata(node *Node, md *NodeMetadata) error {
+	if err := md.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.etcdRequestTimeout)
+	defer cancel()
+
+	key := fmt.Sprintf("nodes/%s/%s/metadata", cluster.InstallID, node.Id)
+	if _, err := cluster.etcd.Put(ctx, key, string(data)); err != nil {
+		return err
+	}
+
+	location := node.Location()
+	node.Metadata = md
+	if node.Location() != location {
+		// The metadata is saved either way, and the CRUSH map catches up
+		// the next time it is reconciled
+		if err := cluster.ReconcileCrush(); err != nil {
+			log.Printf("Unable to move node %s in the CRUSH map: %s", node.Id, err)
+		}
+	}
+	return nil
+}
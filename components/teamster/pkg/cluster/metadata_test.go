@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNodeMetadataValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		md      NodeMetadata
+		wantErr string
+	}{
+		{"empty", NodeMetadata{}, ""},
+		{"valid", NodeMetadata{
+			Name:   "storage 1",
+			Labels: map[string]string{"example.com/tier": "storage", "gpu": ""},
+			Taints: []Taint{{Key: "dedicated", Value: "storage", Effect: "NoSchedule"}},
+			Rack:   "r12",
+			Zone:   "dc-east",
+		}, ""},
+		{"bad label key", NodeMetadata{Labels: map[string]string{"tier!": "a"}}, "tier!"},
+		{"bad label prefix", NodeMetadata{Labels: map[string]string{"Example.com/tier": "a"}}, "DNS subdomain"},
+		{"reserved label", NodeMetadata{Labels: map[string]string{"kubernetes.io/hostname": "a"}}, "reserved"},
+		{"reserved subdomain", NodeMetadata{Labels: map[string]string{"node.operos.paxautoma.com/x": "a"}}, "reserved"},
+		{"long label value", NodeMetadata{Labels: map[string]string{"a": strings.Repeat("x", 64)}}, "at most 63"},
+		{"bad taint effect", NodeMetadata{Taints: []Taint{{Key: "a", Effect: "Never"}}}, "effect"},
+		{"duplicate taint", NodeMetadata{Taints: []Taint{{Key: "a", Effect: "NoSchedule"}, {Key: "a", Value: "b", Effect: "NoSchedule"}}}, "more than once"},
+		{"bad rack", NodeMetadata{Rack: "rack 12"}, "rack"},
+	}
+
+	for _, tt := range tests {
+		err := tt.md.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q. Validate() = %v", tt.name, err)
+		case tt.wantErr != "" && err == nil:
+			t.Errorf("%q. Validate() succeeded, want error containing %q", tt.name, tt.wantErr)
+		case err != nil && !strings.Contains(err.Error(), tt.wantErr):
+			t.Errorf("%q. Validate() = %v, want error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const (
+	// The cluster vars holding the settings. All but the DNS domain can be
+	// changed after installation.
+	DNSDomainVar         = "OPEROS_DNS_DOMAIN"
+	PublicHostnameVar    = "OPEROS_PUBLIC_HOSTNAME"
+	NTPServersVar        = "OPEROS_NTP_SERVERS"
+	StoragePercentageVar = "OPEROS_WORKER_STORAGE_PERCENTAGE"
+
+	DefaultDNSDomain         = "cluster.local"
+	DefaultStoragePercentage = 50
+
+	// The share of each worker disk kept for the system, in percent, as
+	// limited by the installer
+	MinStoragePercentage = 20
+	MaxStoragePercentage = 80
+
+	maxNTPServers = 8
+)
+
+// DefaultNTPServers are the servers the controller synchronizes with if the
+// installer was not told otherwise
+var DefaultNTPServers = []string{
+	"0.pool.ntp.org",
+	"1.pool.ntp.org",
+	"2.pool.ntp.org",
+	"3.pool.ntp.org",
+}
+
+// Settings are the cluster settings. They are kept in etcd as cluster vars,
+// and reach workers through the settings file of their loadout.
+type Settings struct {
+	// DNSDomain is the domain of services and pods. It can not be changed
+	// after installation, since kube-dns and the kubelet of the controller
+	// are set up with it by the installer.
+	DNSDomain string
+	// PublicHostname is the name the controller is reached by from outside
+	// the cluster. It may be empty.
+	PublicHostname string
+	// NTPServers are the time servers of the cluster. Workers synchronize
+	// with the controller first, and fall back to these.
+	NTPServers []string
+	// StoragePercentage is the share of each worker disk kept for the
+	// system. Disks which are already partitioned keep their layout.
+	StoragePercentage int
+}
+
+// settingVars lists the settings which can be changed by the names used in
+// errors and by the API, along with the var each is kept in and whether
+// workers only pick up a change when they reboot
+var settingVars = []struct {
+	name     string
+	variable string
+	reboot   bool
+}{
+	{"public_hostname", PublicHostnameVar, false},
+	{"ntp_servers", NTPServersVar, true},
+	{"storage_percentage", StoragePercentageVar, true},
+}
+
+// SettingsError tells what is wrong with each invalid setting, by name
+type SettingsError map[string]string
+
+func (e SettingsError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := make([]string, len(names))
+	for i, name := range names {
+		problems[i] = fmt.Sprintf("%s: %s", name, e[name])
+	}
+	return strings.Join(problems, "; ")
+}
+
+// validateDNSName checks that a name is made of valid hostnames separated
+// by dots
+func validateDNSName(name string) error {
+	if len(name) > 253 {
+		return fmt.Errorf("%q must be at most 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameRe.MatchString(label) {
+			return fmt.Errorf("%q must be lowercase letters, digits and '-', separated by dots", name)
+		}
+	}
+	return nil
+}
+
+// validateHost checks that a server is given by a DNS name or an IP address
+func validateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if err := validateDNSName(host); err != nil {
+		return fmt.Errorf("%q is neither an IP address nor a DNS name", host)
+	}
+	return nil
+}
+
+// Validate checks each setting, and returns a SettingsError listing those
+// which are invalid
+func (s *Settings) Validate() error {
+	problems := SettingsError{}
+
+	if s.DNSDomain == "" {
+		problems["dns_domain"] = "must not be empty"
+	} else if err := validateDNSName(s.DNSDomain); err != nil {
+		problems["dns_domain"] = err.Error()
+	}
+
+	if s.PublicHostname != "" {
+		if err := validateHost(s.PublicHostname); err != nil {
+			problems["public_hostname"] = err.Error()
+		}
+	}
+
+	if len(s.NTPServers) == 0 || len(s.NTPServers) > maxNTPServers {
+		problems["ntp_servers"] = fmt.Sprintf("there must be between 1 and %d servers", maxNTPServers)
+	} else {
+		seen := make(map[string]bool)
+		for _, server := range s.NTPServers {
+			if err := validateHost(server); err != nil {
+				problems["ntp_servers"] = err.Error()
+				break
+			}
+			if seen[server] {
+				problems["ntp_servers"] = fmt.Sprintf("%s is given more than once", server)
+				break
+			}
+			seen[server] = true
+		}
+	}
+
+	if s.StoragePercentage < MinStoragePercentage || s.StoragePercentage > MaxStoragePercentage {
+		problems["storage_percentage"] = fmt.Sprintf("must be between %d and %d", MinStoragePercentage, MaxStoragePercentage)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// vars returns the cluster vars the settings are kept in
+func (s *Settings) vars() map[string]string {
+	return map[string]string{
+		DNSDomainVar:         s.DNSDomain,
+		PublicHostnameVar:    s.PublicHostname,
+		NTPServersVar:        strings.Join(s.NTPServers, " "),
+		StoragePercentageVar: strconv.Itoa(s.StoragePercentage),
+	}
+}
+
+// Settings reads the settings from the cluster vars. Settings which are
+// missing, as they are on clusters installed before they existed, take
+// their defaults.
+func (cluster *OperosCluster) Settings() *Settings {
+	settings := &Settings{
+		DNSDomain:         cluster.Vars[DNSDomainVar],
+		PublicHostname:    cluster.Vars[PublicHostnameVar],
+		NTPServers:        strings.Fields(cluster.Vars[NTPServersVar]),
+		StoragePercentage: DefaultStoragePercentage,
+	}
+	if settings.DNSDomain == "" {
+		settings.DNSDomain = DefaultDNSDomain
+	}
+	if len(settings.NTPServers) == 0 {
+		settings.NTPServers = append([]string(nil), DefaultNTPServers...)
+	}
+	if value := cluster.Vars[StoragePercentageVar]; value != "" {
+		if percentage, err := strconv.Atoi(value); err == nil {
+			settings.StoragePercentage = percentage
+		} else {
+			log.Printf("Using the default storage percentage: invalid %s %q", StoragePercentageVar, value)
+		}
+	}
+	return settings
+}
+
+// UpdateSettings stores the settings which differ from the current ones in
+// etcd, so that workers are given them the next time they fetch their
+// loadout. It returns the names of the changed settings which workers only
+// apply when they reboot.
+func (cluster *OperosCluster) UpdateSettings(settings *Settings) ([]string, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	if settings.DNSDomain != cluster.Settings().DNSDomain {
+		return nil, SettingsError{"dns_domain": "can not be changed after installation"}
+	}
+
+	current := cluster.Settings().vars()
+	updated := settings.vars()
+
+	var ops []clientv3.Op
+	var rebootRequired []string
+	for _, setting := range settingVars {
+		value := updated[setting.variable]
+		if value == current[setting.variable] {
+			continue
+		}
+		key := fmt.Sprintf("cluster/%s/%s", cluster.InstallID, setting.variable)
+		ops = append(ops, clientv3.OpPut(key, value))
+		if setting.reboot {
+			rebootRequired = append(rebootRequired, setting.name)
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cluster.etcdRequestTimeout)
+	defer cancel()
+
+	// The settings are changed together or not at all
+	if _, err := cluster.etcd.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return nil, err
+	}
+
+	for variable, value := range updated {
+		if value != current[variable] {
+			log.Printf("Changed cluster setting %s to %q", variable, value)
+			cluster.Vars[variable] = value
+		}
+	}
+	return rebootRequired, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	valid := func() *Settings {
+		return &Settings{
+			DNSDomain:         "cluster.local",
+			PublicHostname:    "operos.example.com",
+			NTPServers:        []string{"ntp.example.com", "192.0.2.1"},
+			StoragePercentage: 50,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(s *Settings)
+		invalid []string
+	}{
+		{"valid", func(s *Settings) {}, nil},
+		{"no public hostname", func(s *Settings) { s.PublicHostname = "" }, nil},
+		{"public IP", func(s *Settings) { s.PublicHostname = "203.0.113.7" }, nil},
+		{"empty domain", func(s *Settings) { s.DNSDomain = "" }, []string{"dns_domain"}},
+		{"bad domain", func(s *Settings) { s.DNSDomain = "cluster..local" }, []string{"dns_domain"}},
+		{"bad hostname", func(s *Settings) { s.PublicHostname = "operos_1" }, []string{"public_hostname"}},
+		{"no NTP servers", func(s *Settings) { s.NTPServers = nil }, []string{"ntp_servers"}},
+		{"repeated NTP server", func(s *Settings) { s.NTPServers = []string{"192.0.2.1", "192.0.2.1"} }, []string{"ntp_servers"}},
+		{"low percentage", func(s *Settings) { s.StoragePercentage = 10 }, []string{"storage_percentage"}},
+		{"several", func(s *Settings) {
+			s.DNSDomain = "Cluster"
+			s.StoragePercentage = 81
+		}, []string{"dns_domain", "storage_percentage"}},
+	}
+
+	for _, tt := range tests {
+		settings := valid()
+		tt.modify(settings)
+
+		var invalid []string
+		if err := settings.Validate(); err != nil {
+			problems, ok := err.(SettingsError)
+			if !ok {
+				t.Errorf("%s: Validate() returned %T, want SettingsError", tt.name, err)
+				continue
+			}
+			for name := range problems {
+				invalid = append(invalid, name)
+			}
+			sort.Strings(invalid)
+		}
+		if !reflect.DeepEqual(invalid, tt.invalid) {
+			t.Errorf("%s: invalid settings = %v, want %v", tt.name, invalid, tt.invalid)
+		}
+	}
+}
+
+func TestClusterSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want *Settings
+	}{
+		{"defaults", map[string]string{}, &Settings{
+			DNSDomain:         DefaultDNSDomain,
+			NTPServers:        DefaultNTPServers,
+			StoragePercentage: DefaultStoragePercentage,
+		}},
+		{"set", map[string]string{
+			DNSDomainVar:         "k8s.example.com",
+			PublicHostnameVar:    "operos.example.com",
+			NTPServersVar:        "ntp1.example.com  ntp2.example.com",
+			StoragePercentageVar: "30",
+		}, &Settings{
+			DNSDomain:         "k8s.example.com",
+			PublicHostname:    "operos.example.com",
+			NTPServers:        []string{"ntp1.example.com", "ntp2.example.com"},
+			StoragePercentage: 30,
+		}},
+		{"bad percentage", map[string]string{StoragePercentageVar: "half"}, &Settings{
+			DNSDomain:         DefaultDNSDomain,
+			NTPServers:        DefaultNTPServers,
+			StoragePercentage: DefaultStoragePercentage,
+		}},
+	}
+
+	for _, tt := range tests {
+		cluster := &OperosCluster{Vars: tt.vars}
+		if got := cluster.Settings(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Settings() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateSettingsKeepsDNSDomain(t *testing.T) {
+	cluster := &OperosCluster{Vars: map[string]string{DNSDomainVar: "cluster.local"}}
+	settings := cluster.Settings()
+	settings.DNSDomain = "k8s.example.com"
+
+	_, err := cluster.UpdateSettings(settings)
+	problems, ok := err.(SettingsError)
+	if !ok || problems["dns_domain"] == "" {
+		t.Fatalf("UpdateSettings() = %v, want a dns_domain SettingsError", err)
+	}
+	if got := cluster.Vars[DNSDomainVar]; got != "cluster.local" {
+		t.Errorf("%s = %q, want it unchanged", DNSDomainVar, got)
+	}
+}
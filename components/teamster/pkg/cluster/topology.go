@@ -0,0 +1,340 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/paxautoma/operos/components/prospector"
+)
+
+// CrushRoot is the root bucket of the CRUSH hierarchy, under which
+// datacenters, racks and hosts are placed
+const CrushRoot = "default"
+
+// Sources of node locations
+const (
+	LocationFromMetadata = "metadata"
+	LocationFromLLDP     = "lldp"
+)
+
+// FailureDomains are the bucket types replicated pools can spread their
+// replicas over, from the narrowest to the widest
+var FailureDomains = []string{"host", "rack", "datacenter"}
+
+// NodeLocation is where a node sits in the CRUSH hierarchy. Empty fields
+// leave that level out, e.g. a node without a rack sits directly in its
+// datacenter.
+type NodeLocation struct {
+	Datacenter string
+	Rack       string
+	// Source is LocationFromMetadata, LocationFromLLDP or empty when the
+	// location is unknown
+	Source string
+}
+
+// CrushBucket is a bucket of the hierarchy managed by teamster
+type CrushBucket struct {
+	Name string
+	// Type is datacenter, rack or host
+	Type   string
+	Parent string
+	// Source is where the location of a host came from
+	Source string
+}
+
+var crushNameRe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// CephCommand runs the ceph CLI. It is replaced in tests.
+var CephCommand = func(args ...string) ([]byte, error) {
+	return exec.Command("/usr/bin/ceph", args...).Output()
+}
+
+// Location returns where the node sits. The rack and zone set by operators
+// win; otherwise the rack is named after the switch seen over LLDP on the
+// first linked NIC, which is normally the top-of-rack switch.
+func (node *Node) Location() NodeLocation {
+	if md := node.Metadata; md != nil && (md.Rack != "" || md.Zone != "") {
+		return NodeLocation{
+			Datacenter: md.Zone,
+			Rack:       md.Rack,
+			Source:     LocationFromMetadata,
+		}
+	}
+
+	if node.LatestReport == nil {
+		return NodeLocation{}
+	}
+
+	ifaces := make([]*prospector.NetworkInterface, len(node.LatestReport.Network))
+	copy(ifaces, node.LatestReport.Network)
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	for _, iface := range ifaces {
+		if !iface.Link || iface.Neighbor == nil {
+			continue
+		}
+		switchName := iface.Neighbor.ChassisName
+		if switchName == "" {
+			switchName = iface.Neighbor.ChassisID
+		}
+		if switchName != "" {
+			return NodeLocation{Rack: switchName, Source: LocationFromLLDP}
+		}
+	}
+	return NodeLocation{}
+}
+
+// crushName turns a name into one CRUSH accepts
+func crushName(name string) string {
+	return strings.Trim(crushNameRe.ReplaceAllString(name, "-"), "-")
+}
+
+// BuildTopology returns the datacenter, rack and host buckets the nodes
+// belong in, parents before children. Racks are named after their
+// datacenter too, since bucket names are global.
+func BuildTopology(nodes map[string]*Node) []CrushBucket {
+	buckets := make(map[string]CrushBucket)
+	for _, node := range nodes {
+		location := node.Location()
+		parent := CrushRoot
+
+		if dc := crushName(location.Datacenter); dc != "" {
+			buckets[dc] = CrushBucket{Name: dc, Type: "datacenter", Parent: parent}
+			parent = dc
+		}
+		if rack := crushName(location.Rack); rack != "" {
+			if parent != CrushRoot {
+				rack = parent + "-" + rack
+			}
+			buckets[rack] = CrushBucket{Name: rack, Type: "rack", Parent: parent}
+			parent = rack
+		}
+
+		buckets[node.NodeName()] = CrushBucket{Name: node.NodeName(), Type: "host", Parent: parent, Source: location.Source}
+	}
+
+	depth := map[string]int{"datacenter": 0, "rack": 1, "host": 2}
+	result := make([]CrushBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		result = append(result, bucket)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if depth[result[i].Type] != depth[result[j].Type] {
+			return depth[result[i].Type] < depth[result[j].Type]
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// crushItem is a bucket or OSD as reported by `ceph osd tree`
+type crushItem struct {
+	Type     string
+	Parent   string
+	Children []string
+}
+
+// parseCrushTree parses the output of `ceph osd tree -f json`
+func parseCrushTree(out []byte) (map[string]*crushItem, error) {
+	var tree struct {
+		Nodes []struct {
+			ID       int    `json:"id"`
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Children []int  `json:"children"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(out, &tree); err != nil {
+		return nil, fmt.Errorf("failed to parse the CRUSH tree: %s", err)
+	}
+
+	names := make(map[int]string)
+	items := make(map[string]*crushItem)
+	for _, node := range tree.Nodes {
+		names[node.ID] = node.Name
+		items[node.Name] = &crushItem{Type: node.Type}
+	}
+	for _, node := range tree.Nodes {
+		for _, child := range node.Children {
+			if name, ok := names[child]; ok {
+				items[name].Parent = node.Name
+				items[node.Name].Children = append(items[node.Name].Children, name)
+			}
+		}
+	}
+	return items, nil
+}
+
+// crushLocation returns the `type=name` arguments placing a bucket under
+// parent, up to the root
+func crushLocation(parent string, desired map[string]CrushBucket) []string {
+	var location []string
+	for parent != CrushRoot {
+		bucket := desired[parent]
+		location = append(location, fmt.Sprintf("%s=%s", bucket.Type, bucket.Name))
+		parent = bucket.Parent
+	}
+	return append(location, "root="+CrushRoot)
+}
+
+// crushRuleName is the name of the replicated rule for a failure domain
+func crushRuleName(failureDomain string) string {
+	return "replicated_" + failureDomain
+}
+
+// crushChanges returns the ceph commands which turn the current hierarchy
+// into the desired one: missing buckets are added, buckets in the wrong place
+// are moved, racks and datacenters left empty are removed, and a replicated
+// rule is created for each level of the hierarchy in use. Buckets teamster
+// does not know about, such as hosts of removed nodes, are left alone.
+func crushChanges(current map[string]*crushItem, rules []string, desired []CrushBucket) [][]string {
+	var commands [][]string
+
+	desiredByName := make(map[string]CrushBucket, len(desired))
+	types := map[string]bool{"host": true}
+	for _, bucket := range desired {
+		desiredByName[bucket.Name] = bucket
+		types[bucket.Type] = true
+	}
+
+	for _, bucket := range desired {
+		item, exists := current[bucket.Name]
+		if !exists {
+			commands = append(commands, []string{"osd", "crush", "add-bucket", bucket.Name, bucket.Type})
+		}
+		if !exists || item.Parent != bucket.Parent {
+			args := append([]string{"osd", "crush", "move", bucket.Name}, crushLocation(bucket.Parent, desiredByName)...)
+			commands = append(commands, args)
+		}
+	}
+
+	// Racks are removed before datacenters, which may only hold them
+	var stale []string
+	for name, item := range current {
+		if _, ok := desiredByName[name]; !ok && (item.Type == "rack" || item.Type == "datacenter") {
+			stale = append(stale, name)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if current[stale[i]].Type != current[stale[j]].Type {
+			return current[stale[i]].Type == "rack"
+		}
+		return stale[i] < stale[j]
+	})
+	removed := make(map[string]bool)
+	for _, name := range stale {
+		empty := true
+		for _, child := range current[name].Children {
+			if _, moved := desiredByName[child]; !moved && !removed[child] {
+				empty = false
+			}
+		}
+		if empty {
+			commands = append(commands, []string{"osd", "crush", "remove", name})
+			removed[name] = true
+		}
+	}
+
+	existingRules := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		existingRules[rule] = true
+	}
+	for _, domain := range FailureDomains {
+		if types[domain] && !existingRules[crushRuleName(domain)] {
+			commands = append(commands, []string{"osd", "crush", "rule", "create-replicated", crushRuleName(domain), CrushRoot, domain})
+		}
+	}
+
+	return commands
+}
+
+// ReconcileCrush brings the CRUSH hierarchy in line with the locations of
+// the nodes of the cluster
+func (cluster *OperosCluster) ReconcileCrush() error {
+	return cluster.reconcileCrush(cluster.Nodes)
+}
+
+func (cluster *OperosCluster) reconcileCrush(nodes map[string]*Node) error {
+	out, err := CephCommand("osd", "tree", "-f", "json")
+	if err != nil {
+		return fmt.Errorf("failed to get the CRUSH tree: %s", err)
+	}
+	current, err := parseCrushTree(out)
+	if err != nil {
+		return err
+	}
+
+	rules, err := listCrushRules()
+	if err != nil {
+		return err
+	}
+
+	for _, args := range crushChanges(current, rules, BuildTopology(nodes)) {
+		log.Printf("updating CRUSH map: ceph %s", strings.Join(args, " "))
+		if out, err := CephCommand(args...); err != nil {
+			return fmt.Errorf("ceph %s failed: %s %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+func listCrushRules() ([]string, error) {
+	out, err := CephCommand("osd", "crush", "rule", "ls", "-f", "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CRUSH rules: %s", err)
+	}
+	var rules []string
+	if err := json.Unmarshal(out, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse CRUSH rules: %s", err)
+	}
+	return rules, nil
+}
+
+// CrushRules returns the replicated rules created by ReconcileCrush, by
+// failure domain
+func CrushRules() (map[string]string, error) {
+	rules, err := listCrushRules()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		existing[rule] = true
+	}
+	result := make(map[string]string)
+	for _, domain := range FailureDomains {
+		if existing[crushRuleName(domain)] {
+			result[domain] = crushRuleName(domain)
+		}
+	}
+	return result, nil
+}
+
+// SetPoolCrushRule makes a replicated pool place its replicas by a rule
+func SetPoolCrushRule(pool, rule string) error {
+	if out, err := CephCommand("osd", "pool", "set", pool, "crush_rule", rule); err != nil {
+		return fmt.Errorf("failed to set the rule of pool %s: %s %s", pool, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cluster
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/paxautoma/operos/components/prospector"
+)
+
+func lldpNode(id, switchName string) *Node {
+	return &Node{
+		Id: id,
+		LatestReport: &prospector.Report{
+			Network: []*prospector.NetworkInterface{
+				{Name: "eno2", Link: true, Neighbor: &prospector.LLDPNeighbor{ChassisName: "other"}},
+				{Name: "eno1", Link: true, Neighbor: &prospector.LLDPNeighbor{ChassisName: switchName}},
+			},
+		},
+	}
+}
+
+func TestNodeLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		node *Node
+		want NodeLocation
+	}{
+		{"unknown", &Node{Id: "a"}, NodeLocation{}},
+		{"lldp", lldpNode("a", "tor r12"), NodeLocation{Rack: "tor r12", Source: LocationFromLLDP}},
+		{"metadata wins", &Node{
+			Id:           "a",
+			LatestReport: lldpNode("a", "tor-r12").LatestReport,
+			Metadata:     &NodeMetadata{Rack: "r1", Zone: "east"},
+		}, NodeLocation{Datacenter: "east", Rack: "r1", Source: LocationFromMetadata}},
+		{"name only", &Node{
+			Id:           "a",
+			LatestReport: lldpNode("a", "tor-r12").LatestReport,
+			Metadata:     &NodeMetadata{Name: "storage 1"},
+		}, NodeLocation{Rack: "tor-r12", Source: LocationFromLLDP}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.node.Location(); got != tt.want {
+				t.Errorf("Location() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	nodes := map[string]*Node{
+		"a": {Id: "a", Metadata: &NodeMetadata{Rack: "r1", Zone: "east"}},
+		"b": {Id: "b", Metadata: &NodeMetadata{Rack: "r1", Zone: "west"}},
+		"c": lldpNode("c", "tor r12"),
+		"d": {Id: "d"},
+	}
+
+	want := []CrushBucket{
+		{Name: "east", Type: "datacenter", Parent: CrushRoot},
+		{Name: "west", Type: "datacenter", Parent: CrushRoot},
+		{Name: "east-r1", Type: "rack", Parent: "east"},
+		{Name: "tor-r12", Type: "rack", Parent: CrushRoot},
+		{Name: "west-r1", Type: "rack", Parent: "west"},
+		{Name: "a", Type: "host", Parent: "east-r1", Source: LocationFromMetadata},
+		{Name: "b", Type: "host", Parent: "west-r1", Source: LocationFromMetadata},
+		{Name: "c", Type: "host", Parent: "tor-r12", Source: LocationFromLLDP},
+		{Name: "d", Type: "host", Parent: CrushRoot},
+	}
+	if got := BuildTopology(nodes); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildTopology() = %+v, want %+v", got, want)
+	}
+}
+
+// crushTree is `ceph osd tree -f json` of a cluster set up before racks: two
+// hosts under the root, an old rack holding a host teamster does not know,
+// and an empty rack
+const crushTree = `{
+    "nodes": [
+        {"id": -1, "name": "default", "type": "root", "type_id": 10, "children": [-5, -3, -2]},
+        {"id": -2, "name": "a", "type": "host", "type_id": 1, "children": [0]},
+        {"id": 0, "name": "osd.0", "type": "osd", "type_id": 0, "crush_weight": 1.0},
+        {"id": -3, "name": "b", "type": "host", "type_id": 1, "children": []},
+        {"id": -5, "name": "old", "type": "rack", "type_id": 3, "children": [-4]},
+        {"id": -4, "name": "gone", "type": "host", "type_id": 1, "children": []},
+        {"id": -6, "name": "empty", "type": "rack", "type_id": 3, "children": []}
+    ],
+    "stray": []
+}`
+
+func TestCrushChanges(t *testing.T) {
+	current, err := parseCrushTree([]byte(crushTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := BuildTopology(map[string]*Node{
+		"a": {Id: "a", Metadata: &NodeMetadata{Rack: "r1"}},
+		"b": {Id: "b"},
+	})
+
+	var got []string
+	for _, args := range crushChanges(current, []string{"replicated_rule", "replicated_host"}, desired) {
+		got = append(got, strings.Join(args, " "))
+	}
+	want := []string{
+		"osd crush add-bucket r1 rack",
+		"osd crush move r1 root=default",
+		"osd crush move a rack=r1 root=default",
+		"osd crush remove empty",
+		"osd crush rule create-replicated replicated_rack default rack",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("crushChanges() = %q, want %q", got, want)
+	}
+
+	t.Run("in sync", func(t *testing.T) {
+		current["r1"] = &crushItem{Type: "rack", Parent: CrushRoot}
+		current["a"].Parent = "r1"
+		delete(current, "empty")
+
+		if changes := crushChanges(current, []string{"replicated_host", "replicated_rack"}, desired); len(changes) != 0 {
+			t.Errorf("crushChanges() = %q, want none", changes)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package collision detects machines which compute the same node UUID.
+//
+// Node UUIDs are derived from CRCs of the hardware inventory, and VMs without
+// serial numbers fall back to the MAC of their first NIC. Cloned VMs and hosts
+// with empty DMI serials can therefore end up with the same UUID, and would
+// be handed the same identity and LUKS key by teamster.
+package collision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sighting is a machine seen reporting a UUID
+type Sighting struct {
+	MACs     []string  `json:"macs"`
+	IP       string    `json:"ip"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// sameMachine tells whether two sightings are of the same machine. A machine
+// keeps its MAC addresses when its IP changes, and keeps its IP when virtual
+// interfaces come and go, but not both.
+func (s *Sighting) sameMachine(other *Sighting) bool {
+	if strings.Join(s.MACs, ",") == strings.Join(other.MACs, ",") {
+		return true
+	}
+
+	if s.IP != other.IP {
+		return false
+	}
+
+	for _, mac := range s.MACs {
+		for _, otherMAC := range other.MACs {
+			if mac == otherMAC {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Collision describes a UUID reported by more than one machine
+type Collision struct {
+	UUID      string      `json:"uuid"`
+	Sightings []*Sighting `json:"sightings"`
+}
+
+func (c *Collision) Error() string {
+	machines := make([]string, 0, len(c.Sightings))
+	for _, s := range c.Sightings {
+		machines = append(machines, fmt.Sprintf("%s [%s]", s.IP, strings.Join(s.MACs, ", ")))
+	}
+	return fmt.Sprintf("node UUID %s is reported by %d different machines: %s",
+		c.UUID, len(c.Sightings), strings.Join(machines, "; "))
+}
+
+// Detector remembers which machines reported each UUID. Reports of the same
+// UUID by different machines within Window are a collision.
+type Detector struct {
+	Window time.Duration
+
+	lock      sync.Mutex
+	sightings map[string][]*Sighting
+	// alerted holds the machines of collisions which were already reported
+	alerted map[string]string
+	now     func() time.Time
+}
+
+func NewDetector(window time.Duration) *Detector {
+	return &Detector{
+		Window:    window,
+		sightings: make(map[string][]*Sighting),
+		alerted:   make(map[string]string),
+		now:       time.Now,
+	}
+}
+
+// Observe records a report of uuid by the machine with the given MAC
+// addresses and IP. It returns a collision if other machines reported the
+// same UUID within the window. isNew tells whether the set of colliding
+// machines changed since the collision was last returned, so that alerts
+// are not repeated on every report.
+func (d *Detector) Observe(uuid string, macs []string, ip string) (collision *Collision, isNew bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := d.now()
+
+	sorted := append([]string(nil), macs...)
+	sort.Strings(sorted)
+	sighting := &Sighting{MACs: sorted, IP: ip, LastSeen: now}
+
+	machines := []*Sighting{sighting}
+	for _, s := range d.sightings[uuid] {
+		if now.Sub(s.LastSeen) <= d.Window && !s.sameMachine(sighting) {
+			machines = append(machines, s)
+		}
+	}
+	d.sightings[uuid] = machines
+
+	if len(machines) < 2 {
+		delete(d.alerted, uuid)
+		return nil, false
+	}
+
+	sort.Slice(machines, func(i, j int) bool { return machines[i].IP < machines[j].IP })
+	collision = &Collision{UUID: uuid}
+	keys := make([]string, 0, len(machines))
+	for _, s := range machines {
+		collision.Sightings = append(collision.Sightings, &Sighting{MACs: s.MACs, IP: s.IP, LastSeen: s.LastSeen})
+		keys = append(keys, s.IP+"/"+strings.Join(s.MACs, ","))
+	}
+
+	fingerprint := strings.Join(keys, "|")
+	isNew = d.alerted[uuid] != fingerprint
+	d.alerted[uuid] = fingerprint
+
+	return collision, isNew
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetector(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	newDetector := func() *Detector {
+		d := NewDetector(30 * time.Minute)
+		d.now = func() time.Time { return now }
+		return d
+	}
+
+	t.Run("SameMachine_NoCollision", func(t *testing.T) {
+		d := newDetector()
+		c, _ := d.Observe("uuid-1", []string{"aa", "bb"}, "10.0.0.5")
+		require.Nil(t, c)
+		c, _ = d.Observe("uuid-1", []string{"bb", "aa"}, "10.0.0.5")
+		require.Nil(t, c)
+	})
+
+	t.Run("SameMachineNewIP_NoCollision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa", "bb"}, "10.0.0.5")
+		c, _ := d.Observe("uuid-1", []string{"aa", "bb"}, "10.0.0.7")
+		require.Nil(t, c)
+	})
+
+	t.Run("SameMachineNewVirtualNIC_NoCollision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa", "bb"}, "10.0.0.5")
+		c, _ := d.Observe("uuid-1", []string{"aa", "bb", "cc"}, "10.0.0.5")
+		require.Nil(t, c)
+	})
+
+	t.Run("DifferentMACs_Collision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa"}, "10.0.0.5")
+
+		c, isNew := d.Observe("uuid-1", []string{"cc"}, "10.0.0.6")
+		require.NotNil(t, c)
+		require.True(t, isNew)
+		require.Len(t, c.Sightings, 2)
+		require.Contains(t, c.Error(), "10.0.0.5")
+		require.Contains(t, c.Error(), "10.0.0.6")
+
+		// Both machines are refused until one of them goes away, but the
+		// collision is only new the first time
+		c, isNew = d.Observe("uuid-1", []string{"aa"}, "10.0.0.5")
+		require.NotNil(t, c)
+		require.False(t, isNew)
+	})
+
+	t.Run("SameIPDifferentMACs_Collision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa"}, "10.0.0.5")
+		c, _ := d.Observe("uuid-1", []string{"cc"}, "10.0.0.5")
+		require.NotNil(t, c)
+	})
+
+	t.Run("DifferentUUIDs_NoCollision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa"}, "10.0.0.5")
+		c, _ := d.Observe("uuid-2", []string{"cc"}, "10.0.0.6")
+		require.Nil(t, c)
+	})
+
+	t.Run("OutsideWindow_NoCollision", func(t *testing.T) {
+		d := newDetector()
+		d.Observe("uuid-1", []string{"aa"}, "10.0.0.5")
+
+		now = now.Add(31 * time.Minute)
+		c, _ := d.Observe("uuid-1", []string{"aa"}, "10.0.0.9")
+		require.Nil(t, c)
+	})
+}
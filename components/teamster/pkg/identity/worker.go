@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/paxautoma/operos/components/teamster/pkg/cluster"
@@ -126,7 +127,15 @@ func KubeCephKeyring(ctx interface{}, data *bytes.Buffer) error {
 func OSDLoadout(ctx interface{}, data *bytes.Buffer) error {
 	info := ctx.(*WorkerContext)
 
-	for bdev_uuid, osd := range info.Node.OSDs {
+	// Sorted so that the file only changes when the loadout does
+	bdevUUIDs := make([]string, 0, len(info.Node.OSDs))
+	for bdev_uuid := range info.Node.OSDs {
+		bdevUUIDs = append(bdevUUIDs, bdev_uuid)
+	}
+	sort.Strings(bdevUUIDs)
+
+	for _, bdev_uuid := range bdevUUIDs {
+		osd := info.Node.OSDs[bdev_uuid]
 		if osd.Id != "" {
 			data.WriteString(fmt.Sprintf("%s:%s:%s\n", bdev_uuid, osd.Id, osd.Key))
 		}
@@ -185,7 +194,7 @@ func ClusterSettings(ctx interface{}, data *bytes.Buffer) error {
 
 func HostnameFile(ctx interface{}, data *bytes.Buffer) error {
 	info := ctx.(*WorkerContext)
-	data.WriteString(info.Node.Id)
+	data.WriteString(info.Node.NodeName())
 	return nil
 }
 
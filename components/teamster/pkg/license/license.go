@@ -0,0 +1,211 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package license reads Operos licenses, which are verified offline against
+// the public key of Pax Automa.
+//
+// A license file is a PEM block of type OPEROS LICENSE. Its content is the
+// JSON encoded License, and its Signature header is the base64 encoded
+// ed25519 signature of that content:
+//
+//	-----BEGIN OPEROS LICENSE-----
+//	Signature: 0i3MxGbp...
+//
+//	eyJpZCI6ICJ0ZXN0LTEi...
+//	-----END OPEROS LICENSE-----
+package license
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	pemType         = "OPEROS LICENSE"
+	signatureHeader = "Signature"
+)
+
+// VendorKey is the base64 encoded public key licenses are signed with. It is
+// set when building releases, with
+// -ldflags "-X github.com/paxautoma/operos/components/teamster/pkg/license.VendorKey=<key>"
+var VendorKey string
+
+// ErrInvalidSignature is returned for licenses which were not signed with
+// the expected key, or were changed after signing
+var ErrInvalidSignature = errors.New("license signature is invalid")
+
+// License is what a customer may do with their Operos cluster
+type License struct {
+	ID       string `json:"id"`
+	Customer string `json:"customer"`
+	// InstallID ties the license to one cluster. Licenses without one are
+	// valid on any cluster.
+	InstallID string `json:"install_id,omitempty"`
+	// Nodes is the number of workers which may be registered, or 0 for no
+	// limit
+	Nodes    int       `json:"nodes"`
+	Features []string  `json:"features,omitempty"`
+	Issued   time.Time `json:"issued"`
+	Expires  time.Time `json:"expires"`
+	// GraceDays is how long after expiry the license keeps working, to
+	// give time for renewal
+	GraceDays int `json:"grace_days"`
+}
+
+// State is where a license is in its lifetime
+type State string
+
+const (
+	StateValid State = "valid"
+	// StateGrace is a license past its expiry, but within its grace period
+	StateGrace   State = "grace"
+	StateExpired State = "expired"
+)
+
+// LimitError is returned when a license does not allow something
+type LimitError string
+
+func (e LimitError) Error() string {
+	return string(e)
+}
+
+// ParsePublicKey reads a base64 encoded ed25519 public key
+func ParsePublicKey(text string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode license key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("license key must be %d bytes, not %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Parse reads a license file, and checks that it was signed with key
+func Parse(data []byte, key ed25519.PublicKey) (*License, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemType {
+		return nil, errors.New("not an Operos license")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(block.Headers[signatureHeader])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, ErrInvalidSignature
+	}
+	if !ed25519.Verify(key, block.Bytes, signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	license := new(License)
+	if err := json.Unmarshal(block.Bytes, license); err != nil {
+		return nil, errors.Wrap(err, "failed to decode license")
+	}
+	if err := license.validate(); err != nil {
+		return nil, err
+	}
+	return license, nil
+}
+
+// Sign encodes a license into a license file signed with key
+func Sign(license *License, key ed25519.PrivateKey) ([]byte, error) {
+	if err := license.validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(license)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode license")
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: pemType,
+		Headers: map[string]string{
+			signatureHeader: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+		},
+		Bytes: data,
+	}), nil
+}
+
+func (l *License) validate() error {
+	switch {
+	case l.ID == "":
+		return errors.New("license has no ID")
+	case l.Nodes < 0:
+		return errors.New("license node count must not be negative")
+	case l.GraceDays < 0:
+		return errors.New("license grace period must not be negative")
+	case !l.Expires.After(l.Issued):
+		return errors.New("license expires before it was issued")
+	}
+	return nil
+}
+
+// GraceEnds is when the license stops working
+func (l *License) GraceEnds() time.Time {
+	return l.Expires.AddDate(0, 0, l.GraceDays)
+}
+
+// State tells whether the license is valid at a time
+func (l *License) State(now time.Time) State {
+	switch {
+	case now.Before(l.Expires):
+		return StateValid
+	case now.Before(l.GraceEnds()):
+		return StateGrace
+	default:
+		return StateExpired
+	}
+}
+
+// HasFeature tells whether the license includes a feature
+func (l *License) HasFeature(feature string) bool {
+	for _, f := range l.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCluster tells whether the license applies to a cluster
+func (l *License) CheckCluster(installID string) error {
+	if l.InstallID != "" && l.InstallID != installID {
+		return LimitError(fmt.Sprintf("license %s is for another cluster", l.ID))
+	}
+	return nil
+}
+
+// CheckRegistration tells whether a cluster may register another worker,
+// which brings it to nodes workers. Workers which are already registered
+// keep working whatever the license says.
+func (l *License) CheckRegistration(installID string, nodes int, now time.Time) error {
+	if err := l.CheckCluster(installID); err != nil {
+		return err
+	}
+	if l.State(now) == StateExpired {
+		return LimitError(fmt.Sprintf("license %s expired on %s", l.ID, l.Expires.Format("2006-01-02")))
+	}
+	if l.Nodes > 0 && nodes > l.Nodes {
+		return LimitError(fmt.Sprintf("license %s allows at most %d workers", l.ID, l.Nodes))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package license
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testKeys reads the test keypair, which must never sign real licenses
+func testKeys(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, err := ioutil.ReadFile("testdata/test.pub")
+	require.NoError(t, err)
+	publicKey, err := ParsePublicKey(string(pub))
+	require.NoError(t, err)
+
+	priv, err := ioutil.ReadFile("testdata/test.key")
+	require.NoError(t, err)
+	privateKey, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(priv)))
+	require.NoError(t, err)
+	return publicKey, ed25519.PrivateKey(privateKey)
+}
+
+func testLicense() *License {
+	return &License{
+		ID:        "test-1",
+		Customer:  "Example Corp",
+		InstallID: "f9018b34-c508-4602-8b38-6af064acdd3b",
+		Nodes:     3,
+		Features:  []string{"support"},
+		Issued:    time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		Expires:   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		GraceDays: 30,
+	}
+}
+
+func TestParse(t *testing.T) {
+	publicKey, privateKey := testKeys(t)
+
+	t.Run("Signed_IsParsed", func(t *testing.T) {
+		data, err := Sign(testLicense(), privateKey)
+		require.NoError(t, err)
+
+		license, err := Parse(data, publicKey)
+		require.NoError(t, err)
+		require.Equal(t, testLicense(), license)
+	})
+
+	t.Run("Sample_IsParsed", func(t *testing.T) {
+		data, err := ioutil.ReadFile("testdata/test.license")
+		require.NoError(t, err)
+
+		license, err := Parse(data, publicKey)
+		require.NoError(t, err)
+		require.Equal(t, "test-1", license.ID)
+		require.True(t, license.HasFeature("support"))
+		require.False(t, license.HasFeature("ha"))
+	})
+
+	t.Run("Changed_IsRefused", func(t *testing.T) {
+		data, err := Sign(testLicense(), privateKey)
+		require.NoError(t, err)
+
+		changed := testLicense()
+		changed.Nodes = 100
+		forged, err := Sign(changed, privateKey)
+		require.NoError(t, err)
+		// The content of one license with the signature of another
+		forged = append(data[:bytes.Index(data, []byte("\n\n"))], forged[bytes.Index(forged, []byte("\n\n")):]...)
+
+		_, err = Parse(forged, publicKey)
+		require.Equal(t, ErrInvalidSignature, err)
+	})
+
+	t.Run("OtherKey_IsRefused", func(t *testing.T) {
+		_, otherKey, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+		data, err := Sign(testLicense(), otherKey)
+		require.NoError(t, err)
+
+		_, err = Parse(data, publicKey)
+		require.Equal(t, ErrInvalidSignature, err)
+	})
+
+	t.Run("NotALicense_IsRefused", func(t *testing.T) {
+		_, err := Parse([]byte("jcCPA6TU87"), publicKey)
+		require.Error(t, err)
+	})
+}
+
+func TestCheckRegistration(t *testing.T) {
+	license := testLicense()
+	installID := license.InstallID
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		installID string
+		nodes     int
+		now       time.Time
+		state     State
+		wantErr   bool
+	}{
+		{"within limits", installID, 3, date(2018, 6, 1), StateValid, false},
+		{"too many nodes", installID, 4, date(2018, 6, 1), StateValid, true},
+		{"in grace period", installID, 2, date(2019, 1, 15), StateGrace, false},
+		{"expired", installID, 2, date(2019, 2, 1), StateExpired, true},
+		{"other cluster", "a1b2c3d4", 1, date(2018, 6, 1), StateValid, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.state, license.State(tt.now))
+			err := license.CheckRegistration(tt.installID, tt.nodes, tt.now)
+			if tt.wantErr {
+				require.IsType(t, LimitError(""), err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
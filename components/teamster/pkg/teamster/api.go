@@ -18,8 +18,12 @@ package teamster
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -29,19 +33,24 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/tredoe/osutil/user/crypt/sha512_crypt"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
 	"github.com/paxautoma/operos/components/prospector"
 	"github.com/paxautoma/operos/components/teamster/pkg/cluster"
+	"github.com/paxautoma/operos/components/teamster/pkg/collision"
 	"github.com/paxautoma/operos/components/teamster/pkg/identity"
+	"github.com/paxautoma/operos/components/teamster/pkg/license"
 	"github.com/paxautoma/operos/components/teamster/pkg/tarball"
 )
 
@@ -49,10 +58,44 @@ type TeamsterAPI struct {
 	cluster     *cluster.OperosCluster
 	shadowFile  string
 	rootAccount string
+
+	// nodesLock serializes node registration and updates, which may now
+	// arrive concurrently from boot-time and periodic reports
+	nodesLock sync.Mutex
+	// reboots are the nodes which were asked to reboot, but have not yet
+	// been told so. They are guarded by nodesLock, and forgotten if teamster
+	// restarts.
+	reboots map[string]bool
+
+	collisions *collision.Detector
+
+	// licenseKey verifies licenses, and license is the verified license of
+	// the cluster, if it has one. license is guarded by nodesLock.
+	licenseKey ed25519.PublicKey
+	license    *license.License
 }
 
+// DefaultCollisionWindow is how long a machine reporting a UUID blocks other
+// machines from using it. It spans several prospector agent intervals.
+const DefaultCollisionWindow = 30 * time.Minute
+
+// poolNameRe keeps pool names from being taken for options by the ceph CLI
+var poolNameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
 func NewTeamsterAPI(c *cluster.OperosCluster, shadowFile, rootAccount string) *TeamsterAPI {
-	return &TeamsterAPI{c, shadowFile, rootAccount}
+	return &TeamsterAPI{
+		cluster:     c,
+		shadowFile:  shadowFile,
+		rootAccount: rootAccount,
+		collisions:  collision.NewDetector(DefaultCollisionWindow),
+		reboots:     make(map[string]bool),
+	}
+}
+
+// SetCollisionWindow changes how long reports are remembered for detecting
+// machines which share a UUID
+func (t *TeamsterAPI) SetCollisionWindow(window time.Duration) {
+	t.collisions.Window = window
 }
 
 func (t *TeamsterAPI) GetHttpHandler() http.Handler {
@@ -62,6 +105,16 @@ func (t *TeamsterAPI) GetHttpHandler() http.Handler {
 		Path("/whoami").
 		Name("whoami").
 		Handler(http.HandlerFunc(t.Whoami))
+	router.
+		Methods("POST").
+		Path("/report").
+		Name("report").
+		Handler(http.HandlerFunc(t.Report))
+	router.
+		Methods("POST").
+		Path("/commands/{uuid}").
+		Name("commands").
+		Handler(http.HandlerFunc(t.Commands))
 	router.
 		Methods("GET").
 		Path("/clientcert").
@@ -76,49 +129,44 @@ func (t *TeamsterAPI) RegisterGRPCService(grpcServer *grpc.Server) {
 }
 
 func (t *TeamsterAPI) Whoami(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
-	if err != nil {
-		log.Println(err)
+	report, uuid, ok := readReport(w, r)
+	if !ok {
 		return
 	}
 
-	if err := r.Body.Close(); err != nil {
-		log.Println(err)
-		return
-	}
-
-	report := new(prospector.Report)
+	uuidString := uuid.ToString()
 
-	if err := json.Unmarshal(body, &report); err != nil {
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		if err := json.NewEncoder(w).Encode(err); err != nil {
-			log.Println(err)
-		}
-		return
-	}
+	log.Printf("UUID for node is %s", uuidString)
 
-	uuid, err := report.System.GetUUID()
-	if err != nil {
-		log.Println(err)
+	if !t.checkIdentity(w, r, report, uuidString) {
 		return
 	}
 
-	uuidString := uuid.ToString()
-
-	log.Printf("UUID for node is %s", uuidString)
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
 
 	node, exists := t.cluster.Nodes[uuidString]
 
+	var err error
 	if !exists {
 		log.Printf("%s does not exist: node: %p", uuidString, node)
-		node, err = t.cluster.AddNode(&uuid, uuidString, report)
+		if err := t.checkLicense(len(t.cluster.Nodes) + 1); err != nil {
+			log.Printf("Refusing to register node %s: %s", uuidString, err)
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		node, err = t.cluster.AddNode(uuid, uuidString, report)
 		if err != nil {
 			return
 		}
 	} else {
-		err = t.cluster.UpdateNode(node, &uuid, uuidString, report)
+		// The node is handed its current kubelet certificate, so one it held
+		// before being renamed is no longer accepted
+		previous := node.PreviousKubeletCN
+		node.PreviousKubeletCN = ""
+		err = t.cluster.UpdateNode(node, uuid, uuidString, report)
 		if err != nil {
+			node.PreviousKubeletCN = previous
 			return
 		}
 	}
@@ -132,6 +180,226 @@ func (t *TeamsterAPI) Whoami(w http.ResponseWriter, r *http.Request) {
 	tarball.SendTarball(identity.WorkerManifest, &ctx, w, "worker-credentials.tar.gz")
 }
 
+// Report accepts the periodic reports of the prospector agent running on
+// registered workers. The node's inventory and OSDs are reconciled like on
+// boot, but no credentials are issued; only the OSD loadout is returned, along
+// with the queued commands when the client presented the node's certificate.
+func (t *TeamsterAPI) Report(w http.ResponseWriter, r *http.Request) {
+	report, uuid, ok := readReport(w, r)
+	if !ok {
+		return
+	}
+
+	uuidString := uuid.ToString()
+
+	if !t.checkIdentity(w, r, report, uuidString) {
+		return
+	}
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	node, exists := t.cluster.Nodes[uuidString]
+	if !exists {
+		log.Printf("report from unknown node %s at %s", uuidString, r.RemoteAddr)
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("node %s is not registered", uuidString))
+		return
+	}
+
+	if err := t.cluster.UpdateNode(node, uuid, uuidString, report); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to update node"))
+		return
+	}
+
+	var loadout bytes.Buffer
+	if err := identity.OSDLoadout(&identity.WorkerContext{Node: node, Cluster: t.cluster}, &loadout); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to generate OSD loadout"))
+		return
+	}
+
+	result := &prospector.ReportResult{
+		OSDLoadout: loadout.String(),
+	}
+	if isNodeCertificate(r, node) {
+		result.Commands = t.takeCommands(uuidString)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println(err)
+	}
+}
+
+// Commands hands the prospector agent of a node the commands queued for it.
+// Each command is handed out once, and only to a client presenting the
+// node's kubelet certificate.
+func (t *TeamsterAPI) Commands(w http.ResponseWriter, r *http.Request) {
+	uuid := mux.Vars(r)["uuid"]
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	node, exists := t.cluster.Nodes[uuid]
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("node %s is not registered", uuid))
+		return
+	}
+
+	if !isNodeCertificate(r, node) {
+		log.Printf("refusing commands for node %s to %s: no certificate of the node", uuid, r.RemoteAddr)
+		writeJSONError(w, http.StatusForbidden, fmt.Errorf("the certificate of node %s is required", uuid))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(t.takeCommands(uuid)); err != nil {
+		log.Println(err)
+	}
+}
+
+// isNodeCertificate tells whether the client of a request presented the
+// kubelet certificate of a node. A renamed node still holds the certificate
+// for its previous name until it registers again. Only connections accepted
+// with NodeTLSConfig have verified client certificates.
+func isNodeCertificate(r *http.Request, node *cluster.Node) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return cn == node.KubeletCN() || (node.PreviousKubeletCN != "" && cn == node.PreviousKubeletCN)
+}
+
+// NodeTLSConfig is the TLS configuration for serving the prospector agents of
+// nodes. Clients may identify themselves with a certificate issued by the
+// cluster CA, such as the kubelet certificate of a node.
+func (t *TeamsterAPI) NodeTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load TLS certificate")
+	}
+
+	ca, err := t.cluster.GetCACert()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load cluster CA")
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}, nil
+}
+
+// takeCommands returns the commands queued for a node and forgets them. It
+// must be called with nodesLock held.
+func (t *TeamsterAPI) takeCommands(uuid string) prospector.Commands {
+	commands := prospector.Commands{Reboot: t.reboots[uuid]}
+	if commands.Reboot {
+		log.Printf("telling node %s to reboot", uuid)
+		delete(t.reboots, uuid)
+	}
+	return commands
+}
+
+// checkIdentity refuses reports for a UUID which a different machine has
+// reported recently, so that cloned machines are not handed the same identity
+// and LUKS key. Both machines are refused until one of them goes away.
+func (t *TeamsterAPI) checkIdentity(w http.ResponseWriter, r *http.Request, report *prospector.Report, uuid string) bool {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	c, isNew := t.collisions.Observe(uuid, report.MACAddresses(), ip)
+	if c == nil {
+		return true
+	}
+
+	if isNew {
+		alert := &cluster.Alert{
+			Kind:    cluster.AlertIdentityCollision,
+			Subject: uuid,
+			Message: c.Error(),
+			Details: c,
+		}
+		if err := t.cluster.RaiseAlert(alert); err != nil {
+			log.Printf("failed to store alert: %s", err)
+		}
+	}
+
+	log.Printf("refusing report from %s: %s", ip, c)
+	writeJSONError(w, http.StatusConflict, c)
+	return false
+}
+
+// readReport reads and validates a prospector report from the request body,
+// writing an error response if it can't be used
+func readReport(w http.ResponseWriter, r *http.Request) (*prospector.Report, *prospector.UUIDType, bool) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		log.Println(err)
+		return nil, nil, false
+	}
+
+	if err := r.Body.Close(); err != nil {
+		log.Println(err)
+		return nil, nil, false
+	}
+
+	report, err := prospector.ParseReport(body)
+	if err != nil {
+		log.Printf("rejecting report from %s: %s", r.RemoteAddr, err)
+		status := http.StatusUnprocessableEntity
+		if _, ok := err.(*prospector.UnsupportedSchemaError); ok {
+			status = http.StatusBadRequest
+		}
+		writeJSONError(w, status, err)
+		return nil, nil, false
+	}
+
+	log.Printf("report from %s: schema %s, agent %s, host %q", r.RemoteAddr, report.SchemaVersion, report.AgentVersion, report.Hostname)
+
+	uuid, err := report.System.GetUUID()
+	if err != nil {
+		log.Println(err)
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return nil, nil, false
+	}
+
+	return report, &uuid, true
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	resp := struct {
+		Error string `json:"error"`
+	}{err.Error()}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Println(err)
+	}
+}
+
+// Headers of /clientcert responses describing the issued certificate
+const (
+	CertificateSerialHeader = "X-Certificate-Serial"
+	CertificateExpiryHeader = "X-Certificate-Expiry"
+)
+
+const (
+	// DefaultClientCertTTL is how long client certificates are valid unless
+	// the caller asks otherwise
+	DefaultClientCertTTL = 7 * 24 * time.Hour
+
+	// MaxClientCertTTL limits how long client certificates are valid, since
+	// the API server has no way to learn that one was revoked
+	MaxClientCertTTL = 90 * 24 * time.Hour
+)
+
+// GenClientCert issues a client certificate and kubeconfig for a user, as a
+// tarball. The certificate is valid for the duration given by ttl, or for
+// DefaultClientCertTTL.
 func (t *TeamsterAPI) GenClientCert(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 	user := query.Get("user")
@@ -143,11 +411,36 @@ func (t *TeamsterAPI) GenClientCert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, p, err := t.cluster.AddUser(user, groups)
+	ttl := DefaultClientCertTTL
+	if value := query.Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %q", value), http.StatusBadRequest)
+			return
+		}
+		if ttl > MaxClientCertTTL {
+			http.Error(w, fmt.Sprintf("ttl may be at most %s", MaxClientCertTTL), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, p, err := t.cluster.AddUser(user, groups, ttl)
 	if err != nil {
 		panic(errors.Wrap(err, "failed to create user credentials"))
 	}
 
+	// The serial and expiry let the caller keep track of what was issued
+	block, _ := pem.Decode(c)
+	if block == nil {
+		panic(errors.New("failed to decode the issued certificate"))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to parse the issued certificate"))
+	}
+	w.Header().Set(CertificateSerialHeader, cert.SerialNumber.Text(16))
+	w.Header().Set(CertificateExpiryHeader, cert.NotAfter.UTC().Format(time.RFC3339))
+
 	if host == "" {
 		host, err = getAPIServerIP(t.cluster.Vars["CONTROLLER_PRIVATE_IF"])
 		if err != nil {
@@ -171,15 +464,293 @@ func (t *TeamsterAPI) GenClientCert(w http.ResponseWriter, r *http.Request) {
 }
 
 func (t *TeamsterAPI) ListNodes(ctx context.Context, req *Empty) (*ListNodesResponse, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
 	respNodes := make([]*NodeSummary, len(t.cluster.Nodes))
 	idx := 0
-	for uuid := range t.cluster.Nodes {
-		respNodes[idx] = &NodeSummary{Uuid: uuid}
+	for _, node := range t.cluster.Nodes {
+		respNodes[idx] = nodeSummary(node)
 		idx++
 	}
 	return &ListNodesResponse{Nodes: respNodes}, nil
 }
 
+func nodeSummary(node *cluster.Node) *NodeSummary {
+	return &NodeSummary{
+		Uuid:     node.Id,
+		Metadata: metadataToProto(node.Metadata),
+		Hostname: node.NodeName(),
+	}
+}
+
+func (t *TeamsterAPI) RenameNode(ctx context.Context, req *RenameNodeRequest) (*NodeSummary, error) {
+	if err := cluster.ValidateHostname(req.Hostname); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	node, ok := t.cluster.Nodes[req.Uuid]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "node not found")
+	}
+
+	if err := t.cluster.RenameNode(node, req.Hostname); err == cluster.ErrHostnameTaken {
+		return nil, grpc.Errorf(codes.AlreadyExists, "hostname %s is already taken", req.Hostname)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to rename node")
+	}
+	return nodeSummary(node), nil
+}
+
+func (t *TeamsterAPI) GetNodeMetadata(ctx context.Context, req *GetNodeMetadataRequest) (*NodeMetadata, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	node, ok := t.cluster.Nodes[req.Uuid]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "node not found")
+	}
+	return metadataToProto(node.Metadata), nil
+}
+
+func (t *TeamsterAPI) SetNodeMetadata(ctx context.Context, req *SetNodeMetadataRequest) (*NodeMetadata, error) {
+	md := metadataFromProto(req.Metadata)
+	if err := md.Validate(); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	node, ok := t.cluster.Nodes[req.Uuid]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "node not found")
+	}
+
+	if err := t.cluster.SetNodeMetadata(node, md); err != nil {
+		return nil, errors.Wrap(err, "failed to store node metadata")
+	}
+	return metadataToProto(node.Metadata), nil
+}
+
+func metadataToProto(md *cluster.NodeMetadata) *NodeMetadata {
+	if md == nil {
+		return &NodeMetadata{}
+	}
+
+	result := &NodeMetadata{
+		Name:   md.Name,
+		Labels: md.Labels,
+		Rack:   md.Rack,
+		Zone:   md.Zone,
+	}
+	for _, taint := range md.Taints {
+		result.Taints = append(result.Taints, &Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect})
+	}
+	return result
+}
+
+func metadataFromProto(md *NodeMetadata) *cluster.NodeMetadata {
+	if md == nil {
+		return &cluster.NodeMetadata{}
+	}
+
+	result := &cluster.NodeMetadata{
+		Name:   strings.TrimSpace(md.Name),
+		Labels: md.Labels,
+		Rack:   md.Rack,
+		Zone:   md.Zone,
+	}
+	for _, taint := range md.Taints {
+		result.Taints = append(result.Taints, cluster.Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect})
+	}
+	return result
+}
+
+func (t *TeamsterAPI) GetCrushTopology(ctx context.Context, req *Empty) (*GetCrushTopologyResponse, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	return t.crushTopology()
+}
+
+func (t *TeamsterAPI) ReconcileCrush(ctx context.Context, req *Empty) (*GetCrushTopologyResponse, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	if err := t.cluster.ReconcileCrush(); err != nil {
+		return nil, errors.Wrap(err, "failed to update the CRUSH map")
+	}
+	return t.crushTopology()
+}
+
+// crushTopology must be called with nodesLock held
+func (t *TeamsterAPI) crushTopology() (*GetCrushTopologyResponse, error) {
+	rules, err := cluster.CrushRules()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &GetCrushTopologyResponse{Rules: rules}
+	for _, bucket := range cluster.BuildTopology(t.cluster.Nodes) {
+		resp.Buckets = append(resp.Buckets, &CrushBucket{
+			Name:   bucket.Name,
+			Type:   bucket.Type,
+			Parent: bucket.Parent,
+			Source: bucket.Source,
+		})
+	}
+	return resp, nil
+}
+
+func (t *TeamsterAPI) SetPoolFailureDomain(ctx context.Context, req *SetPoolFailureDomainRequest) (*Empty, error) {
+	if !poolNameRe.MatchString(req.Pool) {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid pool name %q", req.Pool)
+	}
+
+	rules, err := cluster.CrushRules()
+	if err != nil {
+		return nil, err
+	}
+	rule, ok := rules[req.FailureDomain]
+	if !ok {
+		for _, domain := range cluster.FailureDomains {
+			if domain == req.FailureDomain {
+				return nil, grpc.Errorf(codes.FailedPrecondition, "no nodes are placed in a %s yet", domain)
+			}
+		}
+		return nil, grpc.Errorf(codes.InvalidArgument, "failure domain must be one of %s", strings.Join(cluster.FailureDomains, ", "))
+	}
+
+	log.Printf("placing replicas of pool %s by %s", req.Pool, req.FailureDomain)
+	if err := cluster.SetPoolCrushRule(req.Pool, rule); err != nil {
+		return nil, err
+	}
+	return &Empty{}, nil
+}
+
+func (t *TeamsterAPI) GetCephReport(ctx context.Context, req *Empty) (*CephReport, error) {
+	report, err := cluster.GetCephReport()
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unavailable, "%s", err)
+	}
+
+	resp := &CephReport{
+		Status:     string(report.Status),
+		OsdDump:    string(report.OSDDump),
+		OsdDf:      string(report.OSDDF),
+		Df:         string(report.DF),
+		CrushRules: string(report.CrushRules),
+	}
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	for _, location := range t.cluster.OSDLocations() {
+		osd := &OSDLocation{
+			OsdId:    location.OSDID,
+			OsdUuid:  location.OSDUUID,
+			NodeUuid: location.Node.Id,
+			Hostname: location.Node.NodeName(),
+		}
+		if location.Device != nil {
+			osd.Device = location.Device.Name
+			osd.Model = strings.TrimSpace(location.Device.Model)
+			osd.Serial = strings.TrimSpace(location.Device.Serial)
+		}
+		resp.Osds = append(resp.Osds, osd)
+	}
+	return resp, nil
+}
+
+func (t *TeamsterAPI) CreatePool(ctx context.Context, req *CreatePoolRequest) (*Empty, error) {
+	if !poolNameRe.MatchString(req.Name) {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid pool name %q", req.Name)
+	}
+	if req.Size < 1 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "a pool must keep at least one replica")
+	}
+	if req.PgNum < 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid number of placement groups %d", req.PgNum)
+	}
+
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	err := t.cluster.CreatePool(req.Name, int(req.Size), int(req.PgNum))
+	if err == cluster.ErrPoolExists {
+		return nil, grpc.Errorf(codes.AlreadyExists, "pool %s already exists", req.Name)
+	} else if _, ok := err.(cluster.CapacityError); ok {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s", err)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to create pool")
+	}
+	return &Empty{}, nil
+}
+
+func (t *TeamsterAPI) GetClusterSettings(ctx context.Context, req *Empty) (*GetClusterSettingsResponse, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	vars := make(map[string]string, len(t.cluster.Vars))
+	for key, value := range t.cluster.Vars {
+		vars[key] = value
+	}
+	return &GetClusterSettingsResponse{
+		Settings: settingsToProto(t.cluster.Settings()),
+		Vars:     vars,
+	}, nil
+}
+
+func (t *TeamsterAPI) UpdateClusterSettings(ctx context.Context, req *ClusterSettings) (*UpdateClusterSettingsResponse, error) {
+	settings := settingsFromProto(req)
+	if err := settings.Validate(); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	// Held so that workers fetching their loadout see all of the settings
+	// or none of them
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	rebootRequired, err := t.cluster.UpdateSettings(settings)
+	if _, ok := err.(cluster.SettingsError); ok {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to store cluster settings")
+	}
+	return &UpdateClusterSettingsResponse{
+		Settings:       settingsToProto(t.cluster.Settings()),
+		RebootRequired: rebootRequired,
+	}, nil
+}
+
+func settingsToProto(settings *cluster.Settings) *ClusterSettings {
+	return &ClusterSettings{
+		DnsDomain:         settings.DNSDomain,
+		PublicHostname:    settings.PublicHostname,
+		NtpServers:        settings.NTPServers,
+		StoragePercentage: int32(settings.StoragePercentage),
+	}
+}
+
+func settingsFromProto(settings *ClusterSettings) *cluster.Settings {
+	result := &cluster.Settings{
+		DNSDomain:         strings.ToLower(strings.TrimSpace(settings.DnsDomain)),
+		PublicHostname:    strings.ToLower(strings.TrimSpace(settings.PublicHostname)),
+		StoragePercentage: int(settings.StoragePercentage),
+	}
+	for _, server := range settings.NtpServers {
+		if server = strings.ToLower(strings.TrimSpace(server)); server != "" {
+			result.NTPServers = append(result.NTPServers, server)
+		}
+	}
+	return result
+}
+
 func (t *TeamsterAPI) GetNodeHardware(ctx context.Context, req *GetNodeHardwareRequest) (*GetNodeHardwareResponse, error) {
 	if node, ok := t.cluster.Nodes[req.Uuid]; ok {
 		//XXX: disregard error, bad form
@@ -189,6 +760,19 @@ func (t *TeamsterAPI) GetNodeHardware(ctx context.Context, req *GetNodeHardwareR
 	return nil, grpc.Errorf(codes.NotFound, "node not found")
 }
 
+func (t *TeamsterAPI) RebootNode(ctx context.Context, req *RebootNodeRequest) (*Empty, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	if _, ok := t.cluster.Nodes[req.Uuid]; !ok {
+		return nil, grpc.Errorf(codes.NotFound, "node not found")
+	}
+
+	log.Printf("queueing reboot of node %s", req.Uuid)
+	t.reboots[req.Uuid] = true
+	return &Empty{}, nil
+}
+
 func getAPIServerIP(ifname string) (string, error) {
 	iface, err := net.InterfaceByName(ifname)
 	if err != nil {
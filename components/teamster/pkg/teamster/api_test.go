@@ -20,8 +20,11 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	fmt "fmt"
 	"io"
@@ -35,9 +38,15 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/coreos/etcd/clientv3"
+	"github.com/paxautoma/operos/components/prospector"
 	"github.com/paxautoma/operos/components/teamster/pkg/cluster"
+	"github.com/paxautoma/operos/components/teamster/pkg/license"
 )
 
 var (
@@ -100,6 +109,7 @@ func TestGenClientCert(t *testing.T) {
 		require.Contains(t, cert.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
 		require.Equal(t, "mytestuser", cert.Subject.CommonName)
 		require.Contains(t, cert.Issuer.CommonName, "systest-teamster")
+		require.WithinDuration(t, time.Now().Add(DefaultClientCertTTL), cert.NotAfter, time.Hour)
 
 		name := pkix.Name{}
 		name.FillFromRDNSequence(&pkix.RDNSequence{cert.Subject.Names})
@@ -113,6 +123,46 @@ func TestGenClientCert(t *testing.T) {
 		require.NoError(t, key.Validate())
 	})
 
+	t.Run("TTL_LimitsValidity", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/clientcert?user=mytestuser&group=mytestgroup&ttl=24h", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler := api.GetHttpHandler()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		resp, err := readTarball(rr.Body)
+		require.NoError(t, err)
+		certBlock, _ := pem.Decode(resp["operos-credentials/cert.pem"])
+		require.NotNil(t, certBlock)
+		cert, err := x509.ParseCertificate(certBlock.Bytes)
+		require.NoError(t, err)
+
+		require.WithinDuration(t, time.Now().Add(24*time.Hour), cert.NotAfter, time.Hour)
+		require.Equal(t, cert.SerialNumber.Text(16), rr.Header().Get(CertificateSerialHeader))
+		require.Equal(t, cert.NotAfter.UTC().Format(time.RFC3339), rr.Header().Get(CertificateExpiryHeader))
+	})
+
+	t.Run("InvalidTTL_ReturnsBadRequest", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/clientcert?user=mytestuser&group=mytestgroup&ttl=-1h", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(api.GenClientCert)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, rr.Code, http.StatusBadRequest)
+	})
+
+	t.Run("LongTTL_ReturnsBadRequest", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/clientcert?user=mytestuser&group=mytestgroup&ttl=8760h", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(api.GenClientCert)
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, rr.Code, http.StatusBadRequest)
+	})
+
 	t.Run("MissingUsername_ReturnsBadRequest", func(t *testing.T) {
 		req, err := http.NewRequest("GET", "/clientcert?group=mytestgroup", nil)
 		require.NoError(t, err)
@@ -167,6 +217,512 @@ func TestWhoami(t *testing.T) {
 		require.Contains(t, keys, "first test key")
 		require.Contains(t, keys, "second test key")
 	})
+
+	t.Run("SameUUIDFromAnotherMachine_ReturnsConflict", func(t *testing.T) {
+		body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+		require.NoError(t, err)
+
+		// A clone: same hardware, different MAC address and IP
+		report, err := prospector.ParseReport(body)
+		require.NoError(t, err)
+		report.Network = []*prospector.NetworkInterface{{Name: "eth0", MAC: "02:00:00:00:00:99"}}
+		clone, err := json.Marshal(report)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(clone))
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.99:40000"
+		rr := httptest.NewRecorder()
+		handler := api.GetHttpHandler()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusConflict, rr.Code)
+		require.Contains(t, rr.Body.String(), "different machines")
+	})
+
+	t.Run("UnknownSchemaVersion_ReturnsBadRequest", func(t *testing.T) {
+		body := `{"schema_version": "99.0", "hardware": {"system": {"id": "x", "class": "system"}}}`
+
+		req, err := http.NewRequest("POST", "/whoami", strings.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler := api.GetHttpHandler()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "unsupported report schema version")
+	})
+
+	t.Run("MalformedReport_ReturnsUnprocessableEntity", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/whoami", strings.NewReader(`{"hardware": `))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		handler := api.GetHttpHandler()
+		handler.ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	})
+}
+
+func TestReport(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+
+	t.Run("RegisteredNode_ReturnsLoadout", func(t *testing.T) {
+		// Make sure the node is registered
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		req, err = http.NewRequest("POST", "/report", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr = httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusOK, rr.Code)
+		var result prospector.ReportResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	})
+
+	t.Run("MalformedReport_ReturnsUnprocessableEntity", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/report", strings.NewReader(`{"hardware": `))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	})
+}
+
+func TestRebootNode(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	api.GetHttpHandler().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	credentials, err := readTarball(rr.Body)
+	require.NoError(t, err)
+
+	report, err := prospector.ParseReport(body)
+	require.NoError(t, err)
+	uuid, err := report.System.GetUUID()
+	require.NoError(t, err)
+
+	// The connection state of a TLS client presenting the node's kubelet
+	// certificate
+	certBlock, _ := pem.Decode(credentials["etc/kubernetes/ssl/worker.pem"])
+	require.NotNil(t, certBlock)
+	nodeCert, err := x509.ParseCertificate(certBlock.Bytes)
+	require.NoError(t, err)
+	nodeTLS := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{nodeCert}}}
+
+	poll := func() prospector.Commands {
+		req, err := http.NewRequest("POST", "/commands/"+uuid.ToString(), nil)
+		require.NoError(t, err)
+		req.TLS = nodeTLS
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var commands prospector.Commands
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &commands))
+		return commands
+	}
+
+	t.Run("NoReboot_IsNotCommanded", func(t *testing.T) {
+		require.False(t, poll().Reboot)
+	})
+
+	t.Run("QueuedReboot_IsCommandedOnce", func(t *testing.T) {
+		_, err := api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+
+		require.True(t, poll().Reboot)
+		require.False(t, poll().Reboot)
+	})
+
+	sendReport := func(state *tls.ConnectionState) prospector.ReportResult {
+		req, err := http.NewRequest("POST", "/report", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.TLS = state
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var result prospector.ReportResult
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+
+	t.Run("QueuedReboot_IsCommandedWithReport", func(t *testing.T) {
+		_, err := api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+
+		require.True(t, sendReport(nodeTLS).Reboot)
+		require.False(t, poll().Reboot)
+	})
+
+	t.Run("QueuedReboot_IsNotReportedWithoutCertificate", func(t *testing.T) {
+		_, err := api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+
+		require.False(t, sendReport(nil).Reboot)
+		require.True(t, poll().Reboot)
+	})
+
+	t.Run("OtherClients_AreForbidden", func(t *testing.T) {
+		_, err := api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+
+		other := *nodeCert
+		other.Subject = pkix.Name{CommonName: "system:node:someone-else"}
+		for _, state := range []*tls.ConnectionState{nil, {}, {VerifiedChains: [][]*x509.Certificate{{&other}}}} {
+			req, err := http.NewRequest("POST", "/commands/"+uuid.ToString(), nil)
+			require.NoError(t, err)
+			req.TLS = state
+			rr := httptest.NewRecorder()
+			api.GetHttpHandler().ServeHTTP(rr, req)
+			require.Equal(t, http.StatusForbidden, rr.Code)
+		}
+
+		require.True(t, poll().Reboot)
+	})
+
+	t.Run("RenamedNode_KeepsCertificateUntilRegistered", func(t *testing.T) {
+		_, err := api.RenameNode(context.Background(), &RenameNodeRequest{Uuid: uuid.ToString(), Hostname: "rebooted-1"})
+		require.NoError(t, err)
+		_, err = api.RenameNode(context.Background(), &RenameNodeRequest{Uuid: uuid.ToString(), Hostname: "rebooted-2"})
+		require.NoError(t, err)
+
+		_, err = api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+		require.True(t, poll().Reboot)
+
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		req, err = http.NewRequest("POST", "/commands/"+uuid.ToString(), nil)
+		require.NoError(t, err)
+		req.TLS = nodeTLS
+		rr = httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("UnknownNode_IsNotFound", func(t *testing.T) {
+		_, err := api.RebootNode(context.Background(), &RebootNodeRequest{Uuid: "unknown"})
+		require.Error(t, err)
+
+		req, err := http.NewRequest("POST", "/commands/unknown", nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestNodeMetadata(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	api.GetHttpHandler().ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	report, err := prospector.ParseReport(body)
+	require.NoError(t, err)
+	uuid, err := report.System.GetUUID()
+	require.NoError(t, err)
+
+	md := &NodeMetadata{
+		Name:   "storage-1",
+		Labels: map[string]string{"tier": "storage"},
+		Taints: []*Taint{{Key: "dedicated", Value: "storage", Effect: "NoSchedule"}},
+		Rack:   "r12",
+	}
+
+	t.Run("SetMetadata_IsListed", func(t *testing.T) {
+		_, err := api.SetNodeMetadata(context.Background(), &SetNodeMetadataRequest{Uuid: uuid.ToString(), Metadata: md})
+		require.NoError(t, err)
+
+		got, err := api.GetNodeMetadata(context.Background(), &GetNodeMetadataRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+		require.Equal(t, md, got)
+
+		res, err := api.ListNodes(context.Background(), &Empty{})
+		require.NoError(t, err)
+		for _, node := range res.Nodes {
+			if node.Uuid == uuid.ToString() {
+				require.Equal(t, md, node.Metadata)
+			}
+		}
+	})
+
+	t.Run("Metadata_SurvivesReregistration", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		// A fresh teamster loads the metadata from etcd
+		api, err := setupAPI()
+		require.NoError(t, err)
+		got, err := api.GetNodeMetadata(context.Background(), &GetNodeMetadataRequest{Uuid: uuid.ToString()})
+		require.NoError(t, err)
+		require.Equal(t, md, got)
+	})
+
+	t.Run("InvalidMetadata_IsRefused", func(t *testing.T) {
+		_, err := api.SetNodeMetadata(context.Background(), &SetNodeMetadataRequest{
+			Uuid:     uuid.ToString(),
+			Metadata: &NodeMetadata{Labels: map[string]string{"kubernetes.io/hostname": "x"}},
+		})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+	})
+
+	t.Run("UnknownNode_IsNotFound", func(t *testing.T) {
+		_, err := api.GetNodeMetadata(context.Background(), &GetNodeMetadataRequest{Uuid: "unknown"})
+		require.Equal(t, codes.NotFound, grpc.Code(err))
+	})
+}
+
+func TestRenameNode(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+
+	whoami := func() map[string][]byte {
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		resp, err := readTarball(rr.Body)
+		require.NoError(t, err)
+		return resp
+	}
+	whoami()
+
+	report, err := prospector.ParseReport(body)
+	require.NoError(t, err)
+	uuid, err := report.System.GetUUID()
+	require.NoError(t, err)
+
+	t.Run("Rename_IssuesCertificate", func(t *testing.T) {
+		summary, err := api.RenameNode(context.Background(), &RenameNodeRequest{Uuid: uuid.ToString(), Hostname: "storage-1"})
+		require.NoError(t, err)
+		require.Equal(t, "storage-1", summary.Hostname)
+
+		resp := whoami()
+		require.Equal(t, "storage-1", string(resp["etc/hostname"]))
+
+		block, _ := pem.Decode(resp["etc/kubernetes/ssl/worker.pem"])
+		require.NotNil(t, block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		require.Equal(t, "system:node:storage-1", cert.Subject.CommonName)
+	})
+
+	t.Run("InvalidHostname_IsRefused", func(t *testing.T) {
+		_, err := api.RenameNode(context.Background(), &RenameNodeRequest{Uuid: uuid.ToString(), Hostname: "Storage_1"})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+	})
+
+	t.Run("UnknownNode_IsNotFound", func(t *testing.T) {
+		_, err := api.RenameNode(context.Background(), &RenameNodeRequest{Uuid: "unknown", Hostname: "storage-2"})
+		require.Equal(t, codes.NotFound, grpc.Code(err))
+	})
+}
+
+func TestClusterSettings(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+
+	whoami := func() string {
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		resp, err := readTarball(rr.Body)
+		require.NoError(t, err)
+		return string(resp["etc/paxautoma/settings"])
+	}
+
+	original, err := api.GetClusterSettings(context.Background(), &Empty{})
+	require.NoError(t, err)
+	defer api.UpdateClusterSettings(context.Background(), original.Settings)
+
+	t.Run("Update_ReachesWorkers", func(t *testing.T) {
+		settings := *original.Settings
+		settings.NtpServers = []string{"ntp1.example.com", "NTP2.example.com "}
+		settings.PublicHostname = "operos-test.example.com"
+
+		resp, err := api.UpdateClusterSettings(context.Background(), &settings)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ntp_servers"}, resp.RebootRequired)
+		require.Equal(t, []string{"ntp1.example.com", "ntp2.example.com"}, resp.Settings.NtpServers)
+
+		current, err := api.GetClusterSettings(context.Background(), &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, "operos-test.example.com", current.Vars[cluster.PublicHostnameVar])
+
+		file := whoami()
+		require.Contains(t, file, `OPEROS_NTP_SERVERS="ntp1.example.com ntp2.example.com"`)
+		require.Contains(t, file, `OPEROS_PUBLIC_HOSTNAME="operos-test.example.com"`)
+	})
+
+	t.Run("Unchanged_NeedsNoReboot", func(t *testing.T) {
+		current, err := api.GetClusterSettings(context.Background(), &Empty{})
+		require.NoError(t, err)
+		resp, err := api.UpdateClusterSettings(context.Background(), current.Settings)
+		require.NoError(t, err)
+		require.Empty(t, resp.RebootRequired)
+	})
+
+	t.Run("InvalidSettings_AreRefused", func(t *testing.T) {
+		settings := *original.Settings
+		settings.DnsDomain = "cluster_local"
+		settings.StoragePercentage = 95
+
+		_, err := api.UpdateClusterSettings(context.Background(), &settings)
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+		require.Contains(t, err.Error(), "dns_domain")
+		require.Contains(t, err.Error(), "storage_percentage")
+	})
+
+	t.Run("DNSDomain_IsReadOnly", func(t *testing.T) {
+		settings := *original.Settings
+		settings.DnsDomain = "k8s.example.com"
+
+		_, err := api.UpdateClusterSettings(context.Background(), &settings)
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+		require.Contains(t, err.Error(), "dns_domain")
+
+		current, err := api.GetClusterSettings(context.Background(), &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, original.Settings.DnsDomain, current.Settings.DnsDomain)
+	})
+}
+
+// testLicenseKeys reads the keypair the license package is tested with
+func testLicenseKeys(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, err := ioutil.ReadFile("../license/testdata/test.pub")
+	require.NoError(t, err)
+	publicKey, err := license.ParsePublicKey(string(pub))
+	require.NoError(t, err)
+
+	priv, err := ioutil.ReadFile("../license/testdata/test.key")
+	require.NoError(t, err)
+	privateKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(priv)))
+	require.NoError(t, err)
+	return publicKey, ed25519.PrivateKey(privateKey)
+}
+
+func TestLicense(t *testing.T) {
+	api, err := setupAPI()
+	require.NoError(t, err)
+	defer api.cluster.SetLicense(nil)
+
+	body, err := ioutil.ReadFile("../../acceptance-test/data/node001.json")
+	require.NoError(t, err)
+	whoami := func() int {
+		req, err := http.NewRequest("POST", "/whoami", bytes.NewReader(body))
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		api.GetHttpHandler().ServeHTTP(rr, req)
+		return rr.Code
+	}
+	require.Equal(t, http.StatusOK, whoami())
+
+	publicKey, privateKey := testLicenseKeys(t)
+	sign := func(lic *license.License) string {
+		data, err := license.Sign(lic, privateKey)
+		require.NoError(t, err)
+		return string(data)
+	}
+	now := time.Now()
+
+	t.Run("NoKey_CanNotInstall", func(t *testing.T) {
+		_, err := api.SetLicense(context.Background(), &SetLicenseRequest{License: sign(&license.License{
+			ID: "test-1", Issued: now, Expires: now.AddDate(1, 0, 0),
+		})})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+	})
+
+	api.SetLicenseKey(publicKey)
+
+	t.Run("NodeLimit_KeepsRegisteredNodes", func(t *testing.T) {
+		registered := len(api.cluster.Nodes)
+		info, err := api.SetLicense(context.Background(), &SetLicenseRequest{License: sign(&license.License{
+			ID:        "test-2",
+			Customer:  "Example Corp",
+			InstallID: clusterName,
+			Nodes:     registered,
+			Issued:    now,
+			Expires:   now.AddDate(1, 0, 0),
+		})})
+		require.NoError(t, err)
+		require.True(t, info.Installed)
+		require.Equal(t, "valid", info.State)
+		require.Equal(t, int32(registered), info.RegisteredNodes)
+
+		require.Equal(t, http.StatusOK, whoami())
+		require.IsType(t, license.LimitError(""), api.checkLicense(registered+1))
+	})
+
+	t.Run("Expired_RefusesNewNodes", func(t *testing.T) {
+		info, err := api.SetLicense(context.Background(), &SetLicenseRequest{License: sign(&license.License{
+			ID:        "test-3",
+			Issued:    now.AddDate(-1, 0, 0),
+			Expires:   now.AddDate(0, 0, -10),
+			GraceDays: 7,
+		})})
+		require.NoError(t, err)
+		require.Equal(t, "expired", info.State)
+		require.IsType(t, license.LimitError(""), api.checkLicense(1))
+	})
+
+	t.Run("OtherCluster_IsRefused", func(t *testing.T) {
+		_, err := api.SetLicense(context.Background(), &SetLicenseRequest{License: sign(&license.License{
+			ID: "test-4", InstallID: "another-cluster", Issued: now, Expires: now.AddDate(1, 0, 0),
+		})})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+
+		info, err := api.GetLicense(context.Background(), &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, "test-3", info.Id)
+	})
+
+	t.Run("Unsigned_IsRefused", func(t *testing.T) {
+		_, err := api.SetLicense(context.Background(), &SetLicenseRequest{License: "jcCPA6TU87"})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+	})
 }
 
 func readTarball(buf *bytes.Buffer) (result map[string][]byte, err error) {
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package teamster
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/paxautoma/operos/components/teamster/pkg/license"
+)
+
+// SetLicenseKey sets the key licenses are verified with, and loads the
+// license of the cluster. Without a key, no license can be installed.
+func (t *TeamsterAPI) SetLicenseKey(key ed25519.PublicKey) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	t.licenseKey = key
+	t.license = nil
+	if len(t.cluster.License) == 0 {
+		log.Printf("No license is installed")
+		return
+	}
+
+	lic, err := license.Parse(t.cluster.License, key)
+	if err != nil {
+		log.Printf("Ignoring the installed license: %s", err)
+		return
+	}
+	t.license = lic
+	log.Printf("License %s for %s expires on %s", lic.ID, lic.Customer, lic.Expires.Format("2006-01-02"))
+}
+
+// checkLicense tells whether the license allows registering another worker,
+// bringing the cluster to nodes workers. Clusters without a license are not
+// limited. It must be called with nodesLock held.
+func (t *TeamsterAPI) checkLicense(nodes int) error {
+	if t.license == nil {
+		return nil
+	}
+	return t.license.CheckRegistration(t.cluster.InstallID, nodes, time.Now())
+}
+
+// licenseInfo must be called with nodesLock held
+func (t *TeamsterAPI) licenseInfo() *LicenseInfo {
+	info := &LicenseInfo{RegisteredNodes: int32(len(t.cluster.Nodes))}
+	if t.license == nil {
+		return info
+	}
+
+	info.Installed = true
+	info.Id = t.license.ID
+	info.Customer = t.license.Customer
+	info.Nodes = int32(t.license.Nodes)
+	info.Features = t.license.Features
+	info.IssuedUnix = t.license.Issued.Unix()
+	info.ExpiresUnix = t.license.Expires.Unix()
+	info.GraceEndsUnix = t.license.GraceEnds().Unix()
+	info.State = string(t.license.State(time.Now()))
+	return info
+}
+
+func (t *TeamsterAPI) GetLicense(ctx context.Context, req *Empty) (*LicenseInfo, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	return t.licenseInfo(), nil
+}
+
+func (t *TeamsterAPI) SetLicense(ctx context.Context, req *SetLicenseRequest) (*LicenseInfo, error) {
+	t.nodesLock.Lock()
+	defer t.nodesLock.Unlock()
+
+	if t.licenseKey == nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "this build of Operos can not verify licenses")
+	}
+
+	lic, err := license.Parse([]byte(req.License), t.licenseKey)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err := lic.CheckCluster(t.cluster.InstallID); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	if err := t.cluster.SetLicense([]byte(req.License)); err != nil {
+		return nil, errors.Wrap(err, "failed to store license")
+	}
+	t.license = lic
+	log.Printf("Installed license %s for %s", lic.ID, lic.Customer)
+	return t.licenseInfo(), nil
+}
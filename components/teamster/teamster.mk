@@ -14,12 +14,18 @@
 
 TEAMSTER_FILES=$(shell find components/teamster/ -name "*.go")
 
+# The base64 encoded key licenses are verified with. Builds without it can
+# not install licenses.
+LICENSE_PUBLIC_KEY?=
+
 .PHONY: teamster-novm
 teamster-novm: iso/controller/airootfs/usr/bin/teamster
 
 iso/controller/airootfs/usr/bin/teamster: components/teamster/pkg/teamster/teamster.pb.go $(TEAMSTER_FILES) vendor
 	mkdir -p $(dir $@)
-	go build -v -o $@ ./components/teamster/cmd/main.go
+	go build -v -o $@ \
+		-ldflags "-X github.com/paxautoma/operos/components/teamster/pkg/license.VendorKey=$(LICENSE_PUBLIC_KEY)" \
+		./components/teamster/cmd/main.go
 
 clean: clean-teamster
 
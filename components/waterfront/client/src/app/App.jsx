@@ -55,7 +55,7 @@ class App extends React.Component {
     if (this.props.data.loading) {
       return <div>Loading...</div>
     } else if (!this.props.data.login_info.logged_in) {
-      return <LoginScreen />
+      return <LoginScreen loginUrl={this.props.data.login_info.login_url} />
     }
 
     const menu = [
@@ -88,6 +88,7 @@ export default graphql(gql`
   query {
     login_info {
       logged_in
+      login_url
       user {
         username
       }
@@ -64,7 +64,8 @@ class LoginScreen extends React.Component {
       loggingIn: false,
       username: '',
       password: '',
-      failed: false
+      failed: false,
+      error: null
     }
   }
 
@@ -79,6 +80,7 @@ class LoginScreen extends React.Component {
 
     this.setState({
       failed: false,
+      error: null,
       loggingIn: true
     })
 
@@ -99,15 +101,62 @@ class LoginScreen extends React.Component {
       // visible so we don't have to set anything here.
     })
     .catch(err => {
+      // Lockouts and authentication outages are reported as errors
       this.setState({
-        loggingIn: false
+        loggingIn: false,
+        failed: true,
+        error: err.message || String(err)
       });
     });
   }
 
+  onSSOClick(evt) {
+    evt.preventDefault();
+
+    this.setState({
+      loggingIn: true
+    })
+    window.location.href = this.props.loginUrl;
+  }
+
+  renderSSO() {
+    const {classes} = this.props;
+
+    return (
+      <div className={classes.form}>
+        { this.state.loggingIn
+          ? <CircularProgress className={classes.loginButton} size={36} />
+          : <Button
+                raised
+                color="primary"
+                className={classes.loginButton}
+                onClick={this.onSSOClick.bind(this)}
+            >
+              Log in with single sign-on
+            </Button>
+        }
+      </div>
+    );
+  }
+
   render() {
     const {classes} = this.props;
 
+    if (this.props.loginUrl) {
+      return (
+        <div className={classes.root}>
+          <div>
+            <Card className={classes.loginCard} raised>
+              <CardMedia image="/static/login-top.png" title="Operos Login" className={classes.media} />
+              <CardContent>
+                {this.renderSSO()}
+              </CardContent>
+            </Card>
+          </div>
+        </div>
+      );
+    }
+
     return (
       <div className={classes.root}>
         <div>
@@ -144,7 +193,7 @@ class LoginScreen extends React.Component {
                     </Button>
                 }
                 { this.state.failed &&
-                  <p className={classes.failed}>Invalid username or password</p> }
+                  <p className={classes.failed}>{this.state.error || 'Invalid username or password'}</p> }
               </form>
             </CardContent>
           </Card>
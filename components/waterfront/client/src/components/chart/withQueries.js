@@ -20,11 +20,23 @@ import _ from 'lodash';
 import moment from 'moment';
 
 
+// Queries are either PromQL, or one of the metrics named by the API, which
+// only range charts may show
 const queryType = PropTypes.oneOfType([
   PropTypes.shape({
     query: PropTypes.string.isRequired,
     options: PropTypes.object
   }),
+  PropTypes.shape({
+    metric: PropTypes.string.isRequired,
+    // The node to show; all nodes if not set
+    node: PropTypes.string,
+    // Sum the nodes into one series
+    total: PropTypes.bool,
+    // Multiply values by this, such as -1 to show them below the axis
+    scale: PropTypes.number,
+    options: PropTypes.object
+  }),
   PropTypes.string
 ]);
 
@@ -61,13 +73,18 @@ export default (range=false) => (Chart) => {
 
     doQueries() {
       const queries = Array.isArray(this.props.query) ? this.props.query : [this.props.query];
+      const apiClient = this.context.apiClient;
       const promises = [];
       for (const q of queries) {
         const query = typeof q === 'string' ? q : q.query;
-        if (range) {
-          promises.push(this.context.apiClient.metricsQueryRange(query, q.step || 60));
+        if (q.metric) {
+          promises.push(q.node ?
+            apiClient.getNodeMetrics(q.node, q.metric, q.step || 60, q.total) :
+            apiClient.getClusterMetrics(q.metric, q.step || 60, q.total));
+        } else if (range) {
+          promises.push(apiClient.metricsQueryRange(query, q.step || 60));
         } else {
-          promises.push(this.context.apiClient.metricsQuery(query, q.step || 60));
+          promises.push(apiClient.metricsQuery(query, q.step || 60));
         }
       }
 
@@ -75,6 +92,10 @@ export default (range=false) => (Chart) => {
         let data = res.map((r, queryIdx) => {
           const queryOptions = queries[queryIdx].options || {};
 
+          if (queries[queryIdx].metric) {
+            return parseMetrics(r, queries[queryIdx]).map(dataset => _.merge(dataset, queryOptions));
+          }
+
           return r.entity.data.result.map(resultData => {
             let values;
             switch (r.entity.data.resultType) {
@@ -148,3 +169,17 @@ const parseTimeseries = (values, step) => {
 
   return result;
 };
+
+// parseMetrics turns a response of the metrics API into datasets, one for
+// each series
+const parseMetrics = (response, query) => {
+  const scale = query.scale === undefined ? 1 : query.scale;
+
+  return (response.series || []).map(series => ({
+    label: series.node,
+    data: parseTimeseries(
+      // Zero values are left out of API responses
+      (series.samples || []).map(sample => [parseInt(sample.time, 10), (sample.value || 0) * scale]),
+      query.step || 60)
+  }));
+};
@@ -35,8 +35,9 @@ export default class ApiClient {
           throw err.error;
         }
         if (err.status.code) {
-          // Errors from the server look like this
-          throw err.status.text;
+          // Errors from the server look like this, and may explain
+          // themselves in the body
+          throw (err.entity && err.entity.error) || err.status.text;
         }
         throw err;
       });
@@ -53,9 +54,17 @@ export default class ApiClient {
   }
 
   post(resource, entity) {
+    return this.send('POST', resource, entity);
+  }
+
+  put(resource, entity) {
+    return this.send('PUT', resource, entity);
+  }
+
+  send(method, resource, entity) {
     const req = {
       path: `${this.baseUrl}/${resource}`,
-      method: 'POST',
+      method,
       mixin: {
         withCredentials: true
       }
@@ -76,10 +85,115 @@ export default class ApiClient {
     return this.get(`nodes/${nodeId}`).then(res => res.entity.node);
   }
 
+  // Calls onEvent with each node event until the returned function is called.
+  // The browser reconnects by itself, and then receives every node again.
+  watchNodes(onEvent) {
+    const source = new EventSource(`${this.baseUrl}/watch/nodes`, {
+      withCredentials: true
+    });
+    source.onmessage = evt => onEvent(JSON.parse(evt.data));
+    return () => source.close();
+  }
+
+  cordonNode(nodeId) {
+    return this.post(`nodes/${nodeId}/cordon`).then(res => res.entity.node);
+  }
+
+  uncordonNode(nodeId) {
+    return this.post(`nodes/${nodeId}/uncordon`).then(res => res.entity.node);
+  }
+
+  // Drains a node, calling onProgress with each progress update. The
+  // returned promise is rejected with the error message if the drain fails.
+  drainNode(nodeId, options, onProgress) {
+    return fetch(`${this.baseUrl}/nodes/${nodeId}/drain`, {
+      method: 'POST',
+      credentials: 'include',
+      body: JSON.stringify(options || {})
+    }).then(res => {
+      // Progress arrives as one JSON object per line
+      const reader = res.body.getReader();
+      const decoder = new TextDecoder();
+      let buffer = '';
+
+      const read = () => reader.read().then(({done, value}) => {
+        buffer += decoder.decode(value || new Uint8Array(), {stream: !done});
+        const lines = buffer.split('\n');
+        buffer = done ? '' : lines.pop();
+
+        for (const line of lines.filter(l => l.trim())) {
+          const chunk = JSON.parse(line);
+          // Errors before the stream starts are not wrapped
+          const err = chunk.error;
+          if (err) {
+            throw typeof err === 'string' ? err : (err.error || err.message || 'drain failed');
+          }
+          onProgress(chunk.result || chunk);
+        }
+
+        if (!done) {
+          return read();
+        }
+        if (!res.ok) {
+          throw res.statusText;
+        }
+      });
+      return read();
+    });
+  }
+
+  rebootNode(nodeId, force) {
+    return this.post(`nodes/${nodeId}/reboot`, {force: !!force});
+  }
+
+  setNodeMetadata(nodeId, metadata) {
+    return this.put(`nodes/${nodeId}/metadata`, metadata).then(res => res.entity);
+  }
+
+  renameNode(nodeId, hostname) {
+    return this.post(`nodes/${nodeId}/rename`, {hostname}).then(res => res.entity);
+  }
+
   getClusterInfo() {
     return this.get('cluster_info').then(res => res.entity);
   }
 
+  getClusterSettings() {
+    return this.get('cluster/settings').then(res => res.entity);
+  }
+
+  updateClusterSettings(settings) {
+    return this.put('cluster/settings', settings).then(res => res.entity);
+  }
+
+  getLicense() {
+    return this.get('license').then(res => res.entity);
+  }
+
+  uploadLicense(license) {
+    return this.put('license', {license}).then(res => res.entity);
+  }
+
+  // Named metrics over the last hour, one series for each node unless total
+  // is set
+  getNodeMetrics(nodeId, metric, step, total=false) {
+    return this.get(`nodes/${nodeId}/metrics`, {
+      metric,
+      start: moment().subtract(1, 'hours').unix(),
+      step,
+      total
+    }).then(res => res.entity);
+  }
+
+  getClusterMetrics(metric, step, total=false) {
+    return this.get('cluster/metrics', {
+      metric,
+      start: moment().subtract(1, 'hours').unix(),
+      step,
+      total
+    }).then(res => res.entity);
+  }
+
   metricsQuery(query) {
     return this.get('metrics/query', {
       query
@@ -109,6 +223,14 @@ export default class ApiClient {
     return this.post('logout').then(res => res.entity);
   }
 
+  getClientCerts() {
+    return this.get('clientcerts').then(res => res.entity.credentials || []);
+  }
+
+  revokeClientCert(serial) {
+    return this.send('DELETE', `clientcerts/${serial}`);
+  }
+
   setRootPassword(password) {
     return this.post('rootpass', {
       password
@@ -23,14 +23,55 @@ scalar JSON
 enum NodeStatus {
   NOT_READY
   READY
+  NOT_JOINED
+  UNKNOWN
+}
+
+type NodeCondition {
+  type: String!
+  status: String!
+  reason: String
+  message: String
+  last_heartbeat_time: Float
+  last_transition_time: Float
+}
+
+type NodeResources {
+  cpu_millicores: Float!
+  memory_bytes: Float!
+  pods: Float!
+}
+
+type Taint {
+  key: String!
+  value: String
+  effect: String!
+}
+
+type NodeMetadata {
+  name: String
+  labels: JSON
+  taints: [Taint]!
+  rack: String
+  zone: String
 }
 
 type Node {
   id: String!
+  hostname: String!
   status: NodeStatus!
   ip: String
   pod_cidr: String
   hardware_info: String
+  conditions: [NodeCondition]!
+  capacity: NodeResources
+  allocatable: NodeResources
+  taints: [Taint]!
+  labels: JSON
+  kubelet_version: String
+  os_image: String
+  unschedulable: Boolean!
+  metadata: NodeMetadata
 }
 
 type ClusterInfo {
@@ -40,10 +81,12 @@ type ClusterInfo {
 type User {
   username: String!
   loging_time: String!
+  roles: [String]!
 }
 
 type LoginInfo {
   logged_in: Boolean!
+  login_url: String
   user: User
 }
 
@@ -73,7 +116,27 @@ const resolvers = {
     login_info: (_obj, _args, {apiClient}) => apiClient.getLoginInfo()
   },
   Node: {
-    status: node => node.status || 'NOT_READY'
+    hostname: node => node.hostname || node.id,
+    status: node => node.status || 'NOT_READY',
+    conditions: node => node.conditions || [],
+    taints: node => node.taints || [],
+    labels: node => node.labels || {},
+    unschedulable: node => !!node.unschedulable,
+    metadata: node => node.metadata || {}
+  },
+  NodeMetadata: {
+    labels: md => md.labels || {},
+    taints: md => md.taints || []
+  },
+  // 64-bit integers arrive as strings, and defaults are left out
+  NodeCondition: {
+    last_heartbeat_time: cond => Number(cond.last_heartbeat_time || 0),
+    last_transition_time: cond => Number(cond.last_transition_time || 0)
+  },
+  NodeResources: {
+    cpu_millicores: res => Number(res.cpu_millicores || 0),
+    memory_bytes: res => Number(res.memory_bytes || 0),
+    pods: res => Number(res.pods || 0)
   },
   Mutation: {
     login: (_obj, args, {apiClient}) => apiClient.login(args.username, args.password),
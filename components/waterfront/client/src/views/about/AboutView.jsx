@@ -21,9 +21,16 @@ import Typography from 'material-ui/Typography';
 import Table, { TableBody, TableCell, TableHead, TableRow } from 'material-ui/Table';
 import {withStyles} from 'material-ui/styles';
 
+import ClusterSettingsCard from 'views/about/ClusterSettingsCard';
+import LicenseCard from 'views/about/LicenseCard';
+
 const styles = {
   description: {
     margin: '8px 0 16px'
+  },
+  card: {
+    maxWidth: 800,
+    margin: '16px 0'
   }
 };
 
@@ -41,6 +48,9 @@ class AboutView extends React.Component {
           About Operos
         </Typography>
 
+        <LicenseCard className={classes.card} />
+        <ClusterSettingsCard className={classes.card} />
+
         <Typography type="body1" className={classes.description}>
           The following is a list of the settings that apply to this
           installation of Operos.
@@ -0,0 +1,195 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import React from 'react';
+import PropTypes from 'prop-types';
+import Card, {CardContent, CardActions} from 'material-ui/Card';
+import Typography from 'material-ui/Typography';
+import Button from 'material-ui/Button';
+import TextField from 'material-ui/TextField';
+import {CircularProgress} from 'material-ui/Progress';
+import {withStyles} from 'material-ui/styles';
+import {red} from 'material-ui/colors';
+
+const styles = theme => ({
+  fields: {
+    display: 'flex',
+    flexDirection: 'column'
+  },
+  textbox: {
+    maxWidth: 400,
+    marginTop: theme.spacing.unit
+  },
+  progress: {
+    marginLeft: theme.spacing.unit * 2
+  },
+  error: {
+    color: red[500],
+    marginTop: theme.spacing.unit
+  }
+});
+
+const settingLabels = {
+  dns_domain: 'DNS domain',
+  public_hostname: 'Public hostname',
+  ntp_servers: 'NTP servers',
+  storage_percentage: 'Worker system storage'
+};
+
+class ClusterSettingsCard extends React.Component {
+  constructor() {
+    super();
+    this.state = {
+      dns_domain: '',
+      public_hostname: '',
+      ntp_servers: '',
+      storage_percentage: '',
+      loading: true,
+      working: false,
+      message: null,
+      error: null
+    };
+  }
+
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  componentDidMount() {
+    this.context.apiClient.getClusterSettings().then(settings => {
+      this.setSettings(settings);
+      this.setState({loading: false});
+    }).catch(err => {
+      this.setState({error: 'Error loading settings: ' + err.toString()});
+    });
+  }
+
+  setSettings(settings) {
+    this.setState({
+      dns_domain: settings.dns_domain || '',
+      public_hostname: settings.public_hostname || '',
+      ntp_servers: (settings.ntp_servers || []).join(' '),
+      storage_percentage: String(settings.storage_percentage || '')
+    });
+  }
+
+  onChange(event) {
+    this.setState({[event.target.id]: event.target.value});
+  }
+
+  onSubmit(event) {
+    event.preventDefault();
+    this.setState({working: true, message: null, error: null});
+
+    this.context.apiClient.updateClusterSettings({
+      dns_domain: this.state.dns_domain,
+      public_hostname: this.state.public_hostname,
+      ntp_servers: this.state.ntp_servers.split(/[\s,]+/).filter(s => s),
+      storage_percentage: parseInt(this.state.storage_percentage, 10) || 0
+    }).then(res => {
+      this.setSettings(res.settings);
+      const reboot = (res.reboot_required || []).map(s => settingLabels[s] || s);
+      this.setState({
+        working: false,
+        message: reboot.length
+          ? `Settings saved. Workers apply the new ${reboot.join(', ')} when they next reboot.`
+          : 'Settings saved.'
+      });
+    }).catch(err => {
+      this.setState({
+        working: false,
+        error: 'Error saving settings: ' + err.toString()
+      });
+    });
+  }
+
+  render() {
+    const {classes, className} = this.props;
+
+    return (
+      <Card className={className}>
+        <form noValidate autoComplete="off">
+          <CardContent>
+            <Typography type="headline" component="h2">
+              Cluster settings
+            </Typography>
+            <Typography component="p">
+              Workers are given these settings when they boot. Changing the
+              storage share only affects disks which are not yet partitioned.
+            </Typography>
+
+            <div className={classes.fields}>
+              <TextField
+                  id="dns_domain"
+                  label={settingLabels.dns_domain}
+                  helperText="Chosen at installation"
+                  className={classes.textbox}
+                  value={this.state.dns_domain}
+                  disabled
+              />
+              <TextField
+                  id="public_hostname"
+                  label={settingLabels.public_hostname}
+                  className={classes.textbox}
+                  value={this.state.public_hostname}
+                  disabled={this.state.loading}
+                  onChange={this.onChange.bind(this)}
+              />
+              <TextField
+                  id="ntp_servers"
+                  label={settingLabels.ntp_servers}
+                  helperText="Separated by spaces"
+                  className={classes.textbox}
+                  value={this.state.ntp_servers}
+                  disabled={this.state.loading}
+                  onChange={this.onChange.bind(this)}
+              />
+              <TextField
+                  id="storage_percentage"
+                  label={settingLabels.storage_percentage + ' (%)'}
+                  helperText="Between 20 and 80"
+                  type="number"
+                  className={classes.textbox}
+                  value={this.state.storage_percentage}
+                  disabled={this.state.loading}
+                  onChange={this.onChange.bind(this)}
+              />
+            </div>
+
+            { this.state.message && <Typography component="p">{this.state.message}</Typography> }
+            { this.state.error && <Typography component="p" className={classes.error}>{this.state.error}</Typography> }
+          </CardContent>
+          <CardActions>
+            { this.state.working
+              ? <CircularProgress className={classes.progress} size={24} />
+              : <Button
+                    dense
+                    color="primary"
+                    type="submit"
+                    onClick={this.onSubmit.bind(this)}
+                    disabled={this.state.loading}
+                >
+                  Save settings
+                </Button>
+            }
+          </CardActions>
+        </form>
+      </Card>
+    );
+  }
+}
+
+export default withStyles(styles)(ClusterSettingsCard);
@@ -0,0 +1,177 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import React from 'react';
+import PropTypes from 'prop-types';
+import Card, {CardContent, CardActions} from 'material-ui/Card';
+import Typography from 'material-ui/Typography';
+import Button from 'material-ui/Button';
+import TextField from 'material-ui/TextField';
+import Table, {TableBody, TableCell, TableRow} from 'material-ui/Table';
+import {CircularProgress} from 'material-ui/Progress';
+import {withStyles} from 'material-ui/styles';
+import {red} from 'material-ui/colors';
+
+const styles = theme => ({
+  license: {
+    width: '100%',
+    marginTop: theme.spacing.unit,
+    fontFamily: 'monospace'
+  },
+  progress: {
+    marginLeft: theme.spacing.unit * 2
+  },
+  error: {
+    color: red[500],
+    marginTop: theme.spacing.unit
+  }
+});
+
+const formatDate = unix => unix ? new Date(unix * 1000).toLocaleDateString() : '';
+
+const stateLabels = {
+  valid: 'Valid',
+  grace: 'Expired; in grace period',
+  expired: 'Expired; new workers can not join'
+};
+
+class LicenseCard extends React.Component {
+  constructor() {
+    super();
+    this.state = {
+      license: null,
+      text: '',
+      working: false,
+      error: null
+    };
+  }
+
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  componentDidMount() {
+    this.context.apiClient.getLicense().then(license => {
+      this.setState({license});
+    }).catch(err => {
+      this.setState({error: 'Error loading license: ' + err.toString()});
+    });
+  }
+
+  onFile(event) {
+    const file = event.target.files[0];
+    if (!file) {
+      return;
+    }
+    const reader = new FileReader();
+    reader.onload = () => this.setState({text: reader.result});
+    reader.readAsText(file);
+  }
+
+  onUpload(event) {
+    event.preventDefault();
+    this.setState({working: true, error: null});
+
+    this.context.apiClient.uploadLicense(this.state.text).then(license => {
+      this.setState({license, text: '', working: false});
+    }).catch(err => {
+      this.setState({
+        working: false,
+        error: 'Error installing license: ' + err.toString()
+      });
+    });
+  }
+
+  renderLicense(license) {
+    if (!license.installed) {
+      return (
+        <Typography component="p">
+          No license is installed. {license.registered_nodes || 0} workers are registered.
+        </Typography>
+      );
+    }
+
+    const rows = [
+      ['License', license.id],
+      ['Licensed to', license.customer],
+      ['Status', stateLabels[license.state] || license.state],
+      ['Workers', `${license.registered_nodes || 0} of ${license.nodes || 'unlimited'}`],
+      ['Features', (license.features || []).join(', ')],
+      ['Issued', formatDate(license.issued)],
+      ['Expires', formatDate(license.expires)],
+      ['Works until', formatDate(license.grace_ends)]
+    ];
+    return (
+      <Table>
+        <TableBody>
+          {rows.map(([name, value]) => (
+            <TableRow key={name}>
+              <TableCell>{name}</TableCell>
+              <TableCell>{value}</TableCell>
+            </TableRow>
+          ))}
+        </TableBody>
+      </Table>
+    );
+  }
+
+  render() {
+    const {classes, className} = this.props;
+    const {license, error} = this.state;
+
+    return (
+      <Card className={className}>
+        <form noValidate autoComplete="off">
+          <CardContent>
+            <Typography type="headline" component="h2">
+              License
+            </Typography>
+            {license && this.renderLicense(license)}
+
+            <TextField
+                id="license"
+                label="New license"
+                placeholder="-----BEGIN OPEROS LICENSE-----"
+                multiline
+                rows={4}
+                className={classes.license}
+                value={this.state.text}
+                onChange={event => this.setState({text: event.target.value})}
+            />
+            <input type="file" accept=".license,.pem,.txt" onChange={this.onFile.bind(this)} />
+            {error && <Typography component="p" className={classes.error}>{error}</Typography>}
+          </CardContent>
+          <CardActions>
+            { this.state.working
+              ? <CircularProgress className={classes.progress} size={24} />
+              : <Button
+                    dense
+                    color="primary"
+                    type="submit"
+                    onClick={this.onUpload.bind(this)}
+                    disabled={!this.state.text.trim()}
+                >
+                  Install license
+                </Button>
+            }
+          </CardActions>
+        </form>
+      </Card>
+    );
+  }
+}
+
+export default withStyles(styles)(LicenseCard);
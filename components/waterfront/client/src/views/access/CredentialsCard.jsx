@@ -15,21 +15,78 @@ limitations under the License.
 */
 
 import React from 'react';
+import PropTypes from 'prop-types';
 import Card, {CardContent, CardActions} from 'material-ui/Card';
 import Typography from 'material-ui/Typography';
 import Button from 'material-ui/Button';
+import TextField from 'material-ui/TextField';
+import Table, {TableBody, TableRow, TableCell, TableHead} from 'material-ui/Table';
+import {red} from 'material-ui/colors';
 import {withStyles} from 'material-ui/styles';
 
-const styles = {
-};
+const styles = theme => ({
+  ttl: {
+    minWidth: 200,
+    marginTop: theme.spacing.unit
+  },
+  error: {
+    color: red[500]
+  }
+});
+
+// Certificates can not be revoked in the API server, so they are short-lived
+const ttlOptions = [
+  {value: '24h', label: '1 day'},
+  {value: '168h', label: '7 days'},
+  {value: '720h', label: '30 days'},
+  {value: '2160h', label: '90 days'}
+];
+
+const formatTime = time => new Date(time).toLocaleString();
 
 class CredentialsCard extends React.Component {
+  constructor() {
+    super();
+    this.state = {
+      ttl: '168h',
+      credentials: [],
+      error: null
+    };
+  }
+
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  componentDidMount() {
+    this.loadCredentials();
+  }
+
+  loadCredentials() {
+    this.context.apiClient.getClientCerts().then(credentials => {
+      this.setState({credentials: credentials.reverse(), error: null});
+    }).catch(err => {
+      this.setState({error: 'Error listing credentials: ' + err.toString()});
+    });
+  }
+
   onDownloadCredentials() {
-    window.open(`${process.env.API_BASE_URL}/clientcert`, '_blank');
+    window.open(`${process.env.API_BASE_URL}/clientcert?ttl=${this.state.ttl}`, '_blank');
+    // The download is recorded before it starts
+    setTimeout(() => this.loadCredentials(), 2000);
+  }
+
+  onRevoke(serial) {
+    this.context.apiClient.revokeClientCert(serial).then(() => {
+      this.loadCredentials();
+    }).catch(err => {
+      this.setState({error: 'Error revoking credentials: ' + err.toString()});
+    });
   }
 
   render() {
     const {classes, className} = this.props;
+    const {credentials, error} = this.state;
 
     return (
       <Card className={className}>
@@ -40,8 +97,51 @@ class CredentialsCard extends React.Component {
           <Typography component="p">
             Click the button below to generate and download TLS certificate credentials
             that can be used to control the Operos Kubernetes instance via
-            the REST API or <code>kubectl</code>.
+            the REST API or <code>kubectl</code>. They identify you, and carry
+            the permissions of your roles.
           </Typography>
+          <TextField
+              select
+              label="Valid for"
+              className={classes.ttl}
+              value={this.state.ttl}
+              onChange={event => this.setState({ttl: event.target.value})}
+              SelectProps={{native: true}}
+          >
+            {ttlOptions.map(option => (
+              <option key={option.value} value={option.value}>{option.label}</option>
+            ))}
+          </TextField>
+          {credentials.length > 0 &&
+            <Table>
+              <TableHead>
+                <TableRow>
+                  <TableCell>Serial</TableCell>
+                  <TableCell>User</TableCell>
+                  <TableCell>Groups</TableCell>
+                  <TableCell>Expires</TableCell>
+                  <TableCell></TableCell>
+                </TableRow>
+              </TableHead>
+              <TableBody>
+                {credentials.map(cred => (
+                  <TableRow key={cred.serial}>
+                    <TableCell>{cred.serial}</TableCell>
+                    <TableCell>{cred.owner}</TableCell>
+                    <TableCell>{cred.groups.join(', ')}</TableCell>
+                    <TableCell>{formatTime(cred.expires)}</TableCell>
+                    <TableCell>
+                      { cred.revoked
+                        ? 'Revoked'
+                        : <Button dense onClick={() => this.onRevoke(cred.serial)}>Revoke</Button>
+                      }
+                    </TableCell>
+                  </TableRow>
+                ))}
+              </TableBody>
+            </Table>
+          }
+          {error && <Typography component="p" className={classes.error}>{error}</Typography>}
         </CardContent>
         <CardActions>
           <Button dense color="primary" onClick={() => this.onDownloadCredentials()}>
@@ -165,7 +165,8 @@ class MetricsView extends React.Component {
         </Grid>
         <Grid item xs={12}>
           <LineChart query={[{
-              query:'sum(rate(node_cpu{mode!="idle"}[5m])) ',
+              metric: 'CPU_USAGE',
+              total: true,
               step: 10,
               options: {
                 label: 'Used',
@@ -174,7 +175,8 @@ class MetricsView extends React.Component {
                 borderWidth: 1
               }
             }, {
-              query: 'count(node_cpu{mode="idle"})',
+              metric: 'CPU_CAPACITY',
+              total: true,
               step: 10,
               options: {
                 label: 'Available',
@@ -192,7 +194,8 @@ class MetricsView extends React.Component {
 
         <Grid item xs={12}>
           <LineChart query={[{
-              query:'sum(node_memory_MemTotal)-sum(node_memory_MemAvailable)',
+              metric: 'MEMORY_USAGE',
+              total: true,
               step: 10,
               options: {
                 label: 'Used',
@@ -201,7 +204,8 @@ class MetricsView extends React.Component {
                 borderWidth: 1
               }
             }, {
-              query: 'sum(node_memory_MemTotal)',
+              metric: 'MEMORY_CAPACITY',
+              total: true,
               step: 10,
               options: {
                 label: 'Available',
@@ -219,7 +223,8 @@ class MetricsView extends React.Component {
 
         <Grid item xs={12}>
           <LineChart query={[{
-              query:'sum(max(node_filesystem_size{device=~"/dev/.da."}) by (device)) - sum(max(node_filesystem_avail{device=~"/dev/.da."}) by (device))',
+              metric: 'DISK_USAGE',
+              total: true,
               step: 10,
               options: {
                 label: 'Used',
@@ -228,7 +233,8 @@ class MetricsView extends React.Component {
                 borderWidth: 1
               }
             }, {
-              query: 'sum(max(node_filesystem_size{device=~"/dev/.da."}) by (device))',
+              metric: 'DISK_CAPACITY',
+              total: true,
               step: 10,
               options: {
                 label: 'Available',
@@ -246,14 +252,44 @@ class MetricsView extends React.Component {
 
         <Grid item xs={12}>
           <LineChart query={[{
-              query:'sum(rate(node_network_receive_bytes{device=~"(eth|en).*"}[5m]))',
+              metric: 'CEPH_USAGE',
+              step: 10,
+              options: {
+                label: 'Used',
+                borderColor: 'rgb(10, 67, 124)',
+                backgroundColor: 'rgba(10, 67, 124, 0.6)',
+                borderWidth: 1
+              }
+            }, {
+              metric: 'CEPH_CAPACITY',
+              step: 10,
+              options: {
+                label: 'Available',
+                fill: 'none',
+                borderColor: 'rgb(137, 15, 2)',
+                backgroundColor: 'rgba(137, 15, 2, 0.6)',
+                borderWidth: 1
+              }
+            }]}
+            title="Ceph"
+            format={{unit: 'B', decimals: 2, scale: 'binary'}}
+            className={classes.chart}
+          />
+        </Grid>
+
+        <Grid item xs={12}>
+          <LineChart query={[{
+              metric: 'NETWORK_RECEIVE',
+              total: true,
               step: 10,
               options: {
                 label: 'Received',
                 borderWidth: 1
               }
             }, {
-              query: '-sum(rate(node_network_transmit_bytes{device=~"(eth|en).*"}[5m]))',
+              metric: 'NETWORK_TRANSMIT',
+              total: true,
+              scale: -1,
               step: 10,
               options: {
                 label: 'Sent',
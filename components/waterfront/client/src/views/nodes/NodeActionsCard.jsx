@@ -0,0 +1,184 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import React from 'react';
+import PropTypes from 'prop-types';
+import Card, {CardContent, CardActions} from 'material-ui/Card';
+import Typography from 'material-ui/Typography';
+import Button from 'material-ui/Button';
+import IconButton from 'material-ui/IconButton';
+import Icon from 'material-ui/Icon';
+import Snackbar from 'material-ui/Snackbar';
+import {CircularProgress} from 'material-ui/Progress';
+import {withStyles} from 'material-ui/styles';
+
+const styles = theme => ({
+  progress: {
+    marginLeft: theme.spacing.unit * 2
+  },
+  pods: {
+    marginTop: theme.spacing.unit
+  }
+});
+
+class NodeActionsCard extends React.Component {
+  constructor() {
+    super();
+    this.state = {
+      working: false,
+      progress: null,
+      message: null
+    };
+  }
+
+  static propTypes = {
+    node: PropTypes.object.isRequired,
+    onChange: PropTypes.func.isRequired
+  }
+
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  run(action, done) {
+    this.setState({
+      working: true
+    });
+
+    return action().then(() => {
+      this.setState({
+        working: false,
+        message: done
+      });
+      this.props.onChange();
+    }).catch(err => {
+      this.setState({
+        working: false,
+        message: 'Error: ' + err.toString()
+      });
+      this.props.onChange();
+    });
+  }
+
+  onCordon() {
+    const {apiClient} = this.context;
+    const {node} = this.props;
+
+    if (node.unschedulable) {
+      this.run(() => apiClient.uncordonNode(node.id), 'Node uncordoned');
+    } else {
+      this.run(() => apiClient.cordonNode(node.id), 'Node cordoned');
+    }
+  }
+
+  onDrain() {
+    const {apiClient} = this.context;
+    const {node} = this.props;
+
+    this.setState({progress: null});
+    this.run(() => apiClient.drainNode(node.id, {}, progress => {
+      this.setState({progress});
+    }), 'Node drained');
+  }
+
+  onReboot() {
+    const {apiClient} = this.context;
+    const {node} = this.props;
+
+    if (!confirm(`Reboot node ${node.id}?`)) {
+      return;
+    }
+    this.run(() => apiClient.rebootNode(node.id), 'Reboot requested; the node will reboot within a minute');
+  }
+
+  handleCloseSnack() {
+    this.setState({
+      message: null
+    });
+  }
+
+  renderProgress() {
+    const {classes} = this.props;
+    const {progress} = this.state;
+    if (!progress) {
+      return null;
+    }
+
+    const pending = progress.pending_pods || [];
+    const evicted = progress.evicted_pods || [];
+
+    return (
+      <div className={classes.pods}>
+        <Typography component="p">
+          {progress.done ? 'Drained' : progress.message}
+          {` (${evicted.length} of ${evicted.length + pending.length} pods evicted)`}
+        </Typography>
+        { pending.map(pod => (
+          <Typography key={pod} type="caption">{pod}</Typography>
+        ))}
+      </div>
+    );
+  }
+
+  render() {
+    const {classes, className, node} = this.props;
+    const {working} = this.state;
+
+    return (
+      <Card className={className}>
+        <CardContent>
+          <Typography type="subheading">
+            Maintenance
+          </Typography>
+          <Typography component="p">
+            Drain the node to move its workloads elsewhere before rebooting
+            it. Pods covered by a disruption budget are only evicted when the
+            budget allows.
+          </Typography>
+          {this.renderProgress()}
+        </CardContent>
+        <CardActions>
+          <Button dense color="primary" disabled={working} onClick={this.onCordon.bind(this)}>
+            {node.unschedulable ? 'Uncordon' : 'Cordon'}
+          </Button>
+          <Button dense color="primary" disabled={working} onClick={this.onDrain.bind(this)}>
+            Drain
+          </Button>
+          <Button dense color="accent" disabled={working || !node.unschedulable} onClick={this.onReboot.bind(this)}>
+            Reboot
+          </Button>
+          { working && <CircularProgress className={classes.progress} size={24} /> }
+        </CardActions>
+        <Snackbar
+            open={!!this.state.message}
+            onRequestClose={this.handleCloseSnack.bind(this)}
+            message={<span>{this.state.message}</span>}
+            action={
+              <IconButton
+                key="close"
+                color="inherit"
+                onClick={this.handleCloseSnack.bind(this)}
+              >
+                <Icon>close</Icon>
+              </IconButton>
+            }
+        />
+      </Card>
+    );
+  }
+}
+
+export default withStyles(styles)(NodeActionsCard);
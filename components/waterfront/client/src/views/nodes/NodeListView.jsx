@@ -15,6 +15,7 @@ limitations under the License.
 */
 
 import React from 'react';
+import PropTypes from 'prop-types';
 import {Link, withRouter} from 'react-router-dom';
 import Table, {TableBody, TableRow, TableCell, TableHead, TableSortLabel} from 'material-ui/Table';
 import {graphql, gql} from 'react-apollo';
@@ -31,8 +32,15 @@ const styles = theme => ({
   }
 });
 
+export const STATUS_LABELS = {
+  READY: 'Ready',
+  NOT_READY: 'Not ready',
+  NOT_JOINED: 'Not joined',
+  UNKNOWN: 'Unknown'
+};
+
 const sortKeys = {
-  id: node => node.id,
+  hostname: node => node.hostname,
   ip: node => (
     node.ip.split('.').reverse().reduce((acc, val, idx) => {
       return acc + parseInt(val) * Math.pow(256, idx);
@@ -40,12 +48,52 @@ const sortKeys = {
   ),
   status: node => node.status,
   pod_cidr: node => node.pod_cidr,
+  kubelet_version: node => node.kubelet_version,
+};
+
+// Default values are left out of the JSON of events
+const nodeFromEvent = node => ({
+  __typename: 'Node',
+  id: node.id,
+  hostname: node.hostname || node.id,
+  status: node.status || 'NOT_READY',
+  ip: node.ip || '',
+  pod_cidr: node.pod_cidr || '',
+  kubelet_version: node.kubelet_version || '',
+  unschedulable: !!node.unschedulable,
+  metadata: {
+    __typename: 'NodeMetadata',
+    name: (node.metadata && node.metadata.name) || ''
+  }
+});
+
+const applyNodeEvent = (prev, event) => {
+  const node = nodeFromEvent(event.node);
+  const nodes = prev.nodes.filter(n => n.id !== node.id);
+  if (event.type !== 'DELETED') {
+    nodes.push(node);
+  }
+  return {...prev, nodes};
 };
 
 class NodeListView extends React.Component {
 
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  componentDidMount() {
+    this.stopWatching = this.context.apiClient.watchNodes(event => {
+      this.props.data.updateQuery(prev => applyNodeEvent(prev, event));
+    });
+  }
+
+  componentWillUnmount() {
+    this.stopWatching();
+  }
+
   sortNodes(nodes, sortMode) {
-    const key = sortKeys[sortMode];
+    const key = sortKeys[sortMode] || sortKeys.ip;
 
     return nodes.slice().sort((a, b) => {
       a = key(a);
@@ -92,9 +140,9 @@ class NodeListView extends React.Component {
               </TableCell>
               <TableCell>
                 <TableSortLabel
-                    onClick={() => this.onSortChange('id')}
-                    active={sortMode === 'id'}
-                >ID</TableSortLabel>
+                    onClick={() => this.onSortChange('hostname')}
+                    active={sortMode === 'hostname'}
+                >Hostname</TableSortLabel>
               </TableCell>
               <TableCell>
                 <TableSortLabel
@@ -108,6 +156,12 @@ class NodeListView extends React.Component {
                     active={sortMode === 'pod_cidr'}
                 >Pod CIDR</TableSortLabel>
                 </TableCell>
+              <TableCell>
+                <TableSortLabel
+                    onClick={() => this.onSortChange('kubelet_version')}
+                    active={sortMode === 'kubelet_version'}
+                >Kubelet</TableSortLabel>
+              </TableCell>
             </TableRow>
           </TableHead>
           <TableBody>
@@ -125,10 +179,17 @@ class NodeListView extends React.Component {
   renderNode(node) {
     return (
       <TableRow key={node.id}>
-        <TableCell>{node.status == 'READY' ? 'Ready' : 'Not ready'}</TableCell>
-        <TableCell><Link to={`/nodes/${node.id}`}>{node.id}</Link></TableCell>
+        <TableCell>
+          {STATUS_LABELS[node.status]}
+          {node.unschedulable && ' (cordoned)'}
+        </TableCell>
+        <TableCell>
+          <Link to={`/nodes/${node.id}`}>{node.hostname}</Link>
+          {node.metadata.name && ` (${node.metadata.name})`}
+        </TableCell>
         <TableCell>{node.ip}</TableCell>
         <TableCell>{node.pod_cidr}</TableCell>
+        <TableCell>{node.kubelet_version}</TableCell>
       </TableRow>
     );
   }
@@ -137,8 +198,14 @@ class NodeListView extends React.Component {
 export default graphql(gql`{
   nodes {
     id
+    hostname
     status
     ip
     pod_cidr
+    kubelet_version
+    unschedulable
+    metadata {
+      name
+    }
   }
 }`)(withLoader(withStyles(styles)(withRouter(NodeListView))));
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import React from 'react';
+import PropTypes from 'prop-types';
+import _ from 'lodash';
+import Card, {CardContent, CardActions} from 'material-ui/Card';
+import Typography from 'material-ui/Typography';
+import Button from 'material-ui/Button';
+import IconButton from 'material-ui/IconButton';
+import Icon from 'material-ui/Icon';
+import TextField from 'material-ui/TextField';
+import Snackbar from 'material-ui/Snackbar';
+import {CircularProgress} from 'material-ui/Progress';
+import {withStyles} from 'material-ui/styles';
+
+const styles = theme => ({
+  progress: {
+    marginLeft: theme.spacing.unit * 2
+  },
+  textbox: {
+    marginRight: theme.spacing.unit * 2
+  }
+});
+
+// Labels are edited one per line as key=value, and taints as
+// key=value:Effect
+const formatLabels = labels => (
+  _.toPairs(labels).sort().map(([key, value]) => `${key}=${value}`).join('\n')
+);
+
+const formatTaints = taints => (
+  taints.map(t => `${t.key}${t.value ? '=' + t.value : ''}:${t.effect}`).join('\n')
+);
+
+const lines = text => text.split('\n').map(l => l.trim()).filter(l => l);
+
+const parseLabels = text => _.fromPairs(lines(text).map(line => {
+  const idx = line.indexOf('=');
+  return idx < 0 ? [line, ''] : [line.slice(0, idx), line.slice(idx + 1)];
+}));
+
+const parseTaints = text => lines(text).map(line => {
+  const colon = line.lastIndexOf(':');
+  const keyValue = colon < 0 ? line : line.slice(0, colon);
+  const effect = colon < 0 ? 'NoSchedule' : line.slice(colon + 1);
+  const idx = keyValue.indexOf('=');
+  return idx < 0
+    ? {key: keyValue, effect}
+    : {key: keyValue.slice(0, idx), value: keyValue.slice(idx + 1), effect};
+});
+
+class NodeMetadataCard extends React.Component {
+  constructor(props) {
+    super(props);
+    const metadata = props.node.metadata || {};
+    this.state = {
+      working: false,
+      message: null,
+      hostname: props.node.hostname,
+      name: metadata.name || '',
+      rack: metadata.rack || '',
+      zone: metadata.zone || '',
+      labels: formatLabels(metadata.labels || {}),
+      taints: formatTaints(metadata.taints || [])
+    };
+  }
+
+  static propTypes = {
+    node: PropTypes.object.isRequired,
+    onChange: PropTypes.func.isRequired
+  }
+
+  static contextTypes = {
+    apiClient: PropTypes.object
+  }
+
+  onFieldChange(evt) {
+    this.setState({
+      [evt.target.id]: evt.target.value
+    });
+  }
+
+  onSubmit(evt) {
+    evt.preventDefault();
+
+    const {apiClient} = this.context;
+    const {node} = this.props;
+
+    this.setState({
+      working: true
+    });
+
+    const hostname = this.state.hostname.trim();
+    const renamed = hostname !== node.hostname;
+    const rename = renamed
+      ? apiClient.renameNode(node.id, hostname)
+      : Promise.resolve();
+
+    rename.then(() => apiClient.setNodeMetadata(node.id, {
+      name: this.state.name.trim(),
+      rack: this.state.rack.trim(),
+      zone: this.state.zone.trim(),
+      labels: parseLabels(this.state.labels),
+      taints: parseTaints(this.state.taints)
+    })).then(() => {
+      this.setState({
+        working: false,
+        message: renamed
+          ? 'Metadata saved; the new hostname takes effect when the node reboots'
+          : 'Metadata saved; the Kubernetes node is updated shortly'
+      });
+      this.props.onChange();
+    }).catch(err => {
+      this.setState({
+        working: false,
+        message: 'Error: ' + err.toString()
+      });
+    });
+  }
+
+  handleCloseSnack() {
+    this.setState({
+      message: null
+    });
+  }
+
+  render() {
+    const {classes, className} = this.props;
+
+    return (
+      <Card className={className}>
+        <form noValidate autoComplete="off">
+          <CardContent>
+            <Typography type="subheading">
+              Metadata
+            </Typography>
+            <Typography component="p">
+              The hostname is the name of the node in Kubernetes and Ceph, and
+              changes when the node next reboots. Labels and taints set here
+              are applied to the Kubernetes node, and kept when the node
+              registers again. The rack and zone are applied as labels too, so
+              that workloads can be spread across them.
+            </Typography>
+            <div>
+              <TextField
+                  id="hostname"
+                  label="Hostname"
+                  className={classes.textbox}
+                  value={this.state.hostname}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+              <TextField
+                  id="name"
+                  label="Name"
+                  className={classes.textbox}
+                  value={this.state.name}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+              <TextField
+                  id="rack"
+                  label="Rack"
+                  className={classes.textbox}
+                  value={this.state.rack}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+              <TextField
+                  id="zone"
+                  label="Zone"
+                  className={classes.textbox}
+                  value={this.state.zone}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+            </div>
+            <div>
+              <TextField
+                  id="labels"
+                  label="Labels (key=value)"
+                  multiline
+                  rows={4}
+                  className={classes.textbox}
+                  value={this.state.labels}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+              <TextField
+                  id="taints"
+                  label="Taints (key=value:Effect)"
+                  multiline
+                  rows={4}
+                  className={classes.textbox}
+                  value={this.state.taints}
+                  onChange={this.onFieldChange.bind(this)}
+              />
+            </div>
+          </CardContent>
+          <CardActions>
+            { this.state.working
+              ? <CircularProgress className={classes.progress} size={24} />
+              : <Button
+                    dense
+                    color="primary"
+                    type="submit"
+                    onClick={this.onSubmit.bind(this)}>
+                  Save
+                </Button>
+            }
+          </CardActions>
+        </form>
+        <Snackbar
+            open={!!this.state.message}
+            onRequestClose={this.handleCloseSnack.bind(this)}
+            message={<span>{this.state.message}</span>}
+            action={
+              <IconButton
+                key="close"
+                color="inherit"
+                onClick={this.handleCloseSnack.bind(this)}
+              >
+                <Icon>close</Icon>
+              </IconButton>
+            }
+        />
+      </Card>
+    );
+  }
+}
+
+export default withStyles(styles)(NodeMetadataCard);
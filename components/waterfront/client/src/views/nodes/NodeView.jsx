@@ -22,11 +22,16 @@ import Avatar from 'material-ui/Avatar';
 import Collapse from 'material-ui/transitions/Collapse';
 import Card, {CardContent, CardHeader} from 'material-ui/Card';
 import Typography from 'material-ui/Typography';
+import Table, {TableBody, TableRow, TableCell, TableHead} from 'material-ui/Table';
+import humanFormat from 'human-format';
 import {withStyles} from 'material-ui/styles';
 import {graphql, gql} from 'react-apollo';
 import {withRouter} from 'react-router';
 
 import withLoader from 'components/withLoader';
+import {STATUS_LABELS} from './NodeListView';
+import NodeActionsCard from './NodeActionsCard';
+import NodeMetadataCard from './NodeMetadataCard';
 
 const CLASS_ICONS = {
   bridge: 'device_hub',
@@ -47,6 +52,16 @@ const CLASS_ICONS = {
   volume: 'folder',
 };
 
+const formatTime = unix => (
+  unix ? new Date(unix * 1000).toLocaleString() : ''
+);
+
+const formatResources = res => (res ? [
+  `${res.cpu_millicores / 1000} CPU`,
+  `${humanFormat(res.memory_bytes, {scale: 'binary', unit: 'B'})} memory`,
+  `${res.pods} pods`
+].join(', ') : 'Not reported');
+
 const styles = {
   title: {
     marginBottom: 16
@@ -123,21 +138,136 @@ class NodeView extends React.Component {
     return result;
   }
 
+  renderDetails(node) {
+    const {classes} = this.props;
+    const labels = _.toPairs(node.labels).sort();
+
+    return (
+      <Card className={classes.card}>
+        <CardContent>
+          <Typography type="subheading" className={classes.title}>
+            Kubernetes
+          </Typography>
+          <Table>
+            <TableBody>
+              <TableRow>
+                <TableCell>IP address</TableCell>
+                <TableCell>{node.ip}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Pod CIDR</TableCell>
+                <TableCell>{node.pod_cidr}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Kubelet version</TableCell>
+                <TableCell>{node.kubelet_version}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>OS image</TableCell>
+                <TableCell>{node.os_image}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Schedulable</TableCell>
+                <TableCell>{node.unschedulable ? 'No' : 'Yes'}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Capacity</TableCell>
+                <TableCell>{formatResources(node.capacity)}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Allocatable</TableCell>
+                <TableCell>{formatResources(node.allocatable)}</TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Taints</TableCell>
+                <TableCell>
+                  { node.taints.map(taint => (
+                    <div key={taint.key + taint.effect}>
+                      {taint.key}{taint.value ? '=' + taint.value : ''}:{taint.effect}
+                    </div>
+                  ))}
+                </TableCell>
+              </TableRow>
+              <TableRow>
+                <TableCell>Labels</TableCell>
+                <TableCell>
+                  { labels.map(([key, value]) => (
+                    <div key={key}>{key}={value}</div>
+                  ))}
+                </TableCell>
+              </TableRow>
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
+    );
+  }
+
+  renderConditions(node) {
+    const {classes} = this.props;
+
+    return (
+      <Card className={classes.card}>
+        <CardContent>
+          <Typography type="subheading" className={classes.title}>
+            Conditions
+          </Typography>
+          <Table>
+            <TableHead>
+              <TableRow>
+                <TableCell>Type</TableCell>
+                <TableCell>Status</TableCell>
+                <TableCell>Reason</TableCell>
+                <TableCell>Last heartbeat</TableCell>
+                <TableCell>Last transition</TableCell>
+              </TableRow>
+            </TableHead>
+            <TableBody>
+              { node.conditions.map(cond => (
+                <TableRow key={cond.type} title={cond.message}>
+                  <TableCell>{cond.type}</TableCell>
+                  <TableCell>{cond.status}</TableCell>
+                  <TableCell>{cond.reason}</TableCell>
+                  <TableCell>{formatTime(cond.last_heartbeat_time)}</TableCell>
+                  <TableCell>{formatTime(cond.last_transition_time)}</TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
+    );
+  }
+
   render() {
     const {data, classes} = this.props;
     const node = data.node;
 
-    const hardware = JSON.parse(node.hardware_info);
+    const hardware = node.hardware_info ? JSON.parse(node.hardware_info) : null;
 
     return (
       <div>
         <Card className={classes.card}>
           <CardHeader
             avatar={<Avatar><Icon>memory</Icon></Avatar>}
-            title={'Node: ' + node.id}
-            subheader={node.status == 'READY' ? 'Ready' : 'Not ready'}
+            title={'Node: ' + node.hostname + (node.metadata.name ? ` (${node.metadata.name})` : '')}
+            subheader={STATUS_LABELS[node.status]}
           />
         </Card>
+        { node.status !== 'NOT_JOINED' &&
+          <NodeActionsCard
+              className={classes.card}
+              node={node}
+              onChange={() => data.refetch()}
+          />
+        }
+        <NodeMetadataCard
+            className={classes.card}
+            node={node}
+            onChange={() => data.refetch()}
+        />
+        { node.status !== 'NOT_JOINED' && this.renderDetails(node) }
+        { node.conditions.length > 0 && this.renderConditions(node) }
         { hardware &&
           <Card className={classes.card}>
             <CardContent>
@@ -159,11 +289,50 @@ export default withRouter(graphql(gql`
   query getSingleNode($nodeId: String!) {
     node(id: $nodeId) {
       id
+      hostname
       status
       ip
       pod_cidr
       hardware_info
-    }    
+      kubelet_version
+      os_image
+      unschedulable
+      labels
+      conditions {
+        type
+        status
+        reason
+        message
+        last_heartbeat_time
+        last_transition_time
+      }
+      capacity {
+        cpu_millicores
+        memory_bytes
+        pods
+      }
+      allocatable {
+        cpu_millicores
+        memory_bytes
+        pods
+      }
+      taints {
+        key
+        value
+        effect
+      }
+      metadata {
+        name
+        labels
+        rack
+        zone
+        taints {
+          key
+          value
+          effect
+        }
+      }
+    }
   }
 `, {
   options: ({match}) => {
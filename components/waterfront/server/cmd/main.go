@@ -22,15 +22,14 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/http/pprof"
 	"net/url"
 	"path"
+	"path/filepath"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
@@ -57,8 +56,33 @@ func main() {
 	clientDir := flag.String("clientdir", "client", "directory containing the client files")
 	dashboardURL := flag.String("dashboard-url", "http://kubernetes-dashboard.kube-system", "URL of the kube-dashboard for proxying")
 	promURL := flag.String("prometheus-url", "http://prometheus.operos:9090/api/v1", "URL of Prometheus API for proxying")
-	sessionKey := flag.String("session-key", "this is a totally secret key", "cookie session key")
+	sessionKey := flag.String("session-key", "", "cookie session key; if not set, a key is generated in -state-dir")
+	stateDir := flag.String("state-dir", "/var/lib/waterfront", "directory for the session database and generated keys")
+	auditLogPath := flag.String("audit-log", "", "file to append audit events to; defaults to audit.log in -state-dir")
+	secureCookies := flag.Bool("secure-cookies", false, "always mark cookies Secure; otherwise only requests over HTTPS get Secure cookies")
 	debugAddr := flag.String("debug-addr", "", "enable debug server on this address")
+	rolesFile := flag.String("roles-file", waterfront.DefaultRolesFile, "YAML file assigning roles to users and groups")
+	authMethod := flag.String("auth", "pam", "how users log in: pam, ldap or oidc")
+
+	var ldapConfig waterfront.LDAPConfig
+	flag.StringVar(&ldapConfig.URL, "ldap-url", "", "LDAP server, ldap://host[:port] or ldaps://host[:port]")
+	flag.BoolVar(&ldapConfig.StartTLS, "ldap-starttls", false, "use StartTLS on ldap:// connections")
+	flag.StringVar(&ldapConfig.CAFile, "ldap-ca-file", "", "CA certificates for verifying the LDAP server")
+	flag.StringVar(&ldapConfig.BindDN, "ldap-bind-dn", "", "DN to bind as when searching for users and groups; anonymous if empty")
+	flag.StringVar(&ldapConfig.BindPassword, "ldap-bind-password", "", "password of -ldap-bind-dn")
+	flag.StringVar(&ldapConfig.UserBaseDN, "ldap-user-base-dn", "", "base DN of users")
+	flag.StringVar(&ldapConfig.UserFilter, "ldap-user-filter", "(uid=%s)", "filter for finding a user, %s is the username")
+	flag.StringVar(&ldapConfig.GroupBaseDN, "ldap-group-base-dn", "", "base DN of groups; defaults to -ldap-user-base-dn")
+	flag.StringVar(&ldapConfig.GroupFilter, "ldap-group-filter", "(member=%s)", "filter for finding the groups of a user, %s is the user's DN")
+	flag.StringVar(&ldapConfig.GroupNameAttribute, "ldap-group-attribute", "cn", "attribute holding the group name")
+
+	var oidcConfig waterfront.OIDCConfig
+	flag.StringVar(&oidcConfig.IssuerURL, "oidc-issuer", "", "OpenID Connect issuer URL")
+	flag.StringVar(&oidcConfig.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&oidcConfig.ClientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&oidcConfig.RedirectURL, "oidc-redirect-url", "", "URL of /api/v1/oidc/callback as seen by browsers")
+	flag.StringVar(&oidcConfig.UsernameClaim, "oidc-username-claim", "email", "ID token claim holding the username")
+	flag.StringVar(&oidcConfig.GroupsClaim, "oidc-groups-claim", "groups", "ID token claim holding the groups")
 
 	flag.Parse()
 
@@ -81,8 +105,35 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to create kube client: %v", err)
 	}
+	kubeRestConfig, err := kube.NewKubeConfig(*kubeURL, *kubeConfig)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	nodeWatcher := waterfront.NewNodeWatcher(teamsterClient, kubeClient)
+	go nodeWatcher.Run(make(chan struct{}))
 
-	waterfrontAPI := waterfront.NewWaterfrontAPI(teamsterClient, kubeClient)
+	nodeReconciler := waterfront.NewNodeReconciler(teamsterClient, kubeClient)
+	go nodeReconciler.Run(make(chan struct{}))
+
+	workloadWatcher := waterfront.NewWorkloadWatcher(kubeClient)
+	go workloadWatcher.Run(make(chan struct{}))
+
+	waterfrontAPI := waterfront.NewWaterfrontAPI(teamsterClient, kubeRestConfig, nodeWatcher, nodeReconciler,
+		workloadWatcher, waterfront.NewTeamsterCephSource(teamsterClient), waterfront.NewPrometheusSource(prometheusURL))
+
+	gatewayToken, err := waterfront.NewGatewayToken()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if *auditLogPath == "" {
+		*auditLogPath = filepath.Join(*stateDir, "audit.log")
+	}
+	auditLog, err := waterfront.OpenAuditLog(*auditLogPath)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	lis, err := net.Listen("tcp", *listenGrpc)
 	if err != nil {
@@ -94,11 +145,18 @@ func main() {
 			grpc_ctxtags.UnaryServerInterceptor(),
 			grpc_logrus.UnaryServerInterceptor(logrus.NewEntry(logger)),
 			grpc_recovery.UnaryServerInterceptor(),
+			waterfront.AuthorizationInterceptor(gatewayToken, waterfront.MethodRoles, auditLog),
+		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			grpc_ctxtags.StreamServerInterceptor(),
+			grpc_logrus.StreamServerInterceptor(logrus.NewEntry(logger)),
+			grpc_recovery.StreamServerInterceptor(),
+			waterfront.StreamAuthorizationInterceptor(gatewayToken, waterfront.MethodRoles, auditLog),
 		)),
 	)
 	waterfront.RegisterWaterfrontServer(grpcServer, waterfrontAPI)
 
-	grpcMux := runtime.NewServeMux()
+	grpcMux := runtime.NewServeMux(runtime.WithMetadata(waterfront.GatewayMetadata(gatewayToken)))
 	opts := []grpc.DialOption{
 		grpc.WithInsecure(),
 		grpc.WithBackoffMaxDelay(10 * time.Second),
@@ -112,39 +170,134 @@ func main() {
 		grpcServer.Serve(lis)
 	}()
 
-	auth := waterfront.AuthSessionMiddleware(
-		waterfront.SessionStore(sessions.NewCookieStore([]byte(*sessionKey))))
+	key := []byte(*sessionKey)
+	if *sessionKey == "" {
+		if key, err = waterfront.LoadOrCreateSessionKey(filepath.Join(*stateDir, "session.key")); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	sessionStore, err := waterfront.NewBoltStore(
+		filepath.Join(*stateDir, "sessions.db"), waterfront.DefaultSessionValidity, key)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	sessionStore.SecureCookies = *secureCookies
+
+	tokenStore, err := waterfront.NewTokenStore(filepath.Join(*stateDir, "tokens.db"))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	credentialStore, err := waterfront.NewCredentialStore(filepath.Join(*stateDir, "credentials.db"),
+		waterfront.NewKubeCredentialBinder(kubeClient))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	authOpts := []waterfront.AuthSessionOption{
+		waterfront.SessionStore(sessionStore),
+		waterfront.Audit(auditLog),
+		waterfront.Tokens(tokenStore),
+	}
+	var oidcLogin *waterfront.OIDCLogin
+
+	// Groups of externally authenticated users come from the provider, and
+	// are matched against the groups in the roles file. Only PAM falls back to
+	// making root and wheel admins; an external directory needs a roles file.
+	switch *authMethod {
+	case "pam":
+		authOpts = append(authOpts,
+			waterfront.RoleSource(waterfront.NewRoleStore(*rolesFile, waterfront.UnixGroups, &waterfront.DefaultRoleBindings)))
+	case "ldap":
+		ldapAuth, err := waterfront.NewLDAPAuthenticator(ldapConfig)
+		if err != nil {
+			log.Fatalf("invalid LDAP configuration: %v", err)
+		}
+		authOpts = append(authOpts,
+			waterfront.Authentication(ldapAuth),
+			waterfront.RoleSource(waterfront.NewRoleStore(*rolesFile, nil, nil)))
+	case "oidc":
+		oidcLogin, err = waterfront.NewOIDCLogin(context.Background(), oidcConfig, "/api/v1/oidc/login")
+		if err != nil {
+			log.Fatalf("invalid OIDC configuration: %v", err)
+		}
+		authOpts = append(authOpts,
+			waterfront.Authentication(waterfront.NoPasswordAuthenticator),
+			waterfront.OIDC(oidcLogin),
+			waterfront.RoleSource(waterfront.NewRoleStore(*rolesFile, nil, nil)))
+	default:
+		log.Fatalf("unknown authentication method %q", *authMethod)
+	}
+
+	auth := waterfront.AuthSessionMiddleware(authOpts...)
 	cors := handlers.CORS(
 		handlers.AllowCredentials(),
 		handlers.AllowedOrigins([]string{"http://localhost:10000"}),
 		handlers.AllowedHeaders([]string{"Content-Type"}),
 	)
 
-	prometheusProxy := httputil.NewSingleHostReverseProxy(prometheusURL)
-	prometheusProxy.ModifyResponse = func(res *http.Response) error {
-		// Remove the Prometheus CORS stuff since we're gonna apply our own
-		res.Header.Del("Access-Control-Allow-Origin")
-		res.Header.Del("Access-Control-Allow-Credentials")
-		return nil
-	}
-
 	apiRouter := mux.NewRouter()
-	// Our single HTTP URL for generating client cert tarballs
-	apiRouter.Path("/api/v1/clientcert").Handler(waterfront.MakeGenClientCertHandler(*teamsterHTTPAddr))
-	// Proxy to Prometheus
-	apiRouter.PathPrefix("/api/v1/metrics/").Handler(http.StripPrefix("/api/v1/metrics/", cors(prometheusProxy)))
-	// grpc-proxy API
-	apiRouter.PathPrefix("/api/").Handler(cors(http.StripPrefix("/api", grpcMux)))
+	// Client cert tarballs, issued to the logged in user; any user may list
+	// and revoke their own
+	apiRouter.Path("/api/v1/clientcert").Handler(waterfront.Audited(auditLog, waterfront.AuditDownloadClientCert,
+		waterfront.RequireRole(waterfront.RoleViewer, waterfront.MakeGenClientCertHandler(*teamsterHTTPAddr, credentialStore))))
+	apiRouter.Path("/api/v1/clientcerts").Methods("GET").Handler(
+		waterfront.RequireRole(waterfront.RoleViewer, waterfront.GetCredentialsHandler(credentialStore)))
+	apiRouter.Path("/api/v1/clientcerts/{serial}").Methods("DELETE").Handler(waterfront.Audited(auditLog, waterfront.AuditRevokeClientCert,
+		waterfront.RequireRole(waterfront.RoleViewer, waterfront.GetCredentialsHandler(credentialStore))))
+	// Session administration
+	revokeSessions := waterfront.Audited(auditLog, waterfront.AuditRevokeSession,
+		waterfront.RequireRole(waterfront.RoleAdmin, auth.GetSessionsHandler()))
+	apiRouter.Path("/api/v1/sessions").Methods("GET").Handler(
+		waterfront.RequireRole(waterfront.RoleAdmin, auth.GetSessionsHandler()))
+	apiRouter.Path("/api/v1/sessions").Methods("DELETE").Handler(revokeSessions)
+	apiRouter.Path("/api/v1/sessions/{id}").Methods("DELETE").Handler(revokeSessions)
+	// API tokens; any user may manage their own
+	apiRouter.Path("/api/v1/tokens").Methods("GET").Handler(
+		waterfront.RequireRole(waterfront.RoleViewer, auth.GetTokensHandler()))
+	apiRouter.Path("/api/v1/tokens").Methods("POST").Handler(waterfront.Audited(auditLog, waterfront.AuditCreateToken,
+		waterfront.RequireRole(waterfront.RoleViewer, auth.GetTokensHandler())))
+	apiRouter.Path("/api/v1/tokens/{id}").Methods("DELETE").Handler(waterfront.Audited(auditLog, waterfront.AuditRevokeToken,
+		waterfront.RequireRole(waterfront.RoleViewer, auth.GetTokensHandler())))
+	// Audit log
+	apiRouter.Path("/api/v1/audit").Methods("GET").Handler(
+		waterfront.RequireRole(waterfront.RoleAdmin, auditLog.GetAuditHandler()))
+	// Proxy to the query API of Prometheus, limited for each user. Queries
+	// through it and of named metrics share an allowance.
+	metricsProxy := waterfront.NewMetricsProxy(prometheusURL)
+	metricsProxy.Throttle = waterfrontAPI.MetricsThrottle
+	apiRouter.PathPrefix("/api/v1/metrics/").Handler(
+		waterfront.RequireAccess(waterfront.RoleViewer, waterfront.ScopeMetricsRead,
+			http.StripPrefix("/api/v1/metrics/", cors(metricsProxy))))
+	// Container logs and exec sessions, made to the API server as the user
+	podHandlers := waterfront.NewPodHandlers(kubeRestConfig)
+	apiRouter.Path("/api/v1/pods/{namespace}/{name}/log").Methods("GET").Handler(
+		waterfront.RequireAccess(waterfront.RoleViewer, waterfront.ScopeWorkloadsRead, podHandlers.LogsHandler()))
+	apiRouter.Path("/api/v1/pods/{namespace}/{name}/exec").Methods("GET").Handler(waterfront.AuditedTarget(auditLog, waterfront.AuditExecPod, waterfront.ExecTarget,
+		waterfront.RequireAccess(waterfront.RoleOperator, waterfront.ScopeWorkloadsExec, podHandlers.ExecHandler())))
+	// Streaming RPCs, also served as server-sent events
+	apiRouter.PathPrefix("/api/v1/watch/").Handler(cors(http.StripPrefix("/api",
+		waterfront.StripGatewayHeaders(waterfront.EventStream(grpcMux)))))
+	// grpc-proxy API; each method is authorized by the gRPC interceptor
+	apiRouter.PathPrefix("/api/").Handler(cors(http.StripPrefix("/api", waterfront.StripGatewayHeaders(grpcMux))))
 
 	mainRouter := mux.NewRouter()
 	// Auth endpoints
 	mainRouter.Handle("/api/v1/login", cors(auth.GetLoginHandler()))
 	mainRouter.Handle("/api/v1/logout", cors(auth.GetLogoutHandler()))
+	if oidcLogin != nil {
+		mainRouter.Handle(oidcLogin.LoginPath, auth.GetOIDCLoginHandler())
+		mainRouter.Handle("/api/v1/oidc/callback", auth.GetOIDCCallbackHandler())
+	}
 	// API
 	mainRouter.PathPrefix("/api/").Handler(auth.GetAuthHandler(apiRouter))
 
-	// Proxy to the kube-dashboard
-	mainRouter.PathPrefix("/kube-dashboard/").Handler(auth.GetAuthHandler(http.StripPrefix("/kube-dashboard", httputil.NewSingleHostReverseProxy(kubeDashboardURL))))
+	// Proxy to the kube-dashboard, which impersonates the logged in user. The
+	// API server limits what they can do to the RBAC roles bound to their
+	// operos:<role> groups.
+	mainRouter.PathPrefix("/kube-dashboard/").Handler(auth.GetAuthHandler(waterfront.RequireRole(waterfront.RoleViewer,
+		http.StripPrefix("/kube-dashboard", waterfront.ImpersonatingProxy(kubeDashboardURL)))))
 
 	// Static files
 	mainRouter.PathPrefix("/static/").Handler(http.StripPrefix("/static", http.FileServer(http.Dir(path.Join(*clientDir, "static")))))
@@ -23,7 +23,9 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func NewKubeClient(url, kubeConfig string) (*kubernetes.Clientset, error) {
+// NewKubeConfig loads the client configuration of the API server from a
+// kubeconfig file, or from the service account if kubeConfig is empty
+func NewKubeConfig(url, kubeConfig string) (*rest.Config, error) {
 	var (
 		err    error
 		config *rest.Config
@@ -37,6 +39,14 @@ func NewKubeClient(url, kubeConfig string) (*kubernetes.Clientset, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "could not load kube config")
 	}
+	return config, nil
+}
+
+func NewKubeClient(url, kubeConfig string) (*kubernetes.Clientset, error) {
+	config, err := NewKubeConfig(url, kubeConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
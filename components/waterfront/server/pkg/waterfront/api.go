@@ -17,40 +17,79 @@ limitations under the License.
 package waterfront
 
 import (
-	"bufio"
-	"os"
-	"strings"
+	"encoding/json"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	policy_v1beta1 "k8s.io/client-go/pkg/apis/policy/v1beta1"
+	"k8s.io/client-go/rest"
 
 	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
 )
 
 type WaterfrontAPI struct {
-	teamsterClient teamster_proto.TeamsterClient
-	kubeClient     *kubernetes.Clientset
+	// MetricsThrottle limits how often each user may query named metrics
+	MetricsThrottle *RateLimiter
+
+	teamsterClient  teamster_proto.TeamsterClient
+	kubeConfig      *rest.Config
+	nodeWatcher     *NodeWatcher
+	nodeReconciler  *NodeReconciler
+	workloadWatcher *WorkloadWatcher
+	cephSource      CephSource
+	metricsSource   MetricsSource
+	storage         func(ctx context.Context) (storageClient, error)
 }
 
-func NewWaterfrontAPI(teamsterClient teamster_proto.TeamsterClient, kubeClient *kubernetes.Clientset) *WaterfrontAPI {
-	return &WaterfrontAPI{
-		teamsterClient: teamsterClient,
-		kubeClient:     kubeClient,
+// NewWaterfrontAPI returns the API. Calls to Kubernetes on behalf of users
+// are made with kubeConfig, impersonating the user.
+func NewWaterfrontAPI(teamsterClient teamster_proto.TeamsterClient, kubeConfig *rest.Config,
+	nodeWatcher *NodeWatcher, nodeReconciler *NodeReconciler, workloadWatcher *WorkloadWatcher,
+	cephSource CephSource, metricsSource MetricsSource) *WaterfrontAPI {
+	w := &WaterfrontAPI{
+		MetricsThrottle: NewRateLimiter(5, 60),
+		teamsterClient:  teamsterClient,
+		kubeConfig:      kubeConfig,
+		nodeWatcher:     nodeWatcher,
+		nodeReconciler:  nodeReconciler,
+		workloadWatcher: workloadWatcher,
+		cephSource:      cephSource,
+		metricsSource:   metricsSource,
 	}
+	w.storage = func(ctx context.Context) (storageClient, error) {
+		client, err := w.userKubeClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return kubeStorageClient{client}, nil
+	}
+	return w
 }
 
 func (w *WaterfrontAPI) ListNodes(ctx context.Context, empty *Empty) (*ListNodesResponse, error) {
+	if nodes, ok := w.nodeWatcher.Nodes(); ok {
+		return &ListNodesResponse{Nodes: nodes}, nil
+	}
+
 	res, err := w.teamsterClient.ListNodes(ctx, &teamster_proto.Empty{})
 	if err != nil {
 		return nil, errors.Wrap(err, "error accessing teamster")
 	}
 
-	kubeNodeList, err := w.kubeClient.Nodes().List(meta_v1.ListOptions{})
+	kubeClient, err := w.userKubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kubeNodeList, err := kubeClient.Nodes().List(meta_v1.ListOptions{})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed fetching node list from kube")
 	}
@@ -62,16 +101,19 @@ func (w *WaterfrontAPI) ListNodes(ctx context.Context, empty *Empty) (*ListNodes
 	}
 
 	nodes := make([]*Node, len(res.Nodes))
-	teamsterNodeIds := make(map[string]bool)
+	teamsterHostnames := make(map[string]bool)
 	for idx, node := range res.Nodes {
-		kubeNode, _ := kubeNodeMap[node.Uuid]
+		hostname := teamsterHostname(node)
+		kubeNode, _ := kubeNodeMap[hostname]
 		nodes[idx] = nodeFromKube(node.Uuid, kubeNode)
-		teamsterNodeIds[node.Uuid] = true
+		nodes[idx].Hostname = hostname
+		nodes[idx].Metadata = metadataFromTeamster(node.Metadata)
+		teamsterHostnames[hostname] = true
 	}
 
 	for _, kubeNode := range kubeNodeList.Items {
 		nodeID := kubeNode.GetName()
-		if _, inTeamster := teamsterNodeIds[nodeID]; !inTeamster {
+		if _, inTeamster := teamsterHostnames[nodeID]; !inTeamster {
 			nodes = append(nodes, nodeFromKube(nodeID, &kubeNode))
 		}
 	}
@@ -79,34 +121,29 @@ func (w *WaterfrontAPI) ListNodes(ctx context.Context, empty *Empty) (*ListNodes
 	return &ListNodesResponse{Nodes: nodes}, nil
 }
 
-func nodeFromKube(uuid string, kubeNode *kube_v1.Node) *Node {
-	node := &Node{
-		Id:     uuid,
-		Status: NodeStatus_NOT_READY,
-	}
-
-	if kubeNode != nil {
-		node.PodCidr = kubeNode.Spec.PodCIDR
-		node.Ip = kubeNode.Status.Addresses[0].Address
-		node.Status = nodeReady(kubeNode)
+// kubeNodeName returns the name in Kubernetes of the node with an ID. Nodes
+// registered in teamster go by their hostname, and others by their name,
+// which is also their ID.
+func (w *WaterfrontAPI) kubeNodeName(ctx context.Context, id string) (string, error) {
+	res, err := w.teamsterClient.ListNodes(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return "", errors.Wrap(err, "error accessing teamster")
 	}
 
-	return node
-}
-
-func nodeReady(kubeNode *kube_v1.Node) NodeStatus {
-	for _, condition := range kubeNode.Status.Conditions {
-		if condition.Type == kube_v1.NodeReady {
-			if condition.Status == "True" {
-				return NodeStatus_READY
-			}
-			return NodeStatus_NOT_READY
+	for _, node := range res.Nodes {
+		if node.Uuid == id {
+			return teamsterHostname(node), nil
 		}
 	}
-	return NodeStatus_NOT_READY
+	return id, nil
 }
 
 func (w *WaterfrontAPI) GetNode(ctx context.Context, req *GetNodeRequest) (*GetNodeResponse, error) {
+	name, err := w.kubeNodeName(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
 	res, err := w.teamsterClient.GetNodeHardware(ctx, &teamster_proto.GetNodeHardwareRequest{Uuid: req.Id})
 	if err != nil {
 		if grpc.Code(err) != codes.NotFound {
@@ -114,66 +151,334 @@ func (w *WaterfrontAPI) GetNode(ctx context.Context, req *GetNodeRequest) (*GetN
 		}
 	}
 
-	kubeNode, err := w.kubeClient.Nodes().Get(req.Id, meta_v1.GetOptions{})
+	metadata, err := w.teamsterClient.GetNodeMetadata(ctx, &teamster_proto.GetNodeMetadataRequest{Uuid: req.Id})
+	if err != nil {
+		if grpc.Code(err) != codes.NotFound {
+			return nil, errors.Wrap(err, "error accessing teamster")
+		}
+	}
+
+	kubeClient, err := w.userKubeClient(ctx)
 	if err != nil {
+		return nil, err
+	}
+	kubeNode, err := kubeClient.Nodes().Get(name, meta_v1.GetOptions{})
+	if kube_errors.IsNotFound(err) {
+		if res == nil {
+			return nil, status.Errorf(codes.NotFound, "node %s not found", req.Id)
+		}
+		// Registered in teamster, but has not joined Kubernetes
+		kubeNode = nil
+	} else if err != nil {
 		return nil, errors.Wrap(err, "error fetching node info from kube")
 	}
 
 	node := nodeFromKube(req.Id, kubeNode)
+	node.Hostname = name
 	if res != nil {
 		node.HardwareInfo = res.HardwareInfo
 	}
+	node.Metadata = metadataFromTeamster(metadata)
 
 	return &GetNodeResponse{Node: node}, nil
 }
 
-func (w *WaterfrontAPI) readSettingsFile() (map[string]string, error) {
-	fp, err := os.Open("/etc/paxautoma/settings")
-	if err != nil {
-		return nil, errors.Wrap(err, "could not open settings file")
+func (w *WaterfrontAPI) WatchNodes(req *Empty, stream Waterfront_WatchNodesServer) error {
+	if w.nodeWatcher == nil {
+		return status.Error(codes.Unavailable, "node watching is not enabled")
 	}
 
-	result := make(map[string]string)
+	events, cancel := w.nodeWatcher.Subscribe()
+	defer cancel()
 
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, errors.Wrap(err, "failed to read settings file")
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "watch fell behind; start a new one")
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
 		}
+	}
+}
 
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+func (w *WaterfrontAPI) CordonNode(ctx context.Context, req *NodeActionRequest) (*GetNodeResponse, error) {
+	return w.setUnschedulable(ctx, req.Id, true)
+}
 
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) < 2 {
-			return nil, errors.Errorf("could not parse this line in settings file: '%s'", line)
-		}
+func (w *WaterfrontAPI) UncordonNode(ctx context.Context, req *NodeActionRequest) (*GetNodeResponse, error) {
+	return w.setUnschedulable(ctx, req.Id, false)
+}
 
-		result[parts[0]] = strings.Trim(parts[1], "\"")
+func (w *WaterfrontAPI) setUnschedulable(ctx context.Context, id string, unschedulable bool) (*GetNodeResponse, error) {
+	name, err := w.kubeNodeName(ctx, id)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	kubeClient, err := w.userKubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kubeNode, err := kubeDrainClient{kubeClient}.setUnschedulable(name, unschedulable)
+	if kube_errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "node %s has not joined Kubernetes", id)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error updating node in kube")
+	}
+
+	return &GetNodeResponse{Node: nodeFromKube(id, kubeNode)}, nil
 }
 
-func (w *WaterfrontAPI) GetClusterInfo(ctx context.Context, req *Empty) (*GetClusterInfoResponse, error) {
-	res, err := w.teamsterClient.GetCACertExpiry(ctx, &teamster_proto.Empty{})
+func (w *WaterfrontAPI) DrainNode(req *DrainNodeRequest, stream Waterfront_DrainNodeServer) error {
+	kubeClient, err := w.userKubeClient(stream.Context())
+	if err != nil {
+		return err
+	}
+	drainer := &nodeDrainer{
+		client:       kubeDrainClient{kubeClient},
+		pollInterval: drainPollInterval,
+	}
+	name, err := w.kubeNodeName(stream.Context(), req.Id)
 	if err != nil {
+		return err
+	}
+
+	// The drainer works on the Kubernetes node
+	kubeReq := *req
+	kubeReq.Id = name
+	return drainer.drain(stream.Context(), &kubeReq, stream.Send)
+}
+
+func (w *WaterfrontAPI) RebootNode(ctx context.Context, req *RebootNodeRequest) (*Empty, error) {
+	name, err := w.kubeNodeName(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	kubeClient, err := w.userKubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kubeNode, err := kubeClient.Nodes().Get(name, meta_v1.GetOptions{})
+	if err != nil && !kube_errors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "error fetching node info from kube")
+	}
+	// Nodes which have not joined Kubernetes run no pods
+	if err == nil && !kubeNode.Spec.Unschedulable && !req.Force {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s must be cordoned and drained before it is rebooted", req.Id)
+	}
+
+	_, err = w.teamsterClient.RebootNode(ctx, &teamster_proto.RebootNodeRequest{Uuid: req.Id})
+	if grpc.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "node %s is not registered with teamster", req.Id)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	return &Empty{}, nil
+}
+
+func (w *WaterfrontAPI) GetNodeMetadata(ctx context.Context, req *NodeActionRequest) (*NodeMetadata, error) {
+	res, err := w.teamsterClient.GetNodeMetadata(ctx, &teamster_proto.GetNodeMetadataRequest{Uuid: req.Id})
+	if grpc.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "node %s is not registered with teamster", req.Id)
+	} else if err != nil {
 		return nil, errors.Wrap(err, "error accessing teamster")
 	}
 
-	settings, err := w.readSettingsFile()
+	if metadata := metadataFromTeamster(res); metadata != nil {
+		return metadata, nil
+	}
+	return &NodeMetadata{}, nil
+}
+
+func (w *WaterfrontAPI) SetNodeMetadata(ctx context.Context, req *SetNodeMetadataRequest) (*NodeMetadata, error) {
+	res, err := w.teamsterClient.SetNodeMetadata(ctx, &teamster_proto.SetNodeMetadataRequest{
+		Uuid:     req.Id,
+		Metadata: metadataToTeamster(req.Metadata),
+	})
+	switch grpc.Code(err) {
+	case codes.OK:
+	case codes.NotFound:
+		return nil, status.Errorf(codes.NotFound, "node %s is not registered with teamster", req.Id)
+	case codes.InvalidArgument:
+		return nil, status.Error(codes.InvalidArgument, grpc.ErrorDesc(err))
+	default:
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	// Apply it to the Kubernetes node now, rather than at the next interval
+	w.nodeReconciler.Kick()
+
+	if metadata := metadataFromTeamster(res); metadata != nil {
+		return metadata, nil
+	}
+	return &NodeMetadata{}, nil
+}
+
+// kubeDrainClient drains nodes through the Kubernetes API
+type kubeDrainClient struct {
+	kubeClient *kubernetes.Clientset
+}
+
+func (c kubeDrainClient) setUnschedulable(name string, unschedulable bool) (*kube_v1.Node, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": unschedulable},
+	})
 	if err != nil {
 		return nil, err
 	}
+	return c.kubeClient.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, patch)
+}
+
+func (c kubeDrainClient) podsOnNode(name string) ([]kube_v1.Pod, error) {
+	pods, err := c.kubeClient.CoreV1().Pods(meta_v1.NamespaceAll).List(meta_v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+func (c kubeDrainClient) evictPod(pod *kube_v1.Pod, gracePeriod *int64) error {
+	return c.kubeClient.PolicyV1beta1().Evictions(pod.Namespace).Evict(&policy_v1beta1.Eviction{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &meta_v1.DeleteOptions{
+			GracePeriodSeconds: gracePeriod,
+		},
+	})
+}
+
+func (c kubeDrainClient) podGone(pod *kube_v1.Pod) (bool, error) {
+	current, err := c.kubeClient.CoreV1().Pods(pod.Namespace).Get(pod.Name, meta_v1.GetOptions{})
+	if kube_errors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return current.UID != pod.UID, nil
+}
+
+func (w *WaterfrontAPI) RenameNode(ctx context.Context, req *RenameNodeRequest) (*GetNodeResponse, error) {
+	_, err := w.teamsterClient.RenameNode(ctx, &teamster_proto.RenameNodeRequest{
+		Uuid:     req.Id,
+		Hostname: req.Hostname,
+	})
+	switch grpc.Code(err) {
+	case codes.OK:
+	case codes.NotFound:
+		return nil, status.Errorf(codes.NotFound, "node %s is not registered with teamster", req.Id)
+	case codes.InvalidArgument, codes.AlreadyExists:
+		return nil, status.Error(grpc.Code(err), grpc.ErrorDesc(err))
+	default:
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	return w.GetNode(ctx, &GetNodeRequest{Id: req.Id})
+}
+
+func (w *WaterfrontAPI) GetClusterInfo(ctx context.Context, req *Empty) (*GetClusterInfoResponse, error) {
+	license, err := w.teamsterClient.GetLicense(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	settings, err := w.teamsterClient.GetClusterSettings(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
 
 	return &GetClusterInfoResponse{
-		LicenseExpiry: res.ExpiryUnix,
-		Settings:      settings,
+		LicenseExpiry: license.ExpiresUnix,
+		Settings:      settings.Vars,
+	}, nil
+}
+
+func (w *WaterfrontAPI) GetClusterSettings(ctx context.Context, req *Empty) (*ClusterSettings, error) {
+	res, err := w.teamsterClient.GetClusterSettings(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+	return settingsFromTeamster(res.Settings), nil
+}
+
+func (w *WaterfrontAPI) UpdateClusterSettings(ctx context.Context, req *ClusterSettings) (*UpdateClusterSettingsResponse, error) {
+	res, err := w.teamsterClient.UpdateClusterSettings(ctx, &teamster_proto.ClusterSettings{
+		DnsDomain:         req.DnsDomain,
+		PublicHostname:    req.PublicHostname,
+		NtpServers:        req.NtpServers,
+		StoragePercentage: req.StoragePercentage,
+	})
+	switch grpc.Code(err) {
+	case codes.OK:
+	case codes.InvalidArgument:
+		return nil, status.Error(codes.InvalidArgument, grpc.ErrorDesc(err))
+	default:
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	return &UpdateClusterSettingsResponse{
+		Settings:       settingsFromTeamster(res.Settings),
+		RebootRequired: res.RebootRequired,
 	}, nil
 }
 
+func settingsFromTeamster(settings *teamster_proto.ClusterSettings) *ClusterSettings {
+	if settings == nil {
+		return &ClusterSettings{}
+	}
+	return &ClusterSettings{
+		DnsDomain:         settings.DnsDomain,
+		PublicHostname:    settings.PublicHostname,
+		NtpServers:        settings.NtpServers,
+		StoragePercentage: settings.StoragePercentage,
+	}
+}
+
+func (w *WaterfrontAPI) GetLicense(ctx context.Context, req *Empty) (*License, error) {
+	res, err := w.teamsterClient.GetLicense(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+	return licenseFromTeamster(res), nil
+}
+
+func (w *WaterfrontAPI) UploadLicense(ctx context.Context, req *UploadLicenseRequest) (*License, error) {
+	res, err := w.teamsterClient.SetLicense(ctx, &teamster_proto.SetLicenseRequest{License: req.License})
+	switch grpc.Code(err) {
+	case codes.OK:
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return nil, status.Error(grpc.Code(err), grpc.ErrorDesc(err))
+	default:
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+	return licenseFromTeamster(res), nil
+}
+
+func licenseFromTeamster(info *teamster_proto.LicenseInfo) *License {
+	return &License{
+		Installed:       info.Installed,
+		Id:              info.Id,
+		Customer:        info.Customer,
+		Nodes:           info.Nodes,
+		RegisteredNodes: info.RegisteredNodes,
+		Features:        info.Features,
+		Issued:          info.IssuedUnix,
+		Expires:         info.ExpiresUnix,
+		GraceEnds:       info.GraceEndsUnix,
+		State:           info.State,
+	}
+}
+
 func (w *WaterfrontAPI) SetRootPassword(ctx context.Context, req *SetRootPasswordRequest) (*Empty, error) {
 	_, err := w.teamsterClient.SetRootPassword(ctx, &teamster_proto.SetRootPasswordRequest{req.Password})
 	if err != nil {
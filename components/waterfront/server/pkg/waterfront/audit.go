@@ -0,0 +1,322 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Audited actions
+const (
+	AuditLogin              = "login"
+	AuditBasicAuth          = "basic-auth"
+	AuditOIDCLogin          = "oidc-login"
+	AuditLogout             = "logout"
+	AuditSetRootPassword    = "set-root-password"
+	AuditDownloadClientCert = "download-client-cert"
+	AuditRevokeClientCert   = "revoke-client-cert"
+	AuditRevokeSession      = "revoke-session"
+	AuditTokenAuth          = "token-auth"
+	AuditCreateToken        = "create-token"
+	AuditRevokeToken        = "revoke-token"
+	AuditCordonNode         = "cordon-node"
+	AuditUncordonNode       = "uncordon-node"
+	AuditDrainNode          = "drain-node"
+	AuditRebootNode         = "reboot-node"
+	AuditSetNodeMetadata    = "set-node-metadata"
+	AuditRenameNode         = "rename-node"
+	AuditCreatePool         = "create-pool"
+	AuditCreateStorageClass = "create-storage-class"
+	AuditDeleteStorageClass = "delete-storage-class"
+	AuditExecPod            = "exec-pod"
+	AuditUpdateSettings     = "update-cluster-settings"
+	AuditUploadLicense      = "upload-license"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	// AuditDenied is an authenticated user lacking the required role
+	AuditDenied = "denied"
+	// AuditLocked is a login refused because of too many failures
+	AuditLocked = "locked"
+	// AuditError is an action which could not be completed
+	AuditError = "error"
+)
+
+// AuditEvent is an entry of the audit log
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Outcome    string    `json:"outcome"`
+	Username   string    `json:"username,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	// Target is what the action was taken on, such as a node ID
+	Target string `json:"target,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditLog appends events to a file, one JSON object per line. A nil
+// *AuditLog discards events.
+type AuditLog struct {
+	path string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create audit log directory")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit log")
+	}
+
+	return &AuditLog{path: path, file: file}, nil
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// Record appends an event to the log. Failures are logged rather than
+// returned, since they should not stop the action being audited.
+func (a *AuditLog) Record(event *AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	action := event.Action
+	if event.Target != "" {
+		action += " " + event.Target
+	}
+	log.Infof("audit: %s %s by %q from %s %s", action, event.Outcome, event.Username, event.RemoteAddr, event.Reason)
+
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("failed to encode audit event: %v", err)
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Errorf("failed to write audit log: %v", err)
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		log.Errorf("failed to sync audit log: %v", err)
+	}
+}
+
+// AuditQuery selects events from the audit log. Zero fields match anything.
+type AuditQuery struct {
+	Since    time.Time
+	Until    time.Time
+	Username string
+	Action   string
+	Outcome  string
+	// Limit is the most events returned; the latest ones are kept
+	Limit int
+}
+
+func (q *AuditQuery) matches(event *AuditEvent) bool {
+	return (q.Since.IsZero() || !event.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || event.Time.Before(q.Until)) &&
+		(q.Username == "" || event.Username == q.Username) &&
+		(q.Action == "" || event.Action == q.Action) &&
+		(q.Outcome == "" || event.Outcome == q.Outcome)
+}
+
+// Query returns the events matching query, latest first
+func (a *AuditLog) Query(query AuditQuery) ([]*AuditEvent, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit log")
+	}
+	defer file.Close()
+
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A line cut short by a crash should not hide the rest of the log
+			log.Warnf("skipping unreadable audit log line: %v", err)
+			continue
+		}
+		if !query.matches(&event) {
+			continue
+		}
+
+		events = append(events, &event)
+		if query.Limit > 0 && len(events) > query.Limit {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit log")
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAuditHandler serves audit log queries. The query parameters since and
+// until take RFC 3339 times; user, action, outcome and limit filter further.
+func (a *AuditLog) GetAuditHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		query := AuditQuery{
+			Username: params.Get("user"),
+			Action:   params.Get("action"),
+			Outcome:  params.Get("outcome"),
+			Limit:    defaultAuditLimit,
+		}
+
+		for name, field := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+			if value := params.Get(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s: %s", name, err.Error()))
+					return
+				}
+				*field = t
+			}
+		}
+
+		if value := params.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > maxAuditLimit {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit))
+				return
+			}
+			query.Limit = limit
+		}
+
+		events, err := a.Query(query)
+		if err != nil {
+			panic(errors.Wrap(err, "failed to query audit log"))
+		}
+
+		response := struct {
+			Events []*AuditEvent `json:"events"`
+		}{
+			Events: events,
+		}
+		if response.Events == nil {
+			response.Events = []*AuditEvent{}
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			panic(err)
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets audited handlers switch to WebSocket
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection can not be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Audited records each request served by handler as action. It must be used
+// inside GetAuthHandler, and outside RequireRole so that denials are recorded.
+func Audited(audit *AuditLog, action string, handler http.Handler) http.Handler {
+	return AuditedTarget(audit, action, nil, handler)
+}
+
+// AuditedTarget is Audited, with the target of each event taken from the
+// request by target
+func AuditedTarget(audit *AuditLog, action string, target func(r *http.Request) string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+
+		event := &AuditEvent{
+			Action:     action,
+			Outcome:    AuditSuccess,
+			RemoteAddr: remoteHost(r),
+		}
+		if target != nil {
+			event.Target = target(r)
+		}
+		if user := GetUser(r.Context()); user != nil {
+			event.Username = user.Username
+		}
+
+		switch {
+		case recorder.status == http.StatusForbidden:
+			event.Outcome = AuditDenied
+		case recorder.status >= 500:
+			event.Outcome = AuditError
+		case recorder.status >= 400:
+			event.Outcome = AuditFailure
+		}
+		if event.Outcome != AuditSuccess {
+			event.Reason = fmt.Sprintf("HTTP %d", recorder.status)
+		}
+
+		audit.Record(event)
+	})
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestAuditLog(t *testing.T) (*AuditLog, func()) {
+	dir, err := ioutil.TempDir("", "waterfront-audit")
+	require.NoError(t, err)
+
+	audit, err := OpenAuditLog(filepath.Join(dir, "audit.log"))
+	require.NoError(t, err)
+
+	return audit, func() {
+		audit.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	audit, cleanup := newTestAuditLog(t)
+	defer cleanup()
+
+	start := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, event := range []AuditEvent{
+		{Action: AuditLogin, Outcome: AuditFailure, Username: "alice"},
+		{Action: AuditLogin, Outcome: AuditSuccess, Username: "alice"},
+		{Action: AuditSetRootPassword, Outcome: AuditSuccess, Username: "alice"},
+		{Action: AuditLogin, Outcome: AuditSuccess, Username: "bob"},
+	} {
+		event.Time = start.Add(time.Duration(i) * time.Minute)
+		audit.Record(&event)
+	}
+
+	info, err := os.Stat(audit.path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	t.Run("Query_NewestFirst", func(t *testing.T) {
+		events, err := audit.Query(AuditQuery{})
+		require.NoError(t, err)
+		require.Len(t, events, 4)
+		require.Equal(t, "bob", events[0].Username)
+		require.Equal(t, AuditFailure, events[3].Outcome)
+	})
+
+	t.Run("Query_Filters", func(t *testing.T) {
+		events, err := audit.Query(AuditQuery{Username: "alice", Action: AuditLogin})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		events, err = audit.Query(AuditQuery{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		require.Equal(t, AuditSetRootPassword, events[0].Action)
+	})
+
+	t.Run("Query_LimitKeepsLatest", func(t *testing.T) {
+		events, err := audit.Query(AuditQuery{Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, "bob", events[0].Username)
+	})
+
+	t.Run("Handler_RejectsBadTime", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		audit.GetAuditHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/audit?since=yesterday", nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Handler_Queries", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		audit.GetAuditHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/audit?outcome=failure", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response struct {
+			Events []*AuditEvent `json:"events"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		require.Len(t, response.Events, 1)
+	})
+}
+
+func TestAudited(t *testing.T) {
+	audit, cleanup := newTestAuditLog(t)
+	defer cleanup()
+
+	handler := Audited(audit, AuditDownloadClientCert, RequireRole(RoleAdmin,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for _, user := range []*User{
+		{Username: "alice", Roles: []Role{RoleViewer}},
+		{Username: "root", Roles: []Role{RoleAdmin}},
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/clientcert", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, user))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	events, err := audit.Query(AuditQuery{Action: AuditDownloadClientCert})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "root", events[0].Username)
+	require.Equal(t, AuditSuccess, events[0].Outcome)
+	require.Equal(t, "alice", events[1].Username)
+	require.Equal(t, AuditDenied, events[1].Outcome)
+	require.Equal(t, "10.0.0.1", events[1].RemoteAddr)
+
+	handler = AuditedTarget(audit, AuditExecPod, func(r *http.Request) string { return r.URL.Query().Get("pod") },
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/exec?pod=web-1", nil))
+
+	events, err = audit.Query(AuditQuery{Action: AuditExecPod})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "web-1", events[0].Target)
+}
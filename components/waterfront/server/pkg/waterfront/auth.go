@@ -20,10 +20,14 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	gorilla_context "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/msteinert/pam"
 	"github.com/pkg/errors"
@@ -39,6 +43,17 @@ func init() {
 type User struct {
 	Username  string    `json:"username"`
 	LoginTime time.Time `json:"login_time"`
+	Roles     []Role    `json:"roles"`
+	// Groups reported by an external authenticator at login
+	Groups []string `json:"groups,omitempty"`
+	// Scopes limit a user authenticated with an API token. They are nil for
+	// users who logged in.
+	Scopes  []Scope `json:"scopes,omitempty"`
+	TokenID string  `json:"token_id,omitempty"`
+
+	// remoteAddr is the client address of a gRPC call made through the
+	// gateway
+	remoteAddr string
 }
 
 type ContextKey string
@@ -47,17 +62,28 @@ const (
 	ContextKeyuser = ContextKey("user")
 )
 
+// DefaultSessionValidity is how long a login lasts
+const DefaultSessionValidity = 24 * time.Hour
+
 type auth struct {
 	store         sessions.Store
 	validity      time.Duration
 	authenticator Authenticator
+	roles         *RoleStore
+	oidc          *OIDCLogin
+	throttle      *LoginThrottle
+	audit         *AuditLog
+	tokens        *TokenStore
 }
 
 func AuthSessionMiddleware(opts ...AuthSessionOption) *auth {
 	ash := &auth{
-		validity:      24 * time.Hour,
-		store:         sessions.NewCookieStore([]byte("this is a totally secret key")),
+		validity: DefaultSessionValidity,
+		// Without a configured store, sessions do not survive a restart
+		store:         sessions.NewCookieStore(securecookie.GenerateRandomKey(32)),
 		authenticator: PAMAuthenticator,
+		roles:         NewRoleStore(DefaultRolesFile, UnixGroups, &DefaultRoleBindings),
+		throttle:      NewLoginThrottle(),
 	}
 
 	for _, opt := range opts {
@@ -81,47 +107,177 @@ func SessionStore(store sessions.Store) AuthSessionOption {
 	}
 }
 
-func (h *auth) getUser(r *http.Request) (*sessions.Session, *User) {
-	session, err := h.store.Get(r, "operos-waterfront")
-	if err != nil {
-		log.Errorf("failed to obtain session: %s", err.Error())
+func RoleSource(roles *RoleStore) AuthSessionOption {
+	return func(ash *auth) {
+		ash.roles = roles
 	}
+}
 
-	var user User
+func Authentication(authenticator Authenticator) AuthSessionOption {
+	return func(ash *auth) {
+		ash.authenticator = authenticator
+	}
+}
 
-	if username, password, ok := r.BasicAuth(); ok {
-		var loggedIn bool
-		if loggedIn, err = h.authenticator.Authenticate(username, password); err != nil {
-			panic(errors.Wrap(err, "failed to authenticate"))
-		}
+// OIDC enables logging in through an OpenID Connect provider
+func OIDC(oidc *OIDCLogin) AuthSessionOption {
+	return func(ash *auth) {
+		ash.oidc = oidc
+	}
+}
 
-		if !loggedIn {
-			return session, nil
-		}
+func Throttle(throttle *LoginThrottle) AuthSessionOption {
+	return func(ash *auth) {
+		ash.throttle = throttle
+	}
+}
+
+// Audit records logins, logouts and denials in the audit log
+func Audit(audit *AuditLog) AuthSessionOption {
+	return func(ash *auth) {
+		ash.audit = audit
+	}
+}
+
+// Tokens enables authenticating with API tokens
+func Tokens(tokens *TokenStore) AuthSessionOption {
+	return func(ash *auth) {
+		ash.tokens = tokens
+	}
+}
+
+func (h *auth) authenticate(username, password string) (bool, []string, error) {
+	if ga, ok := h.authenticator.(GroupAuthenticator); ok {
+		return ga.AuthenticateGroups(username, password)
+	}
+
+	loggedIn, err := h.authenticator.Authenticate(username, password)
+	return loggedIn, nil, err
+}
 
-		user = User{
-			Username:  username,
-			LoginTime: time.Now(),
+// authError is a login attempt which could not be judged, either because the
+// user is locked out or because the authenticator failed
+type authError struct {
+	status     int
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *authError) write(w http.ResponseWriter) {
+	if e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	writeJSONError(w, e.status, e.message)
+}
+
+// checkPassword authenticates a password login, subject to the throttle. It
+// returns a nil user if the credentials are wrong.
+func (h *auth) checkPassword(r *http.Request, action, username, password string) (*User, *authError) {
+	addr := remoteHost(r)
+	event := &AuditEvent{
+		Action:     action,
+		Username:   username,
+		RemoteAddr: addr,
+	}
+
+	if wait := h.throttle.Check(username, addr); wait > 0 {
+		event.Outcome = AuditLocked
+		h.audit.Record(event)
+		return nil, &authError{
+			status:     http.StatusTooManyRequests,
+			message:    "too many failed logins, try again later",
+			retryAfter: wait,
 		}
-	} else {
-		user, ok := session.Values["user"].(User)
-		if !ok || user.LoginTime.Add(h.validity).Before(time.Now()) {
-			return session, nil
+	}
+
+	loggedIn, groups, err := h.authenticate(username, password)
+	if err != nil {
+		log.Errorf("failed to authenticate %s: %v", username, err)
+		h.throttle.Release(username, addr)
+		event.Outcome = AuditError
+		event.Reason = err.Error()
+		h.audit.Record(event)
+		return nil, &authError{
+			status:  http.StatusServiceUnavailable,
+			message: "authentication is unavailable",
 		}
 	}
 
-	return session, &user
+	if !loggedIn {
+		h.throttle.Failure(username, addr)
+		event.Outcome = AuditFailure
+		h.audit.Record(event)
+		return nil, nil
+	}
+
+	h.throttle.Success(username, addr)
+	event.Outcome = AuditSuccess
+	h.audit.Record(event)
+	return &User{
+		Username:  username,
+		LoginTime: time.Now(),
+		Groups:    groups,
+	}, nil
+}
+
+func (h *auth) getSession(r *http.Request) *sessions.Session {
+	session, err := h.store.Get(r, "operos-waterfront")
+	if err != nil {
+		log.Errorf("failed to obtain session: %s", err.Error())
+	}
+	return session
+}
+
+func (h *auth) getUser(r *http.Request) (*sessions.Session, *User, *authError) {
+	session := h.getSession(r)
+
+	if username, password, ok := r.BasicAuth(); ok {
+		user, authErr := h.checkPassword(r, AuditBasicAuth, username, password)
+		return session, user, authErr
+	}
+
+	user, ok := session.Values["user"].(User)
+	if !ok || user.LoginTime.Add(h.validity).Before(time.Now()) {
+		return session, nil, nil
+	}
+	return session, &user, nil
 }
 
 func (h *auth) GetAuthHandler(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, user := h.getUser(r)
+		var user *User
+		var authErr *authError
+		if token, ok := bearerToken(r); ok {
+			user, authErr = h.tokenUser(r, token)
+		} else {
+			_, user, authErr = h.getUser(r)
+		}
+		if authErr != nil {
+			authErr.write(w)
+			return
+		}
 
 		if user == nil {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
+		// Roles are looked up on every request rather than kept in the
+		// session, so that revoking a role does not wait for a new login
+		if user.TokenID == "" {
+			if reason := h.resolveRoles(user); reason != "" {
+				h.audit.Record(&AuditEvent{
+					Action:     r.URL.Path,
+					Outcome:    AuditDenied,
+					Username:   user.Username,
+					RemoteAddr: remoteHost(r),
+					Reason:     reason,
+				})
+				writeForbidden(w, reason)
+				return
+			}
+		}
+
 		// This is necessary to prevent https://github.com/gorilla/sessions/issues/80
 		defer gorilla_context.Clear(r)
 
@@ -130,17 +286,37 @@ func (h *auth) GetAuthHandler(handler http.Handler) http.Handler {
 	})
 }
 
+// resolveRoles sets the roles of user. It returns the reason the user is
+// denied access if they have no role.
+func (h *auth) resolveRoles(user *User) string {
+	roles, err := h.roles.Roles(user.Username, user.Groups)
+	if err != nil {
+		log.Errorf("failed to look up roles of %s: %v", user.Username, err)
+		return "role bindings could not be read"
+	}
+
+	if len(roles) == 0 {
+		return fmt.Sprintf("user %s has not been granted any role", user.Username)
+	}
+
+	user.Roles = roles
+	return ""
+}
+
 func (h *auth) GetLoginHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, user := h.getUser(r)
+		session, user, authErr := h.getUser(r)
+		if authErr != nil {
+			authErr.write(w)
+			return
+		}
 		h.login(w, r, user, session)
 	})
 }
 
 func (h *auth) GetLogoutHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, _ := h.getUser(r)
-		h.logout(w, r, session)
+		h.logout(w, r, h.getSession(r))
 	})
 }
 
@@ -154,29 +330,30 @@ func (h *auth) login(w http.ResponseWriter, r *http.Request, user *User, s *sess
 
 	if r.Method == "POST" {
 		var creds credentials
-		var err error
-
-		if err = json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 			http.Error(w, fmt.Sprintf("Invalid JSON: %s", err.Error()), http.StatusBadRequest)
 			return
 		}
 
-		if loggedIn, err = h.authenticator.Authenticate(creds.Username, creds.Password); err != nil {
-			panic(errors.Wrap(err, "failed to authenticate"))
+		var authErr *authError
+		if user, authErr = h.checkPassword(r, AuditLogin, creds.Username, creds.Password); authErr != nil {
+			authErr.write(w)
+			return
 		}
 
+		loggedIn = user != nil
 		if loggedIn {
-			log.Infof("user %s authenticated successfully", creds.Username)
-			user = &User{
-				Username:  creds.Username,
-				LoginTime: time.Now(),
+			if reason := h.startSession(w, r, s, AuditLogin, user); reason != "" {
+				writeForbidden(w, reason)
+				return
 			}
-			s.Values["user"] = user
-			if err := s.Save(r, w); err != nil {
-				panic(errors.Wrap(err, "failed to save session"))
-			}
-		} else {
-			log.Infof("user %s authentication failed", creds.Username)
+		}
+	}
+
+	if loggedIn && r.Method != "POST" {
+		if reason := h.resolveRoles(user); reason != "" {
+			writeForbidden(w, reason)
+			return
 		}
 	}
 
@@ -191,10 +368,17 @@ func (h *auth) login(w http.ResponseWriter, r *http.Request, user *User, s *sess
 			User:     user,
 		}
 	} else {
+		var loginURL string
+		if h.oidc != nil {
+			loginURL = h.oidc.LoginPath
+		}
+
 		response = struct {
-			LoggedIn bool `json:"logged_in"`
+			LoggedIn bool   `json:"logged_in"`
+			LoginURL string `json:"login_url,omitempty"`
 		}{
 			LoggedIn: false,
+			LoginURL: loginURL,
 		}
 	}
 
@@ -205,8 +389,45 @@ func (h *auth) login(w http.ResponseWriter, r *http.Request, user *User, s *sess
 	}
 }
 
+// startSession stores a newly authenticated user in the session. It returns
+// the reason the user is denied access if they have no role.
+func (h *auth) startSession(w http.ResponseWriter, r *http.Request, s *sessions.Session, action string, user *User) string {
+	if reason := h.resolveRoles(user); reason != "" {
+		h.audit.Record(&AuditEvent{
+			Action:     action,
+			Outcome:    AuditDenied,
+			Username:   user.Username,
+			RemoteAddr: remoteHost(r),
+			Reason:     reason,
+		})
+		return reason
+	}
+
+	if renewer, ok := h.store.(SessionRenewer); ok {
+		if err := renewer.Renew(s); err != nil {
+			panic(errors.Wrap(err, "failed to renew session"))
+		}
+	}
+	s.Values["user"] = user
+	if err := s.Save(r, w); err != nil {
+		panic(errors.Wrap(err, "failed to save session"))
+	}
+	return ""
+}
+
 func (h *auth) logout(w http.ResponseWriter, r *http.Request, s *sessions.Session) {
+	if user, ok := s.Values["user"].(User); ok {
+		h.audit.Record(&AuditEvent{
+			Action:     AuditLogout,
+			Outcome:    AuditSuccess,
+			Username:   user.Username,
+			RemoteAddr: remoteHost(r),
+		})
+	}
+
 	delete(s.Values, "user")
+	// Ends the session on the server as well as in the browser
+	s.Options.MaxAge = -1
 	if err := s.Save(r, w); err != nil {
 		log.Errorf("failed to save session: %s", err.Error())
 	}
@@ -224,10 +445,95 @@ func (h *auth) logout(w http.ResponseWriter, r *http.Request, s *sessions.Sessio
 	}
 }
 
+// GetSessionsHandler lets administrators list active sessions with GET, and
+// end them with DELETE, either by ID or for all sessions of the user given
+// in the user query parameter
+func (h *auth) GetSessionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager, ok := h.store.(SessionManager)
+		if !ok {
+			http.Error(w, "Session store does not support listing sessions", http.StatusNotImplemented)
+			return
+		}
+
+		var response interface{}
+		switch r.Method {
+		case "GET":
+			infos, err := manager.Sessions()
+			if err != nil {
+				panic(errors.Wrap(err, "failed to list sessions"))
+			}
+
+			current, _ := h.store.Get(r, "operos-waterfront")
+			for _, info := range infos {
+				info.Current = current != nil && current.ID != "" && info.ID == SessionPublicID(current.ID)
+			}
+			response = struct {
+				Sessions []*SessionInfo `json:"sessions"`
+			}{
+				Sessions: infos,
+			}
+
+		case "DELETE":
+			var revoked int
+			if id := mux.Vars(r)["id"]; id != "" {
+				found, err := manager.Revoke(id)
+				if err != nil {
+					panic(errors.Wrap(err, "failed to revoke session"))
+				}
+				if !found {
+					http.Error(w, "Session not found", http.StatusNotFound)
+					return
+				}
+				revoked = 1
+			} else if username := r.URL.Query().Get("user"); username != "" {
+				var err error
+				if revoked, err = manager.RevokeUser(username); err != nil {
+					panic(errors.Wrap(err, "failed to revoke sessions"))
+				}
+			} else {
+				http.Error(w, "Session ID or user is required", http.StatusBadRequest)
+				return
+			}
+
+			if user := GetUser(r.Context()); user != nil {
+				log.Infof("user %s revoked %d sessions", user.Username, revoked)
+			}
+			response = struct {
+				Revoked int `json:"revoked"`
+			}{
+				Revoked: revoked,
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			panic(err)
+		}
+	})
+}
+
 type Authenticator interface {
 	Authenticate(username, password string) (bool, error)
 }
 
+// GroupAuthenticator is implemented by authenticators which also know the
+// groups of the users they authenticate
+type GroupAuthenticator interface {
+	AuthenticateGroups(username, password string) (bool, []string, error)
+}
+
+// NoPasswordAuthenticator rejects all passwords, for when users log in
+// through an external provider
+var NoPasswordAuthenticator = AuthenticatorFunc(func(username, password string) (bool, error) {
+	return false, nil
+})
+
 type AuthenticatorFunc func(username, password string) (bool, error)
 
 func (a AuthenticatorFunc) Authenticate(username, password string) (bool, error) {
@@ -0,0 +1,421 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MethodRoles is the role required to call each method of the waterfront
+// gRPC service. Methods which are not listed can not be called by anyone.
+var MethodRoles = map[string]Role{
+	"/waterfront.Waterfront/ListNodes":             RoleViewer,
+	"/waterfront.Waterfront/GetNode":               RoleViewer,
+	"/waterfront.Waterfront/WatchNodes":            RoleViewer,
+	"/waterfront.Waterfront/CordonNode":            RoleOperator,
+	"/waterfront.Waterfront/UncordonNode":          RoleOperator,
+	"/waterfront.Waterfront/DrainNode":             RoleOperator,
+	"/waterfront.Waterfront/RebootNode":            RoleOperator,
+	"/waterfront.Waterfront/GetNodeMetadata":       RoleViewer,
+	"/waterfront.Waterfront/SetNodeMetadata":       RoleAdmin,
+	"/waterfront.Waterfront/RenameNode":            RoleAdmin,
+	"/waterfront.Waterfront/GetStorageHealth":      RoleViewer,
+	"/waterfront.Waterfront/ListPools":             RoleViewer,
+	"/waterfront.Waterfront/ListOSDs":              RoleViewer,
+	"/waterfront.Waterfront/GetPGStates":           RoleViewer,
+	"/waterfront.Waterfront/CreatePool":            RoleAdmin,
+	"/waterfront.Waterfront/ListStorageClasses":    RoleViewer,
+	"/waterfront.Waterfront/CreateStorageClass":    RoleAdmin,
+	"/waterfront.Waterfront/DeleteStorageClass":    RoleAdmin,
+	"/waterfront.Waterfront/ListVolumes":           RoleViewer,
+	"/waterfront.Waterfront/ListNamespaces":        RoleViewer,
+	"/waterfront.Waterfront/ListWorkloads":         RoleViewer,
+	"/waterfront.Waterfront/ListPods":              RoleViewer,
+	"/waterfront.Waterfront/ListWarningEvents":     RoleViewer,
+	"/waterfront.Waterfront/GetNodeMetrics":        RoleViewer,
+	"/waterfront.Waterfront/GetClusterMetrics":     RoleViewer,
+	"/waterfront.Waterfront/GetClusterInfo":        RoleViewer,
+	"/waterfront.Waterfront/GetClusterSettings":    RoleViewer,
+	"/waterfront.Waterfront/UpdateClusterSettings": RoleAdmin,
+	"/waterfront.Waterfront/GetLicense":            RoleViewer,
+	"/waterfront.Waterfront/UploadLicense":         RoleAdmin,
+	"/waterfront.Waterfront/SetRootPassword":       RoleAdmin,
+}
+
+// MethodScopes is the scope an API token needs to call each method of the
+// waterfront gRPC service, in addition to the role in MethodRoles
+var MethodScopes = map[string]Scope{
+	"/waterfront.Waterfront/ListNodes":             ScopeNodesRead,
+	"/waterfront.Waterfront/GetNode":               ScopeNodesRead,
+	"/waterfront.Waterfront/WatchNodes":            ScopeNodesRead,
+	"/waterfront.Waterfront/CordonNode":            ScopeNodesWrite,
+	"/waterfront.Waterfront/UncordonNode":          ScopeNodesWrite,
+	"/waterfront.Waterfront/DrainNode":             ScopeNodesWrite,
+	"/waterfront.Waterfront/RebootNode":            ScopeNodesWrite,
+	"/waterfront.Waterfront/GetNodeMetadata":       ScopeNodesRead,
+	"/waterfront.Waterfront/SetNodeMetadata":       ScopeNodesWrite,
+	"/waterfront.Waterfront/RenameNode":            ScopeNodesWrite,
+	"/waterfront.Waterfront/GetStorageHealth":      ScopeStorageRead,
+	"/waterfront.Waterfront/ListPools":             ScopeStorageRead,
+	"/waterfront.Waterfront/ListOSDs":              ScopeStorageRead,
+	"/waterfront.Waterfront/GetPGStates":           ScopeStorageRead,
+	"/waterfront.Waterfront/CreatePool":            ScopeStorageWrite,
+	"/waterfront.Waterfront/ListStorageClasses":    ScopeStorageRead,
+	"/waterfront.Waterfront/CreateStorageClass":    ScopeStorageWrite,
+	"/waterfront.Waterfront/DeleteStorageClass":    ScopeStorageWrite,
+	"/waterfront.Waterfront/ListVolumes":           ScopeStorageRead,
+	"/waterfront.Waterfront/ListNamespaces":        ScopeWorkloadsRead,
+	"/waterfront.Waterfront/ListWorkloads":         ScopeWorkloadsRead,
+	"/waterfront.Waterfront/ListPods":              ScopeWorkloadsRead,
+	"/waterfront.Waterfront/ListWarningEvents":     ScopeWorkloadsRead,
+	"/waterfront.Waterfront/GetNodeMetrics":        ScopeMetricsRead,
+	"/waterfront.Waterfront/GetClusterMetrics":     ScopeMetricsRead,
+	"/waterfront.Waterfront/GetClusterInfo":        ScopeClusterRead,
+	"/waterfront.Waterfront/GetClusterSettings":    ScopeClusterRead,
+	"/waterfront.Waterfront/UpdateClusterSettings": ScopeClusterWrite,
+	"/waterfront.Waterfront/GetLicense":            ScopeClusterRead,
+	"/waterfront.Waterfront/UploadLicense":         ScopeClusterWrite,
+	"/waterfront.Waterfront/SetRootPassword":       ScopeClusterWrite,
+}
+
+// AuditedMethods are the gRPC methods recorded in the audit log, and the
+// action each is recorded as
+var AuditedMethods = map[string]string{
+	"/waterfront.Waterfront/CordonNode":            AuditCordonNode,
+	"/waterfront.Waterfront/UncordonNode":          AuditUncordonNode,
+	"/waterfront.Waterfront/DrainNode":             AuditDrainNode,
+	"/waterfront.Waterfront/RebootNode":            AuditRebootNode,
+	"/waterfront.Waterfront/SetNodeMetadata":       AuditSetNodeMetadata,
+	"/waterfront.Waterfront/RenameNode":            AuditRenameNode,
+	"/waterfront.Waterfront/CreatePool":            AuditCreatePool,
+	"/waterfront.Waterfront/CreateStorageClass":    AuditCreateStorageClass,
+	"/waterfront.Waterfront/DeleteStorageClass":    AuditDeleteStorageClass,
+	"/waterfront.Waterfront/SetRootPassword":       AuditSetRootPassword,
+	"/waterfront.Waterfront/UpdateClusterSettings": AuditUpdateSettings,
+	"/waterfront.Waterfront/UploadLicense":         AuditUploadLicense,
+}
+
+// The HTTP gateway passes the authenticated user on to the gRPC server in
+// these metadata keys. The gateway token proves that the metadata was set by
+// the gateway, rather than by a client talking to the gRPC port directly.
+const (
+	metadataGatewayToken = "waterfront-gateway-token"
+	metadataUsername     = "waterfront-username"
+	metadataRoles        = "waterfront-roles"
+	metadataRemoteAddr   = "waterfront-remote-addr"
+	metadataScopes       = "waterfront-scopes"
+)
+
+// NewGatewayToken generates a secret shared by the HTTP gateway and the gRPC
+// server of a waterfront process
+func NewGatewayToken() (string, error) {
+	token, err := randomHex(32)
+	return token, errors.Wrap(err, "failed to generate gateway token")
+}
+
+// GetUser returns the user a request is made by, if any
+func GetUser(ctx context.Context) *User {
+	user, _ := ctx.Value(ContextKeyuser).(*User)
+	return user
+}
+
+// GatewayMetadata returns a grpc-gateway metadata annotator which forwards
+// the user authenticated by the HTTP handlers to the gRPC server
+func GatewayMetadata(token string) func(context.Context, *http.Request) metadata.MD {
+	return func(ctx context.Context, r *http.Request) metadata.MD {
+		user := GetUser(r.Context())
+		if user == nil {
+			return metadata.Pairs(metadataGatewayToken, token, metadataRemoteAddr, remoteHost(r))
+		}
+
+		roles := make([]string, 0, len(user.Roles))
+		for _, role := range user.Roles {
+			roles = append(roles, string(role))
+		}
+
+		md := metadata.Pairs(
+			metadataGatewayToken, token,
+			metadataUsername, user.Username,
+			metadataRoles, strings.Join(roles, ","),
+			metadataRemoteAddr, remoteHost(r),
+		)
+		if user.Scopes != nil {
+			scopes := make([]string, 0, len(user.Scopes))
+			for _, scope := range user.Scopes {
+				scopes = append(scopes, string(scope))
+			}
+			md[metadataScopes] = []string{strings.Join(scopes, ",")}
+		}
+		return md
+	}
+}
+
+// StripGatewayHeaders removes the headers which grpc-gateway would turn into
+// the metadata set by GatewayMetadata, so that clients can not add roles or
+// scopes to those of their user
+func StripGatewayHeaders(next http.Handler) http.Handler {
+	prefix := strings.ToLower(runtime.MetadataHeaderPrefix + "waterfront-")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), prefix) {
+				r.Header.Del(name)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func userFromMetadata(ctx context.Context, token string) (*User, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, errors.New("request carries no metadata")
+	}
+
+	tokens := md[metadataGatewayToken]
+	if len(tokens) != 1 || subtle.ConstantTimeCompare([]byte(tokens[0]), []byte(token)) != 1 {
+		return nil, errors.New("requests must be made through the waterfront HTTP API")
+	}
+
+	usernames := md[metadataUsername]
+	if len(usernames) != 1 || usernames[0] == "" {
+		return nil, errors.New("not logged in")
+	}
+
+	user := &User{Username: usernames[0]}
+	if addrs := md[metadataRemoteAddr]; len(addrs) == 1 {
+		user.remoteAddr = addrs[0]
+	}
+	// Only the gateway sets these, once each; more values were added by the
+	// client
+	if scopes, ok := md[metadataScopes]; ok {
+		if len(scopes) != 1 {
+			return nil, errors.New("request carries conflicting scopes")
+		}
+		user.Scopes = []Scope{}
+		for _, name := range strings.Split(scopes[0], ",") {
+			if scope, err := ParseScope(name); err == nil {
+				user.Scopes = append(user.Scopes, scope)
+			}
+		}
+	}
+	roles := md[metadataRoles]
+	if len(roles) != 1 {
+		return nil, errors.New("request carries conflicting roles")
+	}
+	for _, name := range strings.Split(roles[0], ",") {
+		if role, err := ParseRole(name); err == nil {
+			user.Roles = append(user.Roles, role)
+		}
+	}
+	return user, nil
+}
+
+// AuthorizationInterceptor checks the role of the calling user against
+// methodRoles before each gRPC call, and records calls to AuditedMethods in
+// audit. The user is made available to the method through GetUser.
+func AuthorizationInterceptor(token string, methodRoles map[string]Role, audit *AuditLog) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		user, finish, err := authorizeCall(ctx, token, methodRoles, audit, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := handler(context.WithValue(ctx, ContextKeyuser, user), req)
+		finish(auditTarget(req), err)
+		return resp, err
+	}
+}
+
+// StreamAuthorizationInterceptor is AuthorizationInterceptor for streaming
+// calls
+func StreamAuthorizationInterceptor(token string, methodRoles map[string]Role, audit *AuditLog) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		user, finish, err := authorizeCall(stream.Context(), token, methodRoles, audit, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		wrapped := &auditedStream{WrappedServerStream: grpc_middleware.WrapServerStream(stream)}
+		wrapped.WrappedContext = context.WithValue(stream.Context(), ContextKeyuser, user)
+		err = handler(srv, wrapped)
+		finish(wrapped.target, err)
+		return err
+	}
+}
+
+// auditedStream remembers the target of a streaming call from its request
+type auditedStream struct {
+	*grpc_middleware.WrappedServerStream
+	target string
+}
+
+func (s *auditedStream) RecvMsg(m interface{}) error {
+	err := s.WrappedServerStream.RecvMsg(m)
+	if err == nil && s.target == "" {
+		s.target = auditTarget(m)
+	}
+	return err
+}
+
+// auditTarget returns the ID of what a request acts on, if it has one
+func auditTarget(req interface{}) string {
+	if req, ok := req.(interface {
+		GetId() string
+	}); ok {
+		return req.GetId()
+	}
+	return ""
+}
+
+// authorizeCall returns the user making a gRPC call if they may make it, and
+// a function to record the outcome of the call in the audit log
+func authorizeCall(ctx context.Context, token string, methodRoles map[string]Role, audit *AuditLog, fullMethod string) (*User, func(string, error), error) {
+	user, err := userFromMetadata(ctx, token)
+	if err != nil {
+		return nil, nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	action, audited := AuditedMethods[fullMethod]
+	record := func(outcome, reason string) {
+		if audited {
+			audit.Record(&AuditEvent{
+				Action:     action,
+				Outcome:    outcome,
+				Username:   user.Username,
+				RemoteAddr: user.remoteAddr,
+				Reason:     reason,
+			})
+		}
+	}
+
+	required, ok := methodRoles[fullMethod]
+	if !ok {
+		err := status.Errorf(codes.PermissionDenied, "%s is not available to any role", path.Base(fullMethod))
+		record(AuditDenied, err.Error())
+		return nil, nil, err
+	}
+
+	if !HasRole(user.Roles, required) {
+		reason := deniedReason(user, required, path.Base(fullMethod))
+		record(AuditDenied, reason)
+		return nil, nil, status.Error(codes.PermissionDenied, reason)
+	}
+
+	if user.Scopes != nil {
+		if scope := MethodScopes[fullMethod]; scope == "" || !HasScope(user.Scopes, scope) {
+			reason := scopeDeniedReason(scope, path.Base(fullMethod))
+			record(AuditDenied, reason)
+			return nil, nil, status.Error(codes.PermissionDenied, reason)
+		}
+	}
+
+	return user, func(target string, err error) {
+		if !audited {
+			return
+		}
+		event := &AuditEvent{
+			Action:     action,
+			Outcome:    AuditSuccess,
+			Username:   user.Username,
+			RemoteAddr: user.remoteAddr,
+			Target:     target,
+		}
+		if err != nil {
+			event.Outcome = AuditError
+			event.Reason = err.Error()
+		}
+		audit.Record(event)
+	}, nil
+}
+
+func deniedReason(user *User, required Role, action string) string {
+	held := "no role"
+	if len(user.Roles) > 0 {
+		held = fmt.Sprintf("role %s", user.Roles[0])
+	}
+	return fmt.Sprintf("user %s has %s, but %s requires %s", user.Username, held, action, required)
+}
+
+func scopeDeniedReason(scope Scope, action string) string {
+	if scope == "" {
+		return fmt.Sprintf("%s is not available to API tokens", action)
+	}
+	return fmt.Sprintf("API token lacks scope %s, which %s requires", scope, action)
+}
+
+// RequireRole wraps an HTTP handler so that it is only served to users with
+// the required role. API tokens are refused. It must be used inside
+// GetAuthHandler.
+func RequireRole(required Role, handler http.Handler) http.Handler {
+	return RequireAccess(required, "", handler)
+}
+
+// RequireAccess is RequireRole for handlers which API tokens with the
+// required scope may use
+func RequireAccess(required Role, scope Scope, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r.Context())
+		if user == nil {
+			writeForbidden(w, "not logged in")
+			return
+		}
+
+		if !HasRole(user.Roles, required) {
+			writeForbidden(w, deniedReason(user, required, r.URL.Path))
+			return
+		}
+
+		if user.Scopes != nil && (scope == "" || !HasScope(user.Scopes, scope)) {
+			writeForbidden(w, scopeDeniedReason(scope, r.URL.Path))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func writeForbidden(w http.ResponseWriter, reason string) {
+	writeJSONError(w, http.StatusForbidden, reason)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+
+	response := struct {
+		Error string `json:"error"`
+	}{
+		Error: message,
+	}
+	if err := json.NewEncoder(w).Encode(&response); err != nil {
+		panic(err)
+	}
+}
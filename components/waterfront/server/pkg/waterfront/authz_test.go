@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGatewayMetadata(t *testing.T) {
+	var user *User
+	var userErr error
+	gatewayMux := runtime.NewServeMux(runtime.WithMetadata(GatewayMetadata("secret")))
+	pattern := runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"test"}, ""))
+	// Stands in for a gRPC call, which receives the metadata the gateway
+	// sends
+	gatewayMux.Handle("GET", pattern, func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		ctx, err := runtime.AnnotateContext(r.Context(), gatewayMux, r)
+		require.NoError(t, err)
+		md, _ := metadata.FromOutgoingContext(ctx)
+		user, userErr = userFromMetadata(metadata.NewIncomingContext(ctx, md), "secret")
+	})
+
+	viewer := &User{Username: "alice", Roles: []Role{RoleViewer}, Scopes: []Scope{ScopeNodesRead}}
+	serve := func(handler http.Handler, header http.Header) {
+		user, userErr = nil, nil
+		req := httptest.NewRequest("GET", "/test", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, viewer))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	forged := http.Header{
+		"Grpc-Metadata-Waterfront-Roles":  {"admin"},
+		"Grpc-Metadata-Waterfront-Scopes": {"nodes:write"},
+	}
+
+	t.Run("User_IsForwarded", func(t *testing.T) {
+		serve(StripGatewayHeaders(gatewayMux), nil)
+		require.NoError(t, userErr)
+		require.Equal(t, "alice", user.Username)
+		require.Equal(t, []Role{RoleViewer}, user.Roles)
+		require.Equal(t, []Scope{ScopeNodesRead}, user.Scopes)
+	})
+
+	t.Run("ForgedHeaders_AreStripped", func(t *testing.T) {
+		serve(StripGatewayHeaders(gatewayMux), forged)
+		require.NoError(t, userErr)
+		require.Equal(t, []Role{RoleViewer}, user.Roles)
+		require.Equal(t, []Scope{ScopeNodesRead}, user.Scopes)
+	})
+
+	t.Run("ForgedMetadata_IsRefused", func(t *testing.T) {
+		serve(gatewayMux, http.Header{"Grpc-Metadata-Waterfront-Roles": {"admin"}})
+		require.Error(t, userErr)
+		require.Nil(t, user)
+
+		serve(gatewayMux, http.Header{"Grpc-Metadata-Waterfront-Scopes": {"nodes:write"}})
+		require.Error(t, userErr)
+		require.Nil(t, user)
+	})
+}
@@ -17,23 +17,327 @@ limitations under the License.
 package waterfront
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
-func MakeGenClientCertHandler(teamsterAddr string) http.Handler {
+const (
+	// DefaultCredentialTTL is how long client certificates are valid unless
+	// the user asks otherwise
+	DefaultCredentialTTL = 7 * 24 * time.Hour
+
+	// MaxCredentialTTL limits how long client certificates are valid
+	MaxCredentialTTL = 90 * 24 * time.Hour
+
+	// credentialGroupPrefix starts the Kubernetes group which is unique to
+	// each client certificate
+	credentialGroupPrefix = "operos:credential:"
+
+	// Headers of the teamster /clientcert response describing the issued
+	// certificate
+	certificateSerialHeader = "X-Certificate-Serial"
+	certificateExpiryHeader = "X-Certificate-Expiry"
+)
+
+var credentialsBucket = []byte("credentials")
+
+// ClientCredential records a client certificate issued to a user for kubectl
+// and the Kubernetes API. The certificate names Group, which is bound to the
+// cluster roles of Groups for as long as the certificate is not revoked.
+type ClientCredential struct {
+	Serial  string     `json:"serial"`
+	Owner   string     `json:"owner"`
+	Group   string     `json:"group"`
+	Groups  []string   `json:"groups"`
+	Issued  time.Time  `json:"issued"`
+	Expires time.Time  `json:"expires"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// CredentialBinder gives the group of a client certificate its permissions in
+// Kubernetes, and takes them away again. The API server can not check whether
+// a certificate was revoked, but it does check the bindings on every request.
+type CredentialBinder interface {
+	Bind(cred *ClientCredential) error
+	Unbind(cred *ClientCredential) error
+}
+
+// CredentialStore keeps a record of the client certificates issued through
+// waterfront in a bbolt database, and binds their groups while they are valid
+type CredentialStore struct {
+	db     *bolt.DB
+	binder CredentialBinder
+	now    func() time.Time
+	stop   chan struct{}
+}
+
+func NewCredentialStore(path string, binder CredentialBinder) (*CredentialStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create credential directory")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open credential database %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(credentialsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize credential database")
+	}
+
+	s := &CredentialStore{
+		db:     db,
+		binder: binder,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	go s.cleanupLoop(time.Hour)
+	return s, nil
+}
+
+func (s *CredentialStore) Close() error {
+	close(s.stop)
+	return s.db.Close()
+}
+
+// Add binds the group of an issued certificate, and records the certificate
+func (s *CredentialStore) Add(cred *ClientCredential) error {
+	if err := s.binder.Bind(cred); err != nil {
+		return errors.Wrapf(err, "failed to bind credential %s", cred.Serial)
+	}
+
+	if err := s.put(cred); err != nil {
+		if err := s.binder.Unbind(cred); err != nil {
+			log.Printf("failed to unbind unrecorded credential %s: %v", cred.Serial, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *CredentialStore) put(cred *ClientCredential) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cred); err != nil {
+		return errors.Wrap(err, "failed to encode credential")
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).Put([]byte(cred.Serial), buf.Bytes())
+	})
+	return errors.Wrap(err, "failed to store credential")
+}
+
+// Get returns the credential with the given serial, or nil if there is none
+func (s *CredentialStore) Get(serial string) (*ClientCredential, error) {
+	var cred *ClientCredential
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(credentialsBucket).Get([]byte(serial))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		cred, err = decodeCredential(data)
+		return err
+	})
+	return cred, errors.Wrap(err, "failed to load credential")
+}
+
+// Credentials lists the credentials issued to owner, or all credentials if
+// owner is empty, oldest first
+func (s *CredentialStore) Credentials(owner string) ([]*ClientCredential, error) {
+	creds := []*ClientCredential{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(credentialsBucket).ForEach(func(k, v []byte) error {
+			cred, err := decodeCredential(v)
+			if err != nil {
+				log.Printf("skipping unreadable credential %s: %v", k, err)
+				return nil
+			}
+			if owner == "" || cred.Owner == owner {
+				creds = append(creds, cred)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list credentials")
+	}
+
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].Issued.Before(creds[j].Issued)
+	})
+	return creds, nil
+}
+
+// Revoke unbinds the group of the credential with the given serial, which
+// leaves the certificate without permissions, and marks it as revoked. The
+// record is kept until the certificate expires.
+func (s *CredentialStore) Revoke(serial string) error {
+	cred, err := s.Get(serial)
+	if err != nil {
+		return err
+	} else if cred == nil || cred.Revoked != nil {
+		return nil
+	}
+
+	if err := s.binder.Unbind(cred); err != nil {
+		return errors.Wrapf(err, "failed to unbind credential %s", serial)
+	}
+	now := s.now()
+	cred.Revoked = &now
+	return s.put(cred)
+}
+
+// expire unbinds and forgets the credentials whose certificates expired
+func (s *CredentialStore) expire() (int, error) {
+	creds, err := s.Credentials("")
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	now := s.now()
+	for _, cred := range creds {
+		if cred.Expires.After(now) {
+			continue
+		}
+		if err := s.binder.Unbind(cred); err != nil {
+			return count, errors.Wrapf(err, "failed to unbind credential %s", cred.Serial)
+		}
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(credentialsBucket).Delete([]byte(cred.Serial))
+		})
+		if err != nil {
+			return count, errors.Wrap(err, "failed to delete credential")
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *CredentialStore) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			count, err := s.expire()
+			if err != nil {
+				log.Printf("failed to clean up credentials: %v", err)
+			} else if count > 0 {
+				log.Printf("removed %d expired credentials", count)
+			}
+		}
+	}
+}
+
+func decodeCredential(data []byte) (*ClientCredential, error) {
+	var cred ClientCredential
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cred); err != nil {
+		return nil, errors.Wrap(err, "failed to decode credential")
+	}
+	return &cred, nil
+}
+
+// credentialGroups returns the Kubernetes groups a user may put in a client
+// certificate: those of their roles
+func credentialGroups(user *User) []string {
+	var groups []string
+	for _, group := range KubeGroups(user) {
+		if group != kubeAuthenticatedGroup {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// credentialRequest reads the groups and TTL asked for in a download, which
+// default to all groups the user may have and DefaultCredentialTTL
+func credentialRequest(user *User, query url.Values) ([]string, time.Duration, error) {
+	allowed := credentialGroups(user)
+	groups := query["group"]
+	if len(groups) == 0 {
+		groups = allowed
+	}
+	for _, group := range groups {
+		found := false
+		for _, a := range allowed {
+			found = found || a == group
+		}
+		if !found {
+			return nil, 0, errors.Errorf("group %s is not one of %s", group, strings.Join(allowed, ", "))
+		}
+	}
+
+	ttl := DefaultCredentialTTL
+	if value := query.Get("ttl"); value != "" {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil || ttl <= 0 {
+			return nil, 0, errors.Errorf("invalid ttl %q", value)
+		}
+		if ttl > MaxCredentialTTL {
+			return nil, 0, errors.Errorf("ttl may be at most %s", MaxCredentialTTL)
+		}
+	}
+
+	return groups, ttl, nil
+}
+
+// MakeGenClientCertHandler has teamster issue a client certificate for the
+// logged in user, and records it. The group and ttl parameters choose the
+// groups whose permissions the certificate has and how long it is valid.
+func MakeGenClientCertHandler(teamsterAddr string, credentials *CredentialStore) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r.Context())
+		if user == nil {
+			writeForbidden(w, "not logged in")
+			return
+		}
+		groups, ttl, err := credentialRequest(user, r.URL.Query())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		// The certificate holds only a group of its own, so that revoking it
+		// takes effect as soon as the group is unbound
+		id, err := randomHex(8)
+		if err != nil {
+			panic(errors.Wrap(err, "failed to generate credential group"))
+		}
+		group := credentialGroupPrefix + id
+
 		getURL := url.URL{
 			Scheme: "http",
 			Host:   teamsterAddr,
 			Path:   "/clientcert",
 		}
 		q := getURL.Query()
-		q.Set("user", "admin")
-		q.Add("group", "admin")
+		q.Set("user", user.Username)
+		q.Set("group", group)
+		q.Set("ttl", ttl.String())
 		q.Set("host", strings.SplitN(r.Host, ":", 2)[0])
 
 		getURL.RawQuery = q.Encode()
@@ -41,10 +345,32 @@ func MakeGenClientCertHandler(teamsterAddr string) http.Handler {
 		resp, err := http.Get(getURL.String())
 		if err != nil {
 			log.Printf("error while accessing Teamster: %v", err)
+			http.Error(w, "Could not reach Teamster", http.StatusBadGateway)
 			return
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode == http.StatusOK {
+			cred := &ClientCredential{
+				Serial: resp.Header.Get(certificateSerialHeader),
+				Owner:  user.Username,
+				Group:  group,
+				Groups: groups,
+				Issued: credentials.now(),
+			}
+			expires, err := time.Parse(time.RFC3339, resp.Header.Get(certificateExpiryHeader))
+			if err != nil || cred.Serial == "" {
+				log.Printf("teamster did not describe the issued certificate: %v", err)
+				http.Error(w, "Teamster did not describe the issued certificate", http.StatusBadGateway)
+				return
+			}
+			cred.Expires = expires
+			// Credentials which are not recorded are not handed out
+			if err := credentials.Add(cred); err != nil {
+				panic(err)
+			}
+		}
+
 		for name := range resp.Header {
 			for _, value := range resp.Header[name] {
 				w.Header().Set(name, value)
@@ -59,3 +385,64 @@ func MakeGenClientCertHandler(teamsterAddr string) http.Handler {
 		}
 	})
 }
+
+// GetCredentialsHandler lets users list the client certificates issued to
+// them with GET, and revoke them with DELETE. Administrators see all of them.
+func GetCredentialsHandler(credentials *CredentialStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r.Context())
+		if user == nil {
+			writeForbidden(w, "not logged in")
+			return
+		}
+		isAdmin := HasRole(user.Roles, RoleAdmin)
+
+		var response interface{}
+		switch r.Method {
+		case "GET":
+			owner := user.Username
+			if isAdmin {
+				owner = ""
+			}
+			creds, err := credentials.Credentials(owner)
+			if err != nil {
+				panic(err)
+			}
+			response = struct {
+				Credentials []*ClientCredential `json:"credentials"`
+			}{
+				Credentials: creds,
+			}
+
+		case "DELETE":
+			serial := mux.Vars(r)["serial"]
+			cred, err := credentials.Get(serial)
+			if err != nil {
+				panic(err)
+			}
+			// Credentials of other users are not revealed to exist
+			if cred == nil || (!isAdmin && cred.Owner != user.Username) {
+				http.Error(w, "Credential not found", http.StatusNotFound)
+				return
+			}
+
+			if err := credentials.Revoke(serial); err != nil {
+				panic(err)
+			}
+			response = struct {
+				Revoked int `json:"revoked"`
+			}{
+				Revoked: 1,
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			panic(err)
+		}
+	})
+}
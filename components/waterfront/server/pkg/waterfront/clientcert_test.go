@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// fakeCredentialBinder keeps the groups of bound credentials
+type fakeCredentialBinder struct {
+	bound map[string][]string
+}
+
+func (b *fakeCredentialBinder) Bind(cred *ClientCredential) error {
+	b.bound[cred.Group] = cred.Groups
+	return nil
+}
+
+func (b *fakeCredentialBinder) Unbind(cred *ClientCredential) error {
+	delete(b.bound, cred.Group)
+	return nil
+}
+
+func newTestCredentialStore(t *testing.T) (*CredentialStore, *fakeCredentialBinder, func()) {
+	dir, err := ioutil.TempDir("", "waterfront-credentials")
+	require.NoError(t, err)
+
+	binder := &fakeCredentialBinder{bound: make(map[string][]string)}
+	store, err := NewCredentialStore(filepath.Join(dir, "credentials.db"), binder)
+	require.NoError(t, err)
+
+	return store, binder, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	credentials, binder, cleanup := newTestCredentialStore(t)
+	defer cleanup()
+
+	// teamster issues a certificate with a new serial for every request
+	var teamsterQuery url.Values
+	serials := []string{"1a", "2b", "3c"}
+	teamster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		teamsterQuery = r.URL.Query()
+		w.Header().Set(certificateSerialHeader, serials[0])
+		w.Header().Set(certificateExpiryHeader, "2018-03-09T12:00:00Z")
+		w.Header().Set("Content-Type", "application/gzip")
+		serials = serials[1:]
+		w.Write([]byte("tarball"))
+	}))
+	defer teamster.Close()
+	teamsterURL, err := url.Parse(teamster.URL)
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.Path("/api/v1/clientcert").Handler(MakeGenClientCertHandler(teamsterURL.Host, credentials))
+	router.Path("/api/v1/clientcerts").Handler(GetCredentialsHandler(credentials))
+	router.Path("/api/v1/clientcerts/{serial}").Handler(GetCredentialsHandler(credentials))
+
+	alice := &User{Username: "alice", Roles: []Role{RoleViewer, RoleOperator}}
+	bob := &User{Username: "bob", Roles: []Role{RoleViewer}}
+	root := &User{Username: "root", Roles: []Role{RoleAdmin}}
+
+	serve := func(user *User, method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, user))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	listed := func(user *User) []*ClientCredential {
+		rec := serve(user, "GET", "/api/v1/clientcerts")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var listing struct {
+			Credentials []*ClientCredential `json:"credentials"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&listing))
+		return listing.Credentials
+	}
+
+	t.Run("Download_IssuesForUser", func(t *testing.T) {
+		rec := serve(alice, "GET", "/api/v1/clientcert")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "tarball", rec.Body.String())
+		require.Equal(t, "alice", teamsterQuery.Get("user"))
+		require.Equal(t, DefaultCredentialTTL.String(), teamsterQuery.Get("ttl"))
+
+		creds := listed(alice)
+		require.Len(t, creds, 1)
+		require.Equal(t, "1a", creds[0].Serial)
+		require.Equal(t, []string{"operos:operator", "operos:viewer"}, creds[0].Groups)
+
+		// The certificate has only its own group, bound to the roles
+		require.Equal(t, []string{creds[0].Group}, teamsterQuery["group"])
+		require.True(t, strings.HasPrefix(creds[0].Group, credentialGroupPrefix))
+		require.Equal(t, []string{"operos:operator", "operos:viewer"}, binder.bound[creds[0].Group])
+		require.Equal(t, time.Date(2018, 3, 9, 12, 0, 0, 0, time.UTC), creds[0].Expires.UTC())
+	})
+
+	t.Run("Download_SelectsGroupsAndTTL", func(t *testing.T) {
+		rec := serve(alice, "GET", "/api/v1/clientcert?group=operos:viewer&ttl=24h")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "24h0m0s", teamsterQuery.Get("ttl"))
+		require.Equal(t, []string{"operos:viewer"}, binder.bound[teamsterQuery.Get("group")])
+	})
+
+	t.Run("Download_RejectsOtherGroups", func(t *testing.T) {
+		rec := serve(bob, "GET", "/api/v1/clientcert?group=operos:admin")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.True(t, strings.Contains(rec.Body.String(), "operos:admin"))
+	})
+
+	t.Run("Download_RejectsLongTTL", func(t *testing.T) {
+		rec := serve(bob, "GET", "/api/v1/clientcert?ttl=8760h")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Revoke_OnlyOwn", func(t *testing.T) {
+		rec := serve(bob, "GET", "/api/v1/clientcert")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, listed(bob), 1)
+		require.Len(t, listed(root), 3)
+
+		rec = serve(bob, "DELETE", "/api/v1/clientcerts/1a")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = serve(alice, "DELETE", "/api/v1/clientcerts/1a")
+		require.Equal(t, http.StatusOK, rec.Code)
+		creds := listed(alice)
+		require.NotNil(t, creds[0].Revoked)
+		require.Nil(t, creds[1].Revoked)
+		require.NotContains(t, binder.bound, creds[0].Group)
+		require.Contains(t, binder.bound, creds[1].Group)
+	})
+
+	t.Run("Expired_AreUnbound", func(t *testing.T) {
+		credentials.now = func() time.Time { return time.Date(2018, 3, 10, 0, 0, 0, 0, time.UTC) }
+		defer func() { credentials.now = time.Now }()
+
+		count, err := credentials.expire()
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+		require.Empty(t, binder.bound)
+		require.Empty(t, listed(root))
+	})
+}
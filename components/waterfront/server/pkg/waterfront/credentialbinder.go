@@ -0,0 +1,123 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	rbac_v1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+// clusterRoleBindingClient is the part of the Kubernetes API used to bind the
+// groups of client certificates
+type clusterRoleBindingClient interface {
+	List(opts meta_v1.ListOptions) (*rbac_v1beta1.ClusterRoleBindingList, error)
+	Create(binding *rbac_v1beta1.ClusterRoleBinding) (*rbac_v1beta1.ClusterRoleBinding, error)
+	Delete(name string, options *meta_v1.DeleteOptions) error
+}
+
+type kubeCredentialBinder struct {
+	client clusterRoleBindingClient
+}
+
+// NewKubeCredentialBinder returns a CredentialBinder which binds the group of
+// a client certificate to the cluster roles that the groups it was issued for
+// are bound to, with one ClusterRoleBinding for each role
+func NewKubeCredentialBinder(client kubernetes.Interface) CredentialBinder {
+	return &kubeCredentialBinder{client: client.RbacV1beta1().ClusterRoleBindings()}
+}
+
+func (b *kubeCredentialBinder) Bind(cred *ClientCredential) error {
+	bindings, err := b.client.List(meta_v1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list cluster role bindings")
+	}
+
+	for _, role := range boundClusterRoles(bindings.Items, cred.Groups) {
+		binding := &rbac_v1beta1.ClusterRoleBinding{
+			ObjectMeta: meta_v1.ObjectMeta{Name: cred.Group + ":" + role},
+			RoleRef: rbac_v1beta1.RoleRef{
+				APIGroup: rbac_v1beta1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     role,
+			},
+			Subjects: []rbac_v1beta1.Subject{{
+				APIGroup: rbac_v1beta1.GroupName,
+				Kind:     "Group",
+				Name:     cred.Group,
+			}},
+		}
+		if _, err := b.client.Create(binding); err != nil && !kube_errors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "failed to bind %s to %s", cred.Group, role)
+		}
+	}
+	return nil
+}
+
+func (b *kubeCredentialBinder) Unbind(cred *ClientCredential) error {
+	// Certificates issued before credential groups existed have nothing to
+	// unbind
+	if cred.Group == "" {
+		return nil
+	}
+
+	bindings, err := b.client.List(meta_v1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list cluster role bindings")
+	}
+
+	for _, binding := range bindings.Items {
+		if !strings.HasPrefix(binding.Name, cred.Group+":") {
+			continue
+		}
+		err := b.client.Delete(binding.Name, &meta_v1.DeleteOptions{})
+		if err != nil && !kube_errors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete cluster role binding %s", binding.Name)
+		}
+	}
+	return nil
+}
+
+// boundClusterRoles returns the names of the cluster roles which bindings
+// grant to any of groups
+func boundClusterRoles(bindings []rbac_v1beta1.ClusterRoleBinding, groups []string) []string {
+	found := make(map[string]bool)
+	for _, binding := range bindings {
+		if binding.RoleRef.Kind != "ClusterRole" {
+			continue
+		}
+		for _, subject := range binding.Subjects {
+			for _, group := range groups {
+				if subject.Kind == "Group" && subject.Name == group {
+					found[binding.RoleRef.Name] = true
+				}
+			}
+		}
+	}
+
+	roles := make([]string, 0, len(found))
+	for role := range found {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	rbac_v1beta1 "k8s.io/client-go/pkg/apis/rbac/v1beta1"
+)
+
+type fakeClusterRoleBindingClient struct {
+	bindings map[string]rbac_v1beta1.ClusterRoleBinding
+}
+
+func (c *fakeClusterRoleBindingClient) List(opts meta_v1.ListOptions) (*rbac_v1beta1.ClusterRoleBindingList, error) {
+	list := &rbac_v1beta1.ClusterRoleBindingList{}
+	for _, binding := range c.bindings {
+		list.Items = append(list.Items, binding)
+	}
+	return list, nil
+}
+
+func (c *fakeClusterRoleBindingClient) Create(binding *rbac_v1beta1.ClusterRoleBinding) (*rbac_v1beta1.ClusterRoleBinding, error) {
+	if _, ok := c.bindings[binding.Name]; ok {
+		return nil, kube_errors.NewAlreadyExists(schema.GroupResource{Resource: "clusterrolebindings"}, binding.Name)
+	}
+	c.bindings[binding.Name] = *binding
+	return binding, nil
+}
+
+func (c *fakeClusterRoleBindingClient) Delete(name string, options *meta_v1.DeleteOptions) error {
+	if _, ok := c.bindings[name]; !ok {
+		return kube_errors.NewNotFound(schema.GroupResource{Resource: "clusterrolebindings"}, name)
+	}
+	delete(c.bindings, name)
+	return nil
+}
+
+func groupBinding(name, role string, groups ...string) rbac_v1beta1.ClusterRoleBinding {
+	binding := rbac_v1beta1.ClusterRoleBinding{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		RoleRef:    rbac_v1beta1.RoleRef{Kind: "ClusterRole", Name: role},
+	}
+	for _, group := range groups {
+		binding.Subjects = append(binding.Subjects, rbac_v1beta1.Subject{Kind: "Group", Name: group})
+	}
+	return binding
+}
+
+func TestKubeCredentialBinder(t *testing.T) {
+	client := &fakeClusterRoleBindingClient{bindings: make(map[string]rbac_v1beta1.ClusterRoleBinding)}
+	for _, binding := range []rbac_v1beta1.ClusterRoleBinding{
+		groupBinding("operos:viewer", "view", "operos:viewer"),
+		groupBinding("operos:viewer-cluster", "operos:cluster-viewer", "operos:viewer", "operos:operator"),
+		groupBinding("operos:operator", "edit", "operos:operator"),
+		groupBinding("operos:admin", "cluster-admin", "operos:admin"),
+	} {
+		client.bindings[binding.Name] = binding
+	}
+	binder := &kubeCredentialBinder{client: client}
+
+	viewer := &ClientCredential{Group: "operos:credential:1a", Groups: []string{"operos:viewer"}}
+	operator := &ClientCredential{Group: "operos:credential:2b", Groups: []string{"operos:viewer", "operos:operator"}}
+
+	t.Run("Bind_CopiesRolesOfGroups", func(t *testing.T) {
+		require.NoError(t, binder.Bind(viewer))
+		require.NoError(t, binder.Bind(operator))
+		require.NoError(t, binder.Bind(operator))
+
+		binding := client.bindings["operos:credential:1a:view"]
+		require.Equal(t, "view", binding.RoleRef.Name)
+		require.Equal(t, []rbac_v1beta1.Subject{{
+			APIGroup: rbac_v1beta1.GroupName,
+			Kind:     "Group",
+			Name:     "operos:credential:1a",
+		}}, binding.Subjects)
+		require.Contains(t, client.bindings, "operos:credential:1a:operos:cluster-viewer")
+		require.NotContains(t, client.bindings, "operos:credential:1a:edit")
+
+		for _, role := range []string{"view", "edit", "operos:cluster-viewer"} {
+			require.Contains(t, client.bindings, "operos:credential:2b:"+role)
+		}
+		require.Len(t, client.bindings, 9)
+	})
+
+	t.Run("Unbind_RemovesOnlyItsBindings", func(t *testing.T) {
+		require.NoError(t, binder.Unbind(operator))
+		require.NoError(t, binder.Unbind(operator))
+		require.Len(t, client.bindings, 6)
+		require.Contains(t, client.bindings, "operos:credential:1a:view")
+		require.Contains(t, client.bindings, "operos:operator")
+
+		// Credentials without a group of their own are left alone
+		require.NoError(t, binder.Unbind(&ClientCredential{Groups: []string{"operos:viewer"}}))
+		require.Len(t, client.bindings, 6)
+	})
+}
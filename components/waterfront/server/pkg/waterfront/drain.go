@@ -0,0 +1,239 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// DefaultDrainTimeout is how long a drain waits for pods to be evicted,
+	// unless the request says otherwise
+	DefaultDrainTimeout = 5 * time.Minute
+
+	// drainPollInterval is how often a drain retries evictions refused by a
+	// disruption budget, and checks whether evicted pods are gone
+	drainPollInterval = 2 * time.Second
+
+	// mirrorPodAnnotation marks the API server's copies of static pods,
+	// which can not be evicted
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+// drainClient is the part of the Kubernetes API used to drain a node
+type drainClient interface {
+	setUnschedulable(name string, unschedulable bool) (*kube_v1.Node, error)
+	podsOnNode(name string) ([]kube_v1.Pod, error)
+	evictPod(pod *kube_v1.Pod, gracePeriod *int64) error
+	// podGone tells whether a pod was deleted, or replaced by another one
+	// of the same name
+	podGone(pod *kube_v1.Pod) (bool, error)
+}
+
+// nodeDrainer cordons nodes and evicts their pods
+type nodeDrainer struct {
+	client       drainClient
+	pollInterval time.Duration
+}
+
+// drain evicts the pods of a node until none are left, sending progress
+// whenever it changes. Evictions refused because of a pod disruption budget
+// are retried until the timeout of the request.
+func (d *nodeDrainer) drain(ctx context.Context, req *DrainNodeRequest, send func(*DrainProgress) error) error {
+	timeout := DefaultDrainTimeout
+	if req.TimeoutSeconds > 0 {
+		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if _, err := d.client.setUnschedulable(req.Id, true); kube_errors.IsNotFound(err) {
+		return status.Errorf(codes.NotFound, "node %s not found", req.Id)
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to cordon node %s", req.Id)
+	}
+
+	pods, err := d.client.podsOnNode(req.Id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the pods of node %s", req.Id)
+	}
+
+	pending, skipped, blocked := drainablePods(pods, req)
+	if len(blocked) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "node %s was cordoned, but can not be drained: %s",
+			req.Id, strings.Join(blocked, "; "))
+	}
+
+	var gracePeriod *int64
+	if req.GracePeriodSeconds > 0 {
+		gracePeriod = &req.GracePeriodSeconds
+	}
+
+	requested := make(map[string]bool)
+	var evicted []string
+	var last *DrainProgress
+	for {
+		var remaining []kube_v1.Pod
+		var budgeted []string
+		for idx := range pending {
+			pod := &pending[idx]
+			key := podKey(pod)
+
+			if !requested[key] {
+				err := d.client.evictPod(pod, gracePeriod)
+				switch {
+				case err == nil:
+					requested[key] = true
+				case kube_errors.IsNotFound(err):
+					evicted = append(evicted, key)
+					continue
+				case kube_errors.IsTooManyRequests(err):
+					// A disruption budget does not allow it yet
+					budgeted = append(budgeted, key)
+					remaining = append(remaining, *pod)
+					continue
+				default:
+					return errors.Wrapf(err, "failed to evict pod %s", key)
+				}
+			}
+
+			gone, err := d.client.podGone(pod)
+			if err != nil {
+				return errors.Wrapf(err, "failed to check on pod %s", key)
+			}
+			if gone {
+				evicted = append(evicted, key)
+			} else {
+				remaining = append(remaining, *pod)
+			}
+		}
+		pending = remaining
+
+		progress := &DrainProgress{
+			PendingPods: podKeys(pending),
+			EvictedPods: append([]string(nil), evicted...),
+			SkippedPods: skipped,
+			Done:        len(pending) == 0,
+		}
+		if len(budgeted) > 0 {
+			progress.Message = fmt.Sprintf("waiting for disruption budgets to allow evicting %s", strings.Join(budgeted, ", "))
+		} else if len(pending) > 0 {
+			progress.Message = fmt.Sprintf("waiting for %d pods to terminate", len(pending))
+		}
+
+		if last == nil || progress.Message != last.Message || len(progress.PendingPods) != len(last.PendingPods) {
+			if err := send(progress); err != nil {
+				return err
+			}
+			last = progress
+		}
+		if progress.Done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return status.Errorf(codes.DeadlineExceeded, "timed out after %s with pods left on node %s: %s",
+					timeout, req.Id, strings.Join(progress.PendingPods, ", "))
+			}
+			return status.Error(codes.Canceled, "drain was canceled")
+		case <-time.After(d.pollInterval):
+		}
+	}
+}
+
+// drainablePods sorts the pods of a node into those to be evicted, those
+// which are left alone, and those which stop the node from being drained
+// with the options of the request
+func drainablePods(pods []kube_v1.Pod, req *DrainNodeRequest) (evict []kube_v1.Pod, skipped []string, blocked []string) {
+	for _, pod := range pods {
+		key := podKey(&pod)
+
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			skipped = append(skipped, key)
+			continue
+		}
+
+		controller := podController(&pod)
+		if controller != nil && controller.Kind == "DaemonSet" {
+			// The daemon set controller would put it right back
+			skipped = append(skipped, key)
+			continue
+		}
+
+		if pod.Status.Phase == kube_v1.PodSucceeded || pod.Status.Phase == kube_v1.PodFailed {
+			evict = append(evict, pod)
+			continue
+		}
+
+		if controller == nil && !req.Force {
+			blocked = append(blocked, fmt.Sprintf("pod %s is not managed by a controller, and would be lost", key))
+			continue
+		}
+
+		if hasLocalData(&pod) && !req.DeleteLocalData {
+			blocked = append(blocked, fmt.Sprintf("pod %s has local data in an emptyDir volume", key))
+			continue
+		}
+
+		evict = append(evict, pod)
+	}
+	return evict, skipped, blocked
+}
+
+func podController(pod *kube_v1.Pod) *meta_v1.OwnerReference {
+	for idx, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return &pod.OwnerReferences[idx]
+		}
+	}
+	return nil
+}
+
+func hasLocalData(pod *kube_v1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func podKey(pod *kube_v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func podKeys(pods []kube_v1.Pod) []string {
+	keys := make([]string, 0, len(pods))
+	for idx := range pods {
+		keys = append(keys, podKey(&pods[idx]))
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeDrainClient is a node whose pods terminate as soon as they are
+// evicted, unless a disruption budget refuses the eviction
+type fakeDrainClient struct {
+	node         *kube_v1.Node
+	pods         map[string]kube_v1.Pod
+	refusals     map[string]int
+	stuck        map[string]bool
+	gracePeriods []*int64
+}
+
+func newFakeDrainClient(pods ...kube_v1.Pod) *fakeDrainClient {
+	c := &fakeDrainClient{
+		node:     &kube_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "a"}},
+		pods:     make(map[string]kube_v1.Pod),
+		refusals: make(map[string]int),
+		stuck:    make(map[string]bool),
+	}
+	for _, pod := range pods {
+		c.pods[podKey(&pod)] = pod
+	}
+	return c
+}
+
+func (c *fakeDrainClient) setUnschedulable(name string, unschedulable bool) (*kube_v1.Node, error) {
+	if name != c.node.Name {
+		return nil, kube_errors.NewNotFound(schema.GroupResource{Resource: "nodes"}, name)
+	}
+	c.node.Spec.Unschedulable = unschedulable
+	return c.node, nil
+}
+
+func (c *fakeDrainClient) podsOnNode(name string) ([]kube_v1.Pod, error) {
+	var pods []kube_v1.Pod
+	for _, pod := range c.pods {
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (c *fakeDrainClient) evictPod(pod *kube_v1.Pod, gracePeriod *int64) error {
+	key := podKey(pod)
+	if c.refusals[key] > 0 {
+		c.refusals[key]--
+		return kube_errors.NewTooManyRequests("disruption budget", 1)
+	}
+	c.gracePeriods = append(c.gracePeriods, gracePeriod)
+	if !c.stuck[key] {
+		delete(c.pods, key)
+	}
+	return nil
+}
+
+func (c *fakeDrainClient) podGone(pod *kube_v1.Pod) (bool, error) {
+	_, ok := c.pods[podKey(pod)]
+	return !ok, nil
+}
+
+func testPod(name, controllerKind string) kube_v1.Pod {
+	pod := kube_v1.Pod{ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name}}
+	if controllerKind != "" {
+		controller := true
+		pod.OwnerReferences = []meta_v1.OwnerReference{{Kind: controllerKind, Name: name, Controller: &controller}}
+	}
+	return pod
+}
+
+func drainWith(client *fakeDrainClient, req *DrainNodeRequest) ([]*DrainProgress, error) {
+	drainer := &nodeDrainer{client: client, pollInterval: 10 * time.Millisecond}
+
+	var progress []*DrainProgress
+	err := drainer.drain(context.Background(), req, func(p *DrainProgress) error {
+		progress = append(progress, p)
+		return nil
+	})
+	return progress, err
+}
+
+func TestDrainNode(t *testing.T) {
+	t.Run("ManagedPods_AreEvicted", func(t *testing.T) {
+		mirror := testPod("etcd", "")
+		mirror.Annotations = map[string]string{mirrorPodAnnotation: "x"}
+		client := newFakeDrainClient(testPod("web", "ReplicaSet"), testPod("agent", "DaemonSet"), mirror)
+
+		progress, err := drainWith(client, &DrainNodeRequest{Id: "a", GracePeriodSeconds: 10})
+		require.NoError(t, err)
+		require.True(t, client.node.Spec.Unschedulable)
+
+		last := progress[len(progress)-1]
+		require.True(t, last.Done)
+		require.Equal(t, []string{"default/web"}, last.EvictedPods)
+		require.Empty(t, last.PendingPods)
+		require.Len(t, last.SkippedPods, 2)
+		require.Equal(t, int64(10), *client.gracePeriods[0])
+	})
+
+	t.Run("UnmanagedPod_BlocksDrain", func(t *testing.T) {
+		client := newFakeDrainClient(testPod("web", "ReplicaSet"), testPod("debug", ""))
+
+		_, err := drainWith(client, &DrainNodeRequest{Id: "a"})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+		require.Contains(t, err.Error(), "default/debug")
+		require.True(t, client.node.Spec.Unschedulable)
+		require.Len(t, client.pods, 2)
+	})
+
+	t.Run("UnmanagedPod_IsEvictedWithForce", func(t *testing.T) {
+		client := newFakeDrainClient(testPod("debug", ""))
+
+		_, err := drainWith(client, &DrainNodeRequest{Id: "a", Force: true})
+		require.NoError(t, err)
+		require.Empty(t, client.pods)
+	})
+
+	t.Run("LocalData_BlocksDrain", func(t *testing.T) {
+		pod := testPod("cache", "ReplicaSet")
+		pod.Spec.Volumes = []kube_v1.Volume{{
+			Name:         "scratch",
+			VolumeSource: kube_v1.VolumeSource{EmptyDir: &kube_v1.EmptyDirVolumeSource{}},
+		}}
+
+		_, err := drainWith(newFakeDrainClient(pod), &DrainNodeRequest{Id: "a"})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+
+		_, err = drainWith(newFakeDrainClient(pod), &DrainNodeRequest{Id: "a", DeleteLocalData: true})
+		require.NoError(t, err)
+	})
+
+	t.Run("DisruptionBudget_IsWaitedFor", func(t *testing.T) {
+		client := newFakeDrainClient(testPod("db", "StatefulSet"))
+		client.refusals["default/db"] = 2
+
+		progress, err := drainWith(client, &DrainNodeRequest{Id: "a"})
+		require.NoError(t, err)
+		require.Len(t, progress, 2)
+		require.Contains(t, progress[0].Message, "disruption budgets")
+		require.Equal(t, []string{"default/db"}, progress[0].PendingPods)
+		require.True(t, progress[1].Done)
+	})
+
+	t.Run("StuckPod_TimesOut", func(t *testing.T) {
+		client := newFakeDrainClient(testPod("db", "StatefulSet"))
+		client.stuck["default/db"] = true
+
+		start := time.Now()
+		progress, err := drainWith(client, &DrainNodeRequest{Id: "a", TimeoutSeconds: 1})
+		require.Equal(t, codes.DeadlineExceeded, grpc.Code(err))
+		require.Contains(t, err.Error(), "default/db")
+		require.True(t, time.Since(start) >= time.Second)
+		// The eviction is only requested once
+		require.Len(t, client.gracePeriods, 1)
+		require.Len(t, progress, 1)
+		require.Equal(t, "waiting for 1 pods to terminate", progress[0].Message)
+	})
+
+	t.Run("UnknownNode_IsNotFound", func(t *testing.T) {
+		_, err := drainWith(newFakeDrainClient(), &DrainNodeRequest{Id: "b"})
+		require.Equal(t, codes.NotFound, grpc.Code(err))
+	})
+}
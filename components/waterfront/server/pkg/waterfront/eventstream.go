@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventStreamKeepAlive is how often an idle event stream sends a comment,
+// so that proxies do not time it out
+const eventStreamKeepAlive = 30 * time.Second
+
+// EventStream serves the server-streaming RPCs of grpc-gateway, which are
+// streamed as newline-delimited JSON, as server-sent events to clients which
+// accept text/event-stream. Each result becomes a message event, and a
+// failure an error event.
+func EventStream(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		stream := &eventStreamWriter{ResponseWriter: w, flusher: flusher}
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			stream.keepAlive(stop, eventStreamKeepAlive)
+			close(done)
+		}()
+		// The response must not be written to once the handler returns
+		defer func() {
+			close(stop)
+			<-done
+		}()
+
+		handler.ServeHTTP(stream, r)
+	})
+}
+
+type eventStreamWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+
+	lock        sync.Mutex
+	wroteHeader bool
+	pending     []byte
+}
+
+func (s *eventStreamWriter) WriteHeader(status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.writeHeader(status)
+}
+
+func (s *eventStreamWriter) writeHeader(status int) {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+
+	header := s.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Del("Content-Length")
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write turns each complete line written into an event
+func (s *eventStreamWriter) Write(data []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.writeHeader(http.StatusOK)
+	s.pending = append(s.pending, data...)
+	for {
+		end := bytes.IndexByte(s.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := s.pending[:end]
+		s.pending = s.pending[end+1:]
+
+		if err := s.writeEvent(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (s *eventStreamWriter) writeEvent(line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	var chunk struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	var event bytes.Buffer
+	data := line
+	if err := json.Unmarshal(line, &chunk); err == nil {
+		if chunk.Result != nil {
+			data = chunk.Result
+		} else if chunk.Error != nil {
+			event.WriteString("event: error\n")
+			data = chunk.Error
+		}
+	}
+
+	event.WriteString("data: ")
+	event.Write(data)
+	event.WriteString("\n\n")
+	_, err := s.ResponseWriter.Write(event.Bytes())
+	return err
+}
+
+func (s *eventStreamWriter) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.flusher.Flush()
+}
+
+func (s *eventStreamWriter) CloseNotify() <-chan bool {
+	if notifier, ok := s.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (s *eventStreamWriter) keepAlive(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			s.writeHeader(http.StatusOK)
+			_, err := s.ResponseWriter.Write([]byte(": keep-alive\n\n"))
+			if err == nil {
+				s.flusher.Flush()
+			}
+			s.lock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	// Written the way grpc-gateway streams responses
+	handler := EventStream(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"result":{"type":"ADDED","node":{"id":"a"}}}`))
+		w.Write([]byte("\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`{"result":{"type":"DELETED",`))
+		w.Write([]byte(`"node":{"id":"a"}}}` + "\n"))
+		w.Write([]byte(`{"error":{"grpc_code":14,"message":"gone"}}` + "\n"))
+	}))
+
+	t.Run("EventStreamClient_GetsEvents", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/watch/nodes", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+		require.True(t, rec.Flushed)
+		require.Equal(t,
+			`data: {"type":"ADDED","node":{"id":"a"}}`+"\n\n"+
+				`data: {"type":"DELETED","node":{"id":"a"}}`+"\n\n"+
+				"event: error\n"+`data: {"grpc_code":14,"message":"gone"}`+"\n\n",
+			rec.Body.String())
+	})
+
+	t.Run("OtherClient_GetsJSON", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/watch/nodes", nil))
+
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.Contains(t, rec.Body.String(), `{"result":{"type":"ADDED"`)
+	})
+}
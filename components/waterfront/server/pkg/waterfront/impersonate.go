@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+const (
+	// kubeGroupPrefix is prepended to the waterfront roles of a user to
+	// name the Kubernetes groups they are impersonated with
+	kubeGroupPrefix = "operos:"
+
+	// kubeAuthenticatedGroup is the group of every authenticated user, which
+	// impersonation does not add by itself
+	kubeAuthenticatedGroup = "system:authenticated"
+)
+
+// KubeGroups returns the Kubernetes groups a user is impersonated with: one
+// for each of their waterfront roles, such as operos:admin
+func KubeGroups(user *User) []string {
+	groups := []string{kubeAuthenticatedGroup}
+	for _, role := range user.Roles {
+		groups = append(groups, kubeGroupPrefix+string(role))
+	}
+	sort.Strings(groups[1:])
+	return groups
+}
+
+// ImpersonatedConfig returns a copy of config which makes requests to the
+// API server as user, so that Kubernetes authorizes and audits them as the
+// person logged in to waterfront rather than as waterfront itself
+func ImpersonatedConfig(config *rest.Config, user *User) *rest.Config {
+	impersonated := *config
+	impersonated.Impersonate = rest.ImpersonationConfig{
+		UserName: user.Username,
+		Groups:   KubeGroups(user),
+	}
+	return &impersonated
+}
+
+// userKubeClient returns a client which acts as the user of a gRPC call
+func (w *WaterfrontAPI) userKubeClient(ctx context.Context) (*kubernetes.Clientset, error) {
+	user := GetUser(ctx)
+	if user == nil {
+		return nil, status.Error(codes.Unauthenticated, "no user to act as in Kubernetes")
+	}
+	client, err := kubernetes.NewForConfig(ImpersonatedConfig(w.kubeConfig, user))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kube client")
+	}
+	return client, nil
+}
+
+// setImpersonationHeaders replaces any impersonation and credentials in the
+// headers of a request with those of user
+func setImpersonationHeaders(header http.Header, user *User) {
+	header.Del("Authorization")
+	for name := range header {
+		if strings.HasPrefix(name, transport.ImpersonateUserExtraHeaderPrefix) {
+			header.Del(name)
+		}
+	}
+	header.Set(transport.ImpersonateUserHeader, user.Username)
+	header.Del(transport.ImpersonateGroupHeader)
+	for _, group := range KubeGroups(user) {
+		header.Add(transport.ImpersonateGroupHeader, group)
+	}
+}
+
+// ImpersonatingProxy proxies requests to the kube-dashboard as the logged in
+// user. The dashboard passes the impersonation headers on to the API server,
+// which needs to allow its service account to impersonate.
+func ImpersonatingProxy(target *url.URL) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		setImpersonationHeaders(r.Header, GetUser(r.Context()))
+	}
+	return proxy
+}
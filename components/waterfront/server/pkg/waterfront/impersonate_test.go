@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestImpersonation(t *testing.T) {
+	var kubeReq *http.Request
+	kubeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kubeReq = r
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind": "NodeList", "apiVersion": "v1", "items": []}`))
+	}))
+	defer kubeServer.Close()
+	user := &User{Username: "jane", Roles: []Role{RoleOperator}}
+
+	t.Run("KubeClient_AsUser", func(t *testing.T) {
+		api := &WaterfrontAPI{kubeConfig: &rest.Config{Host: kubeServer.URL}}
+		client, err := api.userKubeClient(context.WithValue(context.Background(), ContextKeyuser, user))
+		require.NoError(t, err)
+		_, err = client.Nodes().List(meta_v1.ListOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "jane", kubeReq.Header.Get("Impersonate-User"))
+		require.Equal(t, []string{"system:authenticated", "operos:operator"}, kubeReq.Header["Impersonate-Group"])
+	})
+
+	t.Run("KubeClient_NoUser", func(t *testing.T) {
+		api := &WaterfrontAPI{kubeConfig: &rest.Config{Host: kubeServer.URL}}
+		_, err := api.userKubeClient(context.Background())
+		require.Equal(t, codes.Unauthenticated, grpc.Code(err))
+	})
+
+	t.Run("Proxy_ReplacesClientHeaders", func(t *testing.T) {
+		target, err := url.Parse(kubeServer.URL)
+		require.NoError(t, err)
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ImpersonatingProxy(target).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextKeyuser, user)))
+		}))
+		defer proxy.Close()
+
+		req, err := http.NewRequest("GET", proxy.URL+"/api/v1/nodes", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer waterfront-token")
+		req.Header.Set("Impersonate-User", "root")
+		req.Header.Add("Impersonate-Group", "operos:admin")
+		req.Header.Set("Impersonate-Extra-Scopes", "all")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Empty(t, kubeReq.Header.Get("Authorization"))
+		require.Empty(t, kubeReq.Header.Get("Impersonate-Extra-Scopes"))
+		require.Equal(t, "jane", kubeReq.Header.Get("Impersonate-User"))
+		require.Equal(t, []string{"system:authenticated", "operos:operator"}, kubeReq.Header["Impersonate-Group"])
+	})
+}
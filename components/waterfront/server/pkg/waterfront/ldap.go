@@ -0,0 +1,231 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	ldap "gopkg.in/ldap.v2"
+)
+
+// LDAPConfig describes how users and their groups are found in a directory
+type LDAPConfig struct {
+	// URL of the server, ldap://host[:port] or ldaps://host[:port]
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// CAFile holds the CA certificates used to verify the server. The
+	// system roots are used if empty.
+	CAFile string
+
+	// BindDN and BindPassword are used to search for users. If empty, the
+	// search is anonymous.
+	BindDN       string
+	BindPassword string
+
+	// UserBaseDN is searched for an entry matching UserFilter, where %s is
+	// replaced with the escaped username
+	UserBaseDN string
+	UserFilter string
+
+	// GroupBaseDN is searched for entries matching GroupFilter, where %s is
+	// replaced with the escaped DN of the user. The GroupNameAttribute of
+	// each entry is the name of the group.
+	GroupBaseDN        string
+	GroupFilter        string
+	GroupNameAttribute string
+
+	Timeout time.Duration
+}
+
+// LDAPAuthenticator authenticates users by binding to an LDAP server as them,
+// and reports the groups they are members of
+type LDAPAuthenticator struct {
+	config    LDAPConfig
+	address   string
+	tlsConfig *tls.Config
+	useTLS    bool
+}
+
+func NewLDAPAuthenticator(config LDAPConfig) (*LDAPAuthenticator, error) {
+	if config.UserBaseDN == "" {
+		return nil, errors.New("LDAP user base DN is required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.UserBaseDN
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = "(member=%s)"
+	}
+	if config.GroupNameAttribute == "" {
+		config.GroupNameAttribute = "cn"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	serverURL, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid LDAP URL")
+	}
+
+	a := &LDAPAuthenticator{
+		config:  config,
+		address: serverURL.Host,
+	}
+
+	switch serverURL.Scheme {
+	case "ldap":
+		if serverURL.Port() == "" {
+			a.address = net.JoinHostPort(serverURL.Host, "389")
+		}
+	case "ldaps":
+		if serverURL.Port() == "" {
+			a.address = net.JoinHostPort(serverURL.Host, "636")
+		}
+		a.useTLS = true
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL scheme %q", serverURL.Scheme)
+	}
+
+	if a.useTLS || config.StartTLS {
+		a.tlsConfig = &tls.Config{ServerName: serverURL.Hostname()}
+		if config.CAFile != "" {
+			pem, err := ioutil.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read LDAP CA file")
+			}
+			a.tlsConfig.RootCAs = x509.NewCertPool()
+			if !a.tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+func (a *LDAPAuthenticator) connect() (*ldap.Conn, error) {
+	var conn *ldap.Conn
+	var err error
+
+	if a.useTLS {
+		conn, err = ldap.DialTLS("tcp", a.address, a.tlsConfig)
+	} else {
+		conn, err = ldap.Dial("tcp", a.address)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to LDAP server %s", a.address)
+	}
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.StartTLS && !a.useTLS {
+		if err := conn.StartTLS(a.tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "LDAP StartTLS failed")
+		}
+	}
+
+	return conn, nil
+}
+
+// bindSearcher binds as the configured search user, if any
+func (a *LDAPAuthenticator) bindSearcher(conn *ldap.Conn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	return errors.Wrap(conn.Bind(a.config.BindDN, a.config.BindPassword), "LDAP search bind failed")
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (bool, error) {
+	ok, _, err := a.AuthenticateGroups(username, password)
+	return ok, err
+}
+
+// AuthenticateGroups checks the password of a user and returns the names of
+// the groups they are a member of
+func (a *LDAPAuthenticator) AuthenticateGroups(username, password string) (bool, []string, error) {
+	// Most servers treat a bind with an empty password as an anonymous bind,
+	// which succeeds
+	if username == "" || password == "" {
+		return false, nil, nil
+	}
+
+	conn, err := a.connect()
+	if err != nil {
+		return false, nil, err
+	}
+	defer conn.Close()
+
+	if err := a.bindSearcher(conn); err != nil {
+		return false, nil, err
+	}
+
+	users, err := conn.Search(ldap.NewSearchRequest(
+		a.config.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn"}, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false, nil, errors.Wrap(err, "LDAP user search failed")
+	}
+	if users == nil || len(users.Entries) != 1 {
+		log.Infof("LDAP user %s not found or not unique", username)
+		return false, nil, nil
+	}
+	userDN := users.Entries[0].DN
+
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return false, nil, nil
+		}
+		return false, nil, errors.Wrap(err, "LDAP bind failed")
+	}
+
+	// Directories often only let the search user list groups
+	if err := a.bindSearcher(conn); err != nil {
+		return false, nil, err
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{a.config.GroupNameAttribute}, nil))
+	if err != nil {
+		return false, nil, errors.Wrap(err, "LDAP group search failed")
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		for _, name := range entry.GetAttributeValues(a.config.GroupNameAttribute) {
+			groups = append(groups, strings.TrimSpace(name))
+		}
+	}
+
+	return true, groups, nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	ber "gopkg.in/asn1-ber.v1"
+	ldap "gopkg.in/ldap.v2"
+)
+
+type testLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer answers simple binds and equality searches over a fixed set
+// of entries
+type testLDAPServer struct {
+	listener net.Listener
+	entries  []*testLDAPEntry
+}
+
+func newTestLDAPServer(t *testing.T, entries []*testLDAPEntry) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testLDAPServer{listener: listener, entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *testLDAPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+
+			code := ldap.LDAPResultInvalidCredentials
+			for _, entry := range s.entries {
+				if entry.dn == dn && entry.password != "" && entry.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			responses = append(responses, testLDAPResult(ldap.ApplicationBindResponse, code))
+
+		case ldap.ApplicationSearchRequest:
+			baseDN := op.Children[0].Value.(string)
+			filter := op.Children[6]
+			if filter.Tag != ldap.FilterEqualityMatch {
+				responses = append(responses, testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultUnwillingToPerform))
+				break
+			}
+			attr := filter.Children[0].Value.(string)
+			value := filter.Children[1].Value.(string)
+
+			for _, entry := range s.entries {
+				if !strings.HasSuffix(entry.dn, baseDN) || !testContains(entry.attrs[attr], value) {
+					continue
+				}
+				responses = append(responses, testLDAPSearchEntry(entry))
+			}
+			responses = append(responses, testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			message.AppendChild(response)
+			if _, err := conn.Write(message.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func testContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func testLDAPResult(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return result
+}
+
+func testLDAPSearchEntry(entry *testLDAPEntry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+
+	attrs := ber.NewSequence("attributes")
+	for name, values := range entry.attrs {
+		attr := ber.NewSequence("attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	result.AppendChild(attrs)
+	return result
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	const (
+		searchDN = "cn=waterfront,ou=services,dc=example,dc=org"
+		aliceDN  = "uid=alice,ou=people,dc=example,dc=org"
+		bobDN    = "uid=bob,ou=people,dc=example,dc=org"
+	)
+
+	server := newTestLDAPServer(t, []*testLDAPEntry{
+		{dn: searchDN, password: "search-secret"},
+		{dn: aliceDN, password: "alice-secret", attrs: map[string][]string{"uid": {"alice"}}},
+		{dn: bobDN, password: "bob-secret", attrs: map[string][]string{"uid": {"bob"}}},
+		{dn: "cn=sre,ou=groups,dc=example,dc=org", attrs: map[string][]string{"cn": {"sre"}, "member": {aliceDN}}},
+		{dn: "cn=dev,ou=groups,dc=example,dc=org", attrs: map[string][]string{"cn": {"dev"}, "member": {aliceDN, bobDN}}},
+	})
+	defer server.Close()
+
+	authenticator, err := NewLDAPAuthenticator(LDAPConfig{
+		URL:          server.URL(),
+		BindDN:       searchDN,
+		BindPassword: "search-secret",
+		UserBaseDN:   "ou=people,dc=example,dc=org",
+		GroupBaseDN:  "ou=groups,dc=example,dc=org",
+	})
+	require.NoError(t, err)
+
+	t.Run("ValidPassword_ReturnsGroups", func(t *testing.T) {
+		ok, groups, err := authenticator.AuthenticateGroups("alice", "alice-secret")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []string{"sre", "dev"}, groups)
+
+		ok, groups, err = authenticator.AuthenticateGroups("bob", "bob-secret")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, []string{"dev"}, groups)
+	})
+
+	t.Run("WrongPassword_IsRejected", func(t *testing.T) {
+		ok, err := authenticator.Authenticate("alice", "bob-secret")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("EmptyPassword_IsRejected", func(t *testing.T) {
+		ok, err := authenticator.Authenticate("alice", "")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("UnknownUser_IsRejected", func(t *testing.T) {
+		ok, err := authenticator.Authenticate("mallory", "alice-secret")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("FilterInjection_IsEscaped", func(t *testing.T) {
+		ok, err := authenticator.Authenticate("*", "alice-secret")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("WrongSearchPassword_IsAnError", func(t *testing.T) {
+		broken, err := NewLDAPAuthenticator(LDAPConfig{
+			URL:          server.URL(),
+			BindDN:       searchDN,
+			BindPassword: "wrong",
+			UserBaseDN:   "ou=people,dc=example,dc=org",
+		})
+		require.NoError(t, err)
+
+		_, err = broken.Authenticate("alice", "alice-secret")
+		require.Error(t, err)
+	})
+}
+
+func TestNewLDAPAuthenticator(t *testing.T) {
+	_, err := NewLDAPAuthenticator(LDAPConfig{URL: "http://example.org", UserBaseDN: "dc=example,dc=org"})
+	require.Error(t, err)
+
+	_, err = NewLDAPAuthenticator(LDAPConfig{URL: "ldap://example.org"})
+	require.Error(t, err)
+
+	a, err := NewLDAPAuthenticator(LDAPConfig{URL: "ldaps://example.org", UserBaseDN: "dc=example,dc=org"})
+	require.NoError(t, err)
+	require.Equal(t, "example.org:636", a.address)
+	require.True(t, a.useTLS)
+}
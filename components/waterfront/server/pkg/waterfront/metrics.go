@@ -0,0 +1,549 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Prometheus itself refuses range queries of more points than this
+	maxMetricsPoints = 11000
+	maxMetricsRange  = 7 * 24 * time.Hour
+)
+
+// MetricsLimits bound the Prometheus queries users may make
+type MetricsLimits struct {
+	// MaxRange is the longest time span a query may cover, both between its
+	// start and end, and in the range selectors and offsets of its PromQL
+	MaxRange time.Duration
+	// MaxPoints is the most samples of each series a range query may return
+	MaxPoints   int
+	MaxQueryLen int
+	// Timeout is how long Prometheus may spend on each query
+	Timeout time.Duration
+}
+
+// durationRe matches the range selectors and offsets of PromQL
+var durationRe = regexp.MustCompile(`(\[\s*|(?i:offset)\s+)(\d+)([smhdwy])`)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// nodeNameRe matches the names of Kubernetes nodes
+var nodeNameRe = regexp.MustCompile(`^[a-z0-9]([-.a-z0-9]*[a-z0-9])?$`)
+
+var promDurationUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// checkQuery refuses PromQL which looks further back than MaxRange
+func (l *MetricsLimits) checkQuery(query string) error {
+	if query == "" {
+		return errors.New("query is required")
+	}
+	if len(query) > l.MaxQueryLen {
+		return errors.Errorf("query must be at most %d characters", l.MaxQueryLen)
+	}
+	for _, match := range durationRe.FindAllStringSubmatch(query, -1) {
+		n, err := strconv.ParseInt(match[2], 10, 64)
+		if err != nil || time.Duration(n) > l.MaxRange/promDurationUnits[match[3]] {
+			return errors.Errorf("query may look back at most %s", l.MaxRange)
+		}
+	}
+	return nil
+}
+
+// checkRange refuses time spans longer than MaxRange
+func (l *MetricsLimits) checkRange(start, end time.Time) error {
+	if end.Before(start) {
+		return errors.New("end must not be before start")
+	}
+	if end.Sub(start) > l.MaxRange {
+		return errors.Errorf("time range must be at most %s", l.MaxRange)
+	}
+	return nil
+}
+
+// checkStep refuses range queries of too many points
+func (l *MetricsLimits) checkStep(start, end time.Time, step time.Duration) error {
+	if step <= 0 {
+		return errors.New("step must be positive")
+	}
+	if end.Sub(start)/step > time.Duration(l.MaxPoints) {
+		return errors.Errorf("step is too small; at most %d points may be returned", l.MaxPoints)
+	}
+	return nil
+}
+
+// parsePromTime reads a time as Prometheus does, in seconds since the epoch
+// or RFC 3339
+func parsePromTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("invalid time %q", value)
+}
+
+// parsePromStep reads a step in seconds, or as a duration like 5m
+func parsePromStep(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+	return 0, errors.Errorf("invalid step %q", value)
+}
+
+func formatPromTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+// timeParams reads the start and end parameters of a request. Missing ends
+// are now, and missing starts are MaxRange before the end.
+func (l *MetricsLimits) timeParams(params url.Values, now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if value := params.Get("end"); value != "" {
+		var err error
+		if end, err = parsePromTime(value); err != nil {
+			return end, end, err
+		}
+	}
+	start := end.Add(-l.MaxRange)
+	if value := params.Get("start"); value != "" {
+		var err error
+		if start, err = parsePromTime(value); err != nil {
+			return start, end, err
+		}
+	}
+	return start, end, l.checkRange(start, end)
+}
+
+// metricsEndpoint tells whether a path of the Prometheus API may be proxied
+func metricsEndpoint(endpoint string) bool {
+	switch endpoint {
+	case "query", "query_range", "series", "labels":
+		return true
+	}
+	parts := strings.Split(endpoint, "/")
+	return len(parts) == 3 && parts[0] == "label" && labelNameRe.MatchString(parts[1]) && parts[2] == "values"
+}
+
+// params checks the parameters of a request to a Prometheus endpoint, and
+// returns those which are passed on. Parameters the endpoint does not know
+// are dropped.
+func (l *MetricsLimits) params(endpoint string, params url.Values, now time.Time) (url.Values, error) {
+	timeout := strconv.FormatFloat(l.Timeout.Seconds(), 'f', -1, 64) + "s"
+
+	switch endpoint {
+	case "query":
+		query := params.Get("query")
+		if err := l.checkQuery(query); err != nil {
+			return nil, err
+		}
+		out := url.Values{"query": {query}, "timeout": {timeout}}
+		if value := params.Get("time"); value != "" {
+			t, err := parsePromTime(value)
+			if err != nil {
+				return nil, err
+			}
+			out.Set("time", formatPromTime(t))
+		}
+		return out, nil
+
+	case "query_range":
+		query := params.Get("query")
+		if err := l.checkQuery(query); err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"start", "end", "step"} {
+			if params.Get(name) == "" {
+				return nil, errors.Errorf("%s is required", name)
+			}
+		}
+		start, end, err := l.timeParams(params, now)
+		if err != nil {
+			return nil, err
+		}
+		step, err := parsePromStep(params.Get("step"))
+		if err != nil {
+			return nil, err
+		}
+		if err := l.checkStep(start, end, step); err != nil {
+			return nil, err
+		}
+		return url.Values{
+			"query":   {query},
+			"start":   {formatPromTime(start)},
+			"end":     {formatPromTime(end)},
+			"step":    {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+			"timeout": {timeout},
+		}, nil
+
+	case "series":
+		matches := params["match[]"]
+		if len(matches) == 0 {
+			return nil, errors.New("match[] is required")
+		}
+		for _, match := range matches {
+			if err := l.checkQuery(match); err != nil {
+				return nil, err
+			}
+		}
+		// Without a start, Prometheus would search all of its data
+		start, end, err := l.timeParams(params, now)
+		if err != nil {
+			return nil, err
+		}
+		return url.Values{
+			"match[]": matches,
+			"start":   {formatPromTime(start)},
+			"end":     {formatPromTime(end)},
+		}, nil
+
+	default:
+		// Label names and values take no parameters
+		return url.Values{}, nil
+	}
+}
+
+// MetricsProxy passes read-only queries on to the Prometheus API, within
+// limits on how much each user may ask of it. The admin API, and anything
+// else which is not a query, is not reachable through it.
+type MetricsProxy struct {
+	Limits   MetricsLimits
+	Throttle *RateLimiter
+
+	target *url.URL
+	proxy  *httputil.ReverseProxy
+	now    func() time.Time
+}
+
+// NewMetricsProxy proxies to the Prometheus API at prometheusURL, such as
+// http://prometheus:9090/api/v1. Requests are expected to have the proxy's
+// prefix stripped from their path, so that they are relative to the API.
+func NewMetricsProxy(prometheusURL *url.URL) *MetricsProxy {
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// Prometheus has no use for the user's credentials
+			r.Header.Del("Cookie")
+			r.Header.Del("Authorization")
+			r.Host = r.URL.Host
+		},
+		ModifyResponse: func(res *http.Response) error {
+			// Remove the Prometheus CORS stuff since we're gonna apply our own
+			res.Header.Del("Access-Control-Allow-Origin")
+			res.Header.Del("Access-Control-Allow-Credentials")
+			return nil
+		},
+	}
+
+	return &MetricsProxy{
+		Limits: MetricsLimits{
+			MaxRange:    maxMetricsRange,
+			MaxPoints:   maxMetricsPoints,
+			MaxQueryLen: 4096,
+			Timeout:     30 * time.Second,
+		},
+		Throttle: NewRateLimiter(5, 60),
+		target:   prometheusURL,
+		proxy:    proxy,
+		now:      time.Now,
+	}
+}
+
+func (p *MetricsProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		writeForbidden(w, "not logged in")
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	if !metricsEndpoint(endpoint) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("%s is not available", endpoint))
+		return
+	}
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+
+	if wait := p.Throttle.Allow(user.Username); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "too many metrics queries, try again later")
+		return
+	}
+
+	params, err := p.Limits.params(endpoint, r.URL.Query(), p.now())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	target := *p.target
+	target.Path = path.Join(target.Path, endpoint)
+	target.RawQuery = params.Encode()
+
+	out := new(http.Request)
+	*out = *r
+	out.URL = &target
+	out.Body = nil
+	out.ContentLength = 0
+	p.proxy.ServeHTTP(w, out)
+}
+
+// MetricsSource runs range queries. Prometheus is the source in production;
+// tests use fixtures.
+type MetricsSource interface {
+	// QueryRange returns the series of a query, labelled by the node they
+	// were scraped from
+	QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]*MetricSeries, error)
+}
+
+type prometheusSource struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// NewPrometheusSource queries the Prometheus API at prometheusURL
+func NewPrometheusSource(prometheusURL *url.URL) MetricsSource {
+	return prometheusSource{
+		url:    prometheusURL,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// The parts of a Prometheus API response which range queries use
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			// Pairs of the time in seconds, and the value as a string
+			Values [][2]interface{} `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func (s prometheusSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]*MetricSeries, error) {
+	target := *s.url
+	target.Path = path.Join(target.Path, "query_range")
+	target.RawQuery = url.Values{
+		"query": {query},
+		"start": {formatPromTime(start)},
+		"end":   {formatPromTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	}.Encode()
+
+	req, err := http.NewRequest("GET", target.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query Prometheus")
+	}
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query Prometheus")
+	}
+	defer res.Body.Close()
+
+	var resp promResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode Prometheus response (%s)", res.Status)
+	}
+	if resp.Status != "success" {
+		return nil, errors.Errorf("Prometheus query failed: %s", resp.Error)
+	}
+	if resp.Data.ResultType != "matrix" {
+		return nil, errors.Errorf("Prometheus returned a %s, not a matrix", resp.Data.ResultType)
+	}
+
+	series := make([]*MetricSeries, len(resp.Data.Result))
+	for i, result := range resp.Data.Result {
+		series[i] = &MetricSeries{
+			Node:    result.Metric["instance"],
+			Samples: make([]*MetricSample, 0, len(result.Values)),
+		}
+		for _, pair := range result.Values {
+			t, _ := pair[0].(float64)
+			text, _ := pair[1].(string)
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, errors.Errorf("Prometheus returned invalid value %q", text)
+			}
+			series[i].Samples = append(series[i].Samples, &MetricSample{Time: int64(t), Value: value})
+		}
+	}
+	return series, nil
+}
+
+// nodeExporter selects the samples scraped by the node exporter. $node is
+// replaced by a matcher for the node being queried.
+const nodeExporter = `job="kubernetes-nodes-node-exporter"$node`
+
+// metricQuery is the PromQL of a named metric
+type metricQuery struct {
+	unit string
+	// expr gives one series for each node, unless cluster is set
+	expr    string
+	cluster bool
+}
+
+var metricQueries = map[Metric]metricQuery{
+	Metric_CPU_USAGE: {"cores",
+		`sum(rate(node_cpu{mode!="idle",` + nodeExporter + `}[5m])) by (instance)`, false},
+	Metric_CPU_CAPACITY: {"cores",
+		`count(node_cpu{mode="idle",` + nodeExporter + `}) by (instance)`, false},
+	Metric_MEMORY_USAGE: {"bytes",
+		`sum(node_memory_MemTotal{` + nodeExporter + `} - node_memory_MemAvailable{` + nodeExporter + `}) by (instance)`, false},
+	Metric_MEMORY_CAPACITY: {"bytes",
+		`sum(node_memory_MemTotal{` + nodeExporter + `}) by (instance)`, false},
+	// Filesystems are counted once for each disk partition, however many
+	// times they are mounted
+	Metric_DISK_USAGE: {"bytes",
+		`sum(max(node_filesystem_size{device=~"/dev/.d[a-z][0-9]*",` + nodeExporter + `} - node_filesystem_avail{device=~"/dev/.d[a-z][0-9]*",` + nodeExporter + `}) by (instance, device)) by (instance)`, false},
+	Metric_DISK_CAPACITY: {"bytes",
+		`sum(max(node_filesystem_size{device=~"/dev/.d[a-z][0-9]*",` + nodeExporter + `}) by (instance, device)) by (instance)`, false},
+	Metric_NETWORK_RECEIVE: {"bytes/s",
+		`sum(rate(node_network_receive_bytes{device=~"(eth|en).*",` + nodeExporter + `}[5m])) by (instance)`, false},
+	Metric_NETWORK_TRANSMIT: {"bytes/s",
+		`sum(rate(node_network_transmit_bytes{device=~"(eth|en).*",` + nodeExporter + `}[5m])) by (instance)`, false},
+	// Reported by the Prometheus module of the Ceph manager
+	Metric_CEPH_USAGE:    {"bytes", `sum(ceph_cluster_total_used_bytes)`, true},
+	Metric_CEPH_CAPACITY: {"bytes", `sum(ceph_cluster_total_bytes)`, true},
+}
+
+// metricsRange reads the time range of a metrics request
+func metricsRange(req *MetricsRequest, now time.Time) (time.Time, time.Time, time.Duration, error) {
+	end := now
+	if req.End != 0 {
+		end = time.Unix(req.End, 0)
+	}
+	start := end.Add(-time.Hour)
+	if req.Start != 0 {
+		start = time.Unix(req.Start, 0)
+	}
+	step := time.Minute
+	if req.Step != 0 {
+		step = time.Duration(req.Step) * time.Second
+	}
+
+	limits := MetricsLimits{MaxRange: maxMetricsRange, MaxPoints: maxMetricsPoints}
+	if err := limits.checkRange(start, end); err != nil {
+		return start, end, step, err
+	}
+	return start, end, step, limits.checkStep(start, end, step)
+}
+
+// queryMetric runs the query of a named metric, for one node or, if node is
+// empty, for all of them
+func (w *WaterfrontAPI) queryMetric(ctx context.Context, req *MetricsRequest, node string) (*MetricsResponse, error) {
+	query, ok := metricQueries[req.Metric]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric %s", req.Metric)
+	}
+	if node != "" && query.cluster {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is only reported for the cluster", req.Metric)
+	}
+
+	start, end, step, err := metricsRange(req, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	matcher := ""
+	if node != "" {
+		matcher = fmt.Sprintf(`,instance=%q`, node)
+	}
+	expr := strings.Replace(query.expr, "$node", matcher, -1)
+	if req.Total && !query.cluster {
+		expr = fmt.Sprintf("sum(%s)", expr)
+	}
+
+	series, err := w.metricsSource.QueryRange(ctx, expr, start, end, step)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", req.Metric)
+	}
+	if req.Total || query.cluster {
+		for _, s := range series {
+			s.Node = ""
+		}
+	}
+	return &MetricsResponse{Metric: req.Metric, Unit: query.unit, Series: series}, nil
+}
+
+// throttleMetrics takes a query from the allowance of the user of a call
+func (w *WaterfrontAPI) throttleMetrics(ctx context.Context) error {
+	user := GetUser(ctx)
+	if user == nil {
+		return status.Error(codes.Unauthenticated, "not logged in")
+	}
+	if wait := w.MetricsThrottle.Allow(user.Username); wait > 0 {
+		return status.Error(codes.ResourceExhausted, "too many metrics queries, try again later")
+	}
+	return nil
+}
+
+// GetNodeMetrics returns a named metric of one node
+func (w *WaterfrontAPI) GetNodeMetrics(ctx context.Context, req *MetricsRequest) (*MetricsResponse, error) {
+	if err := w.throttleMetrics(ctx); err != nil {
+		return nil, err
+	}
+	name, err := w.kubeNodeName(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	// The name ends up in PromQL, so it must be a plain hostname
+	if !nodeNameRe.MatchString(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid node %q", req.Id)
+	}
+	return w.queryMetric(ctx, req, name)
+}
+
+// GetClusterMetrics returns a named metric of every node, or of the cluster
+// as a whole
+func (w *WaterfrontAPI) GetClusterMetrics(ctx context.Context, req *MetricsRequest) (*MetricsResponse, error) {
+	if err := w.throttleMetrics(ctx); err != nil {
+		return nil, err
+	}
+	return w.queryMetric(ctx, req, "")
+}
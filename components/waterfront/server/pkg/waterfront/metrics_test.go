@@ -0,0 +1,356 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+func TestMetricsProxy(t *testing.T) {
+	var received []*http.Request
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer prometheus.Close()
+
+	prometheusURL, err := url.Parse(prometheus.URL + "/api/v1")
+	require.NoError(t, err)
+	now := time.Unix(1525176000, 0)
+
+	newProxy := func() http.Handler {
+		proxy := NewMetricsProxy(prometheusURL)
+		proxy.now = func() time.Time { return now }
+		return http.StripPrefix("/api/v1/metrics/", proxy)
+	}
+	alice := &User{Username: "alice", Roles: []Role{RoleViewer}}
+	serve := func(proxy http.Handler, method, target string) *httptest.ResponseRecorder {
+		received = nil
+		req := httptest.NewRequest(method, "/api/v1/metrics/"+target, nil)
+		req.Header.Set("Cookie", "waterfront=secret")
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, alice))
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Query_IsProxied", func(t *testing.T) {
+		rec := serve(newProxy(), "GET", "query?query=up&stats=all")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+		require.Len(t, received, 1)
+		require.Equal(t, "/api/v1/query", received[0].URL.Path)
+		require.Equal(t, url.Values{"query": {"up"}, "timeout": {"30s"}}, received[0].URL.Query())
+		require.Empty(t, received[0].Header.Get("Cookie"))
+	})
+
+	t.Run("QueryRange_IsProxied", func(t *testing.T) {
+		rec := serve(newProxy(), "GET", "query_range?query=up&start=1525172400&end=2018-05-01T12:00:00Z&step=1m")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, url.Values{
+			"query":   {"up"},
+			"start":   {"1525172400"},
+			"end":     {"1525176000"},
+			"step":    {"60"},
+			"timeout": {"30s"},
+		}, received[0].URL.Query())
+	})
+
+	t.Run("LabelValues_IsProxied", func(t *testing.T) {
+		rec := serve(newProxy(), "GET", "label/instance/values")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "/api/v1/label/instance/values", received[0].URL.Path)
+	})
+
+	t.Run("Series_IsLimitedInTime", func(t *testing.T) {
+		rec := serve(newProxy(), "GET", "series?match[]=up")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "1524571200", received[0].URL.Query().Get("start"))
+		require.Equal(t, "1525176000", received[0].URL.Query().Get("end"))
+	})
+
+	t.Run("OtherEndpoints_AreNotFound", func(t *testing.T) {
+		proxy := newProxy()
+		for _, target := range []string{
+			"admin/tsdb/delete_series?match[]=up",
+			"admin/tsdb/snapshot",
+			"label/../admin/tsdb/snapshot/values",
+			"targets",
+			"status/config",
+		} {
+			rec := serve(proxy, "GET", target)
+			require.Equal(t, http.StatusNotFound, rec.Code, target)
+			require.Empty(t, received, target)
+		}
+	})
+
+	t.Run("Post_IsNotAllowed", func(t *testing.T) {
+		rec := serve(newProxy(), "POST", "query?query=up")
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		require.Empty(t, received)
+	})
+
+	t.Run("Limits_AreEnforced", func(t *testing.T) {
+		proxy := newProxy()
+		for _, target := range []string{
+			"query",
+			"query?query=" + url.QueryEscape("rate(node_cpu[30d])"),
+			"query?query=" + url.QueryEscape("node_load1 OFFSET 2w"),
+			"query?query=" + strings.Repeat("a", 5000),
+			"query_range?query=up&start=1522584000&end=1525176000&step=3600",
+			"query_range?query=up&start=1525172400&end=1525176000&step=0.1",
+			"query_range?query=up&start=1525176000&end=1525172400&step=60",
+			"query_range?query=up&start=1525172400&end=1525176000",
+			"series?match[]=up&start=1522584000",
+		} {
+			rec := serve(proxy, "GET", target)
+			require.Equal(t, http.StatusBadRequest, rec.Code, target)
+			require.Empty(t, received, target)
+		}
+	})
+
+	t.Run("ManyQueries_AreThrottled", func(t *testing.T) {
+		proxy := NewMetricsProxy(prometheusURL)
+		proxy.Throttle = NewRateLimiter(1, 2)
+		handler := http.StripPrefix("/api/v1/metrics/", proxy)
+
+		require.Equal(t, http.StatusOK, serve(handler, "GET", "query?query=up").Code)
+		require.Equal(t, http.StatusOK, serve(handler, "GET", "query?query=up").Code)
+		rec := serve(handler, "GET", "query?query=up")
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	})
+}
+
+type fixtureMetricsSource struct {
+	queries []string
+	series  []*MetricSeries
+}
+
+func (s *fixtureMetricsSource) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]*MetricSeries, error) {
+	s.queries = append(s.queries, query)
+	return s.series, nil
+}
+
+type fakeNodesTeamsterClient struct {
+	teamster_proto.TeamsterClient
+	nodes []*teamster_proto.NodeSummary
+}
+
+func (c *fakeNodesTeamsterClient) ListNodes(ctx context.Context, req *teamster_proto.Empty, opts ...grpc.CallOption) (*teamster_proto.ListNodesResponse, error) {
+	return &teamster_proto.ListNodesResponse{Nodes: c.nodes}, nil
+}
+
+func newMetricsAPI() (*WaterfrontAPI, *fixtureMetricsSource) {
+	source := &fixtureMetricsSource{series: []*MetricSeries{
+		{Node: "worker-1", Samples: []*MetricSample{{Time: 1525176000, Value: 1.5}}},
+	}}
+	teamsterClient := &fakeNodesTeamsterClient{nodes: []*teamster_proto.NodeSummary{
+		{Uuid: "u1", Hostname: "worker-1"},
+		{Uuid: `u2"} or vector(1) #`},
+	}}
+	return &WaterfrontAPI{
+		MetricsThrottle: NewRateLimiter(1000, 1000),
+		teamsterClient:  teamsterClient,
+		metricsSource:   source,
+	}, source
+}
+
+func TestNamedMetrics(t *testing.T) {
+	alice := &User{Username: "alice", Roles: []Role{RoleViewer}}
+	ctx := context.WithValue(context.Background(), ContextKeyuser, alice)
+	newAPI := newMetricsAPI
+
+	t.Run("Node_IsSelected", func(t *testing.T) {
+		api, source := newAPI()
+		resp, err := api.GetNodeMetrics(ctx, &MetricsRequest{Id: "u1", Metric: Metric_MEMORY_USAGE})
+		require.NoError(t, err)
+		require.Equal(t, "bytes", resp.Unit)
+		require.Equal(t, "worker-1", resp.Series[0].Node)
+		require.Equal(t, `sum(node_memory_MemTotal{job="kubernetes-nodes-node-exporter",instance="worker-1"} - `+
+			`node_memory_MemAvailable{job="kubernetes-nodes-node-exporter",instance="worker-1"}) by (instance)`, source.queries[0])
+	})
+
+	t.Run("Cluster_IsTotalled", func(t *testing.T) {
+		api, source := newAPI()
+		resp, err := api.GetClusterMetrics(ctx, &MetricsRequest{Metric: Metric_CPU_USAGE, Total: true})
+		require.NoError(t, err)
+		require.Equal(t, "", resp.Series[0].Node)
+		require.Equal(t, `sum(sum(rate(node_cpu{mode!="idle",job="kubernetes-nodes-node-exporter"}[5m])) by (instance))`, source.queries[0])
+	})
+
+	t.Run("Ceph_IsForClusterOnly", func(t *testing.T) {
+		api, _ := newAPI()
+		_, err := api.GetClusterMetrics(ctx, &MetricsRequest{Metric: Metric_CEPH_USAGE})
+		require.NoError(t, err)
+
+		_, err = api.GetNodeMetrics(ctx, &MetricsRequest{Id: "u1", Metric: Metric_CEPH_USAGE})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+	})
+
+	t.Run("BadRequests_AreRefused", func(t *testing.T) {
+		api, source := newAPI()
+		for _, req := range []*MetricsRequest{
+			{Metric: Metric(42)},
+			{Metric: Metric_CPU_USAGE, Start: 1522584000, End: 1525176000},
+			{Metric: Metric_CPU_USAGE, Start: 1525176000, End: 1525172400},
+			{Metric: Metric_CPU_USAGE, Start: 1525089600, End: 1525176000, Step: 1},
+			{Metric: Metric_CPU_USAGE, Step: -60},
+		} {
+			_, err := api.GetClusterMetrics(ctx, req)
+			require.Equal(t, codes.InvalidArgument, grpc.Code(err), "%+v", req)
+		}
+
+		// Node names end up in PromQL
+		_, err := api.GetNodeMetrics(ctx, &MetricsRequest{Id: `u2"} or vector(1) #`, Metric: Metric_CPU_USAGE})
+		require.Equal(t, codes.InvalidArgument, grpc.Code(err))
+		require.Empty(t, source.queries)
+	})
+
+	t.Run("ManyQueries_AreThrottled", func(t *testing.T) {
+		api, source := newAPI()
+		api.MetricsThrottle = NewRateLimiter(1, 2)
+
+		req := &MetricsRequest{Id: "u1", Metric: Metric_CPU_USAGE}
+		_, err := api.GetNodeMetrics(ctx, req)
+		require.NoError(t, err)
+		_, err = api.GetClusterMetrics(ctx, req)
+		require.NoError(t, err)
+		_, err = api.GetNodeMetrics(ctx, req)
+		require.Equal(t, codes.ResourceExhausted, grpc.Code(err))
+		_, err = api.GetClusterMetrics(ctx, req)
+		require.Equal(t, codes.ResourceExhausted, grpc.Code(err))
+		require.Len(t, source.queries, 2)
+
+		// Other users have their own allowance
+		bob := context.WithValue(context.Background(), ContextKeyuser, &User{Username: "bob"})
+		_, err = api.GetClusterMetrics(bob, req)
+		require.NoError(t, err)
+
+		_, err = api.GetClusterMetrics(context.Background(), req)
+		require.Equal(t, codes.Unauthenticated, grpc.Code(err))
+	})
+}
+
+// scrapedNodeSeries are the labels of node exporter series of two nodes, as
+// Prometheus stores them after the relabelling of the
+// kubernetes-nodes-node-exporter job. The address of every node is the API
+// server, which proxies the scrapes.
+var scrapedNodeSeries = []map[string]string{
+	{
+		"job":                    "kubernetes-nodes-node-exporter",
+		"instance":               "worker-1",
+		"kubernetes_io_hostname": "worker-1",
+		"beta_kubernetes_io_os":  "linux",
+	},
+	{
+		"job":                    "kubernetes-nodes-node-exporter",
+		"instance":               "worker-2",
+		"kubernetes_io_hostname": "worker-2",
+		"beta_kubernetes_io_os":  "linux",
+	},
+}
+
+var (
+	promMatcherRe  = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="([^"]*)"`)
+	promGroupingRe = regexp.MustCompile(`by \(([^)]*)\)`)
+)
+
+func TestNamedMetrics_MatchScrapedLabels(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ContextKeyuser, &User{Username: "alice"})
+	for metric, query := range metricQueries {
+		if query.cluster {
+			continue
+		}
+		api, source := newMetricsAPI()
+		_, err := api.GetNodeMetrics(ctx, &MetricsRequest{Id: "u1", Metric: metric})
+		require.NoError(t, err)
+		expr := source.queries[0]
+
+		// The matchers on node labels select the series of the node alone
+		var selected []string
+		for _, labels := range scrapedNodeSeries {
+			matches := true
+			for _, m := range promMatcherRe.FindAllStringSubmatch(expr, -1) {
+				if value, ok := labels[m[1]]; ok && value != m[2] {
+					matches = false
+				}
+			}
+			if matches {
+				selected = append(selected, labels["kubernetes_io_hostname"])
+			}
+		}
+		require.Equal(t, []string{"worker-1"}, selected, "%s: %s", metric, expr)
+
+		// Each grouping keeps the series of different nodes apart
+		for _, m := range promGroupingRe.FindAllStringSubmatch(expr, -1) {
+			apart := false
+			for _, label := range strings.Split(m[1], ",") {
+				label = strings.TrimSpace(label)
+				apart = apart || scrapedNodeSeries[0][label] != scrapedNodeSeries[1][label]
+			}
+			require.True(t, apart, "%s groups nodes together: %s", metric, expr)
+		}
+	}
+}
+
+func TestPrometheusSource(t *testing.T) {
+	var query url.Values
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		if query.Get("query") == "bad(" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"instance":"worker-1"},"values":[[1525172400,"0.5"],[1525172460,"0.75"]]}
+		]}}`)
+	}))
+	defer prometheus.Close()
+
+	prometheusURL, err := url.Parse(prometheus.URL + "/api/v1")
+	require.NoError(t, err)
+	source := NewPrometheusSource(prometheusURL)
+	start := time.Unix(1525172400, 0)
+
+	series, err := source.QueryRange(context.Background(), "up", start, start.Add(time.Minute), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "60", query.Get("step"))
+	require.Equal(t, []*MetricSeries{{Node: "worker-1", Samples: []*MetricSample{
+		{Time: 1525172400, Value: 0.5},
+		{Time: 1525172460, Value: 0.75},
+	}}}, series)
+
+	_, err = source.QueryRange(context.Background(), "bad(", start, start.Add(time.Minute), time.Minute)
+	require.EqualError(t, err, "Prometheus query failed: parse error")
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"sort"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+// nodeFromKube describes a node. kubeNode is nil for nodes which are
+// registered in teamster but have not joined Kubernetes.
+func nodeFromKube(uuid string, kubeNode *kube_v1.Node) *Node {
+	node := &Node{
+		Id:     uuid,
+		Status: NodeStatus_NOT_JOINED,
+	}
+
+	if kubeNode == nil {
+		return node
+	}
+
+	node.Hostname = kubeNode.GetName()
+	node.Status = nodeStatus(kubeNode)
+	node.Ip = nodeIP(kubeNode)
+	node.PodCidr = kubeNode.Spec.PodCIDR
+	node.Unschedulable = kubeNode.Spec.Unschedulable
+	node.KubeletVersion = kubeNode.Status.NodeInfo.KubeletVersion
+	node.OsImage = kubeNode.Status.NodeInfo.OSImage
+	node.Capacity = nodeResources(kubeNode.Status.Capacity)
+	node.Allocatable = nodeResources(kubeNode.Status.Allocatable)
+
+	for _, condition := range kubeNode.Status.Conditions {
+		node.Conditions = append(node.Conditions, &NodeCondition{
+			Type:               string(condition.Type),
+			Status:             string(condition.Status),
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+			LastHeartbeatTime:  unixTime(condition.LastHeartbeatTime),
+			LastTransitionTime: unixTime(condition.LastTransitionTime),
+		})
+	}
+	// The kubelet reports conditions in no particular order
+	sort.Slice(node.Conditions, func(i, j int) bool {
+		return node.Conditions[i].Type < node.Conditions[j].Type
+	})
+
+	for _, taint := range kubeNode.Spec.Taints {
+		node.Taints = append(node.Taints, &Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: string(taint.Effect),
+		})
+	}
+
+	if len(kubeNode.Labels) > 0 {
+		node.Labels = make(map[string]string, len(kubeNode.Labels))
+		for key, value := range kubeNode.Labels {
+			node.Labels[key] = value
+		}
+	}
+
+	return node
+}
+
+func nodeStatus(kubeNode *kube_v1.Node) NodeStatus {
+	for _, condition := range kubeNode.Status.Conditions {
+		if condition.Type == kube_v1.NodeReady {
+			switch condition.Status {
+			case kube_v1.ConditionTrue:
+				return NodeStatus_READY
+			case kube_v1.ConditionFalse:
+				return NodeStatus_NOT_READY
+			}
+			return NodeStatus_UNKNOWN
+		}
+	}
+	// The kubelet has registered the node without reporting on it yet
+	return NodeStatus_UNKNOWN
+}
+
+// nodeIP returns the address of a node, preferring the one inside the
+// cluster
+func nodeIP(kubeNode *kube_v1.Node) string {
+	for _, addressType := range []kube_v1.NodeAddressType{kube_v1.NodeInternalIP, kube_v1.NodeExternalIP} {
+		for _, address := range kubeNode.Status.Addresses {
+			if address.Type == addressType {
+				return address.Address
+			}
+		}
+	}
+
+	if len(kubeNode.Status.Addresses) > 0 {
+		return kubeNode.Status.Addresses[0].Address
+	}
+	return ""
+}
+
+func nodeResources(resources kube_v1.ResourceList) *NodeResources {
+	if len(resources) == 0 {
+		return nil
+	}
+
+	result := &NodeResources{}
+	if cpu, ok := resources[kube_v1.ResourceCPU]; ok {
+		result.CpuMillicores = cpu.MilliValue()
+	}
+	if memory, ok := resources[kube_v1.ResourceMemory]; ok {
+		result.MemoryBytes = memory.Value()
+	}
+	if pods, ok := resources[kube_v1.ResourcePods]; ok {
+		result.Pods = pods.Value()
+	}
+	return result
+}
+
+// unixTime returns 0 for times which were never set
+func unixTime(t meta_v1.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// teamsterHostname is the name a node registered in teamster goes by in
+// Kubernetes
+func teamsterHostname(node *teamster_proto.NodeSummary) string {
+	if node.Hostname != "" {
+		return node.Hostname
+	}
+	return node.Uuid
+}
+
+// metadataFromTeamster returns nil for nodes without metadata
+func metadataFromTeamster(md *teamster_proto.NodeMetadata) *NodeMetadata {
+	if md == nil || (md.Name == "" && len(md.Labels) == 0 && len(md.Taints) == 0 && md.Rack == "" && md.Zone == "") {
+		return nil
+	}
+
+	result := &NodeMetadata{
+		Name:   md.Name,
+		Labels: md.Labels,
+		Rack:   md.Rack,
+		Zone:   md.Zone,
+	}
+	for _, taint := range md.Taints {
+		result.Taints = append(result.Taints, &Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: taint.Effect,
+		})
+	}
+	return result
+}
+
+func metadataToTeamster(md *NodeMetadata) *teamster_proto.NodeMetadata {
+	result := &teamster_proto.NodeMetadata{}
+	if md == nil {
+		return result
+	}
+
+	result.Name = md.Name
+	result.Labels = md.Labels
+	result.Rack = md.Rack
+	result.Zone = md.Zone
+	for _, taint := range md.Taints {
+		result.Taints = append(result.Taints, &teamster_proto.Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: taint.Effect,
+		})
+	}
+	return result
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+func TestNodeFromKube(t *testing.T) {
+	t.Run("NotJoined", func(t *testing.T) {
+		node := nodeFromKube("a", nil)
+		require.Equal(t, "a", node.Id)
+		require.Equal(t, NodeStatus_NOT_JOINED, node.Status)
+	})
+
+	t.Run("ReadyCondition_SetsStatus", func(t *testing.T) {
+		cases := map[kube_v1.ConditionStatus]NodeStatus{
+			kube_v1.ConditionTrue:    NodeStatus_READY,
+			kube_v1.ConditionFalse:   NodeStatus_NOT_READY,
+			kube_v1.ConditionUnknown: NodeStatus_UNKNOWN,
+		}
+		for condition, expected := range cases {
+			kubeNode := &kube_v1.Node{}
+			kubeNode.Status.Conditions = []kube_v1.NodeCondition{{Type: kube_v1.NodeReady, Status: condition}}
+			require.Equal(t, expected, nodeFromKube("a", kubeNode).Status, string(condition))
+		}
+	})
+
+	t.Run("NoReadyCondition_IsUnknown", func(t *testing.T) {
+		require.Equal(t, NodeStatus_UNKNOWN, nodeFromKube("a", &kube_v1.Node{}).Status)
+	})
+
+	t.Run("InternalIP_IsPreferred", func(t *testing.T) {
+		kubeNode := &kube_v1.Node{}
+		kubeNode.Status.Addresses = []kube_v1.NodeAddress{
+			{Type: kube_v1.NodeHostName, Address: "node-a"},
+			{Type: kube_v1.NodeExternalIP, Address: "203.0.113.2"},
+			{Type: kube_v1.NodeInternalIP, Address: "192.168.0.2"},
+		}
+		require.Equal(t, "192.168.0.2", nodeFromKube("a", kubeNode).Ip)
+
+		kubeNode.Status.Addresses = kubeNode.Status.Addresses[:2]
+		require.Equal(t, "203.0.113.2", nodeFromKube("a", kubeNode).Ip)
+
+		kubeNode.Status.Addresses = kubeNode.Status.Addresses[:1]
+		require.Equal(t, "node-a", nodeFromKube("a", kubeNode).Ip)
+	})
+
+	t.Run("Details", func(t *testing.T) {
+		heartbeat := time.Unix(1520000000, 0)
+		kubeNode := &kube_v1.Node{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:   "a",
+				Labels: map[string]string{"kubernetes.io/hostname": "node-a"},
+			},
+			Spec: kube_v1.NodeSpec{
+				Unschedulable: true,
+				Taints: []kube_v1.Taint{
+					{Key: "dedicated", Value: "storage", Effect: kube_v1.TaintEffectNoSchedule},
+				},
+			},
+			Status: kube_v1.NodeStatus{
+				Capacity: kube_v1.ResourceList{
+					kube_v1.ResourceCPU:    resource.MustParse("4"),
+					kube_v1.ResourceMemory: resource.MustParse("8Gi"),
+					kube_v1.ResourcePods:   resource.MustParse("110"),
+				},
+				Allocatable: kube_v1.ResourceList{
+					kube_v1.ResourceCPU: resource.MustParse("3500m"),
+				},
+				Conditions: []kube_v1.NodeCondition{
+					{Type: kube_v1.NodeReady, Status: kube_v1.ConditionTrue, LastHeartbeatTime: meta_v1.NewTime(heartbeat)},
+					{Type: kube_v1.NodeDiskPressure, Status: kube_v1.ConditionFalse, Reason: "KubeletHasNoDiskPressure"},
+				},
+				NodeInfo: kube_v1.NodeSystemInfo{KubeletVersion: "v1.9.3", OSImage: "Operos"},
+			},
+		}
+
+		node := nodeFromKube("a", kubeNode)
+		require.True(t, node.Unschedulable)
+		require.Equal(t, "v1.9.3", node.KubeletVersion)
+		require.Equal(t, "Operos", node.OsImage)
+		require.Equal(t, &NodeResources{CpuMillicores: 4000, MemoryBytes: 8 << 30, Pods: 110}, node.Capacity)
+		require.Equal(t, &NodeResources{CpuMillicores: 3500}, node.Allocatable)
+		require.Equal(t, []*Taint{{Key: "dedicated", Value: "storage", Effect: "NoSchedule"}}, node.Taints)
+		require.Equal(t, map[string]string{"kubernetes.io/hostname": "node-a"}, node.Labels)
+
+		require.Len(t, node.Conditions, 2)
+		require.Equal(t, "DiskPressure", node.Conditions[0].Type)
+		require.Equal(t, "KubeletHasNoDiskPressure", node.Conditions[0].Reason)
+		require.Equal(t, "Ready", node.Conditions[1].Type)
+		require.Equal(t, heartbeat.Unix(), node.Conditions[1].LastHeartbeatTime)
+		require.Zero(t, node.Conditions[1].LastTransitionTime)
+
+		// The node must not share its labels with the informer cache
+		node.Labels["changed"] = "true"
+		require.NotContains(t, kubeNode.Labels, "changed")
+	})
+}
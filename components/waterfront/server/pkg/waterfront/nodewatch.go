@@ -0,0 +1,309 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+const (
+	// DefaultTeamsterPollInterval is how often teamster is asked for the
+	// nodes registered with it, since it can not be watched
+	DefaultTeamsterPollInterval = 10 * time.Second
+
+	// subscriberBuffer is how many events a subscriber may fall behind by
+	// before it is dropped
+	subscriberBuffer = 64
+)
+
+// NodeWatcher merges the nodes registered in teamster with a Kubernetes node
+// informer, and tells subscribers about every change. It lets node listings
+// be served without asking teamster and the API server each time.
+//
+// The informer lists nodes as waterfront rather than as the user. This shows
+// nobody more than they could see themselves, since every role may read nodes
+// through the bindings in 42-operos-rbac.yml; keep it that way when changing
+// either.
+type NodeWatcher struct {
+	teamsterClient teamster_proto.TeamsterClient
+	kubeClient     *kubernetes.Clientset
+	pollInterval   time.Duration
+
+	lock sync.Mutex
+	// teamsterNodes are the nodes registered in teamster by UUID, which is
+	// also their ID. hostnames maps their names in Kubernetes back to it.
+	teamsterNodes map[string]*teamster_proto.NodeSummary
+	hostnames     map[string]string
+	// kubeNodes are keyed by name. Nodes not registered in teamster go by
+	// their name as ID.
+	kubeNodes      map[string]*kube_v1.Node
+	nodes          map[string]*Node
+	teamsterSynced bool
+	kubeSynced     bool
+	subscribers    map[chan *NodeEvent]bool
+}
+
+func NewNodeWatcher(teamsterClient teamster_proto.TeamsterClient, kubeClient *kubernetes.Clientset) *NodeWatcher {
+	return &NodeWatcher{
+		teamsterClient: teamsterClient,
+		kubeClient:     kubeClient,
+		pollInterval:   DefaultTeamsterPollInterval,
+		teamsterNodes:  make(map[string]*teamster_proto.NodeSummary),
+		hostnames:      make(map[string]string),
+		kubeNodes:      make(map[string]*kube_v1.Node),
+		nodes:          make(map[string]*Node),
+		subscribers:    make(map[chan *NodeEvent]bool),
+	}
+}
+
+// Run watches teamster and Kubernetes until stop is closed
+func (w *NodeWatcher) Run(stop <-chan struct{}) {
+	listWatch := cache.NewListWatchFromClient(
+		w.kubeClient.CoreV1().RESTClient(), "nodes", meta_v1.NamespaceAll, fields.Everything())
+
+	_, controller := cache.NewInformer(listWatch, &kube_v1.Node{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*kube_v1.Node); ok {
+				w.setKubeNode(node)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if node, ok := obj.(*kube_v1.Node); ok {
+				w.setKubeNode(node)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			switch obj := obj.(type) {
+			case *kube_v1.Node:
+				w.deleteKubeNode(obj.GetName())
+			case cache.DeletedFinalStateUnknown:
+				// Nodes are not namespaced, so the key is the name
+				w.deleteKubeNode(obj.Key)
+			}
+		},
+	})
+	go controller.Run(stop)
+
+	go func() {
+		if cache.WaitForCacheSync(stop, controller.HasSynced) {
+			w.lock.Lock()
+			w.kubeSynced = true
+			w.lock.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		w.pollTeamster()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *NodeWatcher) pollTeamster() {
+	ctx, cancel := context.WithTimeout(context.Background(), w.pollInterval)
+	defer cancel()
+
+	res, err := w.teamsterClient.ListNodes(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		// Nodes are kept as they were, rather than reported as removed
+		log.Errorf("failed to list teamster nodes: %v", err)
+		return
+	}
+
+	nodes := make(map[string]*teamster_proto.NodeSummary, len(res.Nodes))
+	for _, node := range res.Nodes {
+		nodes[node.Uuid] = node
+	}
+	w.setTeamsterNodes(nodes)
+}
+
+// Nodes returns the current nodes sorted by ID. It returns false until both
+// teamster and Kubernetes have been listed once.
+func (w *NodeWatcher) Nodes() ([]*Node, bool) {
+	if w == nil {
+		return nil, false
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.teamsterSynced || !w.kubeSynced {
+		return nil, false
+	}
+
+	nodes := make([]*Node, 0, len(w.nodes))
+	for _, node := range w.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+	return nodes, true
+}
+
+// Subscribe returns a channel which receives an ADDED event for each current
+// node, followed by every change. The channel is closed if the subscriber
+// falls too far behind. The returned function ends the subscription.
+func (w *NodeWatcher) Subscribe() (<-chan *NodeEvent, func()) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	events := make(chan *NodeEvent, len(w.nodes)+subscriberBuffer)
+	for _, node := range w.nodes {
+		events <- &NodeEvent{Type: NodeEventType_ADDED, Node: node}
+	}
+	w.subscribers[events] = true
+
+	return events, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		if w.subscribers[events] {
+			delete(w.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+func (w *NodeWatcher) setTeamsterNodes(nodes map[string]*teamster_proto.NodeSummary) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	previous := w.teamsterNodes
+	w.teamsterNodes = nodes
+	w.hostnames = make(map[string]string, len(nodes))
+	for id, node := range nodes {
+		w.hostnames[teamsterHostname(node)] = id
+	}
+
+	// A rename moves a Kubernetes node between IDs, so every node is
+	// refreshed
+	for id := range nodes {
+		w.refresh(id)
+	}
+	for id := range previous {
+		if _, ok := nodes[id]; !ok {
+			w.refresh(id)
+		}
+	}
+	for name := range w.kubeNodes {
+		w.refresh(name)
+	}
+	w.teamsterSynced = true
+}
+
+func (w *NodeWatcher) setKubeNode(kubeNode *kube_v1.Node) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.kubeNodes[kubeNode.GetName()] = kubeNode
+	w.refresh(w.nodeID(kubeNode.GetName()))
+}
+
+func (w *NodeWatcher) deleteKubeNode(name string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.kubeNodes, name)
+	w.refresh(w.nodeID(name))
+}
+
+// nodeID returns the ID of the node with a name in Kubernetes. It must be
+// called with the lock held.
+func (w *NodeWatcher) nodeID(name string) string {
+	if id, ok := w.hostnames[name]; ok {
+		return id
+	}
+	return name
+}
+
+// withoutHeartbeats returns a copy of node without the heartbeat times of its
+// conditions
+func withoutHeartbeats(node *Node) *Node {
+	copied := *node
+	copied.Conditions = make([]*NodeCondition, len(node.Conditions))
+	for i, condition := range node.Conditions {
+		withoutHeartbeat := *condition
+		withoutHeartbeat.LastHeartbeatTime = 0
+		copied.Conditions[i] = &withoutHeartbeat
+	}
+	return &copied
+}
+
+// refresh rebuilds a node from what teamster and Kubernetes know about it,
+// and publishes the difference. It must be called with the lock held.
+func (w *NodeWatcher) refresh(id string) {
+	var node *Node
+	if teamsterNode, ok := w.teamsterNodes[id]; ok {
+		hostname := teamsterHostname(teamsterNode)
+		node = nodeFromKube(id, w.kubeNodes[hostname])
+		node.Hostname = hostname
+		node.Metadata = metadataFromTeamster(teamsterNode.Metadata)
+	} else if kubeNode, ok := w.kubeNodes[id]; ok && w.nodeID(id) == id {
+		node = nodeFromKube(id, kubeNode)
+	}
+
+	previous, existed := w.nodes[id]
+	var event *NodeEvent
+	switch {
+	case node == nil && existed:
+		delete(w.nodes, id)
+		event = &NodeEvent{Type: NodeEventType_DELETED, Node: previous}
+	case node != nil && !existed:
+		w.nodes[id] = node
+		event = &NodeEvent{Type: NodeEventType_ADDED, Node: node}
+	case node != nil && !proto.Equal(withoutHeartbeats(node), withoutHeartbeats(previous)):
+		w.nodes[id] = node
+		event = &NodeEvent{Type: NodeEventType_MODIFIED, Node: node}
+	case node != nil:
+		// The kubelet reports a heartbeat every few seconds. It is kept for
+		// GetNode and ListNodes, but not worth a watch event on its own.
+		w.nodes[id] = node
+		return
+	default:
+		return
+	}
+
+	for events := range w.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Warnf("dropping node watch subscriber which fell behind")
+			delete(w.subscribers, events)
+			close(events)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+func testKubeNode(name string, ready bool) *kube_v1.Node {
+	status := kube_v1.ConditionFalse
+	if ready {
+		status = kube_v1.ConditionTrue
+	}
+
+	return &kube_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		Spec:       kube_v1.NodeSpec{PodCIDR: "10.0.0.0/24"},
+		Status: kube_v1.NodeStatus{
+			Addresses:  []kube_v1.NodeAddress{{Type: kube_v1.NodeInternalIP, Address: "192.168.0.2"}},
+			Conditions: []kube_v1.NodeCondition{{Type: kube_v1.NodeReady, Status: status}},
+		},
+	}
+}
+
+// pendingEvents returns the events of a subscription which have not been
+// read, as type and ID
+func pendingEvents(events <-chan *NodeEvent) []string {
+	var result []string
+	for event := nextEvent(events); event != nil; event = nextEvent(events) {
+		result = append(result, event.Type.String()+" "+event.Node.Id)
+	}
+	return result
+}
+
+// nextEvent returns the next event of a subscription, or nil if there is none
+func nextEvent(events <-chan *NodeEvent) *NodeEvent {
+	select {
+	case event := <-events:
+		return event
+	default:
+		return nil
+	}
+}
+
+func TestNodeWatcher(t *testing.T) {
+	w := NewNodeWatcher(nil, nil)
+
+	_, synced := w.Nodes()
+	require.False(t, synced)
+
+	events, cancel := w.Subscribe()
+	defer cancel()
+
+	t.Run("TeamsterNode_IsAdded", func(t *testing.T) {
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{"a": {Uuid: "a"}})
+
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_ADDED, event.Type)
+		require.Equal(t, "a", event.Node.Id)
+		require.Equal(t, NodeStatus_NOT_JOINED, event.Node.Status)
+	})
+
+	t.Run("JoinedNode_IsModified", func(t *testing.T) {
+		w.setKubeNode(testKubeNode("a", true))
+
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_MODIFIED, event.Type)
+		require.Equal(t, NodeStatus_READY, event.Node.Status)
+		require.Equal(t, "192.168.0.2", event.Node.Ip)
+	})
+
+	t.Run("UnchangedNode_IsNotPublished", func(t *testing.T) {
+		w.setKubeNode(testKubeNode("a", true))
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{"a": {Uuid: "a"}})
+		require.Nil(t, nextEvent(events))
+	})
+
+	t.Run("Heartbeat_IsNotPublished", func(t *testing.T) {
+		node := testKubeNode("a", true)
+		node.Status.Conditions[0].LastHeartbeatTime = meta_v1.Unix(1525176000, 0)
+		w.setKubeNode(node)
+		require.Nil(t, nextEvent(events))
+
+		require.Equal(t, int64(1525176000), w.nodes["a"].Conditions[0].LastHeartbeatTime)
+	})
+
+	t.Run("NodeWithoutAddresses_IsAdded", func(t *testing.T) {
+		node := testKubeNode("b", false)
+		node.Status.Addresses = nil
+		w.setKubeNode(node)
+
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_ADDED, event.Type)
+		require.Empty(t, event.Node.Ip)
+	})
+
+	t.Run("ChangedMetadata_IsModified", func(t *testing.T) {
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{
+			"a": {Uuid: "a", Metadata: &teamster_proto.NodeMetadata{Name: "storage-1"}},
+		})
+
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_MODIFIED, event.Type)
+		require.Equal(t, "storage-1", event.Node.Metadata.Name)
+	})
+
+	t.Run("NewSubscriber_GetsCurrentNodes", func(t *testing.T) {
+		late, cancel := w.Subscribe()
+		defer cancel()
+
+		ids := map[string]bool{}
+		for event := nextEvent(late); event != nil; event = nextEvent(late) {
+			require.Equal(t, NodeEventType_ADDED, event.Type)
+			ids[event.Node.Id] = true
+		}
+		require.Equal(t, map[string]bool{"a": true, "b": true}, ids)
+	})
+
+	t.Run("Nodes_ServedOnceSynced", func(t *testing.T) {
+		w.kubeSynced = true
+		nodes, synced := w.Nodes()
+		require.True(t, synced)
+		require.Len(t, nodes, 2)
+		require.Equal(t, "a", nodes[0].Id)
+	})
+
+	t.Run("NodeGoneFromBoth_IsDeleted", func(t *testing.T) {
+		// Kubernetes still knows the node, but its metadata is gone
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{})
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_MODIFIED, event.Type)
+		require.Nil(t, event.Node.Metadata)
+		require.Nil(t, nextEvent(events))
+
+		w.deleteKubeNode("a")
+		event = nextEvent(events)
+		require.Equal(t, NodeEventType_DELETED, event.Type)
+		require.Equal(t, "a", event.Node.Id)
+	})
+
+	t.Run("SlowSubscriber_IsDropped", func(t *testing.T) {
+		slow, cancel := w.Subscribe()
+		defer cancel()
+
+		for i := 0; i <= subscriberBuffer; i++ {
+			w.setKubeNode(testKubeNode("b", i%2 == 0))
+		}
+
+		count := 0
+		for range slow {
+			count++
+		}
+		require.Equal(t, 1+subscriberBuffer, count)
+	})
+}
+
+func TestNodeWatcherHostnames(t *testing.T) {
+	w := NewNodeWatcher(nil, nil)
+	events, cancel := w.Subscribe()
+	defer cancel()
+
+	t.Run("KubeNode_MatchesByHostname", func(t *testing.T) {
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{"u1": {Uuid: "u1", Hostname: "worker-1"}})
+		require.Equal(t, []string{"ADDED u1"}, pendingEvents(events))
+
+		w.setKubeNode(testKubeNode("worker-1", true))
+		event := nextEvent(events)
+		require.Equal(t, NodeEventType_MODIFIED, event.Type)
+		require.Equal(t, "u1", event.Node.Id)
+		require.Equal(t, "worker-1", event.Node.Hostname)
+		require.Equal(t, NodeStatus_READY, event.Node.Status)
+	})
+
+	t.Run("KubeNodeSeenFirst_MovesToUUID", func(t *testing.T) {
+		w.setKubeNode(testKubeNode("worker-2", true))
+		require.Equal(t, []string{"ADDED worker-2"}, pendingEvents(events))
+
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{
+			"u1": {Uuid: "u1", Hostname: "worker-1"},
+			"u2": {Uuid: "u2", Hostname: "worker-2"},
+		})
+		require.Equal(t, []string{"ADDED u2", "DELETED worker-2"}, pendingEvents(events))
+	})
+
+	t.Run("RenamedNode_LeavesOldKubeNode", func(t *testing.T) {
+		w.setTeamsterNodes(map[string]*teamster_proto.NodeSummary{
+			"u1": {Uuid: "u1", Hostname: "worker-3"},
+			"u2": {Uuid: "u2", Hostname: "worker-2"},
+		})
+		require.Equal(t, []string{"MODIFIED u1", "ADDED worker-1"}, pendingEvents(events))
+		require.Equal(t, NodeStatus_NOT_JOINED, w.nodes["u1"].Status)
+		require.Equal(t, "worker-3", w.nodes["u1"].Hostname)
+
+		w.setKubeNode(testKubeNode("worker-3", true))
+		w.deleteKubeNode("worker-1")
+		require.Equal(t, []string{"MODIFIED u1", "DELETED worker-1"}, pendingEvents(events))
+	})
+}
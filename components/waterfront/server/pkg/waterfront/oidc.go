@@ -0,0 +1,233 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig describes an OpenID Connect provider and the waterfront client
+// registered with it
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback route as seen by browsers, e.g.
+	// https://controller/api/v1/oidc/callback
+	RedirectURL string
+	// UsernameClaim and GroupsClaim name the ID token claims holding the
+	// username and groups
+	UsernameClaim string
+	GroupsClaim   string
+	// Scopes requested in addition to openid
+	Scopes []string
+}
+
+// OIDCLogin logs users in with the OpenID Connect authorization code flow
+type OIDCLogin struct {
+	// LoginPath is where the UI sends browsers to log in
+	LoginPath string
+
+	config   OIDCConfig
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCLogin discovers the endpoints of the provider
+func NewOIDCLogin(ctx context.Context, config OIDCConfig, loginPath string) (*OIDCLogin, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC client ID and redirect URL are required")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "email"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.Scopes == nil {
+		config.Scopes = []string{"profile", "email", "groups"}
+	}
+
+	provider, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to discover OIDC provider %s", config.IssuerURL)
+	}
+
+	return &OIDCLogin{
+		LoginPath: loginPath,
+		config:    config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  config.RedirectURL,
+			Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// userFromIDToken verifies an ID token and reads the user from its claims
+func (o *OIDCLogin) userFromIDToken(ctx context.Context, rawIDToken, nonce string) (*User, error) {
+	idToken, err := o.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ID token")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "invalid ID token claims")
+	}
+
+	username, _ := claims[o.config.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", o.config.UsernameClaim)
+	}
+	if o.config.UsernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return nil, fmt.Errorf("email address %s is not verified", username)
+		}
+	}
+
+	var groups []string
+	switch value := claims[o.config.GroupsClaim].(type) {
+	case string:
+		groups = []string{value}
+	case []interface{}:
+		for _, group := range value {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	return &User{
+		Username:  username,
+		LoginTime: time.Now(),
+		Groups:    groups,
+	}, nil
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// GetOIDCLoginHandler sends the browser to the provider to log in
+func (h *auth) GetOIDCLoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := h.getSession(r)
+
+		state, err := randomHex(16)
+		if err != nil {
+			panic(errors.Wrap(err, "failed to generate OIDC state"))
+		}
+		nonce, err := randomHex(16)
+		if err != nil {
+			panic(errors.Wrap(err, "failed to generate OIDC nonce"))
+		}
+
+		session.Values["oidc_state"] = state
+		session.Values["oidc_nonce"] = nonce
+		if err := session.Save(r, w); err != nil {
+			panic(errors.Wrap(err, "failed to save session"))
+		}
+
+		http.Redirect(w, r, h.oidc.oauth2.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+	})
+}
+
+// GetOIDCCallbackHandler completes a login when the provider redirects the
+// browser back to waterfront
+func (h *auth) GetOIDCCallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := h.getSession(r)
+
+		state, _ := session.Values["oidc_state"].(string)
+		nonce, _ := session.Values["oidc_nonce"].(string)
+		delete(session.Values, "oidc_state")
+		delete(session.Values, "oidc_nonce")
+
+		query := r.URL.Query()
+		if errCode := query.Get("error"); errCode != "" {
+			log.Infof("OIDC login failed: %s: %s", errCode, query.Get("error_description"))
+			http.Error(w, fmt.Sprintf("Login failed: %s", errCode), http.StatusUnauthorized)
+			return
+		}
+
+		if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			http.Error(w, "Invalid login state", http.StatusBadRequest)
+			return
+		}
+
+		token, err := h.oidc.oauth2.Exchange(r.Context(), query.Get("code"))
+		if err != nil {
+			log.Errorf("OIDC code exchange failed: %v", err)
+			http.Error(w, "Login failed: could not redeem authorization code", http.StatusBadGateway)
+			return
+		}
+
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			http.Error(w, "Login failed: provider returned no ID token", http.StatusBadGateway)
+			return
+		}
+
+		user, err := h.oidc.userFromIDToken(r.Context(), rawIDToken, nonce)
+		if err != nil {
+			h.audit.Record(&AuditEvent{
+				Action:     AuditOIDCLogin,
+				Outcome:    AuditFailure,
+				RemoteAddr: remoteHost(r),
+				Reason:     err.Error(),
+			})
+			http.Error(w, fmt.Sprintf("Login failed: %s", err.Error()), http.StatusUnauthorized)
+			return
+		}
+
+		if reason := h.startSession(w, r, session, AuditOIDCLogin, user); reason != "" {
+			writeForbidden(w, reason)
+			return
+		}
+
+		h.audit.Record(&AuditEvent{
+			Action:     AuditOIDCLogin,
+			Outcome:    AuditSuccess,
+			Username:   user.Username,
+			RemoteAddr: remoteHost(r),
+		})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testIssuer is an OpenID Connect provider which issues an ID token with
+// the configured claims for any authorization code
+type testIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/auth",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "test-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "test-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     issuer.sign(t),
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (i *testIssuer) sign(t *testing.T) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	require.NoError(t, err)
+	claims, err := json.Marshal(i.claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	dir, err := ioutil.TempDir("", "waterfront-oidc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	rolesFile := filepath.Join(dir, "roles.yaml")
+	require.NoError(t, ioutil.WriteFile(rolesFile, []byte("groups:\n  sre: operator\n"), 0644))
+
+	login, err := NewOIDCLogin(context.Background(), OIDCConfig{
+		IssuerURL:   issuer.URL,
+		ClientID:    "waterfront",
+		RedirectURL: "https://controller/api/v1/oidc/callback",
+	}, "/api/v1/oidc/login")
+	require.NoError(t, err)
+
+	a := AuthSessionMiddleware(
+		SessionStore(sessions.NewCookieStore([]byte("test session key"))),
+		Authentication(NoPasswordAuthenticator),
+		OIDC(login),
+		RoleSource(NewRoleStore(rolesFile, nil, nil)))
+
+	// startLogin follows the login route and returns the session cookies, the
+	// state and the nonce sent to the provider
+	startLogin := func(t *testing.T) ([]*http.Cookie, string, string) {
+		rec := httptest.NewRecorder()
+		a.GetOIDCLoginHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/oidc/login", nil))
+		require.Equal(t, http.StatusFound, rec.Code)
+
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, issuer.URL+"/auth", location.Scheme+"://"+location.Host+location.Path)
+		require.Equal(t, "waterfront", location.Query().Get("client_id"))
+
+		return rec.Result().Cookies(), location.Query().Get("state"), location.Query().Get("nonce")
+	}
+
+	callback := func(cookies []*http.Cookie, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/oidc/callback?"+query, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		a.GetOIDCCallbackHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	claims := func(nonce string, groups ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            issuer.URL,
+			"sub":            "1234",
+			"aud":            "waterfront",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          nonce,
+			"email":          "alice@example.org",
+			"email_verified": true,
+			"groups":         groups,
+		}
+	}
+
+	t.Run("MappedGroup_LogsIn", func(t *testing.T) {
+		cookies, state, nonce := startLogin(t)
+		issuer.claims = claims(nonce, "sre", "everyone")
+
+		rec := callback(cookies, "code=test-code&state="+url.QueryEscape(state))
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		require.Equal(t, "/", rec.Header().Get("Location"))
+
+		req := httptest.NewRequest("GET", "/api/v1/login", nil)
+		for _, cookie := range rec.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rec = httptest.NewRecorder()
+		a.GetLoginHandler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var response struct {
+			LoggedIn bool `json:"logged_in"`
+			User     User `json:"user"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		require.True(t, response.LoggedIn)
+		require.Equal(t, "alice@example.org", response.User.Username)
+		require.Equal(t, []Role{RoleOperator}, response.User.Roles)
+	})
+
+	t.Run("UnmappedGroup_IsForbidden", func(t *testing.T) {
+		cookies, state, nonce := startLogin(t)
+		issuer.claims = claims(nonce, "everyone")
+
+		rec := callback(cookies, "code=test-code&state="+url.QueryEscape(state))
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		require.Contains(t, rec.Body.String(), "has not been granted any role")
+	})
+
+	t.Run("WrongState_IsRejected", func(t *testing.T) {
+		cookies, _, nonce := startLogin(t)
+		issuer.claims = claims(nonce, "sre")
+
+		rec := callback(cookies, "code=test-code&state=forged")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("WrongNonce_IsRejected", func(t *testing.T) {
+		cookies, state, _ := startLogin(t)
+		issuer.claims = claims("replayed", "sre")
+
+		rec := callback(cookies, "code=test-code&state="+url.QueryEscape(state))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("PasswordLogin_IsDisabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		a.GetLoginHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/login", nil))
+
+		var response struct {
+			LoggedIn bool   `json:"logged_in"`
+			LoginURL string `json:"login_url"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+		require.False(t, response.LoggedIn)
+		require.Equal(t, "/api/v1/oidc/login", response.LoginURL)
+	})
+}
@@ -0,0 +1,369 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// streamExecutor runs a command in a container, as remotecommand does
+type streamExecutor interface {
+	Stream(options remotecommand.StreamOptions) error
+}
+
+// PodHandlers serve the logs of containers, and interactive sessions in
+// them. Requests are made to the API server as the logged in user.
+type PodHandlers struct {
+	config      *rest.Config
+	newExecutor func(config *rest.Config, method string, url *url.URL) (streamExecutor, error)
+}
+
+func NewPodHandlers(config *rest.Config) *PodHandlers {
+	return &PodHandlers{
+		config: config,
+		newExecutor: func(config *rest.Config, method string, url *url.URL) (streamExecutor, error) {
+			return remotecommand.NewExecutor(config, method, url)
+		},
+	}
+}
+
+// userClient returns a client which acts as the user making a request
+func (h *PodHandlers) userClient(r *http.Request) (*kubernetes.Clientset, *rest.Config) {
+	config := ImpersonatedConfig(h.config, GetUser(r.Context()))
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(errors.Wrap(err, "failed to create kube client"))
+	}
+	return client, config
+}
+
+// writeKubeError passes an error of the API server on to the client
+func writeKubeError(w http.ResponseWriter, err error) {
+	if status, ok := err.(kube_errors.APIStatus); ok && status.Status().Code != 0 {
+		http.Error(w, status.Status().Message, int(status.Status().Code))
+		return
+	}
+	log.Errorf("failed to reach the API server: %v", err)
+	http.Error(w, "Failed to reach the Kubernetes API server", http.StatusBadGateway)
+}
+
+func queryBool(query url.Values, name string) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	return b, errors.Wrapf(err, "invalid %s", name)
+}
+
+func queryInt64(query url.Values, name string) (*int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || i < 0 {
+		return nil, errors.Errorf("invalid %s %q", name, value)
+	}
+	return &i, nil
+}
+
+// podLogOptions reads the options of a log request, which are named as in
+// the Kubernetes API
+func podLogOptions(query url.Values) (*kube_v1.PodLogOptions, error) {
+	opts := &kube_v1.PodLogOptions{Container: query.Get("container")}
+	var err error
+	if opts.Follow, err = queryBool(query, "follow"); err != nil {
+		return nil, err
+	}
+	if opts.Previous, err = queryBool(query, "previous"); err != nil {
+		return nil, err
+	}
+	if opts.Timestamps, err = queryBool(query, "timestamps"); err != nil {
+		return nil, err
+	}
+	if opts.TailLines, err = queryInt64(query, "tailLines"); err != nil {
+		return nil, err
+	}
+	if opts.SinceSeconds, err = queryInt64(query, "sinceSeconds"); err != nil {
+		return nil, err
+	}
+	if opts.SinceSeconds != nil && *opts.SinceSeconds == 0 {
+		return nil, errors.New("sinceSeconds must be positive")
+	}
+	return opts, nil
+}
+
+// LogsHandler streams the log of a container as chunked plain text. With
+// follow=true, the response lasts until the container stops or the client
+// goes away.
+func (h *PodHandlers) LogsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		opts, err := podLogOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		client, _ := h.userClient(r)
+		logs, err := client.CoreV1().Pods(vars["namespace"]).GetLogs(vars["name"], opts).Context(r.Context()).Stream()
+		if err != nil {
+			writeKubeError(w, err)
+			return
+		}
+		defer logs.Close()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		flusher, _ := w.(http.Flusher)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := logs.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				if err != io.EOF && r.Context().Err() == nil {
+					log.Warnf("log stream of %s/%s ended: %v", vars["namespace"], vars["name"], err)
+				}
+				return
+			}
+		}
+	})
+}
+
+// Exec session messages, sent as JSON over a WebSocket
+const (
+	// execStdin, sent by the client, is input for the command
+	execStdin = "stdin"
+	// execResize, sent by the client, is the size of its terminal
+	execResize = "resize"
+	// execStdout and execStderr, sent by waterfront, are command output
+	execStdout = "stdout"
+	execStderr = "stderr"
+	// execExit, sent by waterfront, ends the session
+	execExit = "exit"
+)
+
+type execMessage struct {
+	Type string `json:"type"`
+	// Data is base64 encoded in JSON, so that output may be split in the
+	// middle of a UTF-8 sequence
+	Data   []byte `json:"data,omitempty"`
+	Width  uint16 `json:"width,omitempty"`
+	Height uint16 `json:"height,omitempty"`
+	// ExitCode and Error tell how the command ended
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// execSession connects a WebSocket to the streams of a command
+type execSession struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+
+	stdin *io.PipeReader
+	sizes chan remotecommand.TerminalSize
+	done  chan struct{}
+}
+
+func newExecSession(conn *websocket.Conn) *execSession {
+	stdin, stdinWriter := io.Pipe()
+	s := &execSession{
+		conn:  conn,
+		stdin: stdin,
+		sizes: make(chan remotecommand.TerminalSize, 1),
+		done:  make(chan struct{}),
+	}
+	go s.readMessages(stdinWriter)
+	return s
+}
+
+// readMessages passes input and terminal sizes from the client to the
+// command until the client goes away
+func (s *execSession) readMessages(stdin *io.PipeWriter) {
+	defer close(s.done)
+	defer stdin.Close()
+
+	for {
+		var msg execMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case execStdin:
+			if _, err := stdin.Write(msg.Data); err != nil {
+				return
+			}
+		case execResize:
+			size := remotecommand.TerminalSize{Width: msg.Width, Height: msg.Height}
+			// Only the latest size matters
+			select {
+			case <-s.sizes:
+			default:
+			}
+			s.sizes <- size
+		}
+	}
+}
+
+// Next is the next size of the terminal of the client, or nil once the
+// client has gone away
+func (s *execSession) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-s.sizes:
+		return &size
+	case <-s.done:
+		return nil
+	}
+}
+
+func (s *execSession) send(msg *execMessage) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.conn.WriteJSON(msg)
+}
+
+// execOutput is the stdout or stderr of a command
+type execOutput struct {
+	session *execSession
+	stream  string
+}
+
+func (o execOutput) Write(p []byte) (int, error) {
+	if err := o.session.send(&execMessage{Type: o.stream, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// exitStatus is implemented by the errors of commands which exited with a
+// non-zero status
+type exitStatus interface {
+	ExitStatus() int
+}
+
+var execUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// ExecTarget is the audit target of an exec request: the pod, the container
+// if one is given, and the command
+func ExecTarget(r *http.Request) string {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	target := vars["namespace"] + "/" + vars["name"]
+	if container := query.Get("container"); container != "" {
+		target += "/" + container
+	}
+	return fmt.Sprintf("%s %q", target, query["command"])
+}
+
+// ExecHandler runs a command in a container, and connects it to a WebSocket.
+// The command is given by one or more command parameters. With tty=true it
+// runs in a terminal, and stderr is merged with stdout.
+func (h *PodHandlers) ExecHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		query := r.URL.Query()
+		command := query["command"]
+		if len(command) == 0 {
+			http.Error(w, "no command given", http.StatusBadRequest)
+			return
+		}
+		tty, err := queryBool(query, "tty")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		client, config := h.userClient(r)
+		req := client.CoreV1().RESTClient().Post().
+			Resource("pods").
+			Namespace(vars["namespace"]).
+			Name(vars["name"]).
+			SubResource("exec").
+			VersionedParams(&kube_v1.PodExecOptions{
+				Container: query.Get("container"),
+				Command:   command,
+				Stdin:     true,
+				Stdout:    true,
+				Stderr:    !tty,
+				TTY:       tty,
+			}, api.ParameterCodec)
+		executor, err := h.newExecutor(config, "POST", req.URL())
+		if err != nil {
+			panic(errors.Wrap(err, "failed to create executor"))
+		}
+
+		conn, err := execUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader has written the error response
+			return
+		}
+		defer conn.Close()
+
+		session := newExecSession(conn)
+		opts := remotecommand.StreamOptions{
+			SupportedProtocols: remotecommandconsts.SupportedStreamingProtocols,
+			Stdin:              session.stdin,
+			Stdout:             execOutput{session, execStdout},
+			Tty:                tty,
+		}
+		if tty {
+			opts.TerminalSizeQueue = session
+		} else {
+			opts.Stderr = execOutput{session, execStderr}
+		}
+
+		exit := &execMessage{Type: execExit}
+		if err := executor.Stream(opts); err != nil {
+			exit.Error = err.Error()
+			exit.ExitCode = -1
+			if status, ok := err.(exitStatus); ok {
+				exit.ExitCode = status.ExitStatus()
+			}
+		}
+		session.send(exit)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	})
+}
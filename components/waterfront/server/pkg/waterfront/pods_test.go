@@ -0,0 +1,185 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// podTestServer serves the pod handlers to a user, the way main does
+func podTestServer(h *PodHandlers, user *User) *httptest.Server {
+	router := mux.NewRouter()
+	router.Path("/api/v1/pods/{namespace}/{name}/log").Handler(h.LogsHandler())
+	router.Path("/api/v1/pods/{namespace}/{name}/exec").Handler(h.ExecHandler())
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextKeyuser, user)))
+	}))
+}
+
+// exitError is a command which exited with a status
+type exitError int
+
+func (e exitError) Error() string {
+	return "command terminated with non-zero exit code"
+}
+
+func (e exitError) ExitStatus() int {
+	return int(e)
+}
+
+// echoExecutor echoes a line of input, then exits with status 3
+type echoExecutor struct {
+	config *rest.Config
+	url    *url.URL
+}
+
+func (e *echoExecutor) Stream(opts remotecommand.StreamOptions) error {
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(opts.Stdin, buf); err != nil {
+		return err
+	}
+	opts.Stdout.Write(buf)
+	return exitError(3)
+}
+
+func TestPodLogs(t *testing.T) {
+	var kubeReq *http.Request
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kubeReq = r
+		if r.URL.Path != "/api/v1/namespaces/default/pods/web-1/log" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Failure", "message": "pods \"web-2\" not found", "reason": "NotFound", "code": 404}`))
+			return
+		}
+		w.Write([]byte("line 1\nline 2\n"))
+	}))
+	defer apiServer.Close()
+
+	user := &User{Username: "jane", Roles: []Role{RoleViewer}}
+	server := podTestServer(NewPodHandlers(&rest.Config{Host: apiServer.URL}), user)
+	defer server.Close()
+
+	t.Run("Logs_AsUser", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/api/v1/pods/default/web-1/log?container=web&tailLines=10&follow=true")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "line 1\nline 2\n", string(body))
+		require.Equal(t, "jane", kubeReq.Header.Get("Impersonate-User"))
+		require.Equal(t, []string{"system:authenticated", "operos:viewer"}, kubeReq.Header["Impersonate-Group"])
+		require.Equal(t, "web", kubeReq.URL.Query().Get("container"))
+		require.Equal(t, "10", kubeReq.URL.Query().Get("tailLines"))
+		require.Equal(t, "true", kubeReq.URL.Query().Get("follow"))
+	})
+
+	t.Run("Logs_NotFound", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/api/v1/pods/default/web-2/log")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("Logs_BadOption", func(t *testing.T) {
+		res, err := http.Get(server.URL + "/api/v1/pods/default/web-1/log?tailLines=-1")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestPodExec(t *testing.T) {
+	executor := &echoExecutor{}
+	handlers := &PodHandlers{
+		config: &rest.Config{Host: "https://10.57.0.1:6443"},
+		newExecutor: func(config *rest.Config, method string, url *url.URL) (streamExecutor, error) {
+			executor.config = config
+			executor.url = url
+			return executor, nil
+		},
+	}
+	user := &User{Username: "jane", Roles: []Role{RoleOperator}}
+	server := podTestServer(handlers, user)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/v1/pods/default/web-1/exec"
+
+	t.Run("Exec_EchoesAndExits", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?command=cat&container=web", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteJSON(&execMessage{Type: execStdin, Data: []byte("hello")}))
+
+		var msg execMessage
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, execStdout, msg.Type)
+		require.Equal(t, "hello", string(msg.Data))
+
+		msg = execMessage{}
+		require.NoError(t, conn.ReadJSON(&msg))
+		require.Equal(t, execExit, msg.Type)
+		require.Equal(t, 3, msg.ExitCode)
+
+		require.Equal(t, "jane", executor.config.Impersonate.UserName)
+		require.Equal(t, "/api/v1/namespaces/default/pods/web-1/exec", executor.url.Path)
+		require.Equal(t, []string{"cat"}, executor.url.Query()["command"])
+		require.Equal(t, "web", executor.url.Query().Get("container"))
+	})
+
+	t.Run("Exec_NeedsCommand", func(t *testing.T) {
+		_, res, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestExecTarget(t *testing.T) {
+	var target string
+	router := mux.NewRouter()
+	router.Path("/api/v1/pods/{namespace}/{name}/exec").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = ExecTarget(r)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET",
+		"/api/v1/pods/default/web-1/exec?command=sh&command=-c&command=rm+-rf+%2Ftmp%2Fx&container=web", nil))
+	require.Equal(t, `default/web-1/web ["sh" "-c" "rm -rf /tmp/x"]`, target)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/pods/default/web-1/exec?command=ls", nil))
+	require.Equal(t, `default/web-1 ["ls"]`, target)
+}
+
+func TestKubeGroups(t *testing.T) {
+	require.Equal(t, []string{"system:authenticated", "operos:admin", "operos:viewer"},
+		KubeGroups(&User{Username: "root", Roles: []Role{RoleViewer, RoleAdmin}}))
+	require.Equal(t, []string{"system:authenticated"}, KubeGroups(&User{Username: "nobody"}))
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Role is a set of permissions in waterfront. Roles are hierarchical: every
+// role is granted everything the roles below it are.
+type Role string
+
+const (
+	// RoleViewer can see the state of the cluster
+	RoleViewer = Role("viewer")
+	// RoleOperator can also act on nodes and workloads
+	RoleOperator = Role("operator")
+	// RoleAdmin can also change cluster settings and credentials
+	RoleAdmin = Role("admin")
+)
+
+var roleLevels = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := roleLevels[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", name)
+	}
+	return role, nil
+}
+
+// Includes tells whether the role grants everything other does
+func (r Role) Includes(other Role) bool {
+	level, ok := roleLevels[r]
+	return ok && level >= roleLevels[other]
+}
+
+// HasRole tells whether any of roles grants required
+func HasRole(roles []Role, required Role) bool {
+	for _, role := range roles {
+		if role.Includes(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRolesFile is where role bindings are stored on the controller. The
+// waterfront container links it to the host's copy under /etc-host.
+const DefaultRolesFile = "/etc/paxautoma/waterfront-roles.yaml"
+
+// RoleBindings assigns roles to users and groups. It is read from a YAML
+// file such as:
+//
+//   users:
+//     alice: admin
+//   groups:
+//     wheel: admin
+//     ops: operator
+//   default: viewer
+//
+// Groups are the Unix groups on the controller, or the groups reported by the
+// LDAP server or OpenID Connect provider users log in with. Default, if set,
+// is granted to every user who can log in.
+type RoleBindings struct {
+	Users   map[string]Role `yaml:"users"`
+	Groups  map[string]Role `yaml:"groups"`
+	Default Role            `yaml:"default"`
+}
+
+// DefaultRoleBindings are used with PAM when there is no roles file, so that
+// a fresh install can only be administered by root and members of wheel.
+var DefaultRoleBindings = RoleBindings{
+	Users:  map[string]Role{"root": RoleAdmin},
+	Groups: map[string]Role{"wheel": RoleAdmin},
+}
+
+// ParseRoleBindings reads role bindings from YAML
+func ParseRoleBindings(data []byte) (*RoleBindings, error) {
+	var bindings RoleBindings
+	if err := yaml.Unmarshal(data, &bindings); err != nil {
+		return nil, errors.Wrap(err, "invalid roles file")
+	}
+
+	for name, role := range bindings.Users {
+		if _, err := ParseRole(string(role)); err != nil {
+			return nil, errors.Wrapf(err, "user %s", name)
+		}
+	}
+	for name, role := range bindings.Groups {
+		if _, err := ParseRole(string(role)); err != nil {
+			return nil, errors.Wrapf(err, "group %s", name)
+		}
+	}
+	if bindings.Default != "" {
+		if _, err := ParseRole(string(bindings.Default)); err != nil {
+			return nil, errors.Wrap(err, "default")
+		}
+	}
+
+	return &bindings, nil
+}
+
+// Roles returns the roles bound to a user who is a member of groups, highest
+// first
+func (b *RoleBindings) Roles(username string, groups []string) []Role {
+	seen := make(map[Role]bool)
+	if role, ok := b.Users[username]; ok {
+		seen[role] = true
+	}
+	for _, group := range groups {
+		if role, ok := b.Groups[group]; ok {
+			seen[role] = true
+		}
+	}
+	if b.Default != "" {
+		seen[b.Default] = true
+	}
+
+	roles := make([]Role, 0, len(seen))
+	for role := range seen {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roleLevels[roles[i]] > roleLevels[roles[j]] })
+	return roles
+}
+
+// GroupSource looks up the groups a user belongs to
+type GroupSource interface {
+	Groups(username string) ([]string, error)
+}
+
+type GroupSourceFunc func(username string) ([]string, error)
+
+func (f GroupSourceFunc) Groups(username string) ([]string, error) {
+	return f(username)
+}
+
+// UnixGroups looks up groups in the host's /etc/group
+var UnixGroups = GroupSourceFunc(func(username string) ([]string, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		if _, ok := err.(user.UnknownUserError); ok {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to look up user %s", username)
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to look up groups of %s", username)
+	}
+
+	groups := make([]string, 0, len(gids))
+	for _, gid := range gids {
+		group, err := user.LookupGroupId(gid)
+		if err != nil {
+			log.Warnf("failed to look up group %s of user %s: %v", gid, username, err)
+			continue
+		}
+		groups = append(groups, group.Name)
+	}
+	return groups, nil
+})
+
+// RoleStore resolves the roles of users from a roles file. The file is read
+// again whenever it changes, so that revoking a role takes effect on the next
+// request rather than the next login. Groups are looked up in a GroupSource,
+// and may also be supplied by the authenticator which logged the user in.
+type RoleStore struct {
+	path     string
+	groups   GroupSource
+	defaults *RoleBindings
+
+	lock     sync.Mutex
+	bindings *RoleBindings
+	modTime  time.Time
+	loaded   bool
+}
+
+// NewRoleStore creates a RoleStore. groups may be nil when users are
+// authenticated externally and their groups come from the authenticator.
+// defaults are used while there is no roles file; when nil, nobody has a role
+// until one is written, since root and wheel mean nothing to an external
+// directory.
+func NewRoleStore(path string, groups GroupSource, defaults *RoleBindings) *RoleStore {
+	if defaults == nil {
+		defaults = &RoleBindings{}
+	}
+	return &RoleStore{
+		path:     path,
+		groups:   groups,
+		defaults: defaults,
+	}
+}
+
+func (s *RoleStore) currentBindings() (*RoleBindings, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return s.defaults, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read roles file")
+	}
+
+	if s.loaded && info.ModTime().Equal(s.modTime) {
+		return s.bindings, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read roles file")
+	}
+
+	// A broken roles file denies everyone rather than falling back to the
+	// defaults, which could grant more than the file intended
+	bindings, err := ParseRoleBindings(data)
+	if err != nil {
+		return nil, errors.Wrap(err, s.path)
+	}
+
+	log.Infof("loaded role bindings from %s", s.path)
+	s.bindings = bindings
+	s.modTime = info.ModTime()
+	s.loaded = true
+	return bindings, nil
+}
+
+// Roles returns the roles of a user who is a member of extraGroups in
+// addition to the groups known to the store, highest first
+func (s *RoleStore) Roles(username string, extraGroups []string) ([]Role, error) {
+	bindings, err := s.currentBindings()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := extraGroups
+	if s.groups != nil {
+		localGroups, err := s.groups.Groups(username)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(localGroups, extraGroups...)
+	}
+
+	return bindings.Roles(username, groups), nil
+}
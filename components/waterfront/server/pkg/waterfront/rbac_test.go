@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestRoleIncludes(t *testing.T) {
+	require.True(t, RoleAdmin.Includes(RoleOperator))
+	require.True(t, RoleOperator.Includes(RoleOperator))
+	require.False(t, RoleViewer.Includes(RoleOperator))
+	require.False(t, Role("superuser").Includes(RoleViewer))
+}
+
+func TestParseRoleBindings(t *testing.T) {
+	bindings, err := ParseRoleBindings([]byte(`
+users:
+  alice: operator
+groups:
+  wheel: admin
+default: viewer
+`))
+	require.NoError(t, err)
+
+	require.Equal(t, []Role{RoleAdmin, RoleOperator, RoleViewer}, bindings.Roles("alice", []string{"wheel"}))
+	require.Equal(t, []Role{RoleViewer}, bindings.Roles("bob", nil))
+
+	_, err = ParseRoleBindings([]byte("users:\n  alice: superuser\n"))
+	require.Error(t, err)
+}
+
+func TestRoleStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waterfront-roles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rolesFile := filepath.Join(dir, "roles.yaml")
+	groups := GroupSourceFunc(func(username string) ([]string, error) {
+		if username == "carol" {
+			return []string{"wheel"}, nil
+		}
+		return nil, nil
+	})
+	store := NewRoleStore(rolesFile, groups, &DefaultRoleBindings)
+
+	t.Run("MissingFile_UsesDefaults", func(t *testing.T) {
+		roles, err := store.Roles("root", nil)
+		require.NoError(t, err)
+		require.Equal(t, []Role{RoleAdmin}, roles)
+
+		roles, err = store.Roles("carol", nil)
+		require.NoError(t, err)
+		require.Equal(t, []Role{RoleAdmin}, roles)
+
+		roles, err = store.Roles("alice", nil)
+		require.NoError(t, err)
+		require.Empty(t, roles)
+	})
+
+	t.Run("MissingFile_WithoutDefaults_GrantsNothing", func(t *testing.T) {
+		external := NewRoleStore(rolesFile, nil, nil)
+
+		roles, err := external.Roles("root", []string{"wheel"})
+		require.NoError(t, err)
+		require.Empty(t, roles)
+	})
+
+	t.Run("ChangedFile_IsReloaded", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(rolesFile, []byte("users:\n  alice: admin\n"), 0644))
+
+		roles, err := store.Roles("alice", nil)
+		require.NoError(t, err)
+		require.Equal(t, []Role{RoleAdmin}, roles)
+
+		require.NoError(t, ioutil.WriteFile(rolesFile, []byte("users:\n  alice: viewer\n"), 0644))
+		later := time.Now().Add(time.Second)
+		require.NoError(t, os.Chtimes(rolesFile, later, later))
+
+		roles, err = store.Roles("alice", nil)
+		require.NoError(t, err)
+		require.Equal(t, []Role{RoleViewer}, roles)
+	})
+
+	t.Run("InvalidFile_DeniesEveryone", func(t *testing.T) {
+		require.NoError(t, ioutil.WriteFile(rolesFile, []byte("users: [\n"), 0644))
+		later := time.Now().Add(2 * time.Second)
+		require.NoError(t, os.Chtimes(rolesFile, later, later))
+
+		_, err := store.Roles("root", nil)
+		require.Error(t, err)
+	})
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(RoleOperator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name     string
+		user     *User
+		wantCode int
+	}{
+		{"NotLoggedIn", nil, http.StatusForbidden},
+		{"Viewer", &User{Username: "bob", Roles: []Role{RoleViewer}}, http.StatusForbidden},
+		{"Operator", &User{Username: "alice", Roles: []Role{RoleOperator}}, http.StatusNoContent},
+		{"Admin", &User{Username: "root", Roles: []Role{RoleAdmin}}, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/nodes", nil)
+			if tt.user != nil {
+				req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, tt.user))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusForbidden {
+				require.Contains(t, rec.Body.String(), `"error"`)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+const (
+	// DefaultReconcileInterval is how often node metadata is applied to
+	// Kubernetes when nothing asks for it sooner
+	DefaultReconcileInterval = 30 * time.Second
+
+	RackLabel = "operos.paxautoma.com/rack"
+	ZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+	// The annotations below record what was applied, so that labels and
+	// taints removed from the metadata can be removed from the node without
+	// touching those set by anyone else
+	managedLabelsAnnotation = "operos.paxautoma.com/managed-labels"
+	managedTaintsAnnotation = "operos.paxautoma.com/managed-taints"
+	nameAnnotation          = "operos.paxautoma.com/name"
+
+	// uuidAnnotation ties a Kubernetes node to the node registered in
+	// teamster, which keeps its UUID when it is renamed
+	uuidAnnotation = "operos.paxautoma.com/uuid"
+)
+
+// NodeReconciler applies the node metadata kept in teamster to the labels
+// and taints of Kubernetes nodes. Since a node which registers again gets a
+// fresh Kubernetes node, the metadata is applied over and over rather than
+// only when it is set.
+type NodeReconciler struct {
+	teamsterClient teamster_proto.TeamsterClient
+	kubeClient     *kubernetes.Clientset
+	interval       time.Duration
+	kick           chan struct{}
+}
+
+func NewNodeReconciler(teamsterClient teamster_proto.TeamsterClient, kubeClient *kubernetes.Clientset) *NodeReconciler {
+	return &NodeReconciler{
+		teamsterClient: teamsterClient,
+		kubeClient:     kubeClient,
+		interval:       DefaultReconcileInterval,
+		kick:           make(chan struct{}, 1),
+	}
+}
+
+// Run reconciles nodes until stop is closed
+func (r *NodeReconciler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.reconcile(); err != nil {
+			log.Errorf("failed to apply node metadata: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+	}
+}
+
+// Kick makes the reconciler run soon, rather than at the next interval
+func (r *NodeReconciler) Kick() {
+	if r == nil {
+		return
+	}
+
+	select {
+	case r.kick <- struct{}{}:
+	default:
+		// A run is already pending
+	}
+}
+
+func (r *NodeReconciler) reconcile() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+
+	res, err := r.teamsterClient.ListNodes(ctx, &teamster_proto.Empty{})
+	if err != nil {
+		return err
+	}
+
+	kubeNodeList, err := r.kubeClient.CoreV1().Nodes().List(meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	kubeNodes := make(map[string]*kube_v1.Node)
+	for idx := range kubeNodeList.Items {
+		kubeNodes[kubeNodeList.Items[idx].Name] = &kubeNodeList.Items[idx]
+	}
+
+	hostnames := make(map[string]string, len(res.Nodes))
+	for _, node := range res.Nodes {
+		hostname := teamsterHostname(node)
+		hostnames[node.Uuid] = hostname
+		kubeNode, ok := kubeNodes[hostname]
+		if !ok {
+			// Applied once it joins
+			continue
+		}
+
+		patch, err := metadataPatch(kubeNode, node.Uuid, metadataFromTeamster(node.Metadata))
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			continue
+		}
+
+		// A conflict means the node changed since it was listed, and it is
+		// tried again the next time round
+		if _, err := r.kubeClient.CoreV1().Nodes().Patch(hostname, types.MergePatchType, patch); err != nil {
+			log.Errorf("failed to apply metadata to node %s: %v", hostname, err)
+			continue
+		}
+		log.Infof("applied metadata to node %s", hostname)
+	}
+
+	// A renamed node registers again under its new name when it reboots,
+	// which leaves the Kubernetes node under the old name behind
+	for name, kubeNode := range kubeNodes {
+		hostname, ok := hostnames[kubeNode.Annotations[uuidAnnotation]]
+		if !ok || hostname == name {
+			continue
+		}
+		if _, joined := kubeNodes[hostname]; !joined {
+			continue
+		}
+
+		if err := r.kubeClient.CoreV1().Nodes().Delete(name, &meta_v1.DeleteOptions{}); err != nil {
+			log.Errorf("failed to remove node %s, renamed to %s: %v", name, hostname, err)
+			continue
+		}
+		log.Infof("removed node %s, which was renamed to %s", name, hostname)
+	}
+	return nil
+}
+
+// metadataPatch returns a JSON merge patch which brings the labels and
+// taints of a node in line with its metadata, or nil if they already are.
+// The patch also records the UUID of the node.
+// md is nil for nodes without metadata, whose managed labels and taints are
+// removed.
+func metadataPatch(kubeNode *kube_v1.Node, uuid string, md *NodeMetadata) ([]byte, error) {
+	if md == nil {
+		md = &NodeMetadata{}
+	}
+
+	desiredLabels := make(map[string]string, len(md.Labels)+2)
+	for key, value := range md.Labels {
+		desiredLabels[key] = value
+	}
+	if md.Rack != "" {
+		desiredLabels[RackLabel] = md.Rack
+	}
+	if md.Zone != "" {
+		desiredLabels[ZoneLabel] = md.Zone
+	}
+
+	labels := make(map[string]interface{})
+	for key, value := range desiredLabels {
+		if current, ok := kubeNode.Labels[key]; !ok || current != value {
+			labels[key] = value
+		}
+	}
+	for _, key := range splitAnnotation(kubeNode.Annotations[managedLabelsAnnotation]) {
+		if _, ok := desiredLabels[key]; ok {
+			continue
+		}
+		if _, ok := kubeNode.Labels[key]; ok {
+			labels[key] = nil
+		}
+	}
+
+	desiredTaints := make([]kube_v1.Taint, 0, len(md.Taints))
+	replaced := make(map[string]bool)
+	for _, key := range splitAnnotation(kubeNode.Annotations[managedTaintsAnnotation]) {
+		replaced[key] = true
+	}
+	var managedTaints []string
+	for _, taint := range md.Taints {
+		desiredTaints = append(desiredTaints, kube_v1.Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: kube_v1.TaintEffect(taint.Effect),
+		})
+		replaced[taintKey(taint.Key, taint.Effect)] = true
+		managedTaints = append(managedTaints, taintKey(taint.Key, taint.Effect))
+	}
+	var taints []kube_v1.Taint
+	for _, taint := range kubeNode.Spec.Taints {
+		if !replaced[taintKey(taint.Key, string(taint.Effect))] {
+			taints = append(taints, taint)
+		}
+	}
+	taints = append(taints, desiredTaints...)
+
+	managedLabels := make([]string, 0, len(desiredLabels))
+	for key := range desiredLabels {
+		managedLabels = append(managedLabels, key)
+	}
+	annotations := make(map[string]interface{})
+	for key, value := range map[string]string{
+		managedLabelsAnnotation: joinAnnotation(managedLabels),
+		managedTaintsAnnotation: joinAnnotation(managedTaints),
+		nameAnnotation:          md.Name,
+		uuidAnnotation:          uuid,
+	} {
+		if kubeNode.Annotations[key] == value {
+			continue
+		}
+		if value == "" {
+			annotations[key] = nil
+		} else {
+			annotations[key] = value
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"resourceVersion": kubeNode.ResourceVersion,
+	}
+	patch := map[string]interface{}{"metadata": metadata}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	if !sameTaints(taints, kubeNode.Spec.Taints) {
+		// Lists are replaced as a whole by a merge patch
+		patch["spec"] = map[string]interface{}{"taints": taints}
+	}
+
+	if len(patch) == 1 && len(metadata) == 1 {
+		return nil, nil
+	}
+	return json.Marshal(patch)
+}
+
+func taintKey(key, effect string) string {
+	return key + ":" + effect
+}
+
+func sameTaints(a, b []kube_v1.Taint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx].Key != b[idx].Key || a[idx].Value != b[idx].Value || a[idx].Effect != b[idx].Effect {
+			return false
+		}
+	}
+	return true
+}
+
+func splitAnnotation(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func joinAnnotation(values []string) string {
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
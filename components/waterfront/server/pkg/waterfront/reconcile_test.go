@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+)
+
+// applyPatch applies a merge patch produced by metadataPatch to a node
+func applyPatch(t *testing.T, kubeNode *kube_v1.Node, patch []byte) {
+	var parsed struct {
+		Metadata struct {
+			Labels      map[string]*string `json:"labels"`
+			Annotations map[string]*string `json:"annotations"`
+		} `json:"metadata"`
+		Spec *struct {
+			Taints []kube_v1.Taint `json:"taints"`
+		} `json:"spec"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &parsed))
+
+	apply := func(target map[string]string, changes map[string]*string) map[string]string {
+		if target == nil {
+			target = make(map[string]string)
+		}
+		for key, value := range changes {
+			if value == nil {
+				delete(target, key)
+			} else {
+				target[key] = *value
+			}
+		}
+		return target
+	}
+	kubeNode.Labels = apply(kubeNode.Labels, parsed.Metadata.Labels)
+	kubeNode.Annotations = apply(kubeNode.Annotations, parsed.Metadata.Annotations)
+	if parsed.Spec != nil {
+		kubeNode.Spec.Taints = parsed.Spec.Taints
+	}
+}
+
+func TestMetadataPatch(t *testing.T) {
+	kubeNode := &kube_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:            "a",
+			ResourceVersion: "7",
+			Labels:          map[string]string{"kubernetes.io/hostname": "node-1"},
+		},
+		Spec: kube_v1.NodeSpec{
+			Taints: []kube_v1.Taint{{Key: "node.alpha.kubernetes.io/notReady", Effect: kube_v1.TaintEffectNoExecute}},
+		},
+	}
+
+	t.Run("NoMetadata_RecordsUUID", func(t *testing.T) {
+		patch, err := metadataPatch(kubeNode, "u1", nil)
+		require.NoError(t, err)
+		applyPatch(t, kubeNode, patch)
+		require.Equal(t, "u1", kubeNode.Annotations[uuidAnnotation])
+
+		patch, err = metadataPatch(kubeNode, "u1", nil)
+		require.NoError(t, err)
+		require.Nil(t, patch)
+	})
+
+	t.Run("Metadata_IsApplied", func(t *testing.T) {
+		patch, err := metadataPatch(kubeNode, "u1", &NodeMetadata{
+			Name:   "storage-1",
+			Labels: map[string]string{"role": "storage"},
+			Taints: []*Taint{{Key: "dedicated", Value: "storage", Effect: "NoSchedule"}},
+			Rack:   "r1",
+			Zone:   "east",
+		})
+		require.NoError(t, err)
+		require.Contains(t, string(patch), `"resourceVersion":"7"`)
+		applyPatch(t, kubeNode, patch)
+
+		require.Equal(t, map[string]string{
+			"kubernetes.io/hostname": "node-1",
+			"role":                   "storage",
+			RackLabel:                "r1",
+			ZoneLabel:                "east",
+		}, kubeNode.Labels)
+		require.Len(t, kubeNode.Spec.Taints, 2)
+		require.Equal(t, "dedicated", kubeNode.Spec.Taints[1].Key)
+		require.Equal(t, "storage-1", kubeNode.Annotations[nameAnnotation])
+	})
+
+	t.Run("AppliedMetadata_IsNoop", func(t *testing.T) {
+		patch, err := metadataPatch(kubeNode, "u1", &NodeMetadata{
+			Name:   "storage-1",
+			Labels: map[string]string{"role": "storage"},
+			Taints: []*Taint{{Key: "dedicated", Value: "storage", Effect: "NoSchedule"}},
+			Rack:   "r1",
+			Zone:   "east",
+		})
+		require.NoError(t, err)
+		require.Nil(t, patch)
+	})
+
+	t.Run("RemovedMetadata_IsRemoved", func(t *testing.T) {
+		patch, err := metadataPatch(kubeNode, "u1", &NodeMetadata{Labels: map[string]string{"role": "compute"}})
+		require.NoError(t, err)
+		applyPatch(t, kubeNode, patch)
+
+		require.Equal(t, map[string]string{
+			"kubernetes.io/hostname": "node-1",
+			"role":                   "compute",
+		}, kubeNode.Labels)
+		require.Len(t, kubeNode.Spec.Taints, 1)
+		require.Equal(t, "node.alpha.kubernetes.io/notReady", kubeNode.Spec.Taints[0].Key)
+		require.NotContains(t, kubeNode.Annotations, nameAnnotation)
+		require.Equal(t, "role", kubeNode.Annotations[managedLabelsAnnotation])
+	})
+
+	t.Run("OtherLabels_AreLeftAlone", func(t *testing.T) {
+		kubeNode.Labels["team"] = "infra"
+		patch, err := metadataPatch(kubeNode, "u1", nil)
+		require.NoError(t, err)
+		applyPatch(t, kubeNode, patch)
+
+		require.Equal(t, map[string]string{
+			"kubernetes.io/hostname": "node-1",
+			"team":                   "infra",
+		}, kubeNode.Labels)
+	})
+}
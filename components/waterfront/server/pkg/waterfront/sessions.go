@@ -0,0 +1,471 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var sessionsBucket = []byte("sessions")
+
+// sessionRecord is a session as stored in the database
+type sessionRecord struct {
+	Values     map[interface{}]interface{}
+	Username   string
+	Created    time.Time
+	LastSeen   time.Time
+	Expires    time.Time
+	RemoteAddr string
+	UserAgent  string
+}
+
+// SessionInfo describes an active session to administrators
+type SessionInfo struct {
+	// ID identifies the session without granting access to it
+	ID         string    `json:"id"`
+	Username   string    `json:"username"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"last_seen"`
+	Expires    time.Time `json:"expires"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+}
+
+// SessionManager is implemented by session stores which can list and revoke
+// sessions
+type SessionManager interface {
+	Sessions() ([]*SessionInfo, error)
+	// Revoke ends the session with the given public ID
+	Revoke(id string) (bool, error)
+	// RevokeUser ends all sessions of a user
+	RevokeUser(username string) (int, error)
+}
+
+// SessionRenewer is implemented by session stores which keep sessions on the
+// server. Renew drops the stored session so that the next Save issues a new
+// ID, which keeps an ID planted in the browser before login from becoming
+// authenticated.
+type SessionRenewer interface {
+	Renew(session *sessions.Session) error
+}
+
+// SessionPublicID derives the ID shown to administrators from a session ID
+func SessionPublicID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// BoltStore keeps sessions in a bbolt database. The cookie only carries the
+// signed session ID, so sessions can be listed and revoked on the server.
+type BoltStore struct {
+	Options *sessions.Options
+	// SecureCookies sets the Secure flag on all cookies. Otherwise it is set
+	// when the request arrived over HTTPS.
+	SecureCookies bool
+
+	db     *bolt.DB
+	codecs []securecookie.Codec
+	now    func() time.Time
+	stop   chan struct{}
+}
+
+// NewBoltStore opens the session database at path. Sessions expire after
+// maxAge. keyPairs sign the session cookies, as for sessions.NewCookieStore.
+func NewBoltStore(path string, maxAge time.Duration, keyPairs ...[]byte) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create session directory")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open session database %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize session database")
+	}
+
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(maxAge.Seconds()))
+		}
+	}
+
+	s := &BoltStore{
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   int(maxAge.Seconds()),
+			HttpOnly: true,
+		},
+		db:     db,
+		codecs: codecs,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	go s.cleanupLoop(time.Hour)
+
+	return s, nil
+}
+
+func (s *BoltStore) Close() error {
+	close(s.stop)
+	return s.db.Close()
+}
+
+func (s *BoltStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *BoltStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+
+	record, err := s.load(id)
+	if err != nil || record == nil {
+		// The session expired or was revoked; a new one is started
+		return session, err
+	}
+
+	session.ID = id
+	session.Values = record.Values
+	session.IsNew = false
+
+	// Only note activity once a minute, rather than writing on every request
+	if s.now().Sub(record.LastSeen) > time.Minute {
+		if err := s.update(id, func(record *sessionRecord) {
+			record.LastSeen = s.now()
+			record.RemoteAddr = remoteHost(r)
+		}); err != nil {
+			log.Errorf("failed to update session: %v", err)
+		}
+	}
+
+	return session, nil
+}
+
+func (s *BoltStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete([]byte(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, s.cookie(r, session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := randomHex(32)
+		if err != nil {
+			return errors.Wrap(err, "failed to generate session ID")
+		}
+		session.ID = id
+	}
+
+	now := s.now()
+	err := s.update(session.ID, func(record *sessionRecord) {
+		if record.Created.IsZero() {
+			record.Created = now
+		}
+		record.Values = session.Values
+		record.LastSeen = now
+		record.Expires = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+		record.RemoteAddr = remoteHost(r)
+		record.UserAgent = r.UserAgent()
+		record.Username = ""
+		switch user := session.Values["user"].(type) {
+		case User:
+			record.Username = user.Username
+		case *User:
+			record.Username = user.Username
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode session cookie")
+	}
+	http.SetCookie(w, s.cookie(r, session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *BoltStore) cookie(r *http.Request, name, value string, options *sessions.Options) *http.Cookie {
+	cookie := sessions.NewCookie(name, value, options)
+	cookie.HttpOnly = true
+	cookie.Secure = s.SecureCookies || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+	// Lax rather than Strict, because logging in through an OIDC provider
+	// returns to waterfront with a cross-site redirect
+	cookie.SameSite = http.SameSiteLaxMode
+	return cookie
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func encodeSessionRecord(record *sessionRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return nil, errors.Wrap(err, "failed to encode session")
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeSessionRecord(data []byte) (*sessionRecord, error) {
+	var record sessionRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		return nil, errors.Wrap(err, "failed to decode session")
+	}
+	return &record, nil
+}
+
+// load returns the session with the given ID, or nil if it does not exist or
+// has expired
+func (s *BoltStore) load(id string) (*sessionRecord, error) {
+	var record *sessionRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		record, err = decodeSessionRecord(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if record != nil && record.Expires.Before(s.now()) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+func (s *BoltStore) update(id string, modify func(*sessionRecord)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+
+		record := &sessionRecord{}
+		if data := bucket.Get([]byte(id)); data != nil {
+			var err error
+			if record, err = decodeSessionRecord(data); err != nil {
+				return err
+			}
+		}
+
+		modify(record)
+
+		data, err := encodeSessionRecord(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func (s *BoltStore) delete(id []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete(id)
+	})
+}
+
+// deleteWhere deletes the sessions matching a condition and returns how many
+// were deleted
+func (s *BoltStore) deleteWhere(match func(id string, record *sessionRecord) bool) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+
+		var ids [][]byte
+		err := bucket.ForEach(func(id, data []byte) error {
+			record, err := decodeSessionRecord(data)
+			if err != nil || match(string(id), record) {
+				ids = append(ids, append([]byte(nil), id...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		count = len(ids)
+		return nil
+	})
+	return count, err
+}
+
+func (s *BoltStore) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			now := s.now()
+			count, err := s.deleteWhere(func(id string, record *sessionRecord) bool {
+				return record.Expires.Before(now)
+			})
+			if err != nil {
+				log.Errorf("failed to clean up sessions: %v", err)
+			} else if count > 0 {
+				log.Infof("removed %d expired sessions", count)
+			}
+		}
+	}
+}
+
+func (s *BoltStore) Sessions() ([]*SessionInfo, error) {
+	var infos []*SessionInfo
+	now := s.now()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(id, data []byte) error {
+			record, err := decodeSessionRecord(data)
+			if err != nil {
+				log.Errorf("skipping unreadable session: %v", err)
+				return nil
+			}
+			// Sessions without a user belong to logins in progress
+			if record.Expires.Before(now) || record.Username == "" {
+				return nil
+			}
+
+			infos = append(infos, &SessionInfo{
+				ID:         SessionPublicID(string(id)),
+				Username:   record.Username,
+				Created:    record.Created,
+				LastSeen:   record.LastSeen,
+				Expires:    record.Expires,
+				RemoteAddr: record.RemoteAddr,
+				UserAgent:  record.UserAgent,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LastSeen.After(infos[j].LastSeen) })
+	return infos, nil
+}
+
+func (s *BoltStore) Revoke(publicID string) (bool, error) {
+	count, err := s.deleteWhere(func(id string, record *sessionRecord) bool {
+		return SessionPublicID(id) == publicID
+	})
+	return count > 0, err
+}
+
+func (s *BoltStore) Renew(session *sessions.Session) error {
+	if session.ID != "" {
+		if err := s.delete([]byte(session.ID)); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+func (s *BoltStore) RevokeUser(username string) (int, error) {
+	return s.deleteWhere(func(id string, record *sessionRecord) bool {
+		return record.Username == username
+	})
+}
+
+// LoadOrCreateSessionKey reads the session key from path, generating and
+// storing a random key on first start
+func LoadOrCreateSessionKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		key = []byte(strings.TrimSpace(string(key)))
+		if len(key) == 0 {
+			return nil, errors.Errorf("session key file %s is empty", path)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read session key")
+	}
+
+	generated, err := randomHex(32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate session key")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create session key directory")
+	}
+	if err := ioutil.WriteFile(path, []byte(generated+"\n"), 0600); err != nil {
+		return nil, errors.Wrap(err, "failed to store session key")
+	}
+
+	log.Infof("generated a new session key in %s", path)
+	return []byte(generated), nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestBoltStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "waterfront-sessions")
+	require.NoError(t, err)
+
+	store, err := NewBoltStore(filepath.Join(dir, "sessions.db"), time.Hour, []byte("test session key"))
+	require.NoError(t, err)
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// loginAs saves a session for username and returns its cookie
+func loginAs(t *testing.T, store *BoltStore, username string) *http.Cookie {
+	req := httptest.NewRequest("POST", "/api/v1/login", nil)
+	session, err := store.New(req, "operos-waterfront")
+	require.NoError(t, err)
+	require.True(t, session.IsNew)
+
+	session.Values["user"] = &User{Username: username, LoginTime: time.Now()}
+	rec := httptest.NewRecorder()
+	require.NoError(t, store.Save(req, rec, session))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	return cookies[0]
+}
+
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	req := httptest.NewRequest("GET", "/api/v1/login", nil)
+	req.AddCookie(cookie)
+	return req
+}
+
+func TestBoltStore(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	t.Run("SavedSession_IsLoaded", func(t *testing.T) {
+		cookie := loginAs(t, store, "alice")
+		require.True(t, cookie.HttpOnly)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		require.False(t, cookie.Secure)
+
+		session, err := store.New(requestWithCookie(cookie), "operos-waterfront")
+		require.NoError(t, err)
+		require.False(t, session.IsNew)
+
+		user, ok := session.Values["user"].(User)
+		require.True(t, ok)
+		require.Equal(t, "alice", user.Username)
+	})
+
+	t.Run("HTTPS_SetsSecure", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/login", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		session, err := store.New(req, "operos-waterfront")
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		require.NoError(t, store.Save(req, rec, session))
+		require.True(t, rec.Result().Cookies()[0].Secure)
+	})
+
+	t.Run("TamperedCookie_IsRejected", func(t *testing.T) {
+		cookie := loginAs(t, store, "alice")
+		cookie.Value = cookie.Value[:len(cookie.Value)-2] + "xx"
+
+		session, err := store.New(requestWithCookie(cookie), "operos-waterfront")
+		require.Error(t, err)
+		require.True(t, session.IsNew)
+	})
+
+	t.Run("RevokedSession_IsGone", func(t *testing.T) {
+		cookie := loginAs(t, store, "bob")
+
+		infos, err := store.Sessions()
+		require.NoError(t, err)
+		var id string
+		for _, info := range infos {
+			if info.Username == "bob" {
+				id = info.ID
+			}
+		}
+		require.NotEmpty(t, id)
+
+		found, err := store.Revoke(id)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		session, err := store.New(requestWithCookie(cookie), "operos-waterfront")
+		require.NoError(t, err)
+		require.True(t, session.IsNew)
+		require.Empty(t, session.Values)
+	})
+
+	t.Run("RevokeUser_EndsAllSessions", func(t *testing.T) {
+		first := loginAs(t, store, "carol")
+		second := loginAs(t, store, "carol")
+
+		count, err := store.RevokeUser("carol")
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		for _, cookie := range []*http.Cookie{first, second} {
+			session, err := store.New(requestWithCookie(cookie), "operos-waterfront")
+			require.NoError(t, err)
+			require.True(t, session.IsNew)
+		}
+	})
+
+	t.Run("Logout_DeletesSession", func(t *testing.T) {
+		cookie := loginAs(t, store, "dave")
+
+		req := requestWithCookie(cookie)
+		session, err := store.New(req, "operos-waterfront")
+		require.NoError(t, err)
+		session.Options.MaxAge = -1
+		require.NoError(t, store.Save(req, httptest.NewRecorder(), session))
+
+		session, err = store.New(requestWithCookie(cookie), "operos-waterfront")
+		require.NoError(t, err)
+		require.True(t, session.IsNew)
+	})
+
+	t.Run("ExpiredSession_IsNotLoaded", func(t *testing.T) {
+		cookie := loginAs(t, store, "erin")
+
+		store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		defer func() { store.now = time.Now }()
+
+		session, err := store.New(requestWithCookie(cookie), "operos-waterfront")
+		require.NoError(t, err)
+		require.True(t, session.IsNew)
+
+		infos, err := store.Sessions()
+		require.NoError(t, err)
+		require.Empty(t, infos)
+	})
+}
+
+func TestGetSessionsHandler(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	a := AuthSessionMiddleware(SessionStore(store))
+	router := mux.NewRouter()
+	router.Path("/api/v1/sessions").Handler(a.GetSessionsHandler())
+	router.Path("/api/v1/sessions/{id}").Handler(a.GetSessionsHandler())
+
+	adminCookie := loginAs(t, store, "root")
+	loginAs(t, store, "alice")
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.AddCookie(adminCookie)
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, &User{Username: "root", Roles: []Role{RoleAdmin}}))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("GET", "/api/v1/sessions")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var listing struct {
+		Sessions []*SessionInfo `json:"sessions"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&listing))
+	require.Len(t, listing.Sessions, 2)
+
+	var aliceID string
+	for _, info := range listing.Sessions {
+		require.Equal(t, info.Username == "root", info.Current)
+		if info.Username == "alice" {
+			aliceID = info.ID
+		}
+	}
+
+	rec = serve("DELETE", "/api/v1/sessions/"+aliceID)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve("DELETE", "/api/v1/sessions/"+aliceID)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve("DELETE", "/api/v1/sessions")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestLoginHandler_RenewsSession(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	a := AuthSessionMiddleware(
+		SessionStore(store),
+		Authentication(AuthenticatorFunc(func(username, password string) (bool, error) {
+			return password == "secret", nil
+		})),
+		RoleSource(NewRoleStore("/nonexistent/roles.yaml", nil, &DefaultRoleBindings)),
+	)
+
+	// A session the attacker started and planted in the victim's browser
+	req := httptest.NewRequest("GET", "/api/v1/login", nil)
+	session, err := store.New(req, "operos-waterfront")
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	require.NoError(t, store.Save(req, rec, session))
+	planted := rec.Result().Cookies()[0]
+
+	req = httptest.NewRequest("POST", "/api/v1/login",
+		strings.NewReader(`{"username": "root", "password": "secret"}`))
+	req.AddCookie(planted)
+	rec = httptest.NewRecorder()
+	a.GetLoginHandler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"logged_in":true`)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.NotEqual(t, planted.Value, cookies[0].Value)
+
+	session, err = store.New(requestWithCookie(planted), "operos-waterfront")
+	require.NoError(t, err)
+	require.True(t, session.IsNew)
+
+	session, err = store.New(requestWithCookie(cookies[0]), "operos-waterfront")
+	require.NoError(t, err)
+	require.False(t, session.IsNew)
+
+	infos, err := store.Sessions()
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	require.Equal(t, "root", infos[0].Username)
+}
+
+func TestLoadOrCreateSessionKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "waterfront-key")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "session.key")
+	key, err := LoadOrCreateSessionKey(path)
+	require.NoError(t, err)
+	require.Len(t, key, 64)
+
+	again, err := LoadOrCreateSessionKey(path)
+	require.NoError(t, err)
+	require.Equal(t, key, again)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
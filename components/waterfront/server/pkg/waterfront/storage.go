@@ -0,0 +1,304 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+// CephSource reports on the Ceph cluster. Teamster, which runs the ceph CLI
+// on the controller, is the source in production; tests use fixtures.
+type CephSource interface {
+	CephReport(ctx context.Context) (*teamster_proto.CephReport, error)
+}
+
+type teamsterCephSource struct {
+	client teamster_proto.TeamsterClient
+}
+
+// NewTeamsterCephSource asks teamster about the Ceph cluster
+func NewTeamsterCephSource(client teamster_proto.TeamsterClient) CephSource {
+	return teamsterCephSource{client}
+}
+
+func (s teamsterCephSource) CephReport(ctx context.Context) (*teamster_proto.CephReport, error) {
+	return s.client.GetCephReport(ctx, &teamster_proto.Empty{})
+}
+
+// The parts of the JSON output of the ceph CLI the storage overview uses
+type cephStatus struct {
+	Health struct {
+		Status string `json:"status"`
+		Checks map[string]struct {
+			Severity string `json:"severity"`
+			Summary  struct {
+				Message string `json:"message"`
+			} `json:"summary"`
+		} `json:"checks"`
+	} `json:"health"`
+	OSDMap struct {
+		OSDMap struct {
+			NumOSDs   int32 `json:"num_osds"`
+			NumUpOSDs int32 `json:"num_up_osds"`
+			NumInOSDs int32 `json:"num_in_osds"`
+		} `json:"osdmap"`
+	} `json:"osdmap"`
+	PGMap struct {
+		PGsByState []struct {
+			StateName string `json:"state_name"`
+			Count     int64  `json:"count"`
+		} `json:"pgs_by_state"`
+		NumPGs     int64 `json:"num_pgs"`
+		BytesUsed  int64 `json:"bytes_used"`
+		BytesAvail int64 `json:"bytes_avail"`
+		BytesTotal int64 `json:"bytes_total"`
+	} `json:"pgmap"`
+}
+
+type cephOSDDump struct {
+	OSDs []struct {
+		OSD  int32  `json:"osd"`
+		UUID string `json:"uuid"`
+		Up   int    `json:"up"`
+		In   int    `json:"in"`
+	} `json:"osds"`
+	Pools []struct {
+		Pool      int64  `json:"pool"`
+		PoolName  string `json:"pool_name"`
+		Size      int32  `json:"size"`
+		MinSize   int32  `json:"min_size"`
+		CrushRule int64  `json:"crush_rule"`
+		PGNum     int32  `json:"pg_num"`
+	} `json:"pools"`
+}
+
+type cephOSDDF struct {
+	Nodes []struct {
+		ID          int32   `json:"id"`
+		CrushWeight float64 `json:"crush_weight"`
+		Reweight    float64 `json:"reweight"`
+		KB          int64   `json:"kb"`
+		KBUsed      int64   `json:"kb_used"`
+		Utilization float64 `json:"utilization"`
+		PGs         int32   `json:"pgs"`
+	} `json:"nodes"`
+}
+
+type cephDF struct {
+	Pools []struct {
+		ID    int64 `json:"id"`
+		Stats struct {
+			BytesUsed int64 `json:"bytes_used"`
+			MaxAvail  int64 `json:"max_avail"`
+			Objects   int64 `json:"objects"`
+		} `json:"stats"`
+	} `json:"pools"`
+}
+
+type cephCrushRule struct {
+	RuleID   int64  `json:"rule_id"`
+	RuleName string `json:"rule_name"`
+}
+
+// cephReport fetches a report from the Ceph source
+func (w *WaterfrontAPI) cephReport(ctx context.Context) (*teamster_proto.CephReport, error) {
+	report, err := w.cephSource.CephReport(ctx)
+	if grpc.Code(err) == codes.Unavailable {
+		return nil, status.Errorf(codes.Unavailable, "ceph is not available: %s", grpc.ErrorDesc(err))
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error fetching the state of ceph")
+	}
+	return report, nil
+}
+
+// parseCephOutput parses the output of one of the commands of a report
+func parseCephOutput(command, out string, v interface{}) error {
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		return errors.Wrapf(err, "failed to parse the output of ceph %s", command)
+	}
+	return nil
+}
+
+func (w *WaterfrontAPI) GetStorageHealth(ctx context.Context, req *Empty) (*StorageHealth, error) {
+	report, err := w.cephReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var cephStatus cephStatus
+	if err := parseCephOutput("status", report.Status, &cephStatus); err != nil {
+		return nil, err
+	}
+
+	health := &StorageHealth{
+		Status:         cephStatus.Health.Status,
+		TotalBytes:     cephStatus.PGMap.BytesTotal,
+		UsedBytes:      cephStatus.PGMap.BytesUsed,
+		AvailableBytes: cephStatus.PGMap.BytesAvail,
+		NumOsds:        cephStatus.OSDMap.OSDMap.NumOSDs,
+		NumUpOsds:      cephStatus.OSDMap.OSDMap.NumUpOSDs,
+		NumInOsds:      cephStatus.OSDMap.OSDMap.NumInOSDs,
+	}
+	for code, check := range cephStatus.Health.Checks {
+		health.Checks = append(health.Checks, &HealthCheck{
+			Code:     code,
+			Severity: check.Severity,
+			Summary:  check.Summary.Message,
+		})
+	}
+	sort.Slice(health.Checks, func(i, j int) bool {
+		return health.Checks[i].Code < health.Checks[j].Code
+	})
+	return health, nil
+}
+
+func (w *WaterfrontAPI) ListPools(ctx context.Context, req *Empty) (*ListPoolsResponse, error) {
+	report, err := w.cephReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dump cephOSDDump
+	var df cephDF
+	var rules []cephCrushRule
+	if err := parseCephOutput("osd dump", report.OsdDump, &dump); err != nil {
+		return nil, err
+	}
+	if err := parseCephOutput("df", report.Df, &df); err != nil {
+		return nil, err
+	}
+	if err := parseCephOutput("osd crush rule dump", report.CrushRules, &rules); err != nil {
+		return nil, err
+	}
+
+	ruleNames := make(map[int64]string, len(rules))
+	for _, rule := range rules {
+		ruleNames[rule.RuleID] = rule.RuleName
+	}
+
+	pools := make(map[int64]*Pool, len(dump.Pools))
+	resp := &ListPoolsResponse{}
+	for _, pool := range dump.Pools {
+		pools[pool.Pool] = &Pool{
+			Id:        pool.Pool,
+			Name:      pool.PoolName,
+			Size:      pool.Size,
+			MinSize:   pool.MinSize,
+			PgNum:     pool.PGNum,
+			CrushRule: ruleNames[pool.CrushRule],
+		}
+		resp.Pools = append(resp.Pools, pools[pool.Pool])
+	}
+	for _, usage := range df.Pools {
+		if pool, ok := pools[usage.ID]; ok {
+			pool.UsedBytes = usage.Stats.BytesUsed
+			pool.MaxAvailableBytes = usage.Stats.MaxAvail
+			pool.Objects = usage.Stats.Objects
+		}
+	}
+	return resp, nil
+}
+
+func (w *WaterfrontAPI) ListOSDs(ctx context.Context, req *Empty) (*ListOSDsResponse, error) {
+	report, err := w.cephReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dump cephOSDDump
+	var df cephOSDDF
+	if err := parseCephOutput("osd dump", report.OsdDump, &dump); err != nil {
+		return nil, err
+	}
+	if err := parseCephOutput("osd df", report.OsdDf, &df); err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]*teamster_proto.OSDLocation, len(report.Osds))
+	for _, location := range report.Osds {
+		locations[location.OsdId] = location
+	}
+
+	osds := make(map[int32]*OSD, len(dump.OSDs))
+	resp := &ListOSDsResponse{}
+	for _, osd := range dump.OSDs {
+		osds[osd.OSD] = &OSD{
+			Id:   osd.OSD,
+			Uuid: osd.UUID,
+			Up:   osd.Up != 0,
+			In:   osd.In != 0,
+		}
+		if location, ok := locations[strconv.Itoa(int(osd.OSD))]; ok {
+			osds[osd.OSD].NodeId = location.NodeUuid
+			osds[osd.OSD].Hostname = location.Hostname
+			osds[osd.OSD].Device = location.Device
+			osds[osd.OSD].Model = location.Model
+			osds[osd.OSD].Serial = location.Serial
+		}
+		resp.Osds = append(resp.Osds, osds[osd.OSD])
+	}
+	for _, usage := range df.Nodes {
+		if osd, ok := osds[usage.ID]; ok {
+			osd.CrushWeight = usage.CrushWeight
+			osd.Reweight = usage.Reweight
+			osd.Utilization = usage.Utilization
+			osd.TotalBytes = usage.KB * 1024
+			osd.UsedBytes = usage.KBUsed * 1024
+			osd.Pgs = usage.PGs
+		}
+	}
+
+	sort.Slice(resp.Osds, func(i, j int) bool {
+		return resp.Osds[i].Id < resp.Osds[j].Id
+	})
+	return resp, nil
+}
+
+func (w *WaterfrontAPI) GetPGStates(ctx context.Context, req *Empty) (*GetPGStatesResponse, error) {
+	report, err := w.cephReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var cephStatus cephStatus
+	if err := parseCephOutput("status", report.Status, &cephStatus); err != nil {
+		return nil, err
+	}
+
+	resp := &GetPGStatesResponse{Total: cephStatus.PGMap.NumPGs}
+	for _, state := range cephStatus.PGMap.PGsByState {
+		resp.States = append(resp.States, &PGStateCount{
+			State: state.StateName,
+			Count: state.Count,
+		})
+	}
+	// The busiest states first
+	sort.SliceStable(resp.States, func(i, j int) bool {
+		return resp.States[i].Count > resp.States[j].Count
+	})
+	return resp, nil
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+type fixtureCephSource struct {
+	report *teamster_proto.CephReport
+	err    error
+}
+
+func (s fixtureCephSource) CephReport(ctx context.Context) (*teamster_proto.CephReport, error) {
+	return s.report, s.err
+}
+
+// cephFixture is a three OSD cluster with one OSD down, as reported by
+// Ceph luminous
+var cephFixture = &teamster_proto.CephReport{
+	Status: `{
+		"health": {
+			"checks": {
+				"PG_DEGRADED": {"severity": "HEALTH_WARN", "summary": {"message": "Degraded data redundancy: 32 pgs degraded"}},
+				"OSD_DOWN": {"severity": "HEALTH_WARN", "summary": {"message": "1 osds down"}}
+			},
+			"status": "HEALTH_WARN"
+		},
+		"osdmap": {"osdmap": {"epoch": 21, "num_osds": 3, "num_up_osds": 2, "num_in_osds": 3}},
+		"pgmap": {
+			"pgs_by_state": [
+				{"state_name": "active+undersized+degraded", "count": 32},
+				{"state_name": "active+clean", "count": 96}
+			],
+			"num_pgs": 128,
+			"bytes_used": 3221225472,
+			"bytes_avail": 8589934592,
+			"bytes_total": 11811160064
+		}
+	}`,
+	OsdDump: `{
+		"osds": [
+			{"osd": 0, "uuid": "3ab5c3c4-0001", "up": 1, "in": 1, "weight": 1.0},
+			{"osd": 1, "uuid": "3ab5c3c4-0002", "up": 0, "in": 1, "weight": 1.0},
+			{"osd": 2, "uuid": "3ab5c3c4-0003", "up": 1, "in": 1, "weight": 1.0}
+		],
+		"pools": [
+			{"pool": 1, "pool_name": "rbd", "size": 3, "min_size": 2, "crush_rule": 1, "pg_num": 128}
+		]
+	}`,
+	OsdDf: `{
+		"nodes": [
+			{"id": 0, "name": "osd.0", "type": "osd", "crush_weight": 3.6, "reweight": 1.0, "kb": 3906250, "kb_used": 1048576, "utilization": 26.8, "pgs": 128},
+			{"id": 1, "name": "osd.1", "type": "osd", "crush_weight": 3.6, "reweight": 1.0, "kb": 0, "kb_used": 0, "utilization": 0, "pgs": 0},
+			{"id": 2, "name": "osd.2", "type": "osd", "crush_weight": 3.6, "reweight": 0.8, "kb": 3906250, "kb_used": 2097152, "utilization": 53.7, "pgs": 128}
+		],
+		"stray": []
+	}`,
+	Df: `{
+		"stats": {"total_bytes": 11811160064},
+		"pools": [{"name": "rbd", "id": 1, "stats": {"kb_used": 1048576, "bytes_used": 1073741824, "max_avail": 2684354560, "objects": 256}}]
+	}`,
+	CrushRules: `[
+		{"rule_id": 0, "rule_name": "replicated_rule"},
+		{"rule_id": 1, "rule_name": "replicated_rack"}
+	]`,
+	Osds: []*teamster_proto.OSDLocation{
+		{OsdId: "0", NodeUuid: "u1", Hostname: "worker-1", Device: "sdb", Model: "ST4000", Serial: "Z1Z0"},
+		{OsdId: "1", NodeUuid: "u2", Hostname: "worker-2"},
+	},
+}
+
+func TestStorage(t *testing.T) {
+	api := &WaterfrontAPI{cephSource: fixtureCephSource{report: cephFixture}}
+	ctx := context.Background()
+
+	t.Run("Health_ListsChecks", func(t *testing.T) {
+		health, err := api.GetStorageHealth(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, "HEALTH_WARN", health.Status)
+		require.Equal(t, int32(2), health.NumUpOsds)
+		require.Equal(t, int64(11811160064), health.TotalBytes)
+		require.Len(t, health.Checks, 2)
+		require.Equal(t, &HealthCheck{Code: "OSD_DOWN", Severity: "HEALTH_WARN", Summary: "1 osds down"}, health.Checks[0])
+	})
+
+	t.Run("Pools_HaveUsageAndRule", func(t *testing.T) {
+		resp, err := api.ListPools(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, []*Pool{{
+			Id:                1,
+			Name:              "rbd",
+			Size:              3,
+			MinSize:           2,
+			PgNum:             128,
+			CrushRule:         "replicated_rack",
+			UsedBytes:         1073741824,
+			MaxAvailableBytes: 2684354560,
+			Objects:           256,
+		}}, resp.Pools)
+	})
+
+	t.Run("OSDs_HaveNodeAndDisk", func(t *testing.T) {
+		resp, err := api.ListOSDs(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Len(t, resp.Osds, 3)
+
+		require.Equal(t, &OSD{
+			Id:          0,
+			Uuid:        "3ab5c3c4-0001",
+			Up:          true,
+			In:          true,
+			CrushWeight: 3.6,
+			Reweight:    1.0,
+			Utilization: 26.8,
+			TotalBytes:  3906250 * 1024,
+			UsedBytes:   1048576 * 1024,
+			Pgs:         128,
+			NodeId:      "u1",
+			Hostname:    "worker-1",
+			Device:      "sdb",
+			Model:       "ST4000",
+			Serial:      "Z1Z0",
+		}, resp.Osds[0])
+		require.False(t, resp.Osds[1].Up)
+		require.Equal(t, "worker-2", resp.Osds[1].Hostname)
+		// Not created by teamster
+		require.Empty(t, resp.Osds[2].NodeId)
+	})
+
+	t.Run("PGStates_BusiestFirst", func(t *testing.T) {
+		resp, err := api.GetPGStates(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, int64(128), resp.Total)
+		require.Equal(t, []*PGStateCount{
+			{State: "active+clean", Count: 96},
+			{State: "active+undersized+degraded", Count: 32},
+		}, resp.States)
+	})
+
+	t.Run("CephUnavailable_IsUnavailable", func(t *testing.T) {
+		api := &WaterfrontAPI{cephSource: fixtureCephSource{
+			err: grpc.Errorf(codes.Unavailable, "ceph status failed: exit status 1"),
+		}}
+		_, err := api.GetStorageHealth(ctx, &Empty{})
+		require.Equal(t, codes.Unavailable, grpc.Code(err))
+	})
+
+	t.Run("BadOutput_IsError", func(t *testing.T) {
+		api := &WaterfrontAPI{cephSource: fixtureCephSource{
+			report: &teamster_proto.CephReport{Status: "not json"},
+		}}
+		_, err := api.GetPGStates(ctx, &Empty{})
+		require.Error(t, err)
+	})
+}
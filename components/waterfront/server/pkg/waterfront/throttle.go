@@ -0,0 +1,262 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"sync"
+	"time"
+)
+
+type throttleEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	// pending counts the attempts which passed Check and have not ended yet
+	pending int
+}
+
+// LoginThrottle slows down password guessing. Once a username or a client
+// address has used up its allowed failures, each further failure locks it out
+// for twice as long as the one before.
+type LoginThrottle struct {
+	// UserAttempts and AddrAttempts are the failures allowed before lockouts
+	// start. Addresses get more, since many users may share one.
+	UserAttempts int
+	AddrAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	// Forget is how long after its last failure a username or address starts
+	// over
+	Forget time.Duration
+
+	lock      sync.Mutex
+	entries   map[string]*throttleEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewLoginThrottle() *LoginThrottle {
+	return &LoginThrottle{
+		UserAttempts: 5,
+		AddrAttempts: 20,
+		BaseLockout:  time.Second,
+		MaxLockout:   15 * time.Minute,
+		Forget:       time.Hour,
+		entries:      make(map[string]*throttleEntry),
+		now:          time.Now,
+	}
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
+
+func addrKey(addr string) string {
+	return "addr:" + addr
+}
+
+// Check returns how long the username and address remain locked out, or 0 if
+// they may try to log in. An attempt which may go ahead is reserved until it
+// ends with Success, Failure or Release, so that parallel guesses cannot all
+// pass Check before the first of them fails.
+func (t *LoginThrottle) Check(username, addr string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+	user := t.entry(userKey(username), now)
+	host := t.entry(addrKey(addr), now)
+
+	wait := t.wait(user, t.UserAttempts, now)
+	if hostWait := t.wait(host, t.AddrAttempts, now); hostWait > wait {
+		wait = hostWait
+	}
+	if wait == 0 {
+		user.pending++
+		host.pending++
+	}
+	return wait
+}
+
+// wait returns how long an entry keeps further attempts out
+func (t *LoginThrottle) wait(entry *throttleEntry, allowed int, now time.Time) time.Duration {
+	if entry.lockedUntil.After(now) {
+		return entry.lockedUntil.Sub(now)
+	}
+	// Attempts in progress may not add up to more than the failures left, and
+	// past those they go one at a time, so that each failure locks out the
+	// next attempt
+	if entry.pending > 0 && entry.failures+entry.pending >= allowed {
+		return t.BaseLockout
+	}
+	return 0
+}
+
+// Failure records a failed login
+func (t *LoginThrottle) Failure(username, addr string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+	t.fail(t.entry(userKey(username), now), t.UserAttempts, now)
+	t.fail(t.entry(addrKey(addr), now), t.AddrAttempts, now)
+}
+
+// Release ends an attempt which neither succeeded nor failed, such as one
+// which could not reach the authenticator
+func (t *LoginThrottle) Release(username, addr string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	t.entry(userKey(username), now).done()
+	t.entry(addrKey(addr), now).done()
+}
+
+// entry returns the entry for key, starting it over if its failures are old
+func (t *LoginThrottle) entry(key string, now time.Time) *throttleEntry {
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &throttleEntry{}
+		t.entries[key] = entry
+	} else if entry.failures > 0 && now.Sub(entry.lastFailure) > t.Forget {
+		*entry = throttleEntry{pending: entry.pending}
+	}
+	return entry
+}
+
+func (e *throttleEntry) done() {
+	if e.pending > 0 {
+		e.pending--
+	}
+}
+
+func (t *LoginThrottle) fail(entry *throttleEntry, allowed int, now time.Time) {
+	entry.done()
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures > allowed {
+		lockout := t.MaxLockout
+		if shift := uint(entry.failures - allowed - 1); shift < 32 {
+			if backoff := t.BaseLockout << shift; backoff < lockout {
+				lockout = backoff
+			}
+		}
+		entry.lockedUntil = now.Add(lockout)
+	}
+}
+
+// Success clears the failures of a username. The failures of the address are
+// kept, so that knowing one password does not help guess others.
+func (t *LoginThrottle) Success(username, addr string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.entries, userKey(username))
+	t.entry(addrKey(addr), t.now()).done()
+}
+
+// sweep forgets old entries, so that guessing many usernames does not grow
+// the throttle without bounds
+func (t *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.Forget {
+		return
+	}
+	t.lastSweep = now
+
+	for key, entry := range t.entries {
+		if now.Sub(entry.lastFailure) > t.Forget && !entry.lockedUntil.After(now) && entry.pending == 0 {
+			delete(t.entries, key)
+		}
+	}
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits how often each user may make requests. Each user may
+// make Burst requests at once, and Rate more each second after that.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	lock      sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		Rate:    rate,
+		Burst:   burst,
+		buckets: make(map[string]*rateBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a request from the allowance of key. It returns 0 if the
+// request may be made, or how long until it may be tried again.
+func (l *RateLimiter) Allow(key string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = l.refill(bucket, now)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.Rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return 0
+}
+
+func (l *RateLimiter) refill(bucket *rateBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*l.Rate
+	if tokens > float64(l.Burst) {
+		tokens = float64(l.Burst)
+	}
+	return tokens
+}
+
+// sweep forgets keys which have regained their whole allowance, since they
+// are no different from keys which were never seen
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestThrottle() (*LoginThrottle, *time.Time) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	throttle := NewLoginThrottle()
+	throttle.UserAttempts = 3
+	throttle.AddrAttempts = 10
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Run("AllowedFailures_AreNotLocked", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+		for i := 0; i < 3; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+	})
+
+	t.Run("Lockout_Doubles", func(t *testing.T) {
+		throttle, now := newTestThrottle()
+		for i := 0; i < 4; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		require.Equal(t, time.Second, throttle.Check("alice", "10.0.0.1"))
+		require.Equal(t, time.Second, throttle.Check("alice", "10.0.0.2"))
+		require.Zero(t, throttle.Check("bob", "10.0.0.2"))
+
+		throttle.Failure("alice", "10.0.0.1")
+		require.Equal(t, 2*time.Second, throttle.Check("alice", "10.0.0.1"))
+
+		*now = now.Add(2 * time.Second)
+		require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+	})
+
+	t.Run("Lockout_IsCapped", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+		for i := 0; i < 100; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		require.Equal(t, throttle.MaxLockout, throttle.Check("alice", "10.0.0.1"))
+	})
+
+	t.Run("ManyUsernames_LockAddress", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+		for i := 0; i < 11; i++ {
+			throttle.Failure(strings.Repeat("u", i+1), "10.0.0.1")
+		}
+		require.NotZero(t, throttle.Check("someone", "10.0.0.1"))
+		require.Zero(t, throttle.Check("someone", "10.0.0.2"))
+	})
+
+	t.Run("Success_ClearsUserOnly", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+		for i := 0; i < 11; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		throttle.Success("alice", "10.0.0.1")
+		require.Zero(t, throttle.Check("alice", "10.0.0.2"))
+		require.NotZero(t, throttle.Check("alice", "10.0.0.1"))
+	})
+
+	t.Run("ParallelAttempts_AreReserved", func(t *testing.T) {
+		throttle, _ := newTestThrottle()
+		for i := 0; i < 3; i++ {
+			require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+		}
+		require.NotZero(t, throttle.Check("alice", "10.0.0.1"))
+		require.Zero(t, throttle.Check("bob", "10.0.0.1"))
+
+		throttle.Release("alice", "10.0.0.1")
+		require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+
+		for i := 0; i < 3; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+		require.NotZero(t, throttle.Check("alice", "10.0.0.1"))
+
+		throttle.Failure("alice", "10.0.0.1")
+		require.Equal(t, time.Second, throttle.Check("alice", "10.0.0.1"))
+	})
+
+	t.Run("OldFailures_AreForgotten", func(t *testing.T) {
+		throttle, now := newTestThrottle()
+		for i := 0; i < 3; i++ {
+			throttle.Failure("alice", "10.0.0.1")
+		}
+		*now = now.Add(2 * time.Hour)
+		throttle.Failure("alice", "10.0.0.1")
+		require.Zero(t, throttle.Check("alice", "10.0.0.1"))
+		require.Len(t, throttle.entries, 2)
+	})
+}
+
+func TestLoginHandler_Throttle(t *testing.T) {
+	throttle, _ := newTestThrottle()
+	authenticator := AuthenticatorFunc(func(username, password string) (bool, error) {
+		if password == "broken" {
+			return false, errors.New("PAM is not configured")
+		}
+		return password == "secret", nil
+	})
+	a := AuthSessionMiddleware(
+		Authentication(authenticator),
+		Throttle(throttle),
+		RoleSource(NewRoleStore("/nonexistent/roles.yaml", nil, &DefaultRoleBindings)),
+	)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/login",
+			strings.NewReader(`{"username": "root", "password": "`+password+`"}`))
+		req.RemoteAddr = "10.0.0.1:40000"
+		rec := httptest.NewRecorder()
+		a.GetLoginHandler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := login("broken")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	for i := 0; i < 4; i++ {
+		rec = login("wrong")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"logged_in":false`)
+	}
+
+	rec = login("secret")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		require.Zero(t, limiter.Allow("alice"))
+	}
+	require.Equal(t, 500*time.Millisecond, limiter.Allow("alice"))
+	require.Zero(t, limiter.Allow("bob"))
+
+	now = now.Add(500 * time.Millisecond)
+	require.Zero(t, limiter.Allow("alice"))
+	require.NotZero(t, limiter.Allow("alice"))
+
+	// The allowance is regained, but not beyond the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.Zero(t, limiter.Allow("alice"))
+	}
+	require.NotZero(t, limiter.Allow("alice"))
+}
@@ -0,0 +1,502 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scope limits what an API token may do, on top of the role it acts with
+type Scope string
+
+const (
+	ScopeNodesRead     = Scope("nodes:read")
+	ScopeNodesWrite    = Scope("nodes:write")
+	ScopeClusterRead   = Scope("cluster:read")
+	ScopeClusterWrite  = Scope("cluster:write")
+	ScopeMetricsRead   = Scope("metrics:read")
+	ScopeStorageRead   = Scope("storage:read")
+	ScopeStorageWrite  = Scope("storage:write")
+	ScopeWorkloadsRead = Scope("workloads:read")
+	ScopeWorkloadsExec = Scope("workloads:exec")
+)
+
+var knownScopes = map[Scope]bool{
+	ScopeNodesRead:     true,
+	ScopeNodesWrite:    true,
+	ScopeClusterRead:   true,
+	ScopeClusterWrite:  true,
+	ScopeMetricsRead:   true,
+	ScopeStorageRead:   true,
+	ScopeStorageWrite:  true,
+	ScopeWorkloadsRead: true,
+	ScopeWorkloadsExec: true,
+}
+
+func ParseScope(name string) (Scope, error) {
+	scope := Scope(strings.TrimSpace(name))
+	if !knownScopes[scope] {
+		return "", errors.Errorf("unknown scope %q", name)
+	}
+	return scope, nil
+}
+
+// HasScope reports whether scopes include required
+func HasScope(scopes []Scope, required Scope) bool {
+	for _, scope := range scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrInvalidToken is returned for API tokens which are malformed, unknown,
+// revoked or expired
+var ErrInvalidToken = errors.New("invalid API token")
+
+// tokenPrefix starts every API token, to make them recognizable in
+// configuration files and logs
+const tokenPrefix = "wft_"
+
+var tokensBucket = []byte("tokens")
+
+// APIToken describes an API token. The secret itself is only shown once, when
+// the token is created.
+type APIToken struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Owner is the user a personal token acts as, or the name of a service
+	Owner   string `json:"owner"`
+	Service bool   `json:"service"`
+	// Role is the role of a service token. Personal tokens have the roles of
+	// their owner.
+	Role      Role       `json:"role,omitempty"`
+	Scopes    []Scope    `json:"scopes"`
+	CreatedBy string     `json:"created_by"`
+	Created   time.Time  `json:"created"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// tokenRecord is an API token as stored in the database
+type tokenRecord struct {
+	APIToken
+	Hash []byte
+	// Groups of the owner of a personal token, as reported by an external
+	// authenticator when the token was created
+	Groups []string
+}
+
+// TokenStore keeps API tokens in a bbolt database. Only a hash of each token
+// is stored.
+type TokenStore struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+func NewTokenStore(path string) (*TokenStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create token directory")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open token database %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize token database")
+	}
+
+	return &TokenStore{db: db, now: time.Now}, nil
+}
+
+func (s *TokenStore) Close() error {
+	return s.db.Close()
+}
+
+func hashToken(raw string) []byte {
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+// Create stores a new token and returns its secret
+func (s *TokenStore) Create(token *APIToken, groups []string) (string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate token ID")
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	raw := tokenPrefix + id + "_" + secret
+
+	token.ID = id
+	token.Created = s.now()
+	record := &tokenRecord{
+		APIToken: *token,
+		Hash:     hashToken(raw),
+		Groups:   groups,
+	}
+	if err := s.put(record); err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// Authenticate returns the token raw belongs to, and the groups of its owner
+func (s *TokenStore) Authenticate(raw string) (*APIToken, []string, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return nil, nil, ErrInvalidToken
+	}
+	parts := strings.SplitN(strings.TrimPrefix(raw, tokenPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	record, err := s.get(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil || subtle.ConstantTimeCompare(record.Hash, hashToken(raw)) != 1 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	now := s.now()
+	if record.Expires != nil && !now.Before(*record.Expires) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	// Only note use once a minute, rather than writing on every request
+	if record.LastUsed == nil || now.Sub(*record.LastUsed) > time.Minute {
+		record.LastUsed = &now
+		if err := s.put(record); err != nil {
+			log.Errorf("failed to update token: %v", err)
+		}
+	}
+
+	return &record.APIToken, record.Groups, nil
+}
+
+// Get returns the token with the given ID, or nil if there is none
+func (s *TokenStore) Get(id string) (*APIToken, error) {
+	record, err := s.get(id)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	return &record.APIToken, nil
+}
+
+// Tokens lists the tokens owned by owner, or all tokens if owner is empty,
+// oldest first
+func (s *TokenStore) Tokens(owner string) ([]*APIToken, error) {
+	tokens := []*APIToken{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(k, v []byte) error {
+			record, err := decodeTokenRecord(v)
+			if err != nil {
+				log.Errorf("skipping unreadable token %s: %v", k, err)
+				return nil
+			}
+			if owner == "" || (!record.Service && record.Owner == owner) {
+				tokens = append(tokens, &record.APIToken)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list tokens")
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, nil
+}
+
+// Revoke deletes the token with the given ID
+func (s *TokenStore) Revoke(id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokensBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return nil
+		}
+		found = true
+		return bucket.Delete([]byte(id))
+	})
+	return found, errors.Wrap(err, "failed to revoke token")
+}
+
+func (s *TokenStore) get(id string) (*tokenRecord, error) {
+	var record *tokenRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tokensBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		record, err = decodeTokenRecord(data)
+		return err
+	})
+	return record, errors.Wrap(err, "failed to load token")
+}
+
+func (s *TokenStore) put(record *tokenRecord) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return errors.Wrap(err, "failed to encode token")
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).Put([]byte(record.ID), buf.Bytes())
+	})
+	return errors.Wrap(err, "failed to store token")
+}
+
+func decodeTokenRecord(data []byte) (*tokenRecord, error) {
+	var record tokenRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token")
+	}
+	return &record, nil
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[7:]), true
+}
+
+// tokenUser authenticates a request made with an API token
+func (h *auth) tokenUser(r *http.Request, raw string) (*User, *authError) {
+	if h.tokens == nil {
+		return nil, &authError{status: http.StatusUnauthorized, message: "API tokens are not enabled"}
+	}
+
+	token, groups, err := h.tokens.Authenticate(raw)
+	if err == ErrInvalidToken {
+		h.audit.Record(&AuditEvent{
+			Action:     AuditTokenAuth,
+			Outcome:    AuditFailure,
+			RemoteAddr: remoteHost(r),
+			Reason:     err.Error(),
+		})
+		return nil, &authError{status: http.StatusUnauthorized, message: err.Error()}
+	} else if err != nil {
+		log.Errorf("failed to authenticate API token: %v", err)
+		return nil, &authError{status: http.StatusServiceUnavailable, message: "authentication is unavailable"}
+	}
+
+	user := &User{
+		Username:  token.Owner,
+		LoginTime: token.Created,
+		Groups:    groups,
+		Scopes:    token.Scopes,
+		TokenID:   token.ID,
+	}
+
+	if token.Service {
+		// Service names can not be mistaken for users in the roles file
+		user.Username = "service:" + token.Owner
+		user.Roles = []Role{token.Role}
+	} else if reason := h.resolveRoles(user); reason != "" {
+		return nil, &authError{status: http.StatusForbidden, message: reason}
+	}
+
+	return user, nil
+}
+
+type tokenRequest struct {
+	Name    string     `json:"name"`
+	Scopes  []Scope    `json:"scopes"`
+	Expires *time.Time `json:"expires"`
+	// Service tokens are not tied to a user, and act with Role
+	Service bool `json:"service"`
+	Role    Role `json:"role"`
+}
+
+// GetTokensHandler lets users list their API tokens with GET, create them
+// with POST and revoke them with DELETE. Administrators see all tokens, and
+// may create service tokens.
+func (h *auth) GetTokensHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.tokens == nil {
+			http.Error(w, "API tokens are not enabled", http.StatusNotImplemented)
+			return
+		}
+
+		user := GetUser(r.Context())
+		if user == nil {
+			writeForbidden(w, "not logged in")
+			return
+		}
+		isAdmin := HasRole(user.Roles, RoleAdmin)
+
+		var response interface{}
+		status := http.StatusOK
+		switch r.Method {
+		case "GET":
+			owner := user.Username
+			if isAdmin {
+				owner = ""
+			}
+			tokens, err := h.tokens.Tokens(owner)
+			if err != nil {
+				panic(err)
+			}
+			response = struct {
+				Tokens []*APIToken `json:"tokens"`
+			}{
+				Tokens: tokens,
+			}
+
+		case "POST":
+			var req tokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid JSON: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			token, groups, err := h.newToken(user, &req)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if token.Service && !isAdmin {
+				writeForbidden(w, deniedReason(user, RoleAdmin, "creating service tokens"))
+				return
+			}
+
+			secret, err := h.tokens.Create(token, groups)
+			if err != nil {
+				panic(err)
+			}
+			status = http.StatusCreated
+			response = struct {
+				Token  *APIToken `json:"token"`
+				Secret string    `json:"secret"`
+			}{
+				Token:  token,
+				Secret: secret,
+			}
+
+		case "DELETE":
+			id := mux.Vars(r)["id"]
+			token, err := h.tokens.Get(id)
+			if err != nil {
+				panic(err)
+			}
+			// Tokens of other users are not revealed to exist
+			if token == nil || (!isAdmin && (token.Service || token.Owner != user.Username)) {
+				http.Error(w, "Token not found", http.StatusNotFound)
+				return
+			}
+
+			if _, err := h.tokens.Revoke(id); err != nil {
+				panic(err)
+			}
+			response = struct {
+				Revoked int `json:"revoked"`
+			}{
+				Revoked: 1,
+			}
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			panic(err)
+		}
+	})
+}
+
+// newToken validates a request to create a token
+func (h *auth) newToken(user *User, req *tokenRequest) (*APIToken, []string, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, nil, errors.New("token name is required")
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if _, err := ParseScope(string(scope)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if req.Expires != nil && !req.Expires.After(time.Now()) {
+		return nil, nil, errors.New("expiry must be in the future")
+	}
+
+	token := &APIToken{
+		Name:      req.Name,
+		Owner:     user.Username,
+		Scopes:    req.Scopes,
+		CreatedBy: user.Username,
+		Expires:   req.Expires,
+	}
+	groups := user.Groups
+
+	if req.Service {
+		role, err := ParseRole(string(req.Role))
+		if err != nil {
+			return nil, nil, err
+		}
+		token.Service = true
+		token.Owner = req.Name
+		token.Role = role
+		groups = nil
+	} else if req.Role != "" {
+		return nil, nil, errors.New("personal tokens act with the roles of their owner")
+	}
+
+	return token, groups, nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func newTestTokenStore(t *testing.T) (*TokenStore, func()) {
+	dir, err := ioutil.TempDir("", "waterfront-tokens")
+	require.NoError(t, err)
+
+	store, err := NewTokenStore(filepath.Join(dir, "tokens.db"))
+	require.NoError(t, err)
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	store, cleanup := newTestTokenStore(t)
+	defer cleanup()
+
+	t.Run("CreatedToken_Authenticates", func(t *testing.T) {
+		secret, err := store.Create(&APIToken{Name: "ci", Owner: "alice", Scopes: []Scope{ScopeNodesRead}}, []string{"dev"})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(secret, tokenPrefix))
+
+		token, groups, err := store.Authenticate(secret)
+		require.NoError(t, err)
+		require.Equal(t, "alice", token.Owner)
+		require.Equal(t, []string{"dev"}, groups)
+		require.NotNil(t, token.LastUsed)
+	})
+
+	t.Run("WrongSecret_IsRejected", func(t *testing.T) {
+		secret, err := store.Create(&APIToken{Name: "ci", Owner: "alice", Scopes: []Scope{ScopeNodesRead}}, nil)
+		require.NoError(t, err)
+
+		for _, raw := range []string{secret[:len(secret)-1] + "x", "wft_nosuchid_secret", "garbage", ""} {
+			_, _, err := store.Authenticate(raw)
+			require.Equal(t, ErrInvalidToken, err)
+		}
+	})
+
+	t.Run("ExpiredToken_IsRejected", func(t *testing.T) {
+		expires := time.Now().Add(time.Hour)
+		secret, err := store.Create(&APIToken{Name: "ci", Owner: "alice", Scopes: []Scope{ScopeNodesRead}, Expires: &expires}, nil)
+		require.NoError(t, err)
+
+		store.now = func() time.Time { return expires }
+		defer func() { store.now = time.Now }()
+
+		_, _, err = store.Authenticate(secret)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("RevokedToken_IsRejected", func(t *testing.T) {
+		secret, err := store.Create(&APIToken{Name: "ci", Owner: "bob", Scopes: []Scope{ScopeNodesRead}}, nil)
+		require.NoError(t, err)
+
+		tokens, err := store.Tokens("bob")
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+
+		found, err := store.Revoke(tokens[0].ID)
+		require.NoError(t, err)
+		require.True(t, found)
+
+		_, _, err = store.Authenticate(secret)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+}
+
+func TestGetAuthHandler_Token(t *testing.T) {
+	tokens, cleanup := newTestTokenStore(t)
+	defer cleanup()
+
+	a := AuthSessionMiddleware(
+		Tokens(tokens),
+		RoleSource(NewRoleStore("/nonexistent/roles.yaml", nil, &DefaultRoleBindings)),
+	)
+
+	var seen *User
+	handler := a.GetAuthHandler(RequireAccess(RoleViewer, ScopeMetricsRead,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = GetUser(r.Context())
+		})))
+
+	serve := func(secret string) int {
+		req := httptest.NewRequest("GET", "/api/v1/metrics/query", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	personal, err := tokens.Create(&APIToken{Name: "ci", Owner: "root", Scopes: []Scope{ScopeMetricsRead}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(personal))
+	require.Equal(t, "root", seen.Username)
+	require.Equal(t, []Role{RoleAdmin}, seen.Roles)
+
+	service, err := tokens.Create(&APIToken{Name: "ci", Owner: "ci", Service: true, Role: RoleViewer, Scopes: []Scope{ScopeMetricsRead}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(service))
+	require.Equal(t, "service:ci", seen.Username)
+	require.Equal(t, []Role{RoleViewer}, seen.Roles)
+
+	unscoped, err := tokens.Create(&APIToken{Name: "ci", Owner: "root", Scopes: []Scope{ScopeNodesRead}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, serve(unscoped))
+
+	// Owners without a role can not use their tokens
+	orphan, err := tokens.Create(&APIToken{Name: "ci", Owner: "mallory", Scopes: []Scope{ScopeMetricsRead}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, serve(orphan))
+
+	require.Equal(t, http.StatusUnauthorized, serve("wft_0000_bogus"))
+}
+
+func TestGetTokensHandler(t *testing.T) {
+	tokens, cleanup := newTestTokenStore(t)
+	defer cleanup()
+
+	a := AuthSessionMiddleware(Tokens(tokens))
+	router := mux.NewRouter()
+	router.Path("/api/v1/tokens").Handler(a.GetTokensHandler())
+	router.Path("/api/v1/tokens/{id}").Handler(a.GetTokensHandler())
+
+	alice := &User{Username: "alice", Roles: []Role{RoleViewer}}
+	root := &User{Username: "root", Roles: []Role{RoleAdmin}}
+
+	serve := func(user *User, method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), ContextKeyuser, user))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(alice, "POST", "/api/v1/tokens", `{"name": "ci", "scopes": ["nodes:read"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Token  *APIToken `json:"token"`
+		Secret string    `json:"secret"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	require.NotEmpty(t, created.Secret)
+	require.Equal(t, "alice", created.Token.Owner)
+	require.NotContains(t, rec.Body.String(), "hash")
+
+	rec = serve(alice, "POST", "/api/v1/tokens", `{"name": "ci", "scopes": ["everything"]}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(alice, "POST", "/api/v1/tokens", `{"name": "deploy", "scopes": ["nodes:read"], "service": true, "role": "admin"}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serve(root, "POST", "/api/v1/tokens", `{"name": "deploy", "scopes": ["nodes:read"], "service": true, "role": "operator"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	listed := func(user *User) int {
+		rec := serve(user, "GET", "/api/v1/tokens", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var listing struct {
+			Tokens []*APIToken `json:"tokens"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&listing))
+		return len(listing.Tokens)
+	}
+	require.Equal(t, 1, listed(alice))
+	require.Equal(t, 2, listed(root))
+
+	rec = serve(&User{Username: "bob", Roles: []Role{RoleViewer}}, "DELETE", "/api/v1/tokens/"+created.Token.ID, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(alice, "DELETE", "/api/v1/tokens/"+created.Token.ID, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 0, listed(alice))
+}
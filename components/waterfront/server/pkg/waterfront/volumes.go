@@ -0,0 +1,347 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	storage_v1 "k8s.io/client-go/pkg/apis/storage/v1"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+const (
+	// rbdProvisioner is the provisioner Operos installs, which creates RBD
+	// images for claims
+	rbdProvisioner = "ceph.com/rbd"
+
+	// defaultClassAnnotation marks the class claims which name none get
+	defaultClassAnnotation     = "storageclass.kubernetes.io/is-default-class"
+	betaDefaultClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+
+	// betaClaimClassAnnotation names the class of claims made before
+	// spec.storageClassName existed
+	betaClaimClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+)
+
+// storageClient is the part of the Kubernetes API used to manage volumes
+type storageClient interface {
+	listStorageClasses() ([]storage_v1.StorageClass, error)
+	createStorageClass(class *storage_v1.StorageClass) (*storage_v1.StorageClass, error)
+	updateStorageClass(class *storage_v1.StorageClass) error
+	deleteStorageClass(name string) error
+	listVolumes() ([]kube_v1.PersistentVolume, error)
+	listClaims() ([]kube_v1.PersistentVolumeClaim, error)
+}
+
+func isDefaultClass(class *storage_v1.StorageClass) bool {
+	return class.Annotations[defaultClassAnnotation] == "true" ||
+		class.Annotations[betaDefaultClassAnnotation] == "true"
+}
+
+func storageClassFromKube(class *storage_v1.StorageClass) *StorageClass {
+	return &StorageClass{
+		Name:        class.Name,
+		Pool:        class.Parameters["pool"],
+		IsDefault:   isDefaultClass(class),
+		Provisioner: class.Provisioner,
+	}
+}
+
+func volumeFromKube(volume *kube_v1.PersistentVolume) *PersistentVolume {
+	storage := volume.Spec.Capacity[kube_v1.ResourceStorage]
+	result := &PersistentVolume{
+		Name:          volume.Name,
+		CapacityBytes: storage.Value(),
+		StorageClass:  volume.Spec.StorageClassName,
+		Phase:         string(volume.Status.Phase),
+		ReclaimPolicy: string(volume.Spec.PersistentVolumeReclaimPolicy),
+	}
+	if rbd := volume.Spec.RBD; rbd != nil {
+		result.RbdPool = rbd.RBDPool
+		result.RbdImage = rbd.RBDImage
+	}
+	if claim := volume.Spec.ClaimRef; claim != nil {
+		result.ClaimNamespace = claim.Namespace
+		result.ClaimName = claim.Name
+	}
+	return result
+}
+
+// claimClass is the name of the class a claim asked for
+func claimClass(claim *kube_v1.PersistentVolumeClaim) string {
+	if claim.Spec.StorageClassName != nil {
+		return *claim.Spec.StorageClassName
+	}
+	return claim.Annotations[betaClaimClassAnnotation]
+}
+
+func claimFromKube(claim *kube_v1.PersistentVolumeClaim) *PersistentVolumeClaim {
+	requested := claim.Spec.Resources.Requests[kube_v1.ResourceStorage]
+	capacity := claim.Status.Capacity[kube_v1.ResourceStorage]
+	return &PersistentVolumeClaim{
+		Namespace:      claim.Namespace,
+		Name:           claim.Name,
+		Phase:          string(claim.Status.Phase),
+		StorageClass:   claimClass(claim),
+		RequestedBytes: requested.Value(),
+		CapacityBytes:  capacity.Value(),
+		VolumeName:     claim.Spec.VolumeName,
+	}
+}
+
+// poolByName returns a pool of the Ceph cluster, if it exists
+func (w *WaterfrontAPI) poolByName(ctx context.Context, name string) (*Pool, error) {
+	pools, err := w.ListPools(ctx, &Empty{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pool := range pools.Pools {
+		if pool.Name == name {
+			return pool, nil
+		}
+	}
+	return nil, nil
+}
+
+func (w *WaterfrontAPI) CreatePool(ctx context.Context, req *CreatePoolRequest) (*Pool, error) {
+	_, err := w.teamsterClient.CreatePool(ctx, &teamster_proto.CreatePoolRequest{
+		Name:  req.Name,
+		Size:  req.Size,
+		PgNum: req.PgNum,
+	})
+	switch grpc.Code(err) {
+	case codes.OK:
+	case codes.InvalidArgument, codes.AlreadyExists, codes.FailedPrecondition:
+		return nil, status.Error(grpc.Code(err), grpc.ErrorDesc(err))
+	default:
+		return nil, errors.Wrap(err, "error accessing teamster")
+	}
+
+	pool, err := w.poolByName(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	} else if pool == nil {
+		return nil, status.Errorf(codes.Unavailable, "pool %s was created, but ceph does not report it yet", req.Name)
+	}
+	return pool, nil
+}
+
+func (w *WaterfrontAPI) ListStorageClasses(ctx context.Context, req *Empty) (*ListStorageClassesResponse, error) {
+	storage, err := w.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := storage.listStorageClasses()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching storage classes from kube")
+	}
+
+	resp := &ListStorageClassesResponse{}
+	for i := range classes {
+		resp.StorageClasses = append(resp.StorageClasses, storageClassFromKube(&classes[i]))
+	}
+	sort.Slice(resp.StorageClasses, func(i, j int) bool {
+		return resp.StorageClasses[i].Name < resp.StorageClasses[j].Name
+	})
+	return resp, nil
+}
+
+// CreateStorageClass adds a class which keeps volumes in a pool. The monitors
+// and secrets of the new class are copied from an existing RBD class, the
+// default one if there is one.
+func (w *WaterfrontAPI) CreateStorageClass(ctx context.Context, req *StorageClass) (*StorageClass, error) {
+	if req.Name == "" || req.Pool == "" {
+		return nil, status.Error(codes.InvalidArgument, "a storage class needs a name and a pool")
+	}
+
+	pool, err := w.poolByName(ctx, req.Pool)
+	if err != nil {
+		return nil, err
+	} else if pool == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "pool %s does not exist", req.Pool)
+	} else if pool.MaxAvailableBytes <= 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "pool %s has no space available", req.Pool)
+	}
+
+	storage, err := w.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	classes, err := storage.listStorageClasses()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching storage classes from kube")
+	}
+	var template *storage_v1.StorageClass
+	for i := range classes {
+		if classes[i].Provisioner != rbdProvisioner {
+			continue
+		}
+		if template == nil || isDefaultClass(&classes[i]) {
+			template = &classes[i]
+		}
+	}
+	if template == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "there is no %s storage class to copy the ceph settings from", rbdProvisioner)
+	}
+
+	class := &storage_v1.StorageClass{
+		ObjectMeta:  meta_v1.ObjectMeta{Name: req.Name},
+		Provisioner: rbdProvisioner,
+		Parameters:  make(map[string]string, len(template.Parameters)),
+	}
+	for key, value := range template.Parameters {
+		class.Parameters[key] = value
+	}
+	class.Parameters["pool"] = req.Pool
+	if req.IsDefault {
+		class.Annotations = map[string]string{defaultClassAnnotation: "true"}
+	}
+
+	created, err := storage.createStorageClass(class)
+	if kube_errors.IsAlreadyExists(err) {
+		return nil, status.Errorf(codes.AlreadyExists, "storage class %s already exists", req.Name)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error creating storage class in kube")
+	}
+
+	// There can be only one default class
+	if req.IsDefault {
+		for i := range classes {
+			if !isDefaultClass(&classes[i]) {
+				continue
+			}
+			delete(classes[i].Annotations, defaultClassAnnotation)
+			delete(classes[i].Annotations, betaDefaultClassAnnotation)
+			if err := storage.updateStorageClass(&classes[i]); err != nil {
+				return nil, errors.Wrapf(err, "error unsetting the default storage class %s in kube", classes[i].Name)
+			}
+		}
+	}
+
+	return storageClassFromKube(created), nil
+}
+
+func (w *WaterfrontAPI) DeleteStorageClass(ctx context.Context, req *DeleteStorageClassRequest) (*Empty, error) {
+	storage, err := w.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := storage.listClaims()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching persistent volume claims from kube")
+	}
+	var users []string
+	for i := range claims {
+		if claimClass(&claims[i]) == req.Name {
+			users = append(users, claims[i].Namespace+"/"+claims[i].Name)
+		}
+	}
+	if len(users) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "storage class %s is used by claims %v", req.Name, users)
+	}
+
+	err = storage.deleteStorageClass(req.Name)
+	if kube_errors.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "storage class %s does not exist", req.Name)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error deleting storage class in kube")
+	}
+	return &Empty{}, nil
+}
+
+func (w *WaterfrontAPI) ListVolumes(ctx context.Context, req *Empty) (*ListVolumesResponse, error) {
+	storage, err := w.storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := storage.listVolumes()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching persistent volumes from kube")
+	}
+	claims, err := storage.listClaims()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching persistent volume claims from kube")
+	}
+
+	resp := &ListVolumesResponse{}
+	for i := range volumes {
+		resp.Volumes = append(resp.Volumes, volumeFromKube(&volumes[i]))
+	}
+	for i := range claims {
+		resp.Claims = append(resp.Claims, claimFromKube(&claims[i]))
+	}
+	sort.Slice(resp.Volumes, func(i, j int) bool {
+		return resp.Volumes[i].Name < resp.Volumes[j].Name
+	})
+	sort.Slice(resp.Claims, func(i, j int) bool {
+		a, b := resp.Claims[i], resp.Claims[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	return resp, nil
+}
+
+// kubeStorageClient manages volumes through the Kubernetes API
+type kubeStorageClient struct {
+	kubeClient *kubernetes.Clientset
+}
+
+func (c kubeStorageClient) listStorageClasses() ([]storage_v1.StorageClass, error) {
+	classes, err := c.kubeClient.StorageV1().StorageClasses().List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return classes.Items, nil
+}
+
+func (c kubeStorageClient) createStorageClass(class *storage_v1.StorageClass) (*storage_v1.StorageClass, error) {
+	return c.kubeClient.StorageV1().StorageClasses().Create(class)
+}
+
+func (c kubeStorageClient) updateStorageClass(class *storage_v1.StorageClass) error {
+	_, err := c.kubeClient.StorageV1().StorageClasses().Update(class)
+	return err
+}
+
+func (c kubeStorageClient) deleteStorageClass(name string) error {
+	return c.kubeClient.StorageV1().StorageClasses().Delete(name, &meta_v1.DeleteOptions{})
+}
+
+func (c kubeStorageClient) listVolumes() ([]kube_v1.PersistentVolume, error) {
+	volumes, err := c.kubeClient.CoreV1().PersistentVolumes().List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return volumes.Items, nil
+}
+
+func (c kubeStorageClient) listClaims() ([]kube_v1.PersistentVolumeClaim, error) {
+	claims, err := c.kubeClient.CoreV1().PersistentVolumeClaims(meta_v1.NamespaceAll).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return claims.Items, nil
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	kube_errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	storage_v1 "k8s.io/client-go/pkg/apis/storage/v1"
+
+	teamster_proto "github.com/paxautoma/operos/components/teamster/pkg/teamster"
+)
+
+type fakeStorageClient struct {
+	classes map[string]*storage_v1.StorageClass
+	volumes []kube_v1.PersistentVolume
+	claims  []kube_v1.PersistentVolumeClaim
+}
+
+func (c *fakeStorageClient) listStorageClasses() ([]storage_v1.StorageClass, error) {
+	var classes []storage_v1.StorageClass
+	for _, class := range c.classes {
+		classes = append(classes, copyStorageClass(class))
+	}
+	return classes, nil
+}
+
+func (c *fakeStorageClient) createStorageClass(class *storage_v1.StorageClass) (*storage_v1.StorageClass, error) {
+	if _, ok := c.classes[class.Name]; ok {
+		return nil, kube_errors.NewAlreadyExists(schema.GroupResource{Resource: "storageclasses"}, class.Name)
+	}
+	created := copyStorageClass(class)
+	c.classes[class.Name] = &created
+	return &created, nil
+}
+
+func (c *fakeStorageClient) updateStorageClass(class *storage_v1.StorageClass) error {
+	updated := copyStorageClass(class)
+	c.classes[class.Name] = &updated
+	return nil
+}
+
+func (c *fakeStorageClient) deleteStorageClass(name string) error {
+	if _, ok := c.classes[name]; !ok {
+		return kube_errors.NewNotFound(schema.GroupResource{Resource: "storageclasses"}, name)
+	}
+	delete(c.classes, name)
+	return nil
+}
+
+func (c *fakeStorageClient) listVolumes() ([]kube_v1.PersistentVolume, error) {
+	return c.volumes, nil
+}
+
+func (c *fakeStorageClient) listClaims() ([]kube_v1.PersistentVolumeClaim, error) {
+	return c.claims, nil
+}
+
+// forUser serves every user of the API from the fake
+func (c *fakeStorageClient) forUser(ctx context.Context) (storageClient, error) {
+	return c, nil
+}
+
+func copyStorageClass(class *storage_v1.StorageClass) storage_v1.StorageClass {
+	copied := *class
+	copied.Annotations = make(map[string]string, len(class.Annotations))
+	for key, value := range class.Annotations {
+		copied.Annotations[key] = value
+	}
+	copied.Parameters = make(map[string]string, len(class.Parameters))
+	for key, value := range class.Parameters {
+		copied.Parameters[key] = value
+	}
+	return copied
+}
+
+// fakePoolTeamsterClient creates pools in a Ceph fixture
+type fakePoolTeamsterClient struct {
+	teamster_proto.TeamsterClient
+	err     error
+	created []*teamster_proto.CreatePoolRequest
+}
+
+func (c *fakePoolTeamsterClient) CreatePool(ctx context.Context, req *teamster_proto.CreatePoolRequest, opts ...grpc.CallOption) (*teamster_proto.Empty, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.created = append(c.created, req)
+	return &teamster_proto.Empty{}, nil
+}
+
+func storageFixture() *fakeStorageClient {
+	className := "rbd"
+	return &fakeStorageClient{
+		classes: map[string]*storage_v1.StorageClass{
+			"rbd": {
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:        "rbd",
+					Annotations: map[string]string{defaultClassAnnotation: "true"},
+				},
+				Provisioner: rbdProvisioner,
+				Parameters: map[string]string{
+					"monitors":    "10.57.0.1:6789",
+					"pool":        "kube",
+					"userId":      "kube",
+					"imageFormat": "2",
+				},
+			},
+		},
+		volumes: []kube_v1.PersistentVolume{{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "pvc-1234"},
+			Spec: kube_v1.PersistentVolumeSpec{
+				Capacity: kube_v1.ResourceList{
+					kube_v1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+				PersistentVolumeSource: kube_v1.PersistentVolumeSource{
+					RBD: &kube_v1.RBDVolumeSource{
+						RBDPool:  "kube",
+						RBDImage: "kubernetes-dynamic-pvc-1234",
+					},
+				},
+				ClaimRef:                      &kube_v1.ObjectReference{Namespace: "default", Name: "data"},
+				PersistentVolumeReclaimPolicy: kube_v1.PersistentVolumeReclaimDelete,
+				StorageClassName:              "rbd",
+			},
+			Status: kube_v1.PersistentVolumeStatus{Phase: kube_v1.VolumeBound},
+		}},
+		claims: []kube_v1.PersistentVolumeClaim{{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "data"},
+			Spec: kube_v1.PersistentVolumeClaimSpec{
+				StorageClassName: &className,
+				VolumeName:       "pvc-1234",
+				Resources: kube_v1.ResourceRequirements{
+					Requests: kube_v1.ResourceList{
+						kube_v1.ResourceStorage: resource.MustParse("1Gi"),
+					},
+				},
+			},
+			Status: kube_v1.PersistentVolumeClaimStatus{
+				Phase: kube_v1.ClaimBound,
+				Capacity: kube_v1.ResourceList{
+					kube_v1.ResourceStorage: resource.MustParse("1Gi"),
+				},
+			},
+		}},
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ListVolumes_HasRBDImages", func(t *testing.T) {
+		api := &WaterfrontAPI{storage: storageFixture().forUser}
+		resp, err := api.ListVolumes(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, []*PersistentVolume{{
+			Name:           "pvc-1234",
+			CapacityBytes:  1 << 30,
+			StorageClass:   "rbd",
+			Phase:          "Bound",
+			ReclaimPolicy:  "Delete",
+			RbdPool:        "kube",
+			RbdImage:       "kubernetes-dynamic-pvc-1234",
+			ClaimNamespace: "default",
+			ClaimName:      "data",
+		}}, resp.Volumes)
+		require.Equal(t, []*PersistentVolumeClaim{{
+			Namespace:      "default",
+			Name:           "data",
+			Phase:          "Bound",
+			StorageClass:   "rbd",
+			RequestedBytes: 1 << 30,
+			CapacityBytes:  1 << 30,
+			VolumeName:     "pvc-1234",
+		}}, resp.Claims)
+	})
+
+	t.Run("CreateStorageClass_CopiesCephSettings", func(t *testing.T) {
+		storage := storageFixture()
+		api := &WaterfrontAPI{storage: storage.forUser, cephSource: fixtureCephSource{report: cephFixture}}
+		class, err := api.CreateStorageClass(ctx, &StorageClass{Name: "replicated", Pool: "rbd", IsDefault: true})
+		require.NoError(t, err)
+		require.Equal(t, &StorageClass{Name: "replicated", Pool: "rbd", IsDefault: true, Provisioner: rbdProvisioner}, class)
+		require.Equal(t, "10.57.0.1:6789", storage.classes["replicated"].Parameters["monitors"])
+
+		// The old default is no longer the default
+		resp, err := api.ListStorageClasses(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Len(t, resp.StorageClasses, 2)
+		require.Equal(t, "rbd", resp.StorageClasses[0].Name)
+		require.False(t, resp.StorageClasses[0].IsDefault)
+	})
+
+	t.Run("CreateStorageClass_MissingPool", func(t *testing.T) {
+		api := &WaterfrontAPI{storage: storageFixture().forUser, cephSource: fixtureCephSource{report: cephFixture}}
+		_, err := api.CreateStorageClass(ctx, &StorageClass{Name: "fast", Pool: "fast"})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+	})
+
+	t.Run("CreateStorageClass_Exists", func(t *testing.T) {
+		api := &WaterfrontAPI{storage: storageFixture().forUser, cephSource: fixtureCephSource{report: cephFixture}}
+		_, err := api.CreateStorageClass(ctx, &StorageClass{Name: "rbd", Pool: "rbd"})
+		require.Equal(t, codes.AlreadyExists, grpc.Code(err))
+	})
+
+	t.Run("DeleteStorageClass_InUse", func(t *testing.T) {
+		storage := storageFixture()
+		api := &WaterfrontAPI{storage: storage.forUser}
+		_, err := api.DeleteStorageClass(ctx, &DeleteStorageClassRequest{Name: "rbd"})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+
+		storage.claims = nil
+		_, err = api.DeleteStorageClass(ctx, &DeleteStorageClassRequest{Name: "rbd"})
+		require.NoError(t, err)
+		require.Empty(t, storage.classes)
+
+		_, err = api.DeleteStorageClass(ctx, &DeleteStorageClassRequest{Name: "rbd"})
+		require.Equal(t, codes.NotFound, grpc.Code(err))
+	})
+
+	t.Run("CreatePool_ReturnsPool", func(t *testing.T) {
+		teamster := &fakePoolTeamsterClient{}
+		api := &WaterfrontAPI{teamsterClient: teamster, cephSource: fixtureCephSource{report: cephFixture}}
+		pool, err := api.CreatePool(ctx, &CreatePoolRequest{Name: "rbd", Size: 3})
+		require.NoError(t, err)
+		require.Equal(t, int64(1), pool.Id)
+		require.Len(t, teamster.created, 1)
+		require.Equal(t, int32(3), teamster.created[0].Size)
+	})
+
+	t.Run("CreatePool_OverCapacity", func(t *testing.T) {
+		teamster := &fakePoolTeamsterClient{
+			err: grpc.Errorf(codes.FailedPrecondition, "only 2 nodes have OSDs, which can not hold 3 replicas"),
+		}
+		api := &WaterfrontAPI{teamsterClient: teamster, cephSource: fixtureCephSource{report: cephFixture}}
+		_, err := api.CreatePool(ctx, &CreatePoolRequest{Name: "fast", Size: 3})
+		require.Equal(t, codes.FailedPrecondition, grpc.Code(err))
+		require.Contains(t, grpc.ErrorDesc(err), "can not hold 3 replicas")
+	})
+}
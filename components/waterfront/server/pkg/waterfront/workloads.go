@@ -0,0 +1,422 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	apps_v1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	extensions_v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultEventLimit is how many warning events are listed if the request
+// does not say
+const defaultEventLimit = 100
+
+// WorkloadWatcher keeps informers on the namespaces, workloads, pods and
+// warning events of the cluster, so that the workload overview is served
+// from memory rather than by listing them from the API server each time.
+//
+// Like the NodeWatcher, the informers run as waterfront rather than as the
+// user, which is only sound while every role may read all of this through the
+// bindings in 42-operos-rbac.yml.
+type WorkloadWatcher struct {
+	namespaces   cache.Store
+	deployments  cache.Store
+	statefulSets cache.Store
+	daemonSets   cache.Store
+	pods         cache.Store
+	events       cache.Store
+
+	controllers []cache.Controller
+}
+
+func NewWorkloadWatcher(kubeClient *kubernetes.Clientset) *WorkloadWatcher {
+	w := &WorkloadWatcher{}
+	core := kubeClient.CoreV1().RESTClient()
+	apps := kubeClient.AppsV1beta1().RESTClient()
+	extensions := kubeClient.ExtensionsV1beta1().RESTClient()
+
+	for _, informer := range []struct {
+		client        cache.Getter
+		resource      string
+		objType       runtime.Object
+		fieldSelector fields.Selector
+		store         *cache.Store
+	}{
+		{core, "namespaces", &kube_v1.Namespace{}, fields.Everything(), &w.namespaces},
+		{apps, "deployments", &apps_v1beta1.Deployment{}, fields.Everything(), &w.deployments},
+		{apps, "statefulsets", &apps_v1beta1.StatefulSet{}, fields.Everything(), &w.statefulSets},
+		{extensions, "daemonsets", &extensions_v1beta1.DaemonSet{}, fields.Everything(), &w.daemonSets},
+		{core, "pods", &kube_v1.Pod{}, fields.Everything(), &w.pods},
+		// Normal events are far more common, and not shown
+		{core, "events", &kube_v1.Event{}, fields.OneTermEqualSelector("type", kube_v1.EventTypeWarning), &w.events},
+	} {
+		listWatch := cache.NewListWatchFromClient(informer.client, informer.resource, meta_v1.NamespaceAll, informer.fieldSelector)
+		store, controller := cache.NewInformer(listWatch, informer.objType, 0, cache.ResourceEventHandlerFuncs{})
+		*informer.store = store
+		w.controllers = append(w.controllers, controller)
+	}
+	return w
+}
+
+// Run runs the informers until stop is closed
+func (w *WorkloadWatcher) Run(stop <-chan struct{}) {
+	for _, controller := range w.controllers {
+		go controller.Run(stop)
+	}
+	<-stop
+}
+
+// synced tells whether every informer has listed its resource once
+func (w *WorkloadWatcher) synced() bool {
+	for _, controller := range w.controllers {
+		if !controller.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// inNamespace tells whether an object is in a namespace, or namespace is
+// empty for all of them
+func inNamespace(obj meta_v1.Object, namespace string) bool {
+	return namespace == "" || obj.GetNamespace() == namespace
+}
+
+func (w *WorkloadWatcher) Namespaces() []*Namespace {
+	namespaces := make(map[string]*Namespace)
+	for _, obj := range w.namespaces.List() {
+		if ns, ok := obj.(*kube_v1.Namespace); ok {
+			namespaces[ns.Name] = &Namespace{Name: ns.Name, Phase: string(ns.Status.Phase)}
+		}
+	}
+	count := func(store cache.Store, add func(ns *Namespace, obj interface{})) {
+		for _, obj := range store.List() {
+			if meta, ok := obj.(meta_v1.Object); ok {
+				if ns, ok := namespaces[meta.GetNamespace()]; ok {
+					add(ns, obj)
+				}
+			}
+		}
+	}
+	count(w.deployments, func(ns *Namespace, _ interface{}) { ns.Deployments++ })
+	count(w.statefulSets, func(ns *Namespace, _ interface{}) { ns.StatefulSets++ })
+	count(w.daemonSets, func(ns *Namespace, _ interface{}) { ns.DaemonSets++ })
+	count(w.pods, func(ns *Namespace, obj interface{}) {
+		ns.Pods++
+		if phase := obj.(*kube_v1.Pod).Status.Phase; phase != kube_v1.PodRunning && phase != kube_v1.PodSucceeded {
+			ns.UnhealthyPods++
+		}
+	})
+
+	result := make([]*Namespace, 0, len(namespaces))
+	for _, ns := range namespaces {
+		result = append(result, ns)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (w *WorkloadWatcher) Workloads(namespace string) []*Workload {
+	var workloads []*Workload
+	for _, obj := range w.deployments.List() {
+		if deployment, ok := obj.(*apps_v1beta1.Deployment); ok && inNamespace(deployment, namespace) {
+			workloads = append(workloads, workloadFromDeployment(deployment))
+		}
+	}
+	for _, obj := range w.statefulSets.List() {
+		if statefulSet, ok := obj.(*apps_v1beta1.StatefulSet); ok && inNamespace(statefulSet, namespace) {
+			workloads = append(workloads, workloadFromStatefulSet(statefulSet))
+		}
+	}
+	for _, obj := range w.daemonSets.List() {
+		if daemonSet, ok := obj.(*extensions_v1beta1.DaemonSet); ok && inNamespace(daemonSet, namespace) {
+			workloads = append(workloads, workloadFromDaemonSet(daemonSet))
+		}
+	}
+
+	sort.Slice(workloads, func(i, j int) bool {
+		a, b := workloads[i], workloads[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		} else if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+	return workloads
+}
+
+// replicas is the value of an optional replica count, which defaults to one
+func replicas(count *int32) int32 {
+	if count == nil {
+		return 1
+	}
+	return *count
+}
+
+// The rollout status of workloads is worked out the way `kubectl rollout
+// status` does
+
+func workloadFromDeployment(deployment *apps_v1beta1.Deployment) *Workload {
+	desired := replicas(deployment.Spec.Replicas)
+	workload := &Workload{
+		Kind:              "Deployment",
+		Namespace:         deployment.Namespace,
+		Name:              deployment.Name,
+		DesiredReplicas:   desired,
+		ReadyReplicas:     deployment.Status.ReadyReplicas,
+		UpdatedReplicas:   deployment.Status.UpdatedReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+		CreationTime:      unixTime(deployment.CreationTimestamp),
+		RolloutStatus:     RolloutStatus_ROLLOUT_PROGRESSING,
+	}
+
+	status := deployment.Status
+	if deployment.Generation > status.ObservedGeneration {
+		workload.RolloutMessage = "waiting for the deployment spec update to be observed"
+		return workload
+	}
+	for _, condition := range status.Conditions {
+		if condition.Type == apps_v1beta1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			workload.RolloutStatus = RolloutStatus_ROLLOUT_FAILED
+			workload.RolloutMessage = "the deployment exceeded its progress deadline"
+			return workload
+		}
+	}
+
+	switch {
+	case status.UpdatedReplicas < desired:
+		workload.RolloutMessage = fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, desired)
+	case status.Replicas > status.UpdatedReplicas:
+		workload.RolloutMessage = fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas)
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		workload.RolloutMessage = fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas)
+	default:
+		workload.RolloutStatus = RolloutStatus_ROLLOUT_COMPLETE
+	}
+	return workload
+}
+
+func workloadFromStatefulSet(statefulSet *apps_v1beta1.StatefulSet) *Workload {
+	desired := replicas(statefulSet.Spec.Replicas)
+	workload := &Workload{
+		Kind:              "StatefulSet",
+		Namespace:         statefulSet.Namespace,
+		Name:              statefulSet.Name,
+		DesiredReplicas:   desired,
+		ReadyReplicas:     statefulSet.Status.ReadyReplicas,
+		UpdatedReplicas:   statefulSet.Status.UpdatedReplicas,
+		AvailableReplicas: statefulSet.Status.ReadyReplicas,
+		CreationTime:      unixTime(statefulSet.CreationTimestamp),
+		RolloutStatus:     RolloutStatus_ROLLOUT_PROGRESSING,
+	}
+
+	status := statefulSet.Status
+	if status.ObservedGeneration == nil || statefulSet.Generation > *status.ObservedGeneration {
+		workload.RolloutMessage = "waiting for the stateful set spec update to be observed"
+		return workload
+	}
+
+	switch {
+	case status.ReadyReplicas < desired:
+		workload.RolloutMessage = fmt.Sprintf("%d of %d pods are ready", status.ReadyReplicas, desired)
+	case statefulSet.Spec.UpdateStrategy.Type != apps_v1beta1.RollingUpdateStatefulSetStrategyType:
+		// Pods are only replaced when they are deleted
+		workload.RolloutStatus = RolloutStatus_ROLLOUT_COMPLETE
+	case statefulSet.Spec.UpdateStrategy.RollingUpdate != nil && statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition != nil:
+		partition := *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+		if status.UpdatedReplicas < desired-partition {
+			workload.RolloutMessage = fmt.Sprintf("%d out of %d new pods have been updated", status.UpdatedReplicas, desired-partition)
+		} else {
+			workload.RolloutStatus = RolloutStatus_ROLLOUT_COMPLETE
+		}
+	case status.UpdateRevision != status.CurrentRevision:
+		workload.RolloutMessage = fmt.Sprintf("%d out of %d new pods have been updated", status.UpdatedReplicas, desired)
+	default:
+		workload.RolloutStatus = RolloutStatus_ROLLOUT_COMPLETE
+	}
+	return workload
+}
+
+func workloadFromDaemonSet(daemonSet *extensions_v1beta1.DaemonSet) *Workload {
+	status := daemonSet.Status
+	workload := &Workload{
+		Kind:              "DaemonSet",
+		Namespace:         daemonSet.Namespace,
+		Name:              daemonSet.Name,
+		DesiredReplicas:   status.DesiredNumberScheduled,
+		ReadyReplicas:     status.NumberReady,
+		UpdatedReplicas:   status.UpdatedNumberScheduled,
+		AvailableReplicas: status.NumberAvailable,
+		CreationTime:      unixTime(daemonSet.CreationTimestamp),
+		RolloutStatus:     RolloutStatus_ROLLOUT_PROGRESSING,
+	}
+
+	switch {
+	case daemonSet.Generation > status.ObservedGeneration:
+		workload.RolloutMessage = "waiting for the daemon set spec update to be observed"
+	case status.UpdatedNumberScheduled < status.DesiredNumberScheduled:
+		workload.RolloutMessage = fmt.Sprintf("%d out of %d new pods have been updated", status.UpdatedNumberScheduled, status.DesiredNumberScheduled)
+	case status.NumberAvailable < status.DesiredNumberScheduled:
+		workload.RolloutMessage = fmt.Sprintf("%d of %d updated pods are available", status.NumberAvailable, status.DesiredNumberScheduled)
+	default:
+		workload.RolloutStatus = RolloutStatus_ROLLOUT_COMPLETE
+	}
+	return workload
+}
+
+func (w *WorkloadWatcher) Pods(namespace string) ([]*Pod, []*PodPhaseCount) {
+	var pods []*Pod
+	phases := make(map[string]int64)
+	for _, obj := range w.pods.List() {
+		if pod, ok := obj.(*kube_v1.Pod); ok && inNamespace(pod, namespace) {
+			pods = append(pods, podFromKube(pod))
+			phases[string(pod.Status.Phase)]++
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+
+	var counts []*PodPhaseCount
+	for phase, count := range phases {
+		counts = append(counts, &PodPhaseCount{Phase: phase, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Phase < counts[j].Phase
+	})
+	return pods, counts
+}
+
+func podFromKube(pod *kube_v1.Pod) *Pod {
+	result := &Pod{
+		Namespace:    pod.Namespace,
+		Name:         pod.Name,
+		Phase:        string(pod.Status.Phase),
+		Reason:       pod.Status.Reason,
+		NodeName:     pod.Spec.NodeName,
+		PodIp:        pod.Status.PodIP,
+		Containers:   int32(len(pod.Spec.Containers)),
+		CreationTime: unixTime(pod.CreationTimestamp),
+	}
+	for _, container := range pod.Status.ContainerStatuses {
+		result.Restarts += container.RestartCount
+		if container.Ready {
+			result.ReadyContainers++
+		}
+		// The first container which is not running tells why the pod is not
+		if result.Reason != "" {
+			continue
+		}
+		if waiting := container.State.Waiting; waiting != nil && waiting.Reason != "" {
+			result.Reason = waiting.Reason
+		} else if terminated := container.State.Terminated; terminated != nil && terminated.Reason != "" {
+			result.Reason = terminated.Reason
+		}
+	}
+	if pod.DeletionTimestamp != nil {
+		result.Reason = "Terminating"
+	}
+	return result
+}
+
+// WarningEvents returns the most recent warning events, at most limit
+func (w *WorkloadWatcher) WarningEvents(namespace string, limit int) []*WarningEvent {
+	var events []*WarningEvent
+	for _, obj := range w.events.List() {
+		if event, ok := obj.(*kube_v1.Event); ok && inNamespace(event, namespace) {
+			events = append(events, &WarningEvent{
+				Namespace:  event.Namespace,
+				ObjectKind: event.InvolvedObject.Kind,
+				ObjectName: event.InvolvedObject.Name,
+				Reason:     event.Reason,
+				Message:    event.Message,
+				Source:     event.Source.Component,
+				Count:      event.Count,
+				FirstTime:  unixTime(event.FirstTimestamp),
+				LastTime:   unixTime(event.LastTimestamp),
+			})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTime > events[j].LastTime
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+// workloadWatcherSynced fails requests made before the informers have
+// listed everything, rather than return a partial overview
+func (w *WaterfrontAPI) workloadWatcherSynced() error {
+	if !w.workloadWatcher.synced() {
+		return status.Error(codes.Unavailable, "the workload overview is still being loaded")
+	}
+	return nil
+}
+
+func (w *WaterfrontAPI) ListNamespaces(ctx context.Context, req *Empty) (*ListNamespacesResponse, error) {
+	if err := w.workloadWatcherSynced(); err != nil {
+		return nil, err
+	}
+	return &ListNamespacesResponse{Namespaces: w.workloadWatcher.Namespaces()}, nil
+}
+
+func (w *WaterfrontAPI) ListWorkloads(ctx context.Context, req *NamespaceRequest) (*ListWorkloadsResponse, error) {
+	if err := w.workloadWatcherSynced(); err != nil {
+		return nil, err
+	}
+	return &ListWorkloadsResponse{Workloads: w.workloadWatcher.Workloads(req.Namespace)}, nil
+}
+
+func (w *WaterfrontAPI) ListPods(ctx context.Context, req *NamespaceRequest) (*ListPodsResponse, error) {
+	if err := w.workloadWatcherSynced(); err != nil {
+		return nil, err
+	}
+	pods, phases := w.workloadWatcher.Pods(req.Namespace)
+	return &ListPodsResponse{Pods: pods, Phases: phases}, nil
+}
+
+func (w *WaterfrontAPI) ListWarningEvents(ctx context.Context, req *ListWarningEventsRequest) (*ListWarningEventsResponse, error) {
+	if err := w.workloadWatcherSynced(); err != nil {
+		return nil, err
+	}
+	if req.Limit < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid limit %d", req.Limit)
+	}
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultEventLimit
+	}
+	return &ListWarningEventsResponse{Events: w.workloadWatcher.WarningEvents(req.Namespace, limit)}, nil
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2018 Pax Automa Systems, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package waterfront
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_v1 "k8s.io/client-go/pkg/api/v1"
+	apps_v1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	extensions_v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unsyncedController is an informer which has not listed anything yet
+type unsyncedController struct {
+	cache.Controller
+}
+
+func (unsyncedController) HasSynced() bool {
+	return false
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func storeWith(objs ...interface{}) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, obj := range objs {
+		store.Add(obj)
+	}
+	return store
+}
+
+func workloadFixture() *WorkloadWatcher {
+	now := time.Unix(1520000000, 0)
+	return &WorkloadWatcher{
+		namespaces: storeWith(
+			&kube_v1.Namespace{
+				ObjectMeta: meta_v1.ObjectMeta{Name: "default"},
+				Status:     kube_v1.NamespaceStatus{Phase: kube_v1.NamespaceActive},
+			},
+			&kube_v1.Namespace{
+				ObjectMeta: meta_v1.ObjectMeta{Name: "kube-system"},
+				Status:     kube_v1.NamespaceStatus{Phase: kube_v1.NamespaceActive},
+			},
+		),
+		deployments: storeWith(&apps_v1beta1.Deployment{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "web", Generation: 2},
+			Spec:       apps_v1beta1.DeploymentSpec{Replicas: int32Ptr(3)},
+			Status: apps_v1beta1.DeploymentStatus{
+				ObservedGeneration: 2,
+				Replicas:           3,
+				UpdatedReplicas:    3,
+				ReadyReplicas:      3,
+				AvailableReplicas:  3,
+			},
+		}),
+		statefulSets: storeWith(&apps_v1beta1.StatefulSet{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "db", Generation: 1},
+			Spec:       apps_v1beta1.StatefulSetSpec{Replicas: int32Ptr(3)},
+			Status: apps_v1beta1.StatefulSetStatus{
+				ObservedGeneration: int64Ptr(1),
+				Replicas:           3,
+				ReadyReplicas:      2,
+			},
+		}),
+		daemonSets: storeWith(&extensions_v1beta1.DaemonSet{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "kube-system", Name: "kube-proxy"},
+			Status: extensions_v1beta1.DaemonSetStatus{
+				DesiredNumberScheduled: 4,
+				UpdatedNumberScheduled: 4,
+				NumberReady:            4,
+				NumberAvailable:        4,
+			},
+		}),
+		pods: storeWith(
+			&kube_v1.Pod{
+				ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "web-1"},
+				Spec:       kube_v1.PodSpec{NodeName: "worker-1", Containers: []kube_v1.Container{{Name: "web"}}},
+				Status: kube_v1.PodStatus{
+					Phase: kube_v1.PodRunning,
+					PodIP: "10.32.0.5",
+					ContainerStatuses: []kube_v1.ContainerStatus{
+						{Name: "web", Ready: true, RestartCount: 1},
+					},
+				},
+			},
+			&kube_v1.Pod{
+				ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: "db-2"},
+				Spec:       kube_v1.PodSpec{NodeName: "worker-2", Containers: []kube_v1.Container{{Name: "db"}, {Name: "exporter"}}},
+				Status: kube_v1.PodStatus{
+					Phase: kube_v1.PodPending,
+					ContainerStatuses: []kube_v1.ContainerStatus{
+						{Name: "db", RestartCount: 5, State: kube_v1.ContainerState{
+							Waiting: &kube_v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+						}},
+						{Name: "exporter", Ready: true},
+					},
+				},
+			},
+		),
+		events: storeWith(
+			&kube_v1.Event{
+				ObjectMeta:     meta_v1.ObjectMeta{Namespace: "default", Name: "db-2.1"},
+				InvolvedObject: kube_v1.ObjectReference{Kind: "Pod", Name: "db-2"},
+				Reason:         "BackOff",
+				Message:        "Back-off restarting failed container",
+				Source:         kube_v1.EventSource{Component: "kubelet"},
+				Count:          12,
+				FirstTimestamp: meta_v1.NewTime(now.Add(-time.Hour)),
+				LastTimestamp:  meta_v1.NewTime(now),
+			},
+			&kube_v1.Event{
+				ObjectMeta:     meta_v1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns.1"},
+				InvolvedObject: kube_v1.ObjectReference{Kind: "Pod", Name: "kube-dns"},
+				Reason:         "Unhealthy",
+				Count:          1,
+				LastTimestamp:  meta_v1.NewTime(now.Add(-time.Minute)),
+			},
+		),
+	}
+}
+
+func TestWorkloads(t *testing.T) {
+	ctx := context.Background()
+	api := &WaterfrontAPI{workloadWatcher: workloadFixture()}
+
+	t.Run("Namespaces_HaveCounts", func(t *testing.T) {
+		resp, err := api.ListNamespaces(ctx, &Empty{})
+		require.NoError(t, err)
+		require.Equal(t, []*Namespace{
+			{Name: "default", Phase: "Active", Deployments: 1, StatefulSets: 1, Pods: 2, UnhealthyPods: 1},
+			{Name: "kube-system", Phase: "Active", DaemonSets: 1},
+		}, resp.Namespaces)
+	})
+
+	t.Run("Workloads_HaveRolloutStatus", func(t *testing.T) {
+		resp, err := api.ListWorkloads(ctx, &NamespaceRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Workloads, 3)
+
+		require.Equal(t, "db", resp.Workloads[0].Name)
+		require.Equal(t, RolloutStatus_ROLLOUT_PROGRESSING, resp.Workloads[0].RolloutStatus)
+		require.Equal(t, "2 of 3 pods are ready", resp.Workloads[0].RolloutMessage)
+		require.Equal(t, "web", resp.Workloads[1].Name)
+		require.Equal(t, RolloutStatus_ROLLOUT_COMPLETE, resp.Workloads[1].RolloutStatus)
+		require.Equal(t, "DaemonSet", resp.Workloads[2].Kind)
+		require.Equal(t, int32(4), resp.Workloads[2].DesiredReplicas)
+	})
+
+	t.Run("Workloads_InNamespace", func(t *testing.T) {
+		resp, err := api.ListWorkloads(ctx, &NamespaceRequest{Namespace: "kube-system"})
+		require.NoError(t, err)
+		require.Len(t, resp.Workloads, 1)
+		require.Equal(t, "kube-proxy", resp.Workloads[0].Name)
+	})
+
+	t.Run("Pods_HaveRestartsAndReason", func(t *testing.T) {
+		resp, err := api.ListPods(ctx, &NamespaceRequest{Namespace: "default"})
+		require.NoError(t, err)
+		require.Equal(t, &Pod{
+			Namespace:       "default",
+			Name:            "db-2",
+			Phase:           "Pending",
+			Reason:          "CrashLoopBackOff",
+			NodeName:        "worker-2",
+			ReadyContainers: 1,
+			Containers:      2,
+			Restarts:        5,
+		}, resp.Pods[0])
+		require.Equal(t, []*PodPhaseCount{
+			{Phase: "Pending", Count: 1},
+			{Phase: "Running", Count: 1},
+		}, resp.Phases)
+	})
+
+	t.Run("WarningEvents_MostRecentFirst", func(t *testing.T) {
+		resp, err := api.ListWarningEvents(ctx, &ListWarningEventsRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Events, 2)
+		require.Equal(t, &WarningEvent{
+			Namespace:  "default",
+			ObjectKind: "Pod",
+			ObjectName: "db-2",
+			Reason:     "BackOff",
+			Message:    "Back-off restarting failed container",
+			Source:     "kubelet",
+			Count:      12,
+			FirstTime:  1519996400,
+			LastTime:   1520000000,
+		}, resp.Events[0])
+
+		resp, err = api.ListWarningEvents(ctx, &ListWarningEventsRequest{Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, resp.Events, 1)
+	})
+
+	t.Run("NotSynced_IsUnavailable", func(t *testing.T) {
+		watcher := workloadFixture()
+		watcher.controllers = []cache.Controller{unsyncedController{}}
+		api := &WaterfrontAPI{workloadWatcher: watcher}
+		_, err := api.ListPods(ctx, &NamespaceRequest{})
+		require.Equal(t, codes.Unavailable, grpc.Code(err))
+	})
+}
+
+func TestDeploymentRollout(t *testing.T) {
+	tests := []struct {
+		name        string
+		generation  int64
+		status      apps_v1beta1.DeploymentStatus
+		wantStatus  RolloutStatus
+		wantMessage string
+	}{
+		{
+			name:        "not observed",
+			generation:  3,
+			status:      apps_v1beta1.DeploymentStatus{ObservedGeneration: 2},
+			wantStatus:  RolloutStatus_ROLLOUT_PROGRESSING,
+			wantMessage: "waiting for the deployment spec update to be observed",
+		},
+		{
+			name:        "updating",
+			status:      apps_v1beta1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 1},
+			wantStatus:  RolloutStatus_ROLLOUT_PROGRESSING,
+			wantMessage: "1 out of 2 new replicas have been updated",
+		},
+		{
+			name:        "old replicas",
+			status:      apps_v1beta1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2},
+			wantStatus:  RolloutStatus_ROLLOUT_PROGRESSING,
+			wantMessage: "1 old replicas are pending termination",
+		},
+		{
+			name: "deadline exceeded",
+			status: apps_v1beta1.DeploymentStatus{Conditions: []apps_v1beta1.DeploymentCondition{
+				{Type: apps_v1beta1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"},
+			}},
+			wantStatus:  RolloutStatus_ROLLOUT_FAILED,
+			wantMessage: "the deployment exceeded its progress deadline",
+		},
+		{
+			name:       "complete",
+			status:     apps_v1beta1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+			wantStatus: RolloutStatus_ROLLOUT_COMPLETE,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload := workloadFromDeployment(&apps_v1beta1.Deployment{
+				ObjectMeta: meta_v1.ObjectMeta{Generation: tt.generation},
+				Spec:       apps_v1beta1.DeploymentSpec{Replicas: int32Ptr(2)},
+				Status:     tt.status,
+			})
+			require.Equal(t, tt.wantStatus, workload.RolloutStatus)
+			require.Equal(t, tt.wantMessage, workload.RolloutMessage)
+		})
+	}
+}
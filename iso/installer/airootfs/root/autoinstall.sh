@@ -1,7 +1,7 @@
 #!/bin/bash
 
 export OPEROS_KUBERNETES_VERSION=1.8.5
-export OPEROS_KUBE_DASHBOARD_VERSION=1.6.3
+export OPEROS_KUBE_DASHBOARD_VERSION=1.8.3
 export OPEROS_KUBEDNS_VERSION=1.14.5
 export OPEROS_CALICO_VERSION=2.5.1
 export OPEROS_NGINX_VERSION=alpine
@@ -17,7 +17,6 @@ export CONTROLLER_PUBLIC_IF=enp0s8
 export CONTROLLER_PUBLIC_IF_MODE=dhcp
 export CONTROLLER_DISK=/dev/sda
 export OPEROS_VERSION=0.1.x
-export OPEROS_LICENSE_KEY=jcCPA6TU87
 export OPEROS_INSTALL_ID=f9018b34-c508-4602-8b38-6af064acdd3b
 export OPEROS_CONTROLLER_IP=192.168.33.10
 export OPEROS_KUBE_API_INSECURE_PORT=8080
@@ -27,7 +26,9 @@ export OPEROS_POD_CIDR=10.10.0.0/16
 export OPEROS_SERVICE_CIDR=10.11.0.0/16
 export OPEROS_DNS_SERVICE_IP=10.11.0.2
 export OPEROS_DNS_DOMAIN=cluster.local
+export OPEROS_NTP_SERVERS="0.pool.ntp.org 1.pool.ntp.org 2.pool.ntp.org 3.pool.ntp.org"
 export OPEROS_WORKER_STORAGE_PERCENTAGE=50
+export OPEROS_HOSTNAME_PATTERN="worker-{n}"
 export OPEROS_CLUSTER_NAME=asd
 export OPEROS_CLUSTER_ORG=asd
 export OPEROS_CLUSTER_DEPARTMENT=asd
@@ -18,10 +18,9 @@ echo \> Setting up NTP >&3
 arch-chroot /mnt ln -sf /usr/share/zoneinfo/UTC /etc/localtime
 
 cat > /mnt/etc/chrony.conf <<EOF
-server 0.pool.ntp.org iburst
-server 1.pool.ntp.org iburst
-server 2.pool.ntp.org iburst
-server 3.pool.ntp.org iburst
+$(for server in ${OPEROS_NTP_SERVERS:-0.pool.ntp.org 1.pool.ntp.org 2.pool.ntp.org 3.pool.ntp.org}; do
+    echo "server ${server} iburst"
+done)
 local
 
 rtconutc
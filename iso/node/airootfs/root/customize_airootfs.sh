@@ -41,4 +41,7 @@ systemctl enable kube-proxy.service
 # NTP
 systemctl enable chronyd.service
 
+# LLDP, so that prospector can tell which switch the node is plugged into
+systemctl enable lldpd.service
+
 systemctl set-default multi-user.target
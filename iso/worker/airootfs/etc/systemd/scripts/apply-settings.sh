@@ -81,7 +81,7 @@ with_backoff() {
 }
 
 download_settings() {
-    /usr/bin/prospector | curl -X POST -d @- http://${boot_server}:2680/whoami | tar -C / -zxv
+    /usr/bin/prospector report --format json | curl -X POST --data-binary @- http://${boot_server}:2680/whoami | tar -C / -zxv
 }
 
 boot_if=$(set +e; get_boot_if)
@@ -168,8 +168,13 @@ cat > /etc/cni/net.d/10-calico.conflist <<EOF
 }
 EOF
 
+# The controller is preferred; the cluster NTP servers are used when it can
+# not be reached
 cat > /etc/chrony.conf <<EOF
-server ${OPEROS_CONTROLLER_IP} iburst
+server ${OPEROS_CONTROLLER_IP} iburst prefer
+$(for server in ${OPEROS_NTP_SERVERS}; do
+    echo "server ${server} iburst"
+done)
 rtconutc
 rtcsync
 EOF
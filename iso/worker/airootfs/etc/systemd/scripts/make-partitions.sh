@@ -108,7 +108,7 @@ case $1 in
 
         # osd
 
-        BDEVUUID=$(/usr/bin/prospector --blk-device-uuid ${disk} | grep ^$dname | cut -d ',' -f 2)
+        BDEVUUID=$(/usr/bin/prospector disks --format table | awk -v dev="$dname" '$1 == dev { print $2 }')
         OSD_ID=$(cat /etc/paxautoma/osd-loadout | grep "^${BDEVUUID}" | cut -d ':' -f 2)
         OSD_KEY=$(cat /etc/paxautoma/osd-loadout | grep "${BDEVUUID}" | cut -d ':' -f 3)
 
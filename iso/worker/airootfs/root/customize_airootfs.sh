@@ -42,3 +42,4 @@ systemctl enable statustty.service
 systemctl enable make-partitions.service
 systemctl enable apply-settings.service
 systemctl enable pull-images.service
+systemctl enable prospector-agent.service